@@ -0,0 +1,353 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// how long a request may take to arrive, streams aside
+const readTimeout = 10 * time.Second
+
+// largest request body accepted
+const maxBodySize = 64 * 1024
+
+// Rooms is what the API needs from whoever owns the joined rooms,
+// so messages sent through it show up there as well
+type Rooms interface {
+	// rooms joined at the moment
+	Rooms() []*chat.ChatRoom
+	// joins a room, or returns it if it is joined already
+	Join(roomName string) (*chat.ChatRoom, error)
+	// sends a text message to a joined room, returning what was sent
+	Send(cr *chat.ChatRoom, text string) (chat.ChatMessage, error)
+}
+
+// Server is an HTTP server with REST endpoints for the joined rooms, their
+// messages and peers, a stream of incoming messages, a WebSocket for web
+// clients to chat through, and a webhook for other programs to announce
+// things in a room. Every request must carry the auth token, other than
+// those coming through the unix socket
+type Server struct {
+	host   *p2p.P2P
+	rooms  Rooms
+	token  string
+	server *http.Server
+	// server on the unix socket, nil unless there is one
+	socket *http.Server
+
+	// lock for the recent messages and the streams
+	lock sync.Mutex
+	// latest messages of each room, by the full room name
+	recent map[string][]roomMessage
+	// open event streams, with the room they follow, empty for all of them
+	streams map[chan roomMessage]string
+}
+
+// a joined room, as the API shows it
+type roomInfo struct {
+	Room     string `json:"room"`
+	Name     string `json:"name"`
+	Peers    int    `json:"peers"`
+	Password bool   `json:"password"`
+	// how full the queues of the room are
+	Queues chat.QueueStats `json:"queues"`
+}
+
+// the host, as the API shows it
+type statusInfo struct {
+	Peer string `json:"peer"`
+	// how far the host got starting up, joined once it's ready
+	Startup p2p.StartupState `json:"startup"`
+	// p2pchat peers and others connected, and the peers the DHT routes through
+	Peers   int `json:"peers"`
+	Routing int `json:"routing"`
+	// set while none of the bootstrap peers could be reached yet
+	BootstrapRetrying bool   `json:"bootstrap_retrying"`
+	Reachability      string `json:"reachability"`
+}
+
+// a peer in a room, with the username it announced if we know it
+type peerInfo struct {
+	ID       string `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+// a chat message along with the room it was seen in
+type roomMessage struct {
+	Room string `json:"room"`
+	chat.ChatMessage
+}
+
+// Constructor function for a new API server on the given address,
+// for the rooms joined on the host
+func NewServer(addr, token string, host *p2p.P2P, rooms Rooms) *Server {
+	s := &Server{
+		host:    host,
+		rooms:   rooms,
+		token:   token,
+		recent:  make(map[string][]roomMessage),
+		streams: make(map[chan roomMessage]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/rooms", s.handleRooms)
+	mux.HandleFunc("/rooms/", s.handleRoom)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/ws", s.handleSocket)
+	mux.HandleFunc("/webhook/", s.handleWebhook)
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.authorize(mux),
+		ReadHeaderTimeout: readTimeout,
+	}
+	// whoever can get to the socket is as good as us, no token needed there
+	s.socket = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readTimeout,
+	}
+
+	return s
+}
+
+// Method that starts listening, and serves requests in the background.
+// Returns an error if the address can't be listened on
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	go s.server.Serve(listener)
+
+	return nil
+}
+
+// Method that starts listening on a unix socket at the given path as well,
+// which only we can connect to, and serves requests on it in the background
+func (s *Server) StartSocket(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// a socket left behind by a run that didn't get to clean up
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go s.socket.Serve(listener)
+
+	return nil
+}
+
+// Method that stops the server, cutting off the open streams
+func (s *Server) Close() error {
+	s.socket.Close()
+	return s.server.Close()
+}
+
+// Method that wraps a handler so it only serves requests with the auth token,
+// given as a bearer token or, for clients that can't set headers, a query parameter
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or wrong token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Method that tells how far the host got starting up, and how well
+// it is connected, to be polled while it starts
+//
+//	GET /status
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET works here")
+		return
+	}
+
+	status := statusInfo{
+		Peer:              s.host.Host.ID().Pretty(),
+		Startup:           s.host.Startup(),
+		Peers:             len(s.host.Host.Network().Peers()),
+		BootstrapRetrying: s.host.BootstrapRetrying(),
+		Reachability:      s.host.Reachability().String(),
+	}
+	if s.host.KadDHT != nil {
+		status.Routing = s.host.KadDHT.RoutingTable().Size()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Method that lists the joined rooms, or joins one
+//
+//	GET  /rooms
+//	POST /rooms {"room": "name"}
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rooms := s.rooms.Rooms()
+		infos := make([]roomInfo, len(rooms))
+		for i, cr := range rooms {
+			infos[i] = newRoomInfo(cr)
+		}
+		writeJSON(w, http.StatusOK, infos)
+
+	case http.MethodPost:
+		var req struct {
+			Room string `json:"room"`
+		}
+		if err := readJSON(w, r, &req); err != nil || len(req.Room) == 0 {
+			writeError(w, http.StatusBadRequest, "expected {\"room\": \"name\"}")
+			return
+		}
+
+		cr, err := s.rooms.Join(req.Room)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("could not join room: %s", err))
+			return
+		}
+		writeJSON(w, http.StatusCreated, newRoomInfo(cr))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "only GET and POST work here")
+	}
+}
+
+// Method that serves the messages and peers of a joined room
+//
+//	GET  /rooms/<room>/messages
+//	POST /rooms/<room>/messages {"message": "text"}
+//	GET  /rooms/<room>/peers
+func (s *Server) handleRoom(w http.ResponseWriter, r *http.Request) {
+	// room names can have a # in them, which has to be sent as %23
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/rooms/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "expected /rooms/<room>/messages or /rooms/<room>/peers")
+		return
+	}
+
+	roomName, err := url.PathUnescape(path[:i])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "bad room name")
+		return
+	}
+	cr := s.findRoom(roomName)
+	if cr == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not in room %s", roomName))
+		return
+	}
+
+	switch resource := path[i+1:]; {
+	case resource == "messages" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.recentMessages(cr.RoomName))
+
+	case resource == "messages" && r.Method == http.MethodPost:
+		var req struct {
+			Message string `json:"message"`
+		}
+		if err := readJSON(w, r, &req); err != nil || len(strings.TrimSpace(req.Message)) == 0 {
+			writeError(w, http.StatusBadRequest, "expected {\"message\": \"text\"}")
+			return
+		}
+
+		msg, err := s.rooms.Send(cr, req.Message)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, roomMessage{Room: cr.RoomName, ChatMessage: msg})
+
+	case resource == "peers" && r.Method == http.MethodGet:
+		peers := []peerInfo{}
+		for _, id := range cr.GetPeers() {
+			info := peerInfo{ID: id.Pretty()}
+			if prof := cr.Host.Profiles.Lookup(id); prof != nil {
+				info.Username = prof.Username
+			}
+			peers = append(peers, info)
+		}
+		writeJSON(w, http.StatusOK, peers)
+
+	case resource == "messages" || resource == "peers":
+		writeError(w, http.StatusMethodNotAllowed, "wrong method for this endpoint")
+
+	default:
+		writeError(w, http.StatusNotFound, "expected /rooms/<room>/messages or /rooms/<room>/peers")
+	}
+}
+
+// Method that returns the joined room by its full name, or by its plain
+// name if just one joined room goes by it. Nil if there is none
+func (s *Server) findRoom(roomName string) *chat.ChatRoom {
+	var found *chat.ChatRoom
+	matches := 0
+	for _, cr := range s.rooms.Rooms() {
+		if cr.RoomName == roomName {
+			return cr
+		}
+		if name, _ := p2p.SplitRoomName(cr.RoomName); name == roomName {
+			found = cr
+			matches++
+		}
+	}
+
+	if matches != 1 {
+		return nil
+	}
+
+	return found
+}
+
+// This one describes a joined room
+func newRoomInfo(cr *chat.ChatRoom) roomInfo {
+	name, _ := p2p.SplitRoomName(cr.RoomName)
+	return roomInfo{Room: cr.RoomName, Name: name, Peers: len(cr.GetPeers()), Password: cr.HasPassword(), Queues: cr.QueueStats()}
+}
+
+// This one decodes the JSON body of a request
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+}
+
+// This one writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// This one writes a JSON error response with the given status
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
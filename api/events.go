@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// most messages kept for each room, for clients that ask after the fact
+const maxRecentMessages = 200
+
+// messages a stream can fall behind by before it starts missing them
+const streamBuffer = 64
+
+// how often an idle stream gets a comment, so proxies don't cut it
+const streamKeepAlive = 15 * time.Second
+
+// Method that hands a message seen in a room over to the API, keeping it
+// with the recent ones and passing it to the open streams following the room
+func (s *Server) Deliver(roomName string, msg chat.ChatMessage) {
+	// receipts only say who has seen what, too many to be of use here
+	if msg.Type == chat.MessageReceipt {
+		return
+	}
+	rm := roomMessage{Room: roomName, ChatMessage: msg}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	recent := append(s.recent[roomName], rm)
+	if len(recent) > maxRecentMessages {
+		recent = recent[len(recent)-maxRecentMessages:]
+	}
+	s.recent[roomName] = recent
+
+	for stream, room := range s.streams {
+		if len(room) > 0 && room != roomName {
+			continue
+		}
+
+		// slow clients miss messages, rather than holding up the room
+		select {
+		case stream <- rm:
+		default:
+		}
+	}
+}
+
+// Method that returns the messages recently seen in a room, oldest first
+func (s *Server) recentMessages(roomName string) []roomMessage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]roomMessage{}, s.recent[roomName]...)
+}
+
+// Method that streams the messages seen in the joined rooms as server-sent
+// events, until the client goes away. A room can be picked with ?room=
+//
+//	GET /events
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET works here")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	room, ok := s.streamRoom(w, r)
+	if !ok {
+		return
+	}
+
+	stream := s.subscribe(room)
+	defer s.unsubscribe(stream)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+
+		case rm := <-stream:
+			data, err := json.Marshal(rm)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		}
+
+		flusher.Flush()
+	}
+}
+
+// Method that returns the full name of the room a stream asked to follow
+// with ?room=, empty for all of them. Writes the error if we are not in it
+func (s *Server) streamRoom(w http.ResponseWriter, r *http.Request) (string, bool) {
+	room := r.URL.Query().Get("room")
+	if len(room) == 0 {
+		return "", true
+	}
+
+	cr := s.findRoom(room)
+	if cr == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not in room %s", room))
+		return "", false
+	}
+
+	return cr.RoomName, true
+}
+
+// Method that opens a stream of the messages seen in the room,
+// or in all of them if the room is empty
+func (s *Server) subscribe(room string) chan roomMessage {
+	stream := make(chan roomMessage, streamBuffer)
+
+	s.lock.Lock()
+	s.streams[stream] = room
+	s.lock.Unlock()
+
+	return stream
+}
+
+// Method that closes a stream opened with subscribe
+func (s *Server) unsubscribe(stream chan roomMessage) {
+	s.lock.Lock()
+	delete(s.streams, stream)
+	s.lock.Unlock()
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// random bytes in a generated auth token
+const tokenSize = 32
+
+// This one loads the auth token of the API from the given file,
+// generating and saving a new one if the file doesn't exist yet
+func LoadToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if token := strings.TrimSpace(string(data)); len(token) > 0 {
+			return token, nil
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	raw := make([]byte, tokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	// only we get to read it, anyone who can is as good as us
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Method that sends a message pushed by another program to a room, joining the
+// room first if we aren't in it, so cron jobs and CI pipelines can announce
+// things without keeping up with the API. The body is the text itself, or
+// JSON carrying it as text or message, like webhooks elsewhere send it
+//
+//	POST /webhook/<room>
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "only POST works here")
+		return
+	}
+
+	roomName, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/webhook/"))
+	if err != nil || len(roomName) == 0 || strings.Contains(roomName, "/") {
+		writeError(w, http.StatusNotFound, "expected /webhook/<room>")
+		return
+	}
+
+	text, err := readWebhookText(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cr := s.findRoom(roomName)
+	if cr == nil {
+		if cr, err = s.rooms.Join(roomName); err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("could not join room: %s", err))
+			return
+		}
+	}
+
+	msg, err := s.rooms.Send(cr, text)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, roomMessage{Room: cr.RoomName, ChatMessage: msg})
+}
+
+// This one reads the text of a message pushed to a room, from
+// a plain body or from JSON with it as text or message
+func readWebhookText(w http.ResponseWriter, r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return "", fmt.Errorf("could not read the body: %s", err)
+	}
+
+	text := string(body)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req struct {
+			Text    string `json:"text"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			return "", fmt.Errorf("expected {\"text\": \"message\"}")
+		}
+
+		text = req.Text
+		if len(text) == 0 {
+			text = req.Message
+		}
+	}
+
+	if text = strings.TrimSpace(text); len(text) == 0 {
+		return "", fmt.Errorf("nothing to send")
+	}
+
+	return text, nil
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// how long writing a single frame to a web client may take
+const socketWriteTimeout = 10 * time.Second
+
+// how long a web client may stay silent, pongs included, before it's cut off
+const socketReadTimeout = 2 * streamKeepAlive
+
+// largest frame a web client may send
+const maxSocketFrame = maxBodySize
+
+// a message a web client sends to one of the joined rooms
+type socketRequest struct {
+	Room    string `json:"room"`
+	Message string `json:"message"`
+}
+
+// what a web client gets back when its message could not be sent
+type socketError struct {
+	Room  string `json:"room,omitempty"`
+	Error string `json:"error"`
+}
+
+// the token is what keeps other sites out, browsers can't
+// keep it secret from the page they run anyway
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// Method that relays the messages of the joined rooms to a web client
+// over a WebSocket, and sends the messages it writes to their rooms.
+// A room can be picked with ?room=, and the token given with ?token=
+//
+//	GET /ws
+func (s *Server) handleSocket(w http.ResponseWriter, r *http.Request) {
+	room, ok := s.streamRoom(w, r)
+	if !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has answered the client already
+		return
+	}
+	defer conn.Close()
+
+	stream := s.subscribe(room)
+	defer s.unsubscribe(stream)
+
+	// replies to the client, written by the relay along with the messages
+	replies := make(chan interface{}, streamBuffer)
+	done := make(chan struct{})
+	defer close(done)
+
+	go s.relaySocket(conn, stream, replies, done)
+
+	conn.SetReadLimit(maxSocketFrame)
+	conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			// closed by the client, or gone quiet
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(socketReadTimeout))
+
+		// sent messages come back through the stream, only failures need a reply
+		var req socketRequest
+		err = json.Unmarshal(data, &req)
+		if err == nil {
+			err = s.sendFromSocket(req)
+		}
+		if err != nil {
+			select {
+			case replies <- socketError{Room: req.Room, Error: err.Error()}:
+			default:
+			}
+		}
+	}
+}
+
+// Method that sends a message a web client wrote to its room
+func (s *Server) sendFromSocket(req socketRequest) error {
+	if len(strings.TrimSpace(req.Message)) == 0 {
+		return fmt.Errorf("expected {\"room\": \"name\", \"message\": \"text\"}")
+	}
+
+	cr := s.findRoom(req.Room)
+	if cr == nil {
+		return fmt.Errorf("not in room %s", req.Room)
+	}
+
+	_, err := s.rooms.Send(cr, req.Message)
+	return err
+}
+
+// Method that writes the streamed messages and the replies to a web client,
+// pinging it while things are quiet, until the connection is done
+func (s *Server) relaySocket(conn *websocket.Conn, stream chan roomMessage, replies chan interface{}, done chan struct{}) {
+	ping := time.NewTicker(streamKeepAlive)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-done:
+			return
+
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(socketWriteTimeout))
+
+		case rm := <-stream:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			err = conn.WriteJSON(rm)
+
+		case reply := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+			err = conn.WriteJSON(reply)
+		}
+
+		// the read loop notices too, and cleans up
+		if err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// how often messages that disappeared by now are purged
+const expireInterval = time.Minute
+
+// Server is an always-on member of rooms, keeping every message said in
+// them and handing the history of a room to the peers in it that ask,
+// so they can catch up on what was said while they were away. It only
+// remembers, messages still go from peer to peer
+type Server struct {
+	host    *p2p.P2P
+	history *chat.History
+
+	// lock for the rooms
+	lock sync.Mutex
+	// rooms kept, by name
+	rooms map[string]*chat.ChatRoom
+}
+
+// Constructor function for an archive keeping the history of the rooms
+// added to it, which registers the history sync stream handler on the host
+func NewServer(host *p2p.P2P, history *chat.History) *Server {
+	s := &Server{
+		host:    host,
+		history: history,
+		rooms:   make(map[string]*chat.ChatRoom),
+	}
+
+	host.Host.SetStreamHandler(syncProtocol, s.handleStream)
+
+	return s
+}
+
+// Method that starts keeping the messages of a joined room, and
+// answering the peers in it that ask for its history
+func (s *Server) Keep(cr *chat.ChatRoom) {
+	s.lock.Lock()
+	s.rooms[cr.RoomName] = cr
+	s.lock.Unlock()
+
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		if err := s.apply(cr.RoomName, msg); err != nil {
+			s.host.Logger.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  cr.RoomName,
+			}).Errorln("Updating the archive failed")
+		}
+	})
+	cr.OnLog(func(log chat.ChatLog) {
+		s.host.Logger.WithFields(logrus.Fields{
+			"room":   cr.RoomName,
+			"prefix": log.Prefix,
+		}).Debugln(log.Msg)
+	})
+}
+
+// Method that purges the messages which disappeared, until the context is done
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.history.Expire(now); err != nil {
+				s.host.Logger.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Errorln("Purging the archive failed")
+			}
+		}
+	}
+}
+
+// Method that stops answering history sync requests
+func (s *Server) Close() {
+	s.host.Host.RemoveStreamHandler(syncProtocol)
+}
+
+// Method that updates the archive of a room with a message said in it,
+// keeping new messages, and applying edits and retractions of their authors
+func (s *Server) apply(room string, msg chat.ChatMessage) error {
+	switch msg.Type {
+	case chat.MessageEdit:
+		return s.history.Edit(room, msg.Ref, msg.SenderID, msg.Message)
+
+	case chat.MessageDelete:
+		return s.history.Forget(room, msg.Ref, msg.SenderID)
+	}
+
+	rec, ok := record(room, msg)
+	if !ok {
+		return nil
+	}
+
+	return s.history.Add(rec)
+}
+
+// This one turns a message into what is kept of it, the way the terminal
+// interface keeps its history. Reports false for messages that aren't kept,
+// like reactions and votes, which only change other messages
+func record(room string, msg chat.ChatMessage) (chat.HistoryRecord, bool) {
+	text := msg.Message
+	switch {
+	case msg.Type == chat.MessageText || msg.Type == chat.MessageCommand:
+	case (msg.Type == chat.MessageImage || msg.Type == chat.MessagePaste) && msg.Attachment != nil:
+		text = msg.Attachment.Name
+	case msg.Type == chat.MessagePoll && msg.Poll != nil:
+		text = msg.Poll.Question
+	case msg.Type == chat.MessageResponse && msg.Response != nil:
+		text = msg.Response.Text
+	default:
+		return chat.HistoryRecord{}, false
+	}
+
+	now := time.Now()
+	rec := chat.HistoryRecord{
+		Room:       room,
+		ID:         msg.ID,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		Text:       text,
+		Sent:       now.Unix(),
+	}
+	if msg.TTL > 0 {
+		rec.Expires = now.Add(time.Duration(msg.TTL) * time.Second).Unix()
+	}
+
+	return rec, true
+}
+
+// This one answers a history sync request, as long as the peer
+// asking is in the room, and let in if it has a password
+func (s *Server) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(syncTimeout))
+
+	request := syncRequest{}
+	if err := json.NewDecoder(bufio.NewReader(stream)).Decode(&request); err != nil {
+		stream.Reset()
+		return
+	}
+
+	response := s.page(stream.Conn().RemotePeer(), request)
+	if err := json.NewEncoder(stream).Encode(response); err != nil {
+		stream.Reset()
+	}
+}
+
+// Method that returns the page of history the peer asked for, or why not
+func (s *Server) page(from peer.ID, request syncRequest) *syncResponse {
+	s.lock.Lock()
+	cr, ok := s.rooms[request.Room]
+	s.lock.Unlock()
+
+	if !ok {
+		return &syncResponse{Error: "the room is not archived here"}
+	}
+	if !inRoom(cr, from) || !cr.Admits(from) || s.host.PeerLists.IsBlocked(from) {
+		return &syncResponse{Error: "only peers in the room get its history"}
+	}
+
+	var since time.Time
+	if request.Since > 0 {
+		since = time.Unix(request.Since, 0)
+	}
+	records := s.history.Range(request.Room, since, time.Time{})
+
+	response := &syncResponse{Records: records}
+	if len(records) > maxPageRecords {
+		response.Records = records[:maxPageRecords]
+		response.More = true
+	}
+
+	s.host.Logger.WithFields(logrus.Fields{
+		"peer":    from.Pretty(),
+		"room":    request.Room,
+		"records": len(response.Records),
+	}).Debugln("Sent history to a peer")
+
+	return response
+}
+
+// This one checks if the peer is subscribed to the room
+func inRoom(cr *chat.ChatRoom, id peer.ID) bool {
+	for _, p := range cr.GetPeers() {
+		if p == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	host "github.com/libp2p/go-libp2p-host"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// protocol ID of the history sync stream
+const syncProtocol = protocol.ID("/p2pchat/history/1.0.0")
+
+// how long a single page of history may take to arrive
+const syncTimeout = 30 * time.Second
+
+// most records a single page carries
+const maxPageRecords = 500
+
+// most pages a single sync goes through
+const maxSyncPages = 100
+
+// what a peer asks an archive for, the records of a room seen
+// at or after a time, a page at a time
+type syncRequest struct {
+	Room  string `json:"room"`
+	Since int64  `json:"since"`
+}
+
+// a page of history an archive answers with
+type syncResponse struct {
+	Records []chat.HistoryRecord `json:"records,omitempty"`
+	// whether there are records after the page
+	More  bool   `json:"more,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// This one fetches the history of a room the archive peer kept since the
+// given time, going through it a page at a time, and adds it to the given
+// history. Records we have already are left as they are.
+// Returns how many records the archive sent
+func Sync(ctx context.Context, nodeHost host.Host, archive peer.ID, room string, since time.Time, history *chat.History) (int, error) {
+	request := syncRequest{Room: room}
+	if !since.IsZero() {
+		request.Since = since.Unix()
+	}
+
+	count := 0
+	for page := 0; page < maxSyncPages; page++ {
+		response, err := fetchPage(ctx, nodeHost, archive, request)
+		if err != nil {
+			return count, err
+		}
+
+		for _, rec := range response.Records {
+			if len(rec.ID) == 0 || rec.Sent < request.Since {
+				continue
+			}
+
+			rec.Room = room
+			rec.SenderName = p2p.SanitizeText(rec.SenderName)
+			rec.Text = p2p.SanitizeText(rec.Text)
+			if err := history.Add(rec); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		// the next page starts where this one ended, records seen in the
+		// same second come again and are skipped as ones we have
+		last := len(response.Records) - 1
+		if !response.More || last < 0 || response.Records[last].Sent <= request.Since {
+			return count, nil
+		}
+		request.Since = response.Records[last].Sent
+	}
+
+	return count, nil
+}
+
+// This one asks the archive peer for a page of history over a new stream
+func fetchPage(ctx context.Context, nodeHost host.Host, archive peer.ID, request syncRequest) (*syncResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	stream, err := nodeHost.NewStream(ctx, archive, syncProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	deadline, _ := ctx.Deadline()
+	stream.SetDeadline(deadline)
+
+	if err := json.NewEncoder(stream).Encode(request); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	response := &syncResponse{}
+	if err := json.NewDecoder(bufio.NewReader(stream)).Decode(response); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if len(response.Error) > 0 {
+		return nil, fmt.Errorf("the archive refused: %s", p2p.SanitizeText(response.Error))
+	}
+
+	return response, nil
+}
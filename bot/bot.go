@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// Options is how a bot joins the network, the zero value makes it a new
+// peer on every run, found through the public bootstrap peers
+type Options struct {
+	// path of the identity key, to stay the same peer across runs
+	Identity string
+	// peers to find the others through, instead of the public ones
+	Bootstrap []multiaddr.Multiaddr
+	// where the bot logs, the standard logger without one
+	Logger *logrus.Entry
+}
+
+// Handler is called with every message a bot sees, one at a time
+type Handler func(msg *Message)
+
+// Bot is a peer of its own that sits in rooms and hands the text messages and
+// bot commands it sees to its handlers. A bot answering pings is
+//
+//	b, err := bot.New("pingbot", bot.Options{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	b.OnMessage(func(msg *bot.Message) {
+//		if msg.Message == "ping" {
+//			msg.Reply("pong")
+//		}
+//	})
+//	if _, err := b.JoinRoom("lobby"); err != nil {
+//		log.Fatal(err)
+//	}
+//	b.Run()
+type Bot struct {
+	// username the bot goes by in its rooms
+	Name string
+
+	host *p2p.P2P
+
+	// lock for the handlers and the rooms
+	lock     sync.Mutex
+	handlers []Handler
+	rooms    []*chat.ChatRoom
+
+	// closed once the bot has left
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Message is a text message or a bot command seen in one of the rooms
+// of a bot, along with that room
+type Message struct {
+	chat.ChatMessage
+
+	// room the message was seen in
+	Room *chat.ChatRoom
+}
+
+// Constructor function for a new bot, connected to the network
+// and discovering peers, but in no room yet. Failing to get there
+// returns an error, with the host closed again if it was started
+func New(name string, opts Options) (*Bot, error) {
+	lists, err := p2p.LoadPeerLists("")
+	if err != nil {
+		return nil, fmt.Errorf("creating block and mute lists failed: %w", err)
+	}
+
+	host, err := p2p.NewP2P(context.Background(), p2p.Options{
+		Identity:  opts.Identity,
+		PeerLists: lists,
+		Bootstrap: opts.Bootstrap,
+		Logger:    opts.Logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("starting the P2P host failed: %w", err)
+	}
+	if err := host.AnnounceConnect(); err != nil {
+		host.Close()
+		return nil, fmt.Errorf("announcing the service failed: %w", err)
+	}
+
+	return &Bot{Name: name, host: host, done: make(chan struct{})}, nil
+}
+
+// Method that returns the P2P host of the bot, for what the bot API doesn't cover
+func (b *Bot) Host() *p2p.P2P {
+	return b.host
+}
+
+// Method that adds a handler for the messages the bot sees, in any room
+func (b *Bot) OnMessage(handler Handler) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+// Method that joins a room, or returns it if the bot is in it already
+func (b *Bot) JoinRoom(roomName string) (*chat.ChatRoom, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, cr := range b.rooms {
+		if cr.RoomName == roomName {
+			return cr, nil
+		}
+	}
+
+	cr, err := chat.JoinChatRoom(b.host, b.Name, roomName)
+	if err != nil {
+		return nil, err
+	}
+	b.rooms = append(b.rooms, cr)
+
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		b.handle(cr, msg)
+	})
+	cr.OnLog(func(log chat.ChatLog) {
+		b.host.Logger.WithFields(logrus.Fields{
+			"room":   cr.RoomName,
+			"prefix": log.Prefix,
+		}).Debugln(log.Msg)
+	})
+
+	return cr, nil
+}
+
+// Method that sends a text message to one of the rooms of the bot
+func (b *Bot) Send(cr *chat.ChatRoom, text string) error {
+	if err := chat.CheckMessageLength(text); err != nil {
+		return err
+	}
+
+	return cr.Send(cr.NewTextMessage(text))
+}
+
+// Method that answers the message in its room. Bot commands get a bot
+// response, which chats show along with the command, and other
+// messages get a reply quoting them
+func (msg *Message) Reply(text string) error {
+	if err := chat.CheckMessageLength(text); err != nil {
+		return err
+	}
+
+	if msg.Type == chat.MessageCommand && msg.Command != nil {
+		return msg.Room.Send(chat.ChatMessage{
+			Type:     chat.MessageResponse,
+			ID:       chat.NewMessageID(),
+			Ref:      msg.ID,
+			Response: &chat.BotResponse{Command: msg.Command.Name, Text: text},
+		})
+	}
+
+	reply := msg.Room.NewTextMessage(text)
+	reply.ReplyTo = msg.ID
+	reply.Quote = chat.NewReplyQuote(msg.SenderName, msg.Message)
+	return msg.Room.Send(reply)
+}
+
+// Method that hands a message of a room to the handlers,
+// if it is a text message or a bot command
+func (b *Bot) handle(cr *chat.ChatRoom, msg chat.ChatMessage) {
+	if msg.Type != chat.MessageText && msg.Type != chat.MessageCommand {
+		return
+	}
+
+	b.lock.Lock()
+	handlers := append([]Handler(nil), b.handlers...)
+	b.lock.Unlock()
+
+	for _, handler := range handlers {
+		handler(&Message{ChatMessage: msg, Room: cr})
+	}
+}
+
+// Method that keeps the bot in its rooms until it is closed, or the
+// process is asked to stop, leaving the rooms before returning
+func (b *Bot) Run() {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case <-stop:
+		b.Close()
+	case <-b.done:
+	}
+}
+
+// Method that leaves the rooms of the bot and closes its host
+func (b *Bot) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		b.lock.Lock()
+		rooms := b.rooms
+		b.rooms = nil
+		b.lock.Unlock()
+
+		for _, cr := range rooms {
+			cr.Leave()
+		}
+
+		err = b.host.Close()
+		close(b.done)
+	})
+
+	return err
+}
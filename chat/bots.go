@@ -0,0 +1,62 @@
+package chat
+
+import (
+	"regexp"
+	"strings"
+)
+
+// text starting with this invokes a bot, doubling it sends the text as is
+const BotPrefix = "!"
+
+// most fields a single bot response can carry
+const MaxResponseFields = 16
+
+// valid bot command names
+var BotCommandPattern = regexp.MustCompile(`^[a-z0-9_\-]{1,32}$`)
+
+// a command for the bots in the room, like !weather london
+type BotCommand struct {
+	Name string   `json:"name"`
+	Args []string `json:"args,omitempty"`
+}
+
+// a structured answer of a bot, referring to the command message
+type BotResponse struct {
+	// name of the command answered
+	Command string `json:"command"`
+	// set when the command failed
+	Error string `json:"error,omitempty"`
+
+	Text   string     `json:"text,omitempty"`
+	Fields []botField `json:"fields,omitempty"`
+}
+
+// a named value in a bot response
+type botField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// This one parses a bot command out of a message. Returns the text to send
+// instead when the message starts with a doubled prefix, to keep it as text
+func ParseBotCommand(text string) (*BotCommand, string) {
+	if !strings.HasPrefix(text, BotPrefix) {
+		return nil, text
+	}
+
+	if strings.HasPrefix(text, BotPrefix+BotPrefix) {
+		return nil, text[len(BotPrefix):]
+	}
+
+	fields := strings.Fields(text[len(BotPrefix):])
+	if len(fields) == 0 {
+		return nil, text
+	}
+
+	name := strings.ToLower(fields[0])
+	if !BotCommandPattern.MatchString(name) {
+		return nil, text
+	}
+
+	return &BotCommand{Name: name, Args: fields[1:]}, text
+}
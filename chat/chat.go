@@ -0,0 +1,1055 @@
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// longest text message that can be sent, in bytes,
+// longer text goes out as a paste attachment instead
+const maxMessageLength = 4096
+
+// default fallback chat room name
+const defaultRoomName = "lobby"
+
+// how long to wait before subscribing to a room again after losing the
+// subscription, doubling after each failed try up to the longest wait
+const (
+	resubscribeMinWait = time.Second
+	resubscribeMaxWait = 30 * time.Second
+)
+
+// how many messages and logs a room queues for its handlers, and
+// messages for publishing. A full incoming queue holds up reading the
+// room, and a full outgoing one holds up sending, while a full log
+// queue drops its oldest line for the new one
+const (
+	incomingQueue = 64
+	outgoingQueue = 64
+	logQueue      = 128
+)
+
+// chat message types, an empty type is a plain text message
+const (
+	MessageText   = ""
+	MessageImage  = "image"
+	MessageEdit   = "edit"
+	MessageDelete = "delete"
+	MessageReact  = "react"
+	MessagePoll   = "poll"
+	MessageVote   = "vote"
+
+	MessagePreview = "preview"
+	MessagePaste   = "paste"
+	MessageReceipt = "receipt"
+
+	MessageCommand  = "command"
+	MessageResponse = "response"
+
+	MessageModeration = "moderation"
+	messageChunk      = "chunk"
+
+	messageCompressed = "compressed"
+	messageSealed     = "sealed"
+)
+
+type ChatMessage struct {
+	Type       string `json:"type,omitempty"`
+	ID         string `json:"id,omitempty"`
+	Message    string `json:"message"`
+	SenderID   string `json:"senderId"`
+	SenderName string `json:"senderName"`
+	// unix time the message was sent, as the sender's clock tells
+	Sent int64 `json:"sent,omitempty"`
+
+	// ID of the message this one refers to, like the one being edited
+	Ref string `json:"ref,omitempty"`
+	// ID of the message this one replies to, and its sender and first line
+	ReplyTo string      `json:"replyTo,omitempty"`
+	Quote   *ReplyQuote `json:"quote,omitempty"`
+	// IDs of the messages a receipt says were seen
+	Refs []string `json:"refs,omitempty"`
+	// IDs of the peers mentioned in the message
+	Mentions []string `json:"mentions,omitempty"`
+	// seconds after which the message disappears, zero to keep it
+	TTL int64 `json:"ttl,omitempty"`
+
+	// image shared with the room, downloaded from the sender
+	Attachment *p2p.Attachment `json:"attachment,omitempty"`
+
+	// preview of a link in the message this one refers to
+	Preview *LinkPreview `json:"preview,omitempty"`
+
+	// command for the bots in the room, and a bots answer to one
+	Command  *BotCommand  `json:"command,omitempty"`
+	Response *BotResponse `json:"response,omitempty"`
+
+	// poll asked to the room
+	Poll *Poll `json:"poll,omitempty"`
+	// option voted for in the poll this message refers to, starting at one
+	Choice int `json:"choice,omitempty"`
+
+	// piece of a payload too large for a single message
+	Chunk *payloadChunk `json:"chunk,omitempty"`
+
+	// whole chat message, compressed
+	Compressed *compressedPayload `json:"compressed,omitempty"`
+
+	// whole chat message, encrypted with the room password
+	Sealed *p2p.SealedPayload `json:"sealed,omitempty"`
+
+	// signed moderation event
+	Moderation *moderationEvent `json:"moderation,omitempty"`
+
+	// proof-of-work stamp, in rooms demanding one
+	Stamp string `json:"stamp,omitempty"`
+}
+
+// a line for the log pane, the same the services of the host write
+type ChatLog = p2p.Log
+
+// this structure represents a PubSub Chat Room
+type ChatRoom struct {
+	// logs dropped from the full log queue, first so
+	// it is aligned for atomic use on 32-bit platforms
+	droppedLogs uint64
+
+	// P2P host for the Chat Room
+	Host *p2p.P2P
+
+	// moderation state of the room
+	Moderation *roomModeration
+
+	RoomName string
+	Username string
+	// how long our messages last, unless the room creator decides otherwise
+	TTL time.Duration
+	// host ID of the Peer
+	selfID peer.ID
+
+	// messages received, waiting for the handlers
+	incoming chan ChatMessage
+	// messages waiting to be published
+	outgoing chan ChatMessage
+	// lines logged, waiting for the handlers
+	logs chan ChatLog
+	// handlers of the messages, logs and peers joining
+	handlers *roomHandlers
+
+	// chat room lifecycle context
+	ctx context.Context
+	// chat room lifecycle cancellation function
+	cancel context.CancelFunc
+	// makes leaving the room happen once
+	leaveOnce sync.Once
+	// closed once the subscription is no longer read
+	readDone chan struct{}
+	// why the subscription is no longer read, set before readDone is closed
+	readErr error
+	// PubSub topic name of the Chat Room
+	topicName string
+	// lock for the topic and the subscription, which are replaced
+	// when they're lost, and the handler of peer events
+	subLock sync.Mutex
+	// PubSub topic of the Chat Room
+	topic *pubsub.Topic
+	// PubSub subscription for the topic
+	subscription *pubsub.Subscription
+	// set while the subscription is lost and being taken out again
+	subLost bool
+	// handler of the peer events of the topic, once asked for
+	peerEvents *pubsub.TopicEventHandler
+	// reassembly of chunked payloads
+	chunks *chunkAssembler
+	// inbound message rate limiting
+	limiter *rateLimiter
+	// proof-of-work difficulty of the room, zero for none
+	workBits int
+	// proof-of-work stamps already seen
+	stamps *stampLedger
+	// creation key of the room, if we created it
+	roomKey crypto.PrivKey
+	// password of the room and the peers that know it
+	gate *p2p.RoomGate
+
+	// lock for the protected peers
+	protectLock sync.Mutex
+	// peers whose connections the connection manager leaves alone
+	protected map[peer.ID]bool
+	// peers protected even while they aren't in the room
+	pinned map[peer.ID]bool
+}
+
+// This is a constuctor function which returns a new Chat Room
+// for a given P2P host, username and room
+func JoinChatRoom(host *p2p.P2P, username string, roomName string) (*ChatRoom, error) {
+	if len(username) == 0 {
+		username = p2p.DefaultUsername
+	}
+
+	if len(roomName) == 0 {
+		roomName = defaultRoomName
+	}
+
+	// rooms we created come with their creation key
+	_, fingerprint := p2p.SplitRoomName(roomName)
+	roomKey, err := host.RoomKeys.Load(fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the room creation key: %s", err)
+	}
+
+	// create cancellable context, done too once the host is closed
+	pubSubCtx, cancel := context.WithCancel(host.Ctx)
+
+	topicName := fmt.Sprintf("p2p-room-%s", roomName)
+	// rooms demanding proof-of-work are rooms of their own
+	if host.ProofOfWork > 0 {
+		topicName = fmt.Sprintf("%s-pow%d", topicName, host.ProofOfWork)
+	}
+
+	chatRoom := &ChatRoom{
+		Host: host,
+
+		Moderation: newRoomModeration(topicName, fingerprint),
+
+		incoming: make(chan ChatMessage, incomingQueue),
+		outgoing: make(chan ChatMessage, outgoingQueue),
+		logs:     make(chan ChatLog, logQueue),
+		handlers: newRoomHandlers(),
+
+		ctx:       pubSubCtx,
+		cancel:    cancel,
+		readDone:  make(chan struct{}),
+		topicName: topicName,
+		chunks:    newChunkAssembler(),
+		limiter:   newRateLimiter(host.RateLimit),
+		workBits:  host.ProofOfWork,
+		stamps:    newStampLedger(),
+		roomKey:   roomKey,
+		gate:      p2p.NewRoomGate(),
+		protected: make(map[peer.ID]bool),
+		pinned:    make(map[peer.ID]bool),
+
+		RoomName: roomName,
+		Username: username,
+		selfID:   host.Host.ID(),
+	}
+
+	// validate messages before they get delivered or relayed
+	if err := host.PubSub.RegisterTopicValidator(topicName, chatRoom.validateMessage); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// create PubSub topic with the room name
+	topic, err := host.PubSub.Join(topicName)
+	if err != nil {
+		host.PubSub.UnregisterTopicValidator(topicName)
+		cancel()
+		return nil, err
+	}
+
+	// subscribe to the PubSub topic
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		host.PubSub.UnregisterTopicValidator(topicName)
+		cancel()
+		return nil, err
+	}
+
+	chatRoom.topic = topic
+	chatRoom.subscription = sub
+
+	// answer password challenges for the room
+	host.Auth.Register(topicName, chatRoom.gate)
+
+	// let peers know who we are
+	host.Profiles.SetUsername(username)
+
+	// start handing messages and logs to the handlers, once there are any
+	go chatRoom.dispatchMessages()
+	go chatRoom.dispatchLogs()
+	// start reading subscribtions
+	go chatRoom.readSub()
+	// start publishing
+	go chatRoom.PubMessages()
+	// start claiming the room and rebroadcasting its moderation state, if it is ours
+	go chatRoom.republishModeration()
+	// start challenging peers, once the room has a password
+	go chatRoom.challengePeers()
+	// keep the connections to the peers in the room open
+	go chatRoom.protectPeers()
+
+	return chatRoom, nil
+}
+
+// Method that publishes chat messages, and
+// does so in a loop until the pubsub context is canceled
+func (cr *ChatRoom) PubMessages() {
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return
+
+		case msg := <-cr.outgoing:
+			// publish the chat message
+			cr.publish(msg)
+		}
+	}
+}
+
+// This one checks that the text fits in a single message
+func CheckMessageLength(text string) error {
+	if len(text) > maxMessageLength {
+		return fmt.Errorf("message is %s, the limit is %s", p2p.FormatSize(int64(len(text))), p2p.FormatSize(maxMessageLength))
+	}
+
+	return nil
+}
+
+// Method that makes a text message for the room, or a bot command if it is one,
+// with the mentions in it resolved to peers so receivers don't have to guess
+func (cr *ChatRoom) NewTextMessage(text string) ChatMessage {
+	// bot commands keep their text, for anyone who can't read them
+	cmd, text := ParseBotCommand(text)
+
+	msg := ChatMessage{Type: MessageText, ID: NewMessageID(), Message: text, TTL: int64(cr.MessageTTL() / time.Second)}
+	if cmd != nil {
+		msg.Type = MessageCommand
+		msg.Command = cmd
+	}
+
+	for _, p := range cr.ResolveMentions(ParseMentions(text)) {
+		msg.Mentions = append(msg.Mentions, p.Pretty())
+	}
+
+	return msg
+}
+
+// Method that stamps the chat message with our identity,
+// and publishes it to the topic
+func (cr *ChatRoom) publish(chatMsg ChatMessage) {
+	// oversized text would be dropped by everyone anyway
+	if err := CheckMessageLength(chatMsg.Message); err != nil {
+		cr.Log(ChatLog{
+			Prefix: "puberr",
+			Msg:    err.Error(),
+		})
+		return
+	}
+
+	chatMsg.SenderName = cr.Username
+	chatMsg.SenderID = cr.selfID.Pretty()
+	if len(chatMsg.ID) == 0 {
+		chatMsg.ID = NewMessageID()
+	}
+	if chatMsg.Sent == 0 {
+		chatMsg.Sent = time.Now().Unix()
+	}
+
+	// serialize the chat message into JSON
+	msgBytes, err := json.Marshal(chatMsg)
+	if err != nil {
+		cr.Log(ChatLog{
+			Prefix: "puberr",
+			Msg:    "could not marshal JSON",
+		})
+		return
+	}
+
+	// large payloads are compressed, if everyone in the room can read them
+	if cr.Host.Compression && len(msgBytes) > compressThreshold && cr.peersSupport(p2p.FeatureGzip) {
+		if wrapped := cr.compress(msgBytes); wrapped != nil {
+			chatMsg = *wrapped
+			msgBytes, err = json.Marshal(chatMsg)
+		}
+	}
+
+	// password protected rooms only see sealed messages
+	if secret := cr.gate.Secret(); err == nil && secret != nil {
+		var sealed *p2p.SealedPayload
+		if sealed, err = secret.Seal(msgBytes); err == nil {
+			chatMsg = ChatMessage{
+				Type:     messageSealed,
+				SenderID: cr.selfID.Pretty(),
+				Sealed:   sealed,
+			}
+			msgBytes, err = json.Marshal(chatMsg)
+		}
+	}
+
+	// only the envelope that goes out needs a stamp, chunks get their own
+	if err == nil && cr.workBits > 0 && len(msgBytes) <= chunkSize {
+		chatMsg.Stamp = cr.mintStamp()
+		msgBytes, err = json.Marshal(chatMsg)
+	}
+
+	if err != nil {
+		cr.Log(ChatLog{
+			Prefix: "puberr",
+			Msg:    "could not marshal JSON",
+		})
+		return
+	}
+
+	// payloads over the pubsub limit go out in chunks
+	if len(msgBytes) > chunkSize {
+		cr.publishChunks(msgBytes)
+		return
+	}
+
+	if err := cr.currentTopic().Publish(cr.ctx, msgBytes); err != nil {
+		cr.Log(ChatLog{
+			Prefix: "puberr",
+			Msg:    "could not publish message to topic",
+		})
+	}
+}
+
+// Method that wraps a serialized message into a compressed one,
+// returning nil if compression doesn't pay off
+func (cr *ChatRoom) compress(payload []byte) *ChatMessage {
+	compressed, err := compressPayload(payload)
+	if err != nil || compressed == nil {
+		return nil
+	}
+
+	return &ChatMessage{
+		Type:       messageCompressed,
+		SenderName: cr.Username,
+		SenderID:   cr.selfID.Pretty(),
+		Compressed: compressed,
+	}
+}
+
+// Method that checks if every peer in the room announced the feature
+func (cr *ChatRoom) peersSupport(feature string) bool {
+	for _, p := range cr.GetPeers() {
+		prof := cr.Host.Profiles.Lookup(p)
+		if prof == nil || !prof.Supports(feature) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Method that splits a serialized message into chunks and publishes them in order
+func (cr *ChatRoom) publishChunks(payload []byte) {
+	chunks, err := splitChunks(payload)
+	if err != nil {
+		cr.Log(ChatLog{
+			Prefix: "puberr",
+			Msg:    "could not split message into chunks",
+		})
+		return
+	}
+
+	for i := range chunks {
+		chunkMsg := ChatMessage{
+			Type:       messageChunk,
+			SenderName: cr.Username,
+			SenderID:   cr.selfID.Pretty(),
+			Chunk:      &chunks[i],
+		}
+		if cr.workBits > 0 {
+			chunkMsg.Stamp = cr.mintStamp()
+		}
+
+		chunkBytes, err := json.Marshal(chunkMsg)
+		if err != nil {
+			cr.Log(ChatLog{
+				Prefix: "puberr",
+				Msg:    "could not marshal JSON",
+			})
+			return
+		}
+
+		if err := cr.currentTopic().Publish(cr.ctx, chunkBytes); err != nil {
+			cr.Log(ChatLog{
+				Prefix: "puberr",
+				Msg:    fmt.Sprintf("could not publish chunk %d of %d", i+1, len(chunks)),
+			})
+			return
+		}
+	}
+}
+
+// Method that reads messages from the subscription until the room is left
+// or its host is closed, waiting on the subscription in between. A lost
+// subscription is taken out again. Received messages are handed to the
+// decoders, which parse them for the message handlers. Once it stops, and
+// the decoders are done, Done is closed and Err says why
+func (cr *ChatRoom) readSub() {
+	defer close(cr.readDone)
+
+	decoders := cr.startDecoders()
+	defer decoders.stop()
+
+	for {
+		msg, err := cr.currentSub().Next(cr.ctx)
+		if err != nil {
+			// leaving the room, or closing the host, ends the subscription too
+			if cr.ctx.Err() != nil {
+				cr.readErr = cr.stopReason()
+				return
+			}
+			cr.Log(ChatLog{
+				Prefix: "suberr",
+				Msg:    fmt.Sprintf("subscription has closed, subscribing again: %s", err),
+			})
+			if !cr.resubscribe() {
+				cr.readErr = cr.stopReason()
+				return
+			}
+			continue
+		}
+
+		if !decoders.dispatch(cr.ctx, msg) {
+			cr.readErr = cr.stopReason()
+			return
+		}
+	}
+}
+
+// Method that returns why the room stopped, nil if it was left
+func (cr *ChatRoom) stopReason() error {
+	if err := cr.Host.Ctx.Err(); err != nil {
+		return fmt.Errorf("the host was closed: %w", err)
+	}
+
+	return nil
+}
+
+// Method that turns a message read from the subscription into the chat message
+// to hand to the handlers. Returns nil for messages they never see, like our
+// own, the ones of blocked peers and chunks of a message still incomplete.
+// Moderation events are returned without being applied, see moderate.
+// What couldn't be read is logged
+func (cr *ChatRoom) receive(msg *pubsub.Message) *ChatMessage {
+	// check if message is from self
+	if msg.ReceivedFrom == cr.selfID {
+		return nil
+	}
+
+	// blocked peers don't get a word in
+	if cr.Host.PeerLists.IsBlocked(msg.GetFrom()) {
+		return nil
+	}
+
+	cm, err := cr.decodeMessage(msg)
+	if err != nil {
+		cr.Log(ChatLog{
+			Prefix: "suberr",
+			Msg:    err.Error(),
+		})
+		return nil
+	}
+
+	// still waiting for the rest of the chunks
+	if cm == nil {
+		return nil
+	}
+
+	return cm
+}
+
+// Method that applies a received moderation event, reporting whether it
+// should reach the handlers. Events only do when they change something
+func (cr *ChatRoom) moderate(cm *ChatMessage) bool {
+	if cm.Moderation == nil {
+		return false
+	}
+
+	changed, err := cr.Moderation.Apply(cm.Moderation)
+	if err != nil {
+		cr.Log(ChatLog{
+			Prefix: "moderr",
+			Msg:    err.Error(),
+		})
+		return false
+	}
+
+	return changed
+}
+
+// Method that decodes a received pubsub message into a chat message,
+// reassembling chunked ones and opening sealed ones. Returns nil while
+// chunks are still missing, or for sealed messages we have no password for
+func (cr *ChatRoom) decodeMessage(msg *pubsub.Message) (*ChatMessage, error) {
+	cm := &ChatMessage{}
+	if err := json.Unmarshal(msg.Data, cm); err != nil {
+		return nil, fmt.Errorf("could not unmarshal JSON")
+	}
+
+	if cm.Type == messageChunk {
+		// take the chance to forget about chunks that never made it
+		for _, p := range cr.chunks.Expire(time.Now()) {
+			cr.Log(ChatLog{
+				Prefix: "suberr",
+				Msg:    fmt.Sprintf("chunked message from %s timed out", p2p.ShortID(p)),
+			})
+		}
+
+		if cm.Chunk == nil {
+			return nil, fmt.Errorf("chunk message without a chunk")
+		}
+
+		payload, err := cr.chunks.Add(msg.GetFrom(), cm.Chunk)
+		if err != nil || payload == nil {
+			return nil, err
+		}
+
+		cm = &ChatMessage{}
+		if err := json.Unmarshal(payload, cm); err != nil {
+			return nil, fmt.Errorf("could not unmarshal chunked JSON")
+		}
+	}
+
+	if cm.Type == messageSealed {
+		if cm.Sealed == nil {
+			return nil, fmt.Errorf("sealed message without a payload")
+		}
+
+		secret := cr.gate.Secret()
+		if secret == nil {
+			if cr.gate.WarnOnce() {
+				return nil, fmt.Errorf("this room is password protected, /password <password> to join in")
+			}
+			return nil, nil
+		}
+
+		payload, err := secret.Open(cm.Sealed)
+		if err != nil {
+			return nil, fmt.Errorf("could not open message from %s, is the password right?", p2p.ShortID(msg.GetFrom()))
+		}
+
+		cm = &ChatMessage{}
+		if err := json.Unmarshal(payload, cm); err != nil {
+			return nil, fmt.Errorf("could not unmarshal sealed JSON")
+		}
+	}
+
+	if cm.Type == messageCompressed {
+		if cm.Compressed == nil {
+			return nil, fmt.Errorf("compressed message without a payload")
+		}
+
+		payload, err := decompressPayload(cm.Compressed)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress message: %s", err)
+		}
+
+		cm = &ChatMessage{}
+		if err := json.Unmarshal(payload, cm); err != nil {
+			return nil, fmt.Errorf("could not unmarshal compressed JSON")
+		}
+	}
+
+	// wrappers are only ever one level deep, and in this order
+	if cm.Type == messageChunk || cm.Type == messageCompressed || cm.Type == messageSealed {
+		return nil, fmt.Errorf("nested %s message", cm.Type)
+	}
+
+	if err := CheckMessageLength(cm.Message); err != nil {
+		return nil, fmt.Errorf("dropped message from %s: %s", p2p.ShortID(msg.GetFrom()), err)
+	}
+
+	// the claimed sender can't be trusted, the signed message author can
+	cm.SenderID = msg.GetFrom().Pretty()
+
+	// nothing a peer sends gets to mess with our terminal
+	sanitizeMessage(cm)
+
+	return cm, nil
+}
+
+// Method that returns how long messages sent to the room last,
+// the setting of the room creator comes before our own
+func (cr *ChatRoom) MessageTTL() time.Duration {
+	if ttl := cr.Moderation.TTL(); ttl > 0 {
+		return ttl
+	}
+
+	return cr.TTL
+}
+
+// Method that sets the room password, or removes it when empty.
+// Our messages are sealed with it, and peers have to prove they
+// know it before we deliver or relay anything they send
+func (cr *ChatRoom) SetPassword(password string) error {
+	if len(password) == 0 {
+		cr.gate.SetSecret(nil)
+		return nil
+	}
+
+	secret, err := p2p.DeriveRoomSecret(cr.topicName, password)
+	if err != nil {
+		return err
+	}
+
+	cr.gate.SetSecret(secret)
+	go cr.challengeAll()
+
+	return nil
+}
+
+// Method that checks if the room has a password
+func (cr *ChatRoom) HasPassword() bool {
+	return cr.gate.Secret() != nil
+}
+
+// Method that checks if the peer may talk in the room, which in rooms
+// with a password it may once it proved it knows it
+func (cr *ChatRoom) Admits(id peer.ID) bool {
+	return cr.gate.Admits(id)
+}
+
+// Method that challenges every peer in the room that hasn't proven
+// it knows the password yet, now and then, until the room is left
+func (cr *ChatRoom) challengePeers() {
+	ticker := time.NewTicker(p2p.RoomAuthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return
+
+		case <-ticker.C:
+			cr.challengeAll()
+		}
+	}
+}
+
+// Method that challenges the peers in the room once
+func (cr *ChatRoom) challengeAll() {
+	for _, p := range cr.GetPeers() {
+		cr.challenge(p)
+	}
+}
+
+// Method that challenges a peer to prove it knows the room password,
+// unless it already did or was challenged a moment ago
+func (cr *ChatRoom) challenge(id peer.ID) {
+	if !cr.gate.ShouldChallenge(id, time.Now()) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(cr.ctx, p2p.RoomAuthTimeout)
+	defer cancel()
+
+	if err := cr.Host.Auth.Challenge(ctx, cr.topicName, cr.gate, id); err != nil && cr.gate.FirstFailure(id) {
+		cr.Log(ChatLog{
+			Prefix: "autherr",
+			Msg:    fmt.Sprintf("%s did not pass the password check: %s", p2p.ShortID(id), err),
+		})
+	}
+}
+
+// Method that mints a proof-of-work stamp for our next message to the room
+func (cr *ChatRoom) mintStamp() string {
+	return mintStamp(stampResource(cr.topicName, cr.selfID), cr.workBits)
+}
+
+// This one generates a random chat message ID
+func NewMessageID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// Method that returns a list of all peer IDs
+// connected to the Chat Room
+func (cr *ChatRoom) GetPeers() []peer.ID {
+	return cr.currentTopic().ListPeers()
+}
+
+// Method that returns a channel told about every peer joining the Chat Room
+// from now on, blocked peers aside, until the room is left. Peer events
+// lost along with the topic are followed again on the topic joined again
+func (cr *ChatRoom) peerJoins() (<-chan peer.ID, error) {
+	handler, present, err := cr.watchPeers()
+	if err != nil {
+		return nil, err
+	}
+
+	joins := make(chan peer.ID)
+	go func() {
+		defer close(joins)
+
+		for {
+			event, err := handler.NextPeerEvent(cr.ctx)
+			if err != nil {
+				if handler, present = cr.watchPeersAgain(); handler == nil {
+					return
+				}
+				continue
+			}
+
+			if event.Type == pubsub.PeerLeave || present[event.Peer] {
+				delete(present, event.Peer)
+				continue
+			}
+			if cr.Host.PeerLists.IsBlocked(event.Peer) {
+				continue
+			}
+
+			select {
+			case joins <- event.Peer:
+			case <-cr.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return joins, nil
+}
+
+// Method that starts following the peer events of the topic, in place of
+// whatever followed them before. Returns the peers already here as well,
+// which are reported as joining first, and are to be skipped
+func (cr *ChatRoom) watchPeers() (*pubsub.TopicEventHandler, map[peer.ID]bool, error) {
+	present := make(map[peer.ID]bool)
+	for _, p := range cr.GetPeers() {
+		present[p] = true
+	}
+
+	handler, err := cr.currentTopic().EventHandler()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cr.subLock.Lock()
+	if cr.peerEvents != nil {
+		cr.peerEvents.Cancel()
+	}
+	cr.peerEvents = handler
+	cr.subLock.Unlock()
+
+	return handler, present, nil
+}
+
+// Method that follows the peer events again after they were lost, waiting
+// longer after each failed try. Returns nil if the room was left meanwhile
+func (cr *ChatRoom) watchPeersAgain() (*pubsub.TopicEventHandler, map[peer.ID]bool) {
+	wait := resubscribeMinWait
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return nil, nil
+		case <-time.After(wait):
+		}
+
+		handler, present, err := cr.watchPeers()
+		if err == nil {
+			return handler, present
+		}
+
+		if wait *= 2; wait > resubscribeMaxWait {
+			wait = resubscribeMaxWait
+		}
+		cr.Log(ChatLog{
+			Prefix: "suberr",
+			Msg:    fmt.Sprintf("following peers joining failed, trying again in %s: %s", wait, err),
+		})
+	}
+}
+
+// Method that finds a Chat Room peer by its full ID
+// or by the suffix displayed in the peer list
+func (cr *ChatRoom) FindPeer(id string) (peer.ID, error) {
+	var found []peer.ID
+	for _, p := range cr.GetPeers() {
+		if p.Pretty() == id {
+			return p, nil
+		}
+		if strings.HasSuffix(p.Pretty(), id) {
+			found = append(found, p)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("no peer matching %s in the room", id)
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("%d peers match %s, be more specific", len(found), id)
+	}
+}
+
+// Method that returns the topic in use
+func (cr *ChatRoom) currentTopic() *pubsub.Topic {
+	cr.subLock.Lock()
+	defer cr.subLock.Unlock()
+
+	return cr.topic
+}
+
+// Method that returns the subscription in use
+func (cr *ChatRoom) currentSub() *pubsub.Subscription {
+	cr.subLock.Lock()
+	defer cr.subLock.Unlock()
+
+	return cr.subscription
+}
+
+// Method that returns the peer ID we are chatting as
+func (cr *ChatRoom) SelfID() peer.ID {
+	return cr.selfID
+}
+
+// Method that returns the context of the room,
+// which is done once the room is left
+func (cr *ChatRoom) Context() context.Context {
+	return cr.ctx
+}
+
+// Method that reports if the subscription was lost and
+// is still being taken out again
+func (cr *ChatRoom) SubscriptionLost() bool {
+	cr.subLock.Lock()
+	defer cr.subLock.Unlock()
+
+	return cr.subLost
+}
+
+// Method that subscribes to the topic again after the subscription was lost,
+// waiting longer after each failed try, each of which is logged. Returns
+// false if the room was left in the meantime
+func (cr *ChatRoom) resubscribe() bool {
+	cr.subLock.Lock()
+	cr.subLost = true
+	cr.subLock.Unlock()
+
+	wait := resubscribeMinWait
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+
+		sub, err := cr.subscribeAgain()
+		if err != nil {
+			if wait *= 2; wait > resubscribeMaxWait {
+				wait = resubscribeMaxWait
+			}
+			cr.Log(ChatLog{
+				Prefix: "suberr",
+				Msg:    fmt.Sprintf("subscribing again failed, trying again in %s: %s", wait, err),
+			})
+			continue
+		}
+
+		cr.subLock.Lock()
+		cr.subscription = sub
+		cr.subLost = false
+		cr.subLock.Unlock()
+
+		cr.Log(ChatLog{Prefix: "sub", Msg: "subscribed to the room again"})
+		return true
+	}
+}
+
+// Method that takes out a new subscription to the topic. A topic that can't
+// be subscribed to anymore, like one closed under us, is joined again, in
+// place of the old one. Following peer events stops along with it, and
+// starts again on the topic joined again
+func (cr *ChatRoom) subscribeAgain() (*pubsub.Subscription, error) {
+	topic := cr.currentTopic()
+	sub, err := topic.Subscribe()
+	if err == nil {
+		return sub, nil
+	}
+
+	// the topic can only be joined again once nothing uses the old one
+	cr.subLock.Lock()
+	if cr.peerEvents != nil {
+		cr.peerEvents.Cancel()
+	}
+	cr.subLock.Unlock()
+	topic.Close()
+
+	rejoined, joinErr := cr.Host.PubSub.Join(cr.topicName)
+	if joinErr != nil {
+		return nil, fmt.Errorf("%s, and joining the room again failed: %w", err, joinErr)
+	}
+	sub, err = rejoined.Subscribe()
+	if err != nil {
+		rejoined.Close()
+		return nil, err
+	}
+
+	cr.subLock.Lock()
+	cr.topic = rejoined
+	cr.subLock.Unlock()
+
+	cr.Log(ChatLog{Prefix: "sub", Msg: "joined the room again"})
+	return sub, nil
+}
+
+// Method for unsubscribing from the topic. It returns once the subscription
+// is no longer read, so no message is handed on after it, and leaving
+// again does nothing
+func (cr *ChatRoom) Leave() {
+	cr.leaveOnce.Do(func() {
+		// stop reading first, so the subscription going away isn't taken for a lost one
+		cr.cancel()
+
+		// cancel the existing subscription, and the peer events
+		cr.currentSub().Cancel()
+		cr.subLock.Lock()
+		if cr.peerEvents != nil {
+			cr.peerEvents.Cancel()
+		}
+		cr.subLock.Unlock()
+		<-cr.readDone
+
+		// close the topic handler
+		cr.currentTopic().Close()
+		// stop validating messages of the topic
+		cr.Host.PubSub.UnregisterTopicValidator(cr.topicName)
+		// stop answering password challenges for the room
+		cr.Host.Auth.Unregister(cr.topicName, cr.gate)
+		// and keeping its peers connected
+		cr.unprotectPeers()
+	})
+}
+
+// Method that returns a channel closed once the room no longer receives
+// messages, because it was left or its host was closed
+func (cr *ChatRoom) Done() <-chan struct{} {
+	return cr.readDone
+}
+
+// Method that returns why the room no longer receives messages. It is nil
+// while it still does, and once it was left
+func (cr *ChatRoom) Err() error {
+	select {
+	case <-cr.readDone:
+		return cr.readErr
+	default:
+		return nil
+	}
+}
+
+// Method for updating the username
+func (cr *ChatRoom) UpdateUser(username string) {
+	cr.Username = username
+	cr.Host.Profiles.SetUsername(username)
+}
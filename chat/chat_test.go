@@ -0,0 +1,264 @@
+package chat_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/chat/chattest"
+)
+
+// how long a test waits for messages to come through
+const deliveryTimeout = 20 * time.Second
+
+// This one starts a network of the given number of hosts, without rate
+// limits, and has them all join the room. Everything is closed once the
+// test is over
+func joinRoom(t *testing.T, hosts int, roomName string) (*chattest.Network, []*chat.ChatRoom) {
+	t.Helper()
+
+	network, err := chattest.NewNetwork(context.Background(), hosts)
+	if err != nil {
+		t.Fatalf("starting the network: %s", err)
+	}
+	t.Cleanup(func() { network.Close() })
+
+	for _, host := range network.Hosts {
+		host.RateLimit = 0
+	}
+
+	rooms, err := network.Join(roomName)
+	if err != nil {
+		t.Fatalf("joining %s: %s", roomName, err)
+	}
+	t.Cleanup(func() {
+		for _, cr := range rooms {
+			cr.Leave()
+		}
+	})
+
+	return network, rooms
+}
+
+// This one returns a context that is done once the messages should have come through
+func waitContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+func TestDelivery(t *testing.T) {
+	_, rooms := joinRoom(t, 3, "delivery")
+
+	recorders := make([]*chattest.Recorder, len(rooms))
+	for i, cr := range rooms {
+		recorders[i] = chattest.Record(cr)
+	}
+
+	sent := rooms[0].NewTextMessage("hello")
+	if err := rooms[0].Send(sent); err != nil {
+		t.Fatalf("sending: %s", err)
+	}
+
+	ctx := waitContext(t)
+	for i, r := range recorders[1:] {
+		msgs, err := r.Wait(ctx, 1, chat.MessageText)
+		if err != nil {
+			t.Fatalf("peer%d: %s", i+1, err)
+		}
+
+		got := msgs[0]
+		if got.Message != "hello" || got.ID != sent.ID {
+			t.Errorf("peer%d got %q with ID %s, want %q with ID %s", i+1, got.Message, got.ID, "hello", sent.ID)
+		}
+		if got.SenderID != rooms[0].SelfID().Pretty() || got.SenderName != "peer0" {
+			t.Errorf("peer%d got the message from %s (%s), want peer0", i+1, got.SenderName, got.SenderID)
+		}
+	}
+
+	// our own messages don't come back to us
+	time.Sleep(time.Second)
+	if msgs := recorders[0].Messages(); len(msgs) > 0 {
+		t.Errorf("the sender got %d messages back", len(msgs))
+	}
+}
+
+func TestDecoderOrder(t *testing.T) {
+	const perSender = 200
+
+	network, rooms := joinRoom(t, 4, "order")
+	recorder := chattest.Record(rooms[0])
+
+	// the senders take turns, so their messages are spread over the decoders.
+	// Handed to the decoders right away, as pubsub itself doesn't keep the
+	// messages of a sender in order when they come in quick succession
+	var msgs []*pubsub.Message
+	for i := 1; i <= perSender; i++ {
+		for _, host := range network.Hosts[1:] {
+			data, err := json.Marshal(chat.ChatMessage{
+				Type:    chat.MessageText,
+				ID:      chat.NewMessageID(),
+				Message: fmt.Sprintf("%d", i),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			from := host.Host.ID()
+			msgs = append(msgs, &pubsub.Message{
+				Message:      &pb.Message{From: []byte(from), Data: data},
+				ReceivedFrom: from,
+			})
+		}
+	}
+	rooms[0].DecodeAll(msgs)
+
+	got, err := recorder.Wait(waitContext(t), len(msgs), chat.MessageText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := make(map[string]int)
+	for _, msg := range got {
+		var seq int
+		if _, err := fmt.Sscanf(msg.Message, "%d", &seq); err != nil {
+			t.Fatalf("unexpected message %q", msg.Message)
+		}
+		if seq != last[msg.SenderID]+1 {
+			t.Fatalf("message %d of %s came after message %d", seq, msg.SenderID, last[msg.SenderID])
+		}
+		last[msg.SenderID] = seq
+	}
+}
+
+func TestChunkedMessage(t *testing.T) {
+	_, rooms := joinRoom(t, 2, "chunks")
+	recorder := chattest.Record(rooms[1])
+
+	// random text, so that even compressed it takes a few chunks
+	data := make([]byte, 768*1024)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	text := hex.EncodeToString(data)
+
+	err := rooms[0].Send(chat.ChatMessage{
+		Type:     chat.MessageResponse,
+		Response: &chat.BotResponse{Command: "dump", Text: text},
+	})
+	if err != nil {
+		t.Fatalf("sending: %s", err)
+	}
+
+	msgs, err := recorder.Wait(waitContext(t), 1, chat.MessageResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := msgs[0].Response
+	if got == nil || got.Command != "dump" || got.Text != text {
+		t.Errorf("the reassembled response doesn't match the one sent")
+	}
+}
+
+func TestChunkedMessageRateLimit(t *testing.T) {
+	network, err := chattest.NewNetwork(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("starting the network: %s", err)
+	}
+	t.Cleanup(func() { network.Close() })
+
+	// uncompressed, the message takes as many chunks as a message can
+	network.Hosts[0].Compression = false
+
+	rooms, err := network.Join("limited")
+	if err != nil {
+		t.Fatalf("joining the room: %s", err)
+	}
+	t.Cleanup(func() {
+		for _, cr := range rooms {
+			cr.Leave()
+		}
+	})
+	recorder := chattest.Record(rooms[1])
+
+	// just short of the largest message, leaving room for the rest of it
+	data := make([]byte, chat.MaxChunkedSize/2-4096)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	text := hex.EncodeToString(data)
+
+	err = rooms[0].Send(chat.ChatMessage{
+		Type:     chat.MessageResponse,
+		Response: &chat.BotResponse{Command: "dump", Text: text},
+	})
+	if err != nil {
+		t.Fatalf("sending: %s", err)
+	}
+
+	ctx := waitContext(t)
+	msgs, err := recorder.Wait(ctx, 1, chat.MessageResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msgs[0].Response; got == nil || got.Text != text {
+		t.Errorf("the reassembled response doesn't match the one sent")
+	}
+
+	// and the sender wasn't taken for flooding the room
+	if err := rooms[0].Send(rooms[0].NewTextMessage("still here")); err != nil {
+		t.Fatalf("sending: %s", err)
+	}
+	if _, err := recorder.Wait(ctx, 1, chat.MessageText); err != nil {
+		t.Errorf("the message after the chunked one didn't come through: %s", err)
+	}
+}
+
+func TestPasswordRoom(t *testing.T) {
+	_, rooms := joinRoom(t, 3, "sealed")
+
+	// the last peer doesn't know the password
+	knowing := rooms[:2]
+	for _, cr := range knowing {
+		if err := cr.SetPassword("hunter2"); err != nil {
+			t.Fatalf("setting the password: %s", err)
+		}
+	}
+
+	knows := chattest.Record(knowing[1])
+	stranger := chattest.Record(rooms[2])
+
+	// peers are heard once they proved they know the password, which takes
+	// a challenge first, so the message is sent again until it comes through
+	ctx := waitContext(t)
+	var msgs []chat.ChatMessage
+	for len(msgs) == 0 {
+		if err := knowing[0].Send(knowing[0].NewTextMessage("secret")); err != nil {
+			t.Fatalf("sending: %s", err)
+		}
+
+		retry, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		msgs, _ = knows.Wait(retry, 1, chat.MessageText)
+		cancel()
+		if ctx.Err() != nil {
+			t.Fatal("the peers knowing the password never heard each other")
+		}
+	}
+	if msgs[0].Message != "secret" {
+		t.Errorf("got %q, want %q", msgs[0].Message, "secret")
+	}
+
+	time.Sleep(time.Second)
+	if msgs := stranger.Messages(); len(msgs) > 0 {
+		t.Errorf("the peer without the password got %d messages", len(msgs))
+	}
+}
@@ -0,0 +1,204 @@
+package chattest
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// how long the peers of a room may take to see each other
+const joinTimeout = 10 * time.Second
+
+// Network is a number of hosts living in memory, linked by a mocknet and
+// all connected to each other, each running the whole chat stack. Rooms
+// joined on it are tested without touching the public DHT, or any network
+type Network struct {
+	// the mocknet linking the hosts, for cutting links and the
+	// like, nil on a network over the loopback interface
+	Mocknet mocknet.Mocknet
+	// the hosts, in the order they were made
+	Hosts []*p2p.P2P
+
+	// where the hosts keep their room keys, removed on closing
+	dir    string
+	cancel context.CancelFunc
+}
+
+// Constructor function for a network of the given number of hosts,
+// running until it is closed or the context is done
+func NewNetwork(ctx context.Context, n int) (*Network, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// hosts with keys of their own, as PubSub checks what they sign
+	mn := mocknet.New(ctx)
+	for i := 0; i < n; i++ {
+		pvtkey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", 4001+i))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if _, err := mn.AddPeer(pvtkey, addr); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	if err := mn.LinkAll(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "chattest")
+	if err != nil {
+		// the mocknet goes away with its context
+		cancel()
+		return nil, err
+	}
+
+	network := &Network{Mocknet: mn, dir: dir, cancel: cancel}
+	for _, node := range mn.Hosts() {
+		if err := network.addHost(ctx, node); err != nil {
+			network.Close()
+			return nil, err
+		}
+	}
+
+	// connecting once PubSub runs on every host has them all greet each other
+	if err := mn.ConnectAllButSelf(); err != nil {
+		network.Close()
+		return nil, err
+	}
+
+	return network, nil
+}
+
+// Constructor function for a network of the given number of hosts, like
+// NewNetwork, only connected over TCP on the loopback interface instead of
+// a mocknet, so the transports, encryption and multiplexing are in the way
+// too. It has no Mocknet
+func NewLoopbackNetwork(ctx context.Context, n int) (*Network, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	dir, err := ioutil.TempDir("", "chattest")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	network := &Network{dir: dir, cancel: cancel}
+	for i := 0; i < n; i++ {
+		node, err := libp2p.New(ctx, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		if err != nil {
+			network.Close()
+			return nil, err
+		}
+		if err := network.addHost(ctx, node); err != nil {
+			node.Close()
+			network.Close()
+			return nil, err
+		}
+	}
+
+	// each pair connected once, from the host made first
+	for i, a := range network.Hosts {
+		for _, b := range network.Hosts[i+1:] {
+			if err := a.Host.Connect(ctx, peer.AddrInfo{ID: b.Host.ID(), Addrs: b.Host.Addrs()}); err != nil {
+				network.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return network, nil
+}
+
+// Method that runs the chat stack on a libp2p host, as the next host
+// of the network, keeping its room keys in a directory of its own
+func (n *Network) addHost(ctx context.Context, node host.Host) error {
+	i := len(n.Hosts)
+	host, err := p2p.NewP2PFromHost(ctx, node, p2p.Options{
+		RoomKeys: filepath.Join(n.dir, fmt.Sprintf("host%d", i)),
+		Logger:   logrus.WithField("host", i),
+	})
+	if err != nil {
+		return err
+	}
+
+	n.Hosts = append(n.Hosts, host)
+	return nil
+}
+
+// Method that has every host join the room, as peer0, peer1 and so on, and
+// waits for each of them to see all the others there. The rooms are returned
+// in the order of the hosts
+func (n *Network) Join(roomName string) ([]*chat.ChatRoom, error) {
+	var rooms []*chat.ChatRoom
+	leave := func() {
+		for _, cr := range rooms {
+			cr.Leave()
+		}
+	}
+
+	for i, host := range n.Hosts {
+		cr, err := chat.JoinChatRoom(host, fmt.Sprintf("peer%d", i), roomName)
+		if err != nil {
+			leave()
+			return nil, err
+		}
+		rooms = append(rooms, cr)
+	}
+
+	deadline := time.Now().Add(joinTimeout)
+	for _, cr := range rooms {
+		for len(cr.GetPeers()) < len(rooms)-1 {
+			if time.Now().After(deadline) {
+				leave()
+				return nil, fmt.Errorf("%s sees %d of the %d other peers in %s", cr.Username, len(cr.GetPeers()), len(rooms)-1, roomName)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	// what is published before the heartbeat puts the peers in the mesh
+	// of each other can get lost on the way
+	time.Sleep(pubsub.GossipSubHeartbeatInterval)
+
+	return rooms, nil
+}
+
+// Method that closes every host and the mocknet, and removes the room keys
+func (n *Network) Close() error {
+	var closeErr error
+	for _, host := range n.Hosts {
+		if err := host.Close(); err != nil {
+			closeErr = err
+		}
+	}
+	// the mocknet goes away with its context
+	n.cancel()
+
+	if err := os.RemoveAll(n.dir); err != nil {
+		closeErr = err
+	}
+
+	return closeErr
+}
@@ -0,0 +1,69 @@
+package chattest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// Recorder keeps the messages a room receives, in the order they came,
+// for checking what was delivered and how
+type Recorder struct {
+	lock     sync.Mutex
+	messages []chat.ChatMessage
+	// closed and replaced on every message, waking up whoever waits
+	changed chan struct{}
+}
+
+// Constructor function for a recorder of the messages the room
+// receives from now on
+func Record(cr *chat.ChatRoom) *Recorder {
+	r := &Recorder{changed: make(chan struct{})}
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		r.messages = append(r.messages, msg)
+		close(r.changed)
+		r.changed = make(chan struct{})
+	})
+
+	return r
+}
+
+// Method that returns the messages received so far
+func (r *Recorder) Messages() []chat.ChatMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]chat.ChatMessage(nil), r.messages...)
+}
+
+// Method that waits for the given number of messages of the type, like
+// chat.MessageText, and returns them in the order they came. Fails with
+// what came so far if the context is done first
+func (r *Recorder) Wait(ctx context.Context, count int, msgType string) ([]chat.ChatMessage, error) {
+	for {
+		r.lock.Lock()
+		var matching []chat.ChatMessage
+		for _, msg := range r.messages {
+			if msg.Type == msgType {
+				matching = append(matching, msg)
+			}
+		}
+		changed := r.changed
+		r.lock.Unlock()
+
+		if len(matching) >= count {
+			return matching[:count], nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return matching, fmt.Errorf("got %d of %d messages: %w", len(matching), count, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package chat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// payloads larger than this are split into chunks, which keeps
+// every chunk comfortably below the 1MB pubsub message limit
+const chunkSize = 512 * 1024
+
+// largest payload we are willing to reassemble
+const maxChunkedSize = 16 << 20
+
+// how many payloads a single peer can have in flight
+const maxPendingPerPeer = 4
+
+// how long we wait for all chunks of a payload to arrive
+const chunkTimeout = 30 * time.Second
+
+// one sequence numbered piece of a larger payload
+type payloadChunk struct {
+	// identifies the payload this chunk belongs to
+	ID string `json:"id"`
+	// position of this chunk, counting from zero
+	Seq int `json:"seq"`
+	// number of chunks in the payload
+	Total int `json:"total"`
+	// SHA256 of the whole payload
+	Hash string `json:"hash"`
+	// chunk content
+	Data []byte `json:"data"`
+}
+
+// This one splits the payload into sequence numbered chunks
+func splitChunks(payload []byte) ([]payloadChunk, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(payload)
+	total := (len(payload) + chunkSize - 1) / chunkSize
+
+	chunks := make([]payloadChunk, 0, total)
+	for seq := 0; seq < total; seq++ {
+		end := (seq + 1) * chunkSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		chunks = append(chunks, payloadChunk{
+			ID:    hex.EncodeToString(idBytes),
+			Seq:   seq,
+			Total: total,
+			Hash:  hex.EncodeToString(hash[:]),
+			Data:  payload[seq*chunkSize : end],
+		})
+	}
+
+	return chunks, nil
+}
+
+// a payload which is still being reassembled
+type partialPayload struct {
+	total    int
+	hash     string
+	parts    [][]byte
+	received int
+	size     int
+	deadline time.Time
+}
+
+// key of a payload in reassembly, chunk IDs are only unique per sender
+type chunkKey struct {
+	from peer.ID
+	id   string
+}
+
+// reassembles chunked payloads received from the room
+type chunkAssembler struct {
+	lock    sync.Mutex
+	pending map[chunkKey]*partialPayload
+}
+
+// Constructor function for a new chunk assembler
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{pending: make(map[chunkKey]*partialPayload)}
+}
+
+// Method that adds a received chunk. It returns the whole payload once
+// the last chunk arrives and the payload checks out, or nil while incomplete
+func (ca *chunkAssembler) Add(from peer.ID, chunk *payloadChunk) ([]byte, error) {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+
+	if chunk.Total <= 0 || chunk.Total > maxChunkedSize/chunkSize+1 ||
+		chunk.Seq < 0 || chunk.Seq >= chunk.Total || len(chunk.Data) > chunkSize {
+		return nil, fmt.Errorf("malformed chunk from %s", p2p.ShortID(from))
+	}
+
+	key := chunkKey{from: from, id: chunk.ID}
+	partial, ok := ca.pending[key]
+	if !ok {
+		inflight := 0
+		for k := range ca.pending {
+			if k.from == from {
+				inflight++
+			}
+		}
+		if inflight >= maxPendingPerPeer {
+			return nil, fmt.Errorf("too many chunked payloads in flight from %s", p2p.ShortID(from))
+		}
+
+		partial = &partialPayload{
+			total:    chunk.Total,
+			hash:     chunk.Hash,
+			parts:    make([][]byte, chunk.Total),
+			deadline: time.Now().Add(chunkTimeout),
+		}
+		ca.pending[key] = partial
+	}
+
+	// every chunk has to agree on what the payload is
+	if partial.total != chunk.Total || partial.hash != chunk.Hash {
+		delete(ca.pending, key)
+		return nil, fmt.Errorf("inconsistent chunks from %s", p2p.ShortID(from))
+	}
+
+	// duplicates are harmless, just ignore them
+	if partial.parts[chunk.Seq] != nil {
+		return nil, nil
+	}
+
+	partial.size += len(chunk.Data)
+	if partial.size > maxChunkedSize {
+		delete(ca.pending, key)
+		return nil, fmt.Errorf("chunked payload from %s is too large", p2p.ShortID(from))
+	}
+
+	partial.parts[chunk.Seq] = chunk.Data
+	partial.received++
+
+	if partial.received < partial.total {
+		return nil, nil
+	}
+
+	delete(ca.pending, key)
+
+	payload := make([]byte, 0, partial.size)
+	for _, part := range partial.parts {
+		payload = append(payload, part...)
+	}
+
+	hash := sha256.Sum256(payload)
+	if hex.EncodeToString(hash[:]) != partial.hash {
+		return nil, fmt.Errorf("chunked payload from %s failed the integrity check", p2p.ShortID(from))
+	}
+
+	return payload, nil
+}
+
+// Method that drops payloads whose chunks did not arrive in time,
+// returning the peers that sent them
+func (ca *chunkAssembler) Expire(now time.Time) []peer.ID {
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+
+	var expired []peer.ID
+	for key, partial := range ca.pending {
+		if now.After(partial.deadline) {
+			delete(ca.pending, key)
+			expired = append(expired, key.from)
+		}
+	}
+
+	return expired
+}
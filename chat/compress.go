@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// payloads smaller than this are not worth compressing
+const compressThreshold = 1024
+
+// a compressed chat message payload
+type compressedPayload struct {
+	// compression algorithm, one of the profile features
+	Encoding string `json:"encoding"`
+	// compressed serialized chat message
+	Data []byte `json:"data"`
+}
+
+// This one gzips the payload, returning nil if that
+// would not make the payload any smaller
+func compressPayload(payload []byte) (*compressedPayload, error) {
+	buf := &bytes.Buffer{}
+
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(payload) {
+		return nil, nil
+	}
+
+	return &compressedPayload{Encoding: p2p.FeatureGzip, Data: buf.Bytes()}, nil
+}
+
+// This one decompresses the payload, refusing to inflate
+// it past the largest payload we would reassemble from chunks
+func decompressPayload(cp *compressedPayload) ([]byte, error) {
+	if cp.Encoding != p2p.FeatureGzip {
+		return nil, fmt.Errorf("unsupported encoding %q", cp.Encoding)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(cp.Data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	payload, err := ioutil.ReadAll(io.LimitReader(reader, maxChunkedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxChunkedSize {
+		return nil, fmt.Errorf("compressed payload is too large")
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,192 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"runtime"
+	"sync"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// most goroutines decoding the messages of a room at once, and how many
+// messages wait for each of them. A full queue holds up reading the room
+const (
+	maxDecoders = 4
+	decodeQueue = 16
+)
+
+// decodeJob is a message waiting to be decoded, or a moderation
+// event already decoded which waits to be applied
+type decodeJob struct {
+	msg *pubsub.Message
+	cm  *ChatMessage
+}
+
+// decoders open, decompress and parse the messages of a room on a few
+// goroutines, so a slow message doesn't hold up the ones behind it. The
+// messages of a sender always go to the same goroutine, and so reach the
+// handlers in the order they were received. Moderation events all go
+// through one more goroutine of their own, so they are checked and applied
+// in the order they came in, whoever sent them
+type decoders struct {
+	queues     []chan decodeJob
+	moderation chan decodeJob
+	// done once every goroutine of the senders has stopped,
+	// and once the moderation one has
+	wait     sync.WaitGroup
+	moderate sync.WaitGroup
+}
+
+// Method that starts the goroutines decoding the messages of the room,
+// one for each CPU up to a few, handing what they decode to the handlers
+func (cr *ChatRoom) startDecoders() *decoders {
+	count := runtime.NumCPU()
+	if count > maxDecoders {
+		count = maxDecoders
+	}
+
+	return cr.startDecoderPool(count)
+}
+
+// Method that starts the given number of goroutines decoding the messages
+// of the room, and the one applying its moderation events
+func (cr *ChatRoom) startDecoderPool(count int) *decoders {
+	ds := &decoders{
+		queues:     make([]chan decodeJob, count),
+		moderation: make(chan decodeJob, decodeQueue),
+	}
+	for i := range ds.queues {
+		ds.queues[i] = make(chan decodeJob, decodeQueue)
+
+		ds.wait.Add(1)
+		go func(queue <-chan decodeJob) {
+			defer ds.wait.Done()
+			cr.decode(ds, queue)
+		}(ds.queues[i])
+	}
+
+	ds.moderate.Add(1)
+	go func() {
+		defer ds.moderate.Done()
+		cr.decodeModeration(ds.moderation)
+	}()
+
+	return ds
+}
+
+// Method that hands a received message to the goroutine decoding the
+// messages of its sender, or moderation events to the moderation one.
+// Returns false if the context was done first
+func (ds *decoders) dispatch(ctx context.Context, msg *pubsub.Message) bool {
+	queue := ds.moderation
+	if peekType(msg.Data) != MessageModeration {
+		// by the author, whoever passed the message on
+		hash := fnv.New32a()
+		hash.Write([]byte(msg.GetFrom()))
+		queue = ds.queues[hash.Sum32()%uint32(len(ds.queues))]
+	}
+
+	select {
+	case queue <- decodeJob{msg: msg}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Method that hands a moderation event, found in a chunked, sealed or
+// compressed message, to the moderation goroutine. Returns false if
+// the context was done first
+func (ds *decoders) forward(ctx context.Context, cm *ChatMessage) bool {
+	select {
+	case ds.moderation <- decodeJob{cm: cm}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Method that stops the decoding goroutines once they are done with what
+// was handed to them, and waits for them. The moderation goroutine stops
+// last, the others might still forward events to it until then
+func (ds *decoders) stop() {
+	for _, queue := range ds.queues {
+		close(queue)
+	}
+	ds.wait.Wait()
+
+	close(ds.moderation)
+	ds.moderate.Wait()
+}
+
+// This one returns the type of an encoded message without decoding the
+// rest of it. Wrapped messages only show the type of their wrapper
+func peekType(data []byte) string {
+	var peek struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &peek); err != nil {
+		return ""
+	}
+
+	return peek.Type
+}
+
+// Method that decodes the messages from the queue until it is closed,
+// handing them to the handlers of the room, until the room is left.
+// Moderation events unwrapped here go on to the moderation goroutine
+func (cr *ChatRoom) decode(ds *decoders, queue <-chan decodeJob) {
+	for job := range queue {
+		cm := cr.receive(job.msg)
+		if cm == nil {
+			continue
+		}
+
+		if cm.Type == MessageModeration {
+			if !ds.forward(cr.ctx, cm) {
+				return
+			}
+			continue
+		}
+
+		if !cr.deliver(cm) {
+			return
+		}
+	}
+}
+
+// Method that applies the moderation events from the queue one at a time
+// until it is closed, decoding the ones not decoded yet, and handing those
+// that change something to the handlers of the room, until the room is left
+func (cr *ChatRoom) decodeModeration(queue <-chan decodeJob) {
+	for job := range queue {
+		cm := job.cm
+		if cm == nil {
+			if cm = cr.receive(job.msg); cm == nil {
+				continue
+			}
+		}
+
+		// anything but moderation dressed up as it is dropped
+		if cm.Type != MessageModeration || !cr.moderate(cm) {
+			continue
+		}
+
+		if !cr.deliver(cm) {
+			return
+		}
+	}
+}
+
+// Method that sends a decoded message into the message queue, returning
+// false if the room was left first
+func (cr *ChatRoom) deliver(cm *ChatMessage) bool {
+	select {
+	case cr.incoming <- *cm:
+		return true
+	case <-cr.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,198 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// MessageHandler is called with every message received in a room
+type MessageHandler func(ChatMessage)
+
+// LogHandler is called with every line a room logs
+type LogHandler func(ChatLog)
+
+// PeerJoinHandler is called with every peer joining a room, blocked peers aside
+type PeerJoinHandler func(peer.ID)
+
+// handlers of the events of a room. Messages and logs wait for the first
+// handler of their kind, so none are missed between joining and handling
+type roomHandlers struct {
+	lock      sync.Mutex
+	messages  []MessageHandler
+	logs      []LogHandler
+	peerJoins []PeerJoinHandler
+
+	// closed once the first handler of their kind is there
+	messagesReady chan struct{}
+	logsReady     chan struct{}
+}
+
+// Constructor function for handlers of a room, none yet
+func newRoomHandlers() *roomHandlers {
+	return &roomHandlers{
+		messagesReady: make(chan struct{}),
+		logsReady:     make(chan struct{}),
+	}
+}
+
+// Method that adds a handler of the messages received in the room. Handlers
+// are called one at a time, in the order they were added, on a goroutine of
+// the room, so a handler that takes long holds up the messages after it
+func (cr *ChatRoom) OnMessage(handler MessageHandler) {
+	cr.handlers.lock.Lock()
+	defer cr.handlers.lock.Unlock()
+
+	cr.handlers.messages = append(cr.handlers.messages, handler)
+	if len(cr.handlers.messages) == 1 {
+		close(cr.handlers.messagesReady)
+	}
+}
+
+// Method that adds a handler of the lines logged by the room,
+// called the way message handlers are
+func (cr *ChatRoom) OnLog(handler LogHandler) {
+	cr.handlers.lock.Lock()
+	defer cr.handlers.lock.Unlock()
+
+	cr.handlers.logs = append(cr.handlers.logs, handler)
+	if len(cr.handlers.logs) == 1 {
+		close(cr.handlers.logsReady)
+	}
+}
+
+// Method that adds a handler of the peers joining the room from now on,
+// called the way message handlers are, until the room is left
+func (cr *ChatRoom) OnPeerJoin(handler PeerJoinHandler) error {
+	cr.handlers.lock.Lock()
+	defer cr.handlers.lock.Unlock()
+
+	// peer events are only followed once someone cares about them
+	if len(cr.handlers.peerJoins) == 0 {
+		joins, err := cr.peerJoins()
+		if err != nil {
+			return err
+		}
+		go cr.dispatchPeerJoins(joins)
+	}
+
+	cr.handlers.peerJoins = append(cr.handlers.peerJoins, handler)
+	return nil
+}
+
+// Method that queues a message to be published to the room,
+// failing once the room is left
+func (cr *ChatRoom) Send(msg ChatMessage) error {
+	select {
+	case cr.outgoing <- msg:
+		return nil
+	case <-cr.ctx.Done():
+		return fmt.Errorf("left the room %s", cr.RoomName)
+	}
+}
+
+// Method that queues a line for the handlers of the room, never waiting for
+// them. With the queue full, the oldest line waiting is dropped for it
+func (cr *ChatRoom) Log(log ChatLog) {
+	for cr.ctx.Err() == nil {
+		select {
+		case cr.logs <- log:
+			return
+		default:
+		}
+
+		// the handlers may have taken one in the meantime
+		select {
+		case <-cr.logs:
+			atomic.AddUint64(&cr.droppedLogs, 1)
+		default:
+		}
+	}
+}
+
+// QueueStats is how many messages and logs of a room are waiting in its
+// queues, and how many logs were dropped because their queue was full
+type QueueStats struct {
+	Incoming    int    `json:"incoming"`
+	Outgoing    int    `json:"outgoing"`
+	Logs        int    `json:"logs"`
+	DroppedLogs uint64 `json:"dropped_logs"`
+}
+
+// Method that returns how full the queues of the room are
+func (cr *ChatRoom) QueueStats() QueueStats {
+	return QueueStats{
+		Incoming:    len(cr.incoming),
+		Outgoing:    len(cr.outgoing),
+		Logs:        len(cr.logs),
+		DroppedLogs: atomic.LoadUint64(&cr.droppedLogs),
+	}
+}
+
+// Method that hands the messages received in the room to its handlers,
+// once there are any, until the room is left
+func (cr *ChatRoom) dispatchMessages() {
+	select {
+	case <-cr.handlers.messagesReady:
+	case <-cr.ctx.Done():
+		return
+	}
+
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return
+
+		case msg := <-cr.incoming:
+			cr.handlers.lock.Lock()
+			handlers := cr.handlers.messages
+			cr.handlers.lock.Unlock()
+
+			for _, handler := range handlers {
+				handler(msg)
+			}
+		}
+	}
+}
+
+// Method that hands the lines logged by the room to its handlers,
+// once there are any, until the room is left
+func (cr *ChatRoom) dispatchLogs() {
+	select {
+	case <-cr.handlers.logsReady:
+	case <-cr.ctx.Done():
+		return
+	}
+
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return
+
+		case log := <-cr.logs:
+			cr.handlers.lock.Lock()
+			handlers := cr.handlers.logs
+			cr.handlers.lock.Unlock()
+
+			for _, handler := range handlers {
+				handler(log)
+			}
+		}
+	}
+}
+
+// Method that hands the peers joining the room to its handlers,
+// until the room is left
+func (cr *ChatRoom) dispatchPeerJoins(joins <-chan peer.ID) {
+	for id := range joins {
+		cr.handlers.lock.Lock()
+		handlers := cr.handlers.peerJoins
+		cr.handlers.lock.Unlock()
+
+		for _, handler := range handlers {
+			handler(id)
+		}
+	}
+}
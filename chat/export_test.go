@@ -0,0 +1,46 @@
+package chat
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// ModerationEvent is a signed moderation event
+type ModerationEvent = moderationEvent
+
+// largest chunked message that is reassembled
+const MaxChunkedSize = maxChunkedSize
+
+// Method that hands the messages to decoders of their own, the way reading the
+// subscription does, and waits until they are decoded. What they decode goes
+// to the message handlers of the room as usual. There are always as many
+// decoders as there can be, however many CPUs the tests run on
+func (cr *ChatRoom) DecodeAll(msgs []*pubsub.Message) {
+	ds := cr.startDecoderPool(maxDecoders)
+	for _, msg := range msgs {
+		if !ds.dispatch(cr.ctx, msg) {
+			break
+		}
+	}
+	ds.stop()
+}
+
+// This one returns the moderation state of a room created with the given key
+func NewRoomModeration(room string, roomKey crypto.PubKey) (*roomModeration, error) {
+	fingerprint, err := p2p.RoomFingerprint(roomKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRoomModeration(room, fingerprint), nil
+}
+
+// This one signs a moderation event for the room with the host key,
+// and with the room creation key too if it's given
+func SignModeration(pvtkey, roomKey crypto.PrivKey, action, room string, target peer.ID) (*moderationEvent, error) {
+	return signModeration(pvtkey, roomKey, action, room, target, time.Time{}, 0)
+}
@@ -0,0 +1,511 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// extension of the history file of each room
+const historyExt = ".jsonl"
+
+// most results a single search returns
+const maxSearchResults = 100
+
+// a message kept in the history
+type HistoryRecord struct {
+	// room the message was sent to, implied by the file it is kept in
+	Room string `json:"-"`
+
+	ID         string `json:"id"`
+	SenderID   string `json:"senderId"`
+	SenderName string `json:"senderName"`
+	Text       string `json:"text"`
+
+	// unix time the message was seen
+	Sent int64 `json:"sent"`
+	// unix time the message disappears, zero if it doesn't
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// History keeps the messages of every room we were in on disk,
+// one file per room, along with a full-text index over all of them.
+// Retracted and expired messages are purged from the files too.
+// A nil history keeps nothing
+type History struct {
+	Dir string
+
+	// lock for everything below
+	lock sync.Mutex
+	// records of each room, oldest first
+	rooms map[string][]*HistoryRecord
+	// records by room and ID
+	byID map[string]*HistoryRecord
+	// records by the words in them
+	index map[string]map[*HistoryRecord]bool
+	// earliest expiry of any record, zero if none expires
+	nextExpiry int64
+}
+
+// This one opens the history kept in the given directory, loading
+// every room in it. Messages that expired in the meantime are purged
+func OpenHistory(dir string) (*History, error) {
+	h := &History{
+		Dir:   dir,
+		rooms: make(map[string][]*HistoryRecord),
+		byID:  make(map[string]*HistoryRecord),
+		index: make(map[string]map[*HistoryRecord]bool),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+historyExt))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	for _, file := range files {
+		room, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(file), historyExt))
+		if err != nil {
+			continue
+		}
+
+		purged, err := h.load(room, file, now)
+		if err != nil {
+			return nil, err
+		}
+
+		if purged {
+			if err := h.save(room); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return h, nil
+}
+
+// Method that loads the records of a room from its file, skipping
+// the expired and broken ones. Reports if anything was skipped
+func (h *History) load(room, file string, now int64) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	purged := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxChunkedSize)
+	for scanner.Scan() {
+		rec := &HistoryRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil || len(rec.ID) == 0 {
+			purged = true
+			continue
+		}
+
+		if rec.Expires > 0 && rec.Expires <= now {
+			purged = true
+			continue
+		}
+
+		rec.Room = room
+		h.insert(rec)
+	}
+
+	return purged, scanner.Err()
+}
+
+// Method that writes the records of a room to its file, replacing it.
+// Expects the lock to be held
+func (h *History) save(room string) error {
+	if err := os.MkdirAll(h.Dir, 0700); err != nil {
+		return err
+	}
+
+	records := h.rooms[room]
+	path := h.path(room)
+	if len(records) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	lines := &strings.Builder{}
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		lines.Write(data)
+		lines.WriteByte('\n')
+	}
+
+	// write it aside first, a crash midway shouldn't lose the room
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(lines.String()), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Method that returns the history file of a room
+func (h *History) path(room string) string {
+	return filepath.Join(h.Dir, url.PathEscape(room)+historyExt)
+}
+
+// Method that adds a record to memory and the index, expects the lock to be held
+func (h *History) insert(rec *HistoryRecord) {
+	key := historyKey(rec.Room, rec.ID)
+	if previous, ok := h.byID[key]; ok {
+		h.remove(previous)
+	}
+
+	h.rooms[rec.Room] = append(h.rooms[rec.Room], rec)
+	h.byID[key] = rec
+	h.indexRecord(rec)
+
+	if rec.Expires > 0 && (h.nextExpiry == 0 || rec.Expires < h.nextExpiry) {
+		h.nextExpiry = rec.Expires
+	}
+}
+
+// Method that drops a record from memory and the index, expects the lock to be held
+func (h *History) remove(rec *HistoryRecord) {
+	delete(h.byID, historyKey(rec.Room, rec.ID))
+	h.unindexRecord(rec)
+
+	records := h.rooms[rec.Room]
+	for i, r := range records {
+		if r == rec {
+			h.rooms[rec.Room] = append(records[:i], records[i+1:]...)
+			break
+		}
+	}
+
+	if len(h.rooms[rec.Room]) == 0 {
+		delete(h.rooms, rec.Room)
+	}
+}
+
+// Method that adds the words of a record to the index
+func (h *History) indexRecord(rec *HistoryRecord) {
+	for _, word := range SearchWords(rec.Text + " " + rec.SenderName) {
+		if h.index[word] == nil {
+			h.index[word] = make(map[*HistoryRecord]bool)
+		}
+		h.index[word][rec] = true
+	}
+}
+
+// Method that removes the words of a record from the index
+func (h *History) unindexRecord(rec *HistoryRecord) {
+	for _, word := range SearchWords(rec.Text + " " + rec.SenderName) {
+		delete(h.index[word], rec)
+		if len(h.index[word]) == 0 {
+			delete(h.index, word)
+		}
+	}
+}
+
+// Method that keeps a message sent to the room, and writes it down
+func (h *History) Add(rec HistoryRecord) error {
+	if h == nil || len(rec.ID) == 0 || len(rec.Text) == 0 {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// a message we already have is only ever changed through an edit
+	if _, ok := h.byID[historyKey(rec.Room, rec.ID)]; ok {
+		return nil
+	}
+
+	h.insert(&rec)
+
+	if err := os.MkdirAll(h.Dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(h.path(rec.Room), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Method that replaces the text of a kept message after an edit,
+// as long as the edit comes from the sender of the message
+func (h *History) Edit(room, id, senderID, text string) error {
+	if h == nil {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	rec, ok := h.byID[historyKey(room, id)]
+	if !ok || rec.SenderID != senderID {
+		return nil
+	}
+
+	h.unindexRecord(rec)
+	rec.Text = text
+	h.indexRecord(rec)
+
+	return h.save(room)
+}
+
+// Method that returns a kept message
+func (h *History) Lookup(room, id string) (HistoryRecord, bool) {
+	if h == nil {
+		return HistoryRecord{}, false
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	rec, ok := h.byID[historyKey(room, id)]
+	if !ok {
+		return HistoryRecord{}, false
+	}
+
+	return *rec, true
+}
+
+// Method that purges a message from the history, after its author retracted it.
+// Retractions from anyone but the sender of the message are ignored
+func (h *History) Forget(room, id, senderID string) error {
+	if h == nil {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	rec, ok := h.byID[historyKey(room, id)]
+	if !ok || rec.SenderID != senderID {
+		return nil
+	}
+
+	h.remove(rec)
+	return h.save(room)
+}
+
+// Method that purges the messages which disappeared by now
+func (h *History) Expire(now time.Time) error {
+	if h == nil {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	unix := now.Unix()
+	if h.nextExpiry == 0 || unix < h.nextExpiry {
+		return nil
+	}
+
+	h.nextExpiry = 0
+	changed := make(map[string]bool)
+	for _, records := range h.rooms {
+		for _, rec := range records {
+			if rec.Expires == 0 {
+				continue
+			}
+			if rec.Expires <= unix {
+				changed[rec.Room] = true
+				continue
+			}
+			if h.nextExpiry == 0 || rec.Expires < h.nextExpiry {
+				h.nextExpiry = rec.Expires
+			}
+		}
+	}
+
+	for room := range changed {
+		var expired []*HistoryRecord
+		for _, rec := range h.rooms[room] {
+			if rec.Expires > 0 && rec.Expires <= unix {
+				expired = append(expired, rec)
+			}
+		}
+		for _, rec := range expired {
+			h.remove(rec)
+		}
+
+		if err := h.save(room); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Method that finds the kept messages containing every word of the query,
+// words match as prefixes so "deploy" finds "deployment" too. Newest first
+func (h *History) Search(query string) []HistoryRecord {
+	if h == nil {
+		return nil
+	}
+
+	words := SearchWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var matches map[*HistoryRecord]bool
+	for _, word := range words {
+		found := make(map[*HistoryRecord]bool)
+		for indexed, records := range h.index {
+			if !strings.HasPrefix(indexed, word) {
+				continue
+			}
+			for rec := range records {
+				if matches == nil || matches[rec] {
+					found[rec] = true
+				}
+			}
+		}
+
+		matches = found
+		if len(matches) == 0 {
+			return nil
+		}
+	}
+
+	results := make([]HistoryRecord, 0, len(matches))
+	for rec := range matches {
+		results = append(results, *rec)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Sent > results[j].Sent
+	})
+
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+
+	return results
+}
+
+// Method that returns a kept message along with the messages
+// around it in its room, up to the given number on each side
+func (h *History) Context(room, id string, around int) []HistoryRecord {
+	if h == nil {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	records := h.rooms[room]
+	for i, rec := range records {
+		if rec.ID != id {
+			continue
+		}
+
+		from, to := i-around, i+around+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(records) {
+			to = len(records)
+		}
+
+		context := make([]HistoryRecord, 0, to-from)
+		for _, r := range records[from:to] {
+			context = append(context, *r)
+		}
+		return context
+	}
+
+	return nil
+}
+
+// Method that returns the rooms with kept messages, by name
+func (h *History) Rooms() []string {
+	if h == nil {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	return rooms
+}
+
+// Method that returns the kept messages of a room seen within the given
+// times, in the order they were seen. A zero time leaves that end open
+func (h *History) Range(room string, from, to time.Time) []HistoryRecord {
+	if h == nil {
+		return nil
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var records []HistoryRecord
+	for _, rec := range h.rooms[room] {
+		if !from.IsZero() && rec.Sent < from.Unix() {
+			continue
+		}
+		if !to.IsZero() && rec.Sent >= to.Unix() {
+			continue
+		}
+		records = append(records, *rec)
+	}
+
+	return records
+}
+
+// This one returns the key of a record, message IDs are only unique within a room
+func historyKey(room, id string) string {
+	return room + "\x00" + id
+}
+
+// This one splits text into lower case words for the search index
+func SearchWords(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	seen := make(map[string]bool, len(fields))
+	words := fields[:0]
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			words = append(words, field)
+		}
+	}
+
+	return words
+}
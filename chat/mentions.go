@@ -0,0 +1,128 @@
+package chat
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// matches @username mentions inside a message
+var MentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+(?:#[\p{L}\p{N}]+)?)`)
+
+// This one returns the usernames mentioned in a message, without duplicates
+func ParseMentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	for _, match := range MentionPattern.FindAllStringSubmatch(text, -1) {
+		// sentence punctuation is not part of the name
+		name := strings.TrimRight(match[1], ".-")
+		if len(name) == 0 || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Method that resolves the mentioned usernames to the IDs of room peers
+// announcing them in their profiles. Names with a peer ID suffix,
+// like alice#ab12, only mention the peer they belong to
+func (cr *ChatRoom) ResolveMentions(names []string) []peer.ID {
+	if len(names) == 0 {
+		return nil
+	}
+
+	// peer ID suffixes wanted by username, an empty one wants everyone
+	wanted := make(map[string][]string, len(names))
+	for _, name := range names {
+		username, suffix := SplitUsername(name)
+		wanted[username] = append(wanted[username], suffix)
+	}
+
+	var mentioned []peer.ID
+	for _, p := range cr.GetPeers() {
+		prof := cr.Host.Profiles.Lookup(p)
+		if prof == nil {
+			continue
+		}
+
+		for _, suffix := range wanted[prof.Username] {
+			if strings.HasSuffix(p.Pretty(), suffix) {
+				mentioned = append(mentioned, p)
+				break
+			}
+		}
+	}
+
+	return mentioned
+}
+
+// This one checks if the message mentions the given peer
+func Mentions(msg ChatMessage, id peer.ID) bool {
+	for _, mentioned := range msg.Mentions {
+		if mentioned == id.Pretty() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// This one checks if the text contains one of the words, as a whole word
+// and ignoring case, like a username written out without the @
+func ContainsWord(text string, words []string) bool {
+	lower := strings.ToLower(text)
+	for _, word := range words {
+		word = strings.ToLower(word)
+		if len(word) == 0 {
+			continue
+		}
+
+		for from := 0; from < len(lower); {
+			i := strings.Index(lower[from:], word)
+			if i < 0 {
+				break
+			}
+			start, end := from+i, from+i+len(word)
+
+			if !IsWordRune(lastRune(lower[:start])) && !IsWordRune(firstRune(lower[end:])) {
+				return true
+			}
+			from = end
+		}
+	}
+
+	return false
+}
+
+// This one checks if the rune is part of a word
+func IsWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+// This one returns the first rune of the text, zero if empty
+func firstRune(text string) rune {
+	r, _ := utf8.DecodeRuneInString(text)
+	if r == utf8.RuneError {
+		return 0
+	}
+
+	return r
+}
+
+// This one returns the last rune of the text, zero if empty
+func lastRune(text string) rune {
+	r, _ := utf8.DecodeLastRuneInString(text)
+	if r == utf8.RuneError {
+		return 0
+	}
+
+	return r
+}
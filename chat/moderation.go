@@ -0,0 +1,758 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// moderation actions
+const (
+	// the holder of the room creation key claims the room as its creator
+	ModClaim = "claim"
+	// the room creator makes the target a moderator
+	ModGrant = "grant"
+	// the room creator takes the moderator rights of the target away
+	ModRevoke = "revoke"
+	// the target can't post until the event expires
+	ModMute = "mute"
+	// the target can't post for as long as we are in the room
+	ModKick = "kick"
+	// the room creator sets how long messages last in the room
+	ModTTL = "ttl"
+	// the room creator sets the topic and description of the room
+	ModTopic = "topic"
+)
+
+// longest room topic and description, in bytes
+const maxTopicLength = 120
+const maxDescriptionLength = 1024
+
+// how long a mute lasts when no duration is given
+const DefaultMuteDuration = 10 * time.Minute
+
+// how often the room creator rebroadcasts the moderation state for newcomers
+const moderationRepublish = 2 * time.Minute
+
+// a signed moderation event. It carries the issuers public key,
+// so anyone relaying it can be verified, not just the original author
+type moderationEvent struct {
+	Action string `json:"action"`
+	Room   string `json:"room"`
+	Target string `json:"target"`
+	Issued int64  `json:"issued"`
+	Until  int64  `json:"until,omitempty"`
+	TTL    int64  `json:"ttl,omitempty"`
+
+	// room metadata, for the topic action
+	Topic       string `json:"topic,omitempty"`
+	Description string `json:"description,omitempty"`
+	Created     int64  `json:"created,omitempty"`
+
+	Issuer    string `json:"issuer"`
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature"`
+
+	// room creation key and its signature, on events issued by the room creator
+	RoomKey       []byte `json:"roomKey,omitempty"`
+	RoomSignature []byte `json:"roomSignature,omitempty"`
+}
+
+// Method that returns the bytes covered by the signature
+func (me *moderationEvent) signedBytes() []byte {
+	return []byte(fmt.Sprintf("p2pchat-moderation|%s|%s|%s|%d|%d|%d|%q|%q|%d|%s",
+		me.Action, me.Room, me.Target, me.Issued, me.Until, me.TTL, me.Topic, me.Description, me.Created, me.Issuer))
+}
+
+// This one creates a moderation event signed with the given key,
+// and with the room creation key too if we hold it
+func signModeration(pvtkey, roomKey crypto.PrivKey, action, room string, target peer.ID, until time.Time, ttl time.Duration) (*moderationEvent, error) {
+	event := &moderationEvent{
+		Action: action,
+		Room:   room,
+		Target: target.Pretty(),
+	}
+	if !until.IsZero() {
+		event.Until = until.Unix()
+	}
+	if ttl > 0 {
+		event.TTL = int64(ttl / time.Second)
+	}
+
+	if err := event.sign(pvtkey, roomKey); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+// Method that stamps the event with the issuer and the time, and signs it
+// with the given key, and with the room creation key too if we hold it
+func (me *moderationEvent) sign(pvtkey, roomKey crypto.PrivKey) error {
+	issuer, err := peer.IDFromPrivateKey(pvtkey)
+	if err != nil {
+		return err
+	}
+
+	me.Key, err = crypto.MarshalPublicKey(pvtkey.GetPublic())
+	if err != nil {
+		return err
+	}
+
+	me.Issuer = issuer.Pretty()
+	me.Issued = time.Now().Unix()
+
+	me.Signature, err = pvtkey.Sign(me.signedBytes())
+	if err != nil {
+		return err
+	}
+
+	if roomKey != nil {
+		me.RoomKey, err = crypto.MarshalPublicKey(roomKey.GetPublic())
+		if err != nil {
+			return err
+		}
+
+		me.RoomSignature, err = roomKey.Sign(me.signedBytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Method that verifies the event signature and that the key belongs
+// to the claimed issuer, returning the issuer and target peers
+func (me *moderationEvent) Verify() (peer.ID, peer.ID, error) {
+	key, err := crypto.UnmarshalPublicKey(me.Key)
+	if err != nil {
+		return "", "", fmt.Errorf("bad moderation key: %s", err)
+	}
+
+	issuer, err := peer.IDFromPublicKey(key)
+	if err != nil || issuer.Pretty() != me.Issuer {
+		return "", "", fmt.Errorf("moderation key does not belong to the issuer")
+	}
+
+	ok, err := key.Verify(me.signedBytes(), me.Signature)
+	if err != nil || !ok {
+		return "", "", fmt.Errorf("bad moderation signature")
+	}
+
+	target, err := peer.Decode(me.Target)
+	if err != nil {
+		return "", "", fmt.Errorf("bad moderation target")
+	}
+
+	return issuer, target, nil
+}
+
+// Method that checks if the event comes before the other one. Events are
+// ordered by when they were issued, then a revocation comes after a grant
+// of the same second, and otherwise the one with the lower hash comes first,
+// so that every peer settles on the same one whichever arrives first
+func (me *moderationEvent) before(other *moderationEvent) bool {
+	if me.Issued != other.Issued {
+		return me.Issued < other.Issued
+	}
+
+	if me.Action != other.Action {
+		return me.Action != ModRevoke
+	}
+
+	return bytes.Compare(me.hash(), other.hash()) < 0
+}
+
+// Method that returns the hash of the signed event
+func (me *moderationEvent) hash() []byte {
+	sum := sha256.Sum256(append(me.signedBytes(), me.Signature...))
+	return sum[:]
+}
+
+// Method that checks if the event was signed with the
+// room creation key matching the given fingerprint
+func (me *moderationEvent) SignedByCreator(fingerprint string) bool {
+	if len(fingerprint) == 0 || len(me.RoomKey) == 0 {
+		return false
+	}
+
+	key, err := crypto.UnmarshalPublicKey(me.RoomKey)
+	if err != nil {
+		return false
+	}
+
+	if actual, err := p2p.RoomFingerprint(key); err != nil || actual != fingerprint {
+		return false
+	}
+
+	ok, err := key.Verify(me.signedBytes(), me.RoomSignature)
+	return err == nil && ok
+}
+
+// metadata of a room, set by its creator
+type roomMeta struct {
+	Topic       string
+	Description string
+	Created     time.Time
+}
+
+// roomModeration is the moderation state of a single room
+type roomModeration struct {
+	lock sync.RWMutex
+
+	// topic the events are bound to
+	room string
+	// fingerprint of the room creation key, empty if the room has none
+	fingerprint string
+	// the peer the room creator currently uses, and since when
+	owner   peer.ID
+	claimed int64
+	// how long messages last in the room, and since when
+	ttl    time.Duration
+	ttlSet int64
+	// topic, description and creation time of the room, and since when
+	meta    roomMeta
+	metaSet int64
+
+	moderators map[peer.ID]bool
+	// the latest grant or revocation of the moderator rights of each peer
+	moderatorsSet map[peer.ID]*moderationEvent
+	muted         map[peer.ID]time.Time
+	kicked        map[peer.ID]bool
+
+	// accepted events, rebroadcast by the room creator
+	events []*moderationEvent
+}
+
+// Constructor function for the moderation state of a room
+func newRoomModeration(room, fingerprint string) *roomModeration {
+	return &roomModeration{
+		room:          room,
+		fingerprint:   fingerprint,
+		moderators:    make(map[peer.ID]bool),
+		moderatorsSet: make(map[peer.ID]*moderationEvent),
+		muted:         make(map[peer.ID]time.Time),
+		kicked:        make(map[peer.ID]bool),
+	}
+}
+
+// Method that checks the event without applying it
+func (rm *roomModeration) Check(event *moderationEvent) (peer.ID, peer.ID, error) {
+	issuer, target, creator, err := rm.verify(event)
+	if err != nil {
+		return "", "", err
+	}
+
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	if err := rm.check(event, issuer, target, creator); err != nil {
+		return "", "", err
+	}
+
+	return issuer, target, nil
+}
+
+// Method that verifies the signatures of the event, which doesn't need
+// the lock, returning its issuer and target and whether the room creator
+// signed it
+func (rm *roomModeration) verify(event *moderationEvent) (peer.ID, peer.ID, bool, error) {
+	issuer, target, err := event.Verify()
+	if err != nil {
+		return "", "", false, err
+	}
+
+	if event.Room != rm.room {
+		return "", "", false, fmt.Errorf("moderation event for another room")
+	}
+
+	if len(rm.fingerprint) == 0 {
+		return "", "", false, fmt.Errorf("this room has no creation key, so it can't be moderated")
+	}
+
+	// the creator is whoever holds the room creation key
+	return issuer, target, event.SignedByCreator(rm.fingerprint), nil
+}
+
+// Method that checks a verified event against the state of the room,
+// whether its issuer may do it and it isn't outdated. Expects the lock to be held
+func (rm *roomModeration) check(event *moderationEvent, issuer, target peer.ID, creator bool) error {
+	switch event.Action {
+	case ModClaim:
+		if !creator {
+			return fmt.Errorf("only the room creator can claim the room")
+		}
+		if issuer != target {
+			return fmt.Errorf("the room can only be claimed for oneself")
+		}
+		if event.Issued < rm.claimed {
+			return fmt.Errorf("outdated claim of the room")
+		}
+
+	case ModGrant, ModRevoke:
+		if !creator {
+			return fmt.Errorf("only the room creator can %s moderators", event.Action)
+		}
+		if last := rm.moderatorsSet[target]; last != nil && event.before(last) {
+			return fmt.Errorf("outdated moderator %s", event.Action)
+		}
+
+	case ModTTL:
+		if !creator {
+			return fmt.Errorf("only the room creator can set disappearing messages")
+		}
+		if event.TTL < 0 || event.Issued < rm.ttlSet {
+			return fmt.Errorf("outdated disappearing messages setting")
+		}
+
+	case ModTopic:
+		if !creator {
+			return fmt.Errorf("only the room creator can set the topic")
+		}
+		if len(event.Topic) > maxTopicLength || len(event.Description) > maxDescriptionLength {
+			return fmt.Errorf("room topic or description too long")
+		}
+		if event.Issued < rm.metaSet {
+			return fmt.Errorf("outdated room topic")
+		}
+
+	case ModMute, ModKick:
+		if !creator && !rm.moderators[issuer] {
+			return fmt.Errorf("only moderators can %s", event.Action)
+		}
+		if target == rm.owner {
+			return fmt.Errorf("the room creator can't be moderated")
+		}
+
+	default:
+		return fmt.Errorf("unknown moderation action %q", event.Action)
+	}
+
+	return nil
+}
+
+// Method that verifies and applies a moderation event,
+// reporting whether it changed anything
+func (rm *roomModeration) Apply(event *moderationEvent) (bool, error) {
+	issuer, target, creator, err := rm.verify(event)
+	if err != nil {
+		return false, err
+	}
+
+	// checked under the same lock it's applied with, so nothing applied
+	// in between makes it outdated, or takes the issuer's rights away
+	rm.lock.Lock()
+	defer rm.lock.Unlock()
+
+	if err := rm.check(event, issuer, target, creator); err != nil {
+		return false, err
+	}
+
+	switch event.Action {
+	case ModClaim:
+		changed := rm.owner != target
+		rm.owner, rm.claimed = target, event.Issued
+		rm.replaceEvents(event)
+
+		return changed, nil
+
+	case ModTopic:
+		meta := roomMeta{Topic: event.Topic, Description: event.Description}
+		if event.Created > 0 {
+			meta.Created = time.Unix(event.Created, 0)
+		}
+
+		changed := rm.meta != meta
+		rm.meta, rm.metaSet = meta, event.Issued
+		rm.replaceEvents(event)
+
+		return changed, nil
+
+	case ModTTL:
+		ttl := time.Duration(event.TTL) * time.Second
+		changed := rm.ttl != ttl
+		rm.ttl, rm.ttlSet = ttl, event.Issued
+		rm.replaceEvents(event)
+
+		return changed, nil
+
+	case ModGrant, ModRevoke:
+		granted := event.Action == ModGrant
+		changed := rm.moderators[target] != granted
+		if granted {
+			rm.moderators[target] = true
+		} else {
+			delete(rm.moderators, target)
+		}
+		rm.moderatorsSet[target] = event
+		rm.replaceTargetEvents(event)
+
+		// what a revoked moderator did is taken back along with the rights,
+		// so that newcomers, who won't accept it, see the same room as we do
+		if !granted && rm.dropIssuedBy(event.Target) {
+			rm.rebuildSanctions()
+			changed = true
+		}
+
+		return changed, nil
+
+	case ModMute:
+		until := time.Unix(event.Until, 0)
+		if !until.After(rm.muted[target]) {
+			return false, nil
+		}
+		rm.muted[target] = until
+
+	case ModKick:
+		if rm.kicked[target] {
+			return false, nil
+		}
+		rm.kicked[target] = true
+	}
+
+	rm.events = append(rm.events, event)
+	return true, nil
+}
+
+// Method that replaces the accepted events of the same action with the
+// given one, only the latest is worth rebroadcasting. Expects the lock to be held
+func (rm *roomModeration) replaceEvents(event *moderationEvent) {
+	events := rm.events[:0]
+	for _, accepted := range rm.events {
+		if accepted.Action != event.Action {
+			events = append(events, accepted)
+		}
+	}
+
+	rm.events = append(events, event)
+}
+
+// Method that replaces the accepted grants and revocations of the target of
+// the given one with it, only the latest tells whether the target is still
+// a moderator. Expects the lock to be held
+func (rm *roomModeration) replaceTargetEvents(event *moderationEvent) {
+	events := rm.events[:0]
+	for _, accepted := range rm.events {
+		if (accepted.Action != ModGrant && accepted.Action != ModRevoke) || accepted.Target != event.Target {
+			events = append(events, accepted)
+		}
+	}
+
+	rm.events = append(events, event)
+}
+
+// Method that drops the accepted mutes and kicks issued by the peer,
+// reporting if there were any. Expects the lock to be held
+func (rm *roomModeration) dropIssuedBy(issuer string) bool {
+	dropped := false
+	events := rm.events[:0]
+	for _, accepted := range rm.events {
+		if (accepted.Action == ModMute || accepted.Action == ModKick) && accepted.Issuer == issuer {
+			dropped = true
+			continue
+		}
+		events = append(events, accepted)
+	}
+	rm.events = events
+
+	return dropped
+}
+
+// Method that works out who is muted and kicked again from
+// the accepted events. Expects the lock to be held
+func (rm *roomModeration) rebuildSanctions() {
+	rm.muted = make(map[peer.ID]time.Time)
+	rm.kicked = make(map[peer.ID]bool)
+
+	for _, event := range rm.events {
+		target, err := peer.Decode(event.Target)
+		if err != nil {
+			continue
+		}
+
+		switch event.Action {
+		case ModMute:
+			if until := time.Unix(event.Until, 0); until.After(rm.muted[target]) {
+				rm.muted[target] = until
+			}
+		case ModKick:
+			rm.kicked[target] = true
+		}
+	}
+}
+
+// Method that returns the room metadata set by its creator
+func (rm *roomModeration) Meta() roomMeta {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	return rm.meta
+}
+
+// Method that returns how long messages last in the room, zero if forever
+func (rm *roomModeration) TTL() time.Duration {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	return rm.ttl
+}
+
+// Method that checks if a peer is muted or kicked
+func (rm *roomModeration) IsSilenced(id peer.ID) bool {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	return rm.kicked[id] || time.Now().Before(rm.muted[id])
+}
+
+// Method that returns the peer of the room creator, empty if it wasn't claimed yet
+func (rm *roomModeration) Owner() peer.ID {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	return rm.owner
+}
+
+// Method that checks if the peer can moderate the room
+func (rm *roomModeration) IsModerator(id peer.ID) bool {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	return id == rm.owner || rm.moderators[id]
+}
+
+// Method that returns the accepted events still worth rebroadcasting
+func (rm *roomModeration) Events() []*moderationEvent {
+	rm.lock.RLock()
+	defer rm.lock.RUnlock()
+
+	now := time.Now().Unix()
+	events := make([]*moderationEvent, 0, len(rm.events))
+	for _, event := range rm.events {
+		if event.Action == ModMute && event.Until < now {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// Method that signs, applies and publishes a moderation event. The duration
+// is how long a mute lasts, or how long messages last for the ttl action
+func (cr *ChatRoom) Moderate(action string, target peer.ID, duration time.Duration) error {
+	pvtkey := cr.Host.Host.Peerstore().PrivKey(cr.selfID)
+	if pvtkey == nil {
+		return fmt.Errorf("missing the private key of this host")
+	}
+
+	var until time.Time
+	if action == ModMute {
+		until = time.Now().Add(duration)
+	}
+
+	var ttl time.Duration
+	if action == ModTTL {
+		ttl = duration
+	}
+
+	event, err := signModeration(pvtkey, cr.roomKey, action, cr.topicName, target, until, ttl)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cr.Moderation.Apply(event); err != nil {
+		return err
+	}
+
+	cr.publish(ChatMessage{Type: MessageModeration, Moderation: event})
+	return nil
+}
+
+// Method that signs, applies and publishes the room topic and description,
+// along with the creation time of the room
+func (cr *ChatRoom) SetTopic(topic, description string) error {
+	if cr.roomKey == nil {
+		return fmt.Errorf("only the creator of the room can set its topic")
+	}
+
+	pvtkey := cr.Host.Host.Peerstore().PrivKey(cr.selfID)
+	if pvtkey == nil {
+		return fmt.Errorf("missing the private key of this host")
+	}
+
+	event := &moderationEvent{
+		Action:      ModTopic,
+		Room:        cr.topicName,
+		Target:      cr.selfID.Pretty(),
+		Topic:       topic,
+		Description: description,
+	}
+
+	// the creation key was made along with the room
+	_, fingerprint := p2p.SplitRoomName(cr.RoomName)
+	if created := cr.Host.RoomKeys.Created(fingerprint); !created.IsZero() {
+		event.Created = created.Unix()
+	}
+
+	if err := event.sign(pvtkey, cr.roomKey); err != nil {
+		return err
+	}
+
+	if _, err := cr.Moderation.Apply(event); err != nil {
+		return err
+	}
+
+	cr.publish(ChatMessage{Type: MessageModeration, Moderation: event})
+	return nil
+}
+
+// Method that validates messages before they are delivered or relayed.
+// Forged moderation events and messages without the proof-of-work the room
+// demands are rejected, while messages from silenced and flooding peers,
+// and from peers that don't know the room password, are ignored
+func (cr *ChatRoom) validateMessage(_ context.Context, _ peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	author := msg.GetFrom()
+	if cr.Moderation.IsSilenced(author) {
+		return pubsub.ValidationIgnore
+	}
+
+	// strangers get challenged, and heard once they pass
+	if author != cr.selfID && !cr.gate.Admits(author) {
+		go cr.challenge(author)
+		return pubsub.ValidationIgnore
+	}
+
+	cm := &ChatMessage{}
+	if err := json.Unmarshal(msg.Data, cm); err != nil {
+		return pubsub.ValidationReject
+	}
+
+	// rooms demanding proof-of-work drop anything without fresh work
+	if cr.workBits > 0 {
+		now := time.Now()
+		if err := checkStamp(cm.Stamp, stampResource(cr.topicName, author), cr.workBits, now); err != nil {
+			return pubsub.ValidationReject
+		}
+		if !cr.stamps.Spend(cm.Stamp, now) {
+			return pubsub.ValidationReject
+		}
+	}
+
+	// our own messages are not for us to throttle, and
+	// a chunked message is throttled as one message
+	if author != cr.selfID {
+		var allowed, muted bool
+		if cm.Type == messageChunk && cm.Chunk != nil {
+			allowed, muted = cr.limiter.AllowChunk(author, cm.Chunk)
+		} else {
+			allowed, muted = cr.limiter.Allow(author)
+		}
+		if muted {
+			cr.Log(ChatLog{
+				Prefix: "flood",
+				Msg:    fmt.Sprintf("%s is flooding the room, muted for %s", p2p.ShortID(author), autoMuteDuration),
+			})
+		}
+		if !allowed {
+			return pubsub.ValidationIgnore
+		}
+	}
+
+	// moderation events are checked inside the seal too
+	if secret := cr.gate.Secret(); secret != nil && cm.Type == messageSealed && cm.Sealed != nil {
+		payload, err := secret.Open(cm.Sealed)
+		if err != nil {
+			return pubsub.ValidationIgnore
+		}
+
+		cm = &ChatMessage{}
+		if err := json.Unmarshal(payload, cm); err != nil {
+			return pubsub.ValidationReject
+		}
+	}
+
+	if cm.Type == MessageModeration {
+		if cm.Moderation == nil {
+			return pubsub.ValidationReject
+		}
+		if _, _, err := cr.Moderation.Check(cm.Moderation); err != nil {
+			return pubsub.ValidationReject
+		}
+	}
+
+	return pubsub.ValidationAccept
+}
+
+// Method that lets the room creator claim the room, and rebroadcast
+// the moderation state now and then, so peers joining later learn about it
+func (cr *ChatRoom) republishModeration() {
+	if cr.roomKey == nil {
+		return
+	}
+
+	if err := cr.Moderate(ModClaim, cr.selfID, 0); err != nil {
+		cr.Log(ChatLog{Prefix: "moderr", Msg: err.Error()})
+	}
+
+	ticker := time.NewTicker(moderationRepublish)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return
+
+		case <-ticker.C:
+			for _, event := range cr.Moderation.Events() {
+				cr.publish(ChatMessage{Type: MessageModeration, Moderation: event})
+			}
+		}
+	}
+}
+
+// This one describes a moderation event for the log
+func DescribeModeration(event *moderationEvent) string {
+	issuer, target := event.Issuer, event.Target
+	if id, err := peer.Decode(issuer); err == nil {
+		issuer = p2p.ShortID(id)
+	}
+	if id, err := peer.Decode(target); err == nil {
+		target = p2p.ShortID(id)
+	}
+
+	switch event.Action {
+	case ModClaim:
+		return fmt.Sprintf("%s is the creator of this room", issuer)
+	case ModGrant:
+		return fmt.Sprintf("%s made %s a moderator", issuer, target)
+	case ModRevoke:
+		return fmt.Sprintf("%s revoked %s as a moderator", issuer, target)
+	case ModMute:
+		return fmt.Sprintf("%s muted %s until %s", issuer, target, time.Unix(event.Until, 0).Format("15:04"))
+	case ModKick:
+		return fmt.Sprintf("%s kicked %s", issuer, target)
+	case ModTopic:
+		if len(event.Topic) == 0 {
+			return fmt.Sprintf("%s cleared the room topic", issuer)
+		}
+		return fmt.Sprintf("%s set the room topic to %q", issuer, event.Topic)
+	case ModTTL:
+		if event.TTL == 0 {
+			return fmt.Sprintf("%s turned disappearing messages off", issuer)
+		}
+		return fmt.Sprintf("%s set messages to disappear after %s", issuer, time.Duration(event.TTL)*time.Second)
+	default:
+		return fmt.Sprintf("%s did something unknown to %s", issuer, target)
+	}
+}
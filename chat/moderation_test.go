@@ -0,0 +1,165 @@
+package chat_test
+
+import (
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/chat/chattest"
+)
+
+// This one waits until every room agrees with the check, failing the test if
+// they don't in time
+func waitAll(t *testing.T, rooms []*chat.ChatRoom, what string, check func(cr *chat.ChatRoom) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(deliveryTimeout)
+	for _, cr := range rooms {
+		for !check(cr) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never saw %s", cr.Username, what)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestModeration(t *testing.T) {
+	network, err := chattest.NewNetwork(waitContext(t), 3)
+	if err != nil {
+		t.Fatalf("starting the network: %s", err)
+	}
+	t.Cleanup(func() { network.Close() })
+
+	// the first host creates the room, and holds its key
+	roomName, err := network.Hosts[0].RoomKeys.Create("moderated")
+	if err != nil {
+		t.Fatalf("creating the room: %s", err)
+	}
+
+	rooms, err := network.Join(roomName)
+	if err != nil {
+		t.Fatalf("joining %s: %s", roomName, err)
+	}
+	t.Cleanup(func() {
+		for _, cr := range rooms {
+			cr.Leave()
+		}
+	})
+
+	// the rooms wait for their handlers to take what changed
+	for _, cr := range rooms {
+		chattest.Record(cr)
+	}
+
+	creator, moderator, member := rooms[0], rooms[1], rooms[2]
+
+	// the claim made on joining went out before anyone else was there
+	if err := creator.Moderate(chat.ModClaim, creator.SelfID(), 0); err != nil {
+		t.Fatalf("claiming the room: %s", err)
+	}
+	waitAll(t, rooms, "the room claimed", func(cr *chat.ChatRoom) bool {
+		return cr.Moderation.Owner() == creator.SelfID()
+	})
+
+	// nobody but the creator and moderators gets to moderate
+	if err := member.Moderate(chat.ModKick, moderator.SelfID(), 0); err == nil {
+		t.Error("a member kicked someone")
+	}
+	if err := moderator.Moderate(chat.ModGrant, member.SelfID(), 0); err == nil {
+		t.Error("someone but the creator granted a moderator")
+	}
+
+	if err := creator.Moderate(chat.ModGrant, moderator.SelfID(), 0); err != nil {
+		t.Fatalf("granting a moderator: %s", err)
+	}
+	waitAll(t, rooms, "the moderator granted", func(cr *chat.ChatRoom) bool {
+		return cr.Moderation.IsModerator(moderator.SelfID())
+	})
+
+	if err := moderator.Moderate(chat.ModMute, member.SelfID(), time.Minute); err != nil {
+		t.Fatalf("muting a member: %s", err)
+	}
+	waitAll(t, rooms, "the member muted", func(cr *chat.ChatRoom) bool {
+		return cr.Moderation.IsSilenced(member.SelfID())
+	})
+
+	// the creator can't be moderated, even by its moderators
+	if err := moderator.Moderate(chat.ModKick, creator.SelfID(), 0); err == nil {
+		t.Error("a moderator kicked the room creator")
+	}
+
+	// a revoked moderator is back to being a member
+	if err := creator.Moderate(chat.ModRevoke, moderator.SelfID(), 0); err != nil {
+		t.Fatalf("revoking a moderator: %s", err)
+	}
+	waitAll(t, rooms, "the moderator revoked", func(cr *chat.ChatRoom) bool {
+		return !cr.Moderation.IsModerator(moderator.SelfID())
+	})
+	// and what it did is taken back along with its rights
+	waitAll(t, rooms, "the member unmuted", func(cr *chat.ChatRoom) bool {
+		return !cr.Moderation.IsSilenced(member.SelfID())
+	})
+	if err := moderator.Moderate(chat.ModKick, member.SelfID(), 0); err == nil {
+		t.Error("a revoked moderator kicked someone")
+	}
+
+	for _, cr := range rooms {
+		for _, id := range []peer.ID{creator.SelfID(), moderator.SelfID()} {
+			if cr.Moderation.IsSilenced(id) {
+				t.Errorf("%s sees %s silenced", cr.Username, id)
+			}
+		}
+	}
+}
+
+func TestModeratorTie(t *testing.T) {
+	hostKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roomKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, targetKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := peer.IDFromPublicKey(targetKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a grant and a revocation issued in the same second
+	var grant, revoke *chat.ModerationEvent
+	for grant == nil || grant.Issued != revoke.Issued {
+		if grant, err = chat.SignModeration(hostKey, roomKey, chat.ModGrant, "tie", target); err != nil {
+			t.Fatal(err)
+		}
+		if revoke, err = chat.SignModeration(hostKey, roomKey, chat.ModRevoke, "tie", target); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the revocation wins, whichever of them arrives first
+	for _, events := range [][]*chat.ModerationEvent{{grant, revoke}, {revoke, grant}} {
+		rm, err := chat.NewRoomModeration("tie", roomKey.GetPublic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			rm.Apply(event)
+		}
+
+		if rm.IsModerator(target) {
+			t.Errorf("%s then %s left the target a moderator", events[0].Action, events[1].Action)
+		}
+		if kept := rm.Events(); len(kept) != 1 || kept[0] != revoke {
+			t.Errorf("%s then %s kept %d events, want the revocation alone", events[0].Action, events[1].Action, len(kept))
+		}
+	}
+}
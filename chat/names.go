@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// separates a username from the peer ID suffix telling its holders apart
+const nameSuffixSeparator = "#"
+
+// characters of the peer ID used to tell holders of the same username apart
+const nameSuffixLength = 4
+
+// This one returns the username with a suffix of the peers ID, like alice#ab12
+func Disambiguate(username, id string) string {
+	if len(id) > nameSuffixLength {
+		id = id[len(id)-nameSuffixLength:]
+	}
+
+	return username + nameSuffixSeparator + id
+}
+
+// This one splits a disambiguated username into the name and the peer ID suffix
+func SplitUsername(name string) (string, string) {
+	i := strings.LastIndex(name, nameSuffixSeparator)
+	if i < 0 {
+		return name, ""
+	}
+
+	return name[:i], name[i+len(nameSuffixSeparator):]
+}
+
+// Method that returns the usernames announced by more than one peer in
+// the room, us included, as learned through the profile handshake
+func (cr *ChatRoom) UsernameCollisions() map[string]bool {
+	holders := map[string]int{cr.Username: 1}
+	for _, p := range cr.GetPeers() {
+		if prof := cr.Host.Profiles.Lookup(p); prof != nil {
+			holders[prof.Username]++
+		}
+	}
+
+	collisions := make(map[string]bool)
+	for name, count := range holders {
+		if count > 1 {
+			collisions[name] = true
+		}
+	}
+
+	return collisions
+}
+
+// Method that returns the other peers in the room announcing the username
+func (cr *ChatRoom) UsernameHolders(username string) []peer.ID {
+	var holders []peer.ID
+	for _, p := range cr.GetPeers() {
+		if prof := cr.Host.Profiles.Lookup(p); prof != nil && prof.Username == username {
+			holders = append(holders, p)
+		}
+	}
+
+	return holders
+}
@@ -0,0 +1,101 @@
+package chat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// KeyPins remember the identity key first seen under each username,
+// trust on first use like SSH known hosts. Peer IDs are derived from
+// the identity keys, so pinning the peer ID pins the key
+type KeyPins struct {
+	lock sync.Mutex
+	path string
+
+	// base58 encoded peer IDs by username
+	Pins map[string]string `json:"pins"`
+}
+
+// This one loads the pins from the given file,
+// starting with no pins if the file doesn't exist yet
+func LoadKeyPins(path string) (*KeyPins, error) {
+	kp := &KeyPins{path: path, Pins: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return kp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, kp); err != nil {
+		return nil, err
+	}
+
+	if kp.Pins == nil {
+		kp.Pins = make(map[string]string)
+	}
+
+	return kp, nil
+}
+
+// Method that writes the pins to disk, expects the lock to be held
+func (kp *KeyPins) save() error {
+	data, err := json.MarshalIndent(kp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(kp.path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(kp.path, data, 0600)
+}
+
+// Method that checks the key used under a username against the pinned one,
+// pinning it if the username is new. Returns the pinned peer if it differs
+func (kp *KeyPins) Check(username string, id peer.ID) (peer.ID, bool, error) {
+	// everyone starts out with the default name, there is nothing to pin
+	if len(username) == 0 || username == p2p.DefaultUsername {
+		return "", false, nil
+	}
+
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	pinned, ok := kp.Pins[username]
+	if !ok {
+		kp.Pins[username] = id.Pretty()
+		return "", false, kp.save()
+	}
+
+	if pinned == id.Pretty() {
+		return "", false, nil
+	}
+
+	previous, err := peer.Decode(pinned)
+	if err != nil {
+		// a broken pin is no pin at all
+		kp.Pins[username] = id.Pretty()
+		return "", false, kp.save()
+	}
+
+	return previous, true, nil
+}
+
+// Method that pins the username to the given peer, accepting its new key
+func (kp *KeyPins) Pin(username string, id peer.ID) error {
+	kp.lock.Lock()
+	defer kp.lock.Unlock()
+
+	kp.Pins[username] = id.Pretty()
+	return kp.save()
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// most options a single poll can offer
+const MaxPollOptions = 10
+
+// a poll carried inside a chat message, votes refer to it by the message ID
+type Poll struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+}
+
+// This one parses the arguments of the poll command, a question
+// followed by the options, where quotes keep words together
+func ParsePoll(arg string) (*Poll, error) {
+	fields, err := SplitQuoted(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("usage: /poll \"question\" option1 option2 ...")
+	}
+	if len(fields)-1 > MaxPollOptions {
+		return nil, fmt.Errorf("a poll can have at most %d options", MaxPollOptions)
+	}
+
+	return &Poll{Question: fields[0], Options: fields[1:]}, nil
+}
+
+// This one splits a command argument on spaces, except within double quotes
+func SplitQuoted(arg string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+
+	quoted, started := false, false
+	for _, r := range arg {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+
+		case unicode.IsSpace(r) && !quoted:
+			if started {
+				fields = append(fields, field.String())
+				field.Reset()
+				started = false
+			}
+
+		default:
+			field.WriteRune(r)
+			started = true
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if started {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
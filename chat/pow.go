@@ -0,0 +1,132 @@
+package chat
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// hashcash style stamp version we mint
+const stampVersion = "1"
+
+// time format of the stamp date, the one hashcash uses
+const stampDateFormat = "060102150405"
+
+// how far a stamp date may be off from our clock
+const stampWindow = 2 * time.Minute
+
+// This one returns what a stamp of the peer in the room is minted for,
+// colons are the stamp field separator and can't be part of it
+func stampResource(topic string, id peer.ID) string {
+	return strings.ReplaceAll(topic, ":", "_") + "/" + id.Pretty()
+}
+
+// This one mints a hashcash style stamp for the resource, trying counters
+// until the SHA-256 of the stamp starts with the given number of zero bits
+func mintStamp(resource string, difficulty int) string {
+	salt := make([]byte, 12)
+	rand.Read(salt)
+
+	prefix := fmt.Sprintf("%s:%d:%s:%s::%s:", stampVersion, difficulty,
+		time.Now().UTC().Format(stampDateFormat), resource, base64.RawStdEncoding.EncodeToString(salt))
+
+	for counter := uint64(0); ; counter++ {
+		stamp := prefix + strconv.FormatUint(counter, 36)
+		if leadingZeroBits(sha256.Sum256([]byte(stamp))) >= difficulty {
+			return stamp
+		}
+	}
+}
+
+// This one checks that the stamp is for the resource, fresh enough,
+// and carries at least the demanded amount of work
+func checkStamp(stamp, resource string, difficulty int, now time.Time) error {
+	fields := strings.Split(stamp, ":")
+	if len(fields) != 7 || fields[0] != stampVersion {
+		return fmt.Errorf("malformed stamp")
+	}
+
+	claimed, err := strconv.Atoi(fields[1])
+	if err != nil || claimed < difficulty {
+		return fmt.Errorf("stamp of too little work")
+	}
+
+	if fields[3] != resource {
+		return fmt.Errorf("stamp for another resource")
+	}
+
+	date, err := time.Parse(stampDateFormat, fields[2])
+	if err != nil {
+		return fmt.Errorf("malformed stamp date")
+	}
+	if date.Before(now.Add(-stampWindow)) || date.After(now.Add(stampWindow)) {
+		return fmt.Errorf("stale stamp")
+	}
+
+	if leadingZeroBits(sha256.Sum256([]byte(stamp))) < claimed {
+		return fmt.Errorf("stamp of too little work")
+	}
+
+	return nil
+}
+
+// This one counts the leading zero bits of a hash
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+
+	return zeros
+}
+
+// stampLedger remembers spent stamps for as long as they are fresh,
+// so the same work can't be used for more than one message
+type stampLedger struct {
+	lock  sync.Mutex
+	spent map[string]time.Time
+
+	lastSweep time.Time
+}
+
+// Constructor function for an empty stamp ledger
+func newStampLedger() *stampLedger {
+	return &stampLedger{
+		spent:     make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Method that marks the stamp as spent, reporting false if it already was
+func (sl *stampLedger) Spend(stamp string, now time.Time) bool {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	// stamps older than the window would be rejected anyway
+	if now.Sub(sl.lastSweep) > stampWindow {
+		for spent, at := range sl.spent {
+			if now.Sub(at) > 2*stampWindow {
+				delete(sl.spent, spent)
+			}
+		}
+		sl.lastSweep = now
+	}
+
+	if _, ok := sl.spent[stamp]; ok {
+		return false
+	}
+
+	sl.spent[stamp] = now
+	return true
+}
@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// how long we wait for a linked page
+const PreviewTimeout = 5 * time.Second
+
+// how much of a page we read looking for its metadata
+const maxPreviewBody = 512 << 10
+
+// longest preview title and description we keep, in columns
+const MaxPreviewTitle = 100
+const MaxPreviewDescription = 200
+
+// matches links inside a message
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"\[\]]+`)
+
+// title and description of a linked page, fetched by the sender
+// so that receivers never have to hit the link themselves
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// This one returns the first link in a message, empty if there is none
+func FindURL(text string) string {
+	// sentence punctuation is not part of the link
+	return strings.TrimRight(urlPattern.FindString(text), ".,;:!?)")
+}
+
+// This one fetches the page behind the link and reads its metadata
+func FetchPreview(ctx context.Context, url string) (*LinkPreview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "p2pchat link preview")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+
+	if media, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || media != "text/html" {
+		return nil, fmt.Errorf("%s is not a web page", url)
+	}
+
+	preview := parsePreview(io.LimitReader(resp.Body, maxPreviewBody))
+	if preview == nil {
+		return nil, fmt.Errorf("%s has no title", url)
+	}
+
+	preview.URL = url
+	return preview, nil
+}
+
+// This one reads the title and description of an HTML page, preferring
+// the Open Graph ones. Returns nil if the page has no title at all
+func parsePreview(body io.Reader) *LinkPreview {
+	var title, ogTitle, description, ogDescription string
+
+	tokens := html.NewTokenizer(body)
+	inTitle := false
+
+	for {
+		switch tokens.Next() {
+		case html.ErrorToken:
+			// end of the page, or as much of it as we read
+			return newLinkPreview(firstOf(ogTitle, title), firstOf(ogDescription, description))
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokens.Token()
+			switch token.Data {
+			case "title":
+				inTitle = true
+
+			case "meta":
+				var name, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "name", "property":
+						name = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+
+				switch name {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				}
+
+			case "body":
+				// metadata lives in the head
+				return newLinkPreview(firstOf(ogTitle, title), firstOf(ogDescription, description))
+			}
+
+		case html.TextToken:
+			if inTitle && len(title) == 0 {
+				title = string(tokens.Text())
+			}
+
+		case html.EndTagToken:
+			if token := tokens.Token(); token.Data == "title" {
+				inTitle = false
+			}
+		}
+	}
+}
+
+// This one builds a cleaned up preview, nil without a title
+func newLinkPreview(title, description string) *LinkPreview {
+	preview := &LinkPreview{
+		Title:       CleanPreviewText(title, MaxPreviewTitle),
+		Description: CleanPreviewText(description, MaxPreviewDescription),
+	}
+
+	if len(preview.Title) == 0 {
+		return nil
+	}
+
+	return preview
+}
+
+// This one collapses whitespace and shortens preview text
+func CleanPreviewText(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+
+	return TruncateWidth(text, limit)
+}
+
+// This one returns the first non empty string
+func firstOf(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+
+	return ""
+}
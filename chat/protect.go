@@ -0,0 +1,99 @@
+package chat
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// how often the peers in a room are looked at again, to protect newcomers
+const protectInterval = 10 * time.Second
+
+// Method that keeps the connections to the peers in the room protected,
+// so the connection manager trimming down to its watermarks never closes
+// them. Newcomers are protected as they show up, and peers gone are no
+// longer, until the room is left
+func (cr *ChatRoom) protectPeers() {
+	cr.syncProtected()
+
+	ticker := time.NewTicker(protectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.ctx.Done():
+			return
+
+		case <-ticker.C:
+			cr.syncProtected()
+		}
+	}
+}
+
+// Method that protects the peers in the room, and stops
+// protecting the ones that left it, unless they were pinned
+func (cr *ChatRoom) syncProtected() {
+	here := make(map[peer.ID]bool)
+	for _, p := range cr.GetPeers() {
+		here[p] = true
+	}
+
+	cr.protectLock.Lock()
+	defer cr.protectLock.Unlock()
+
+	// a room being left protects nobody anymore
+	if cr.ctx.Err() != nil {
+		return
+	}
+
+	connmgr := cr.Host.Host.ConnManager()
+	for p := range here {
+		if !cr.protected[p] {
+			connmgr.Protect(p, cr.protectTag())
+			cr.protected[p] = true
+		}
+	}
+	for p := range cr.protected {
+		if !here[p] && !cr.pinned[p] {
+			connmgr.Unprotect(p, cr.protectTag())
+			delete(cr.protected, p)
+		}
+	}
+}
+
+// Method that protects the connection to a peer for as long as we are in
+// the room, even while the peer isn't, like the other side of a direct
+// conversation
+func (cr *ChatRoom) ProtectPeer(id peer.ID) {
+	cr.protectLock.Lock()
+	defer cr.protectLock.Unlock()
+
+	if cr.ctx.Err() != nil {
+		return
+	}
+
+	cr.pinned[id] = true
+	if !cr.protected[id] {
+		cr.Host.Host.ConnManager().Protect(id, cr.protectTag())
+		cr.protected[id] = true
+	}
+}
+
+// Method that stops protecting the connections to the peers of the room
+func (cr *ChatRoom) unprotectPeers() {
+	cr.protectLock.Lock()
+	defer cr.protectLock.Unlock()
+
+	connmgr := cr.Host.Host.ConnManager()
+	for p := range cr.protected {
+		connmgr.Unprotect(p, cr.protectTag())
+	}
+	cr.protected = make(map[peer.ID]bool)
+	cr.pinned = make(map[peer.ID]bool)
+}
+
+// Method that returns the tag the connections to the peers of the
+// room are protected under, one of its own for each room
+func (cr *ChatRoom) protectTag() string {
+	return "p2pchat/room/" + cr.topicName
+}
@@ -0,0 +1,150 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// messages dropped by the limiter before the peer gets muted for a while
+const autoMuteStrikes = 20
+
+// how long a flooding peer stays auto muted
+const autoMuteDuration = 5 * time.Minute
+
+// token bucket of a single peer
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	strikes int
+
+	mutedUntil time.Time
+}
+
+// rateLimiter throttles inbound messages per peer with token buckets,
+// muting peers that keep exceeding the limit
+type rateLimiter struct {
+	lock sync.Mutex
+
+	// tokens added per second, zero disables the limiter
+	rate float64
+	// size of the bucket, the burst a peer may send at once
+	burst float64
+
+	buckets map[peer.ID]*tokenBucket
+	// chunked messages whose first chunk was let through,
+	// with how many more of their chunks come free
+	chunked map[chunkKey]*chunkedAllowance
+}
+
+// the chunks of an allowed chunked message still to come
+type chunkedAllowance struct {
+	left     int
+	deadline time.Time
+}
+
+// Constructor function for a new rate limiter, bursts of up to twice the rate are allowed
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   rate * 2,
+		buckets: make(map[peer.ID]*tokenBucket),
+		chunked: make(map[chunkKey]*chunkedAllowance),
+	}
+}
+
+// Method that takes a token from the peers bucket. It reports whether the
+// message is allowed, and whether this very message got the peer auto muted
+func (rl *rateLimiter) Allow(id peer.ID) (bool, bool) {
+	if rl.rate <= 0 {
+		return true, false
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	return rl.take(id, time.Now())
+}
+
+// Method that takes a token for a chunk of a chunked message. The message
+// costs a token once, taken by the first of its chunks to arrive, and the
+// rest of the chunks it says it has come free. It reports the same as Allow
+func (rl *rateLimiter) AllowChunk(id peer.ID, chunk *payloadChunk) (bool, bool) {
+	if rl.rate <= 0 {
+		return true, false
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := time.Now()
+	key := chunkKey{from: id, id: chunk.ID}
+
+	if allowance, ok := rl.chunked[key]; ok && now.Before(allowance.deadline) {
+		if bucket := rl.buckets[id]; bucket != nil && now.Before(bucket.mutedUntil) {
+			return false, false
+		}
+
+		allowance.left--
+		if allowance.left <= 0 {
+			delete(rl.chunked, key)
+		}
+		return true, false
+	}
+
+	allowed, muted := rl.take(id, now)
+	if !allowed || chunk.Total <= 1 || chunk.Total > maxChunkedSize/chunkSize+1 {
+		return allowed, muted
+	}
+
+	// forget about messages whose chunks stopped coming
+	for k, allowance := range rl.chunked {
+		if !now.Before(allowance.deadline) {
+			delete(rl.chunked, k)
+		}
+	}
+	rl.chunked[key] = &chunkedAllowance{left: chunk.Total - 1, deadline: now.Add(chunkTimeout)}
+
+	return true, false
+}
+
+// Method that takes a token from the peers bucket at the given time,
+// reporting the same as Allow. Expects the lock to be held
+func (rl *rateLimiter) take(id peer.ID, now time.Time) (bool, bool) {
+	bucket, ok := rl.buckets[id]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[id] = bucket
+	}
+
+	if now.Before(bucket.mutedUntil) {
+		return false, false
+	}
+
+	// refill for the time since the last message
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rl.rate
+	if bucket.tokens > rl.burst {
+		bucket.tokens = rl.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+
+		// a full bucket means the peer calmed down
+		if bucket.tokens >= rl.burst-1 {
+			bucket.strikes = 0
+		}
+		return true, false
+	}
+
+	bucket.strikes++
+	if bucket.strikes < autoMuteStrikes {
+		return false, false
+	}
+
+	bucket.strikes = 0
+	bucket.mutedUntil = now.Add(autoMuteDuration)
+	return false, true
+}
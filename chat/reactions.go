@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+)
+
+// longest reaction we accept, in bytes, enough for any emoji sequence
+const maxReactionLength = 32
+
+// shortcodes for the usual reactions, so they can be typed anywhere
+var reactionShortcodes = map[string]string{
+	":+1:":    "👍",
+	":-1:":    "👎",
+	":heart:": "❤️",
+	":joy:":   "😂",
+	":tada:":  "🎉",
+	":wow:":   "😮",
+	":sad:":   "😢",
+	":eyes:":  "👀",
+}
+
+// This one resolves a reaction shortcode, and rejects anything
+// that looks more like a message than a reaction
+func ParseReaction(reaction string) (string, error) {
+	if emoji, ok := reactionShortcodes[reaction]; ok {
+		return emoji, nil
+	}
+
+	if len(reaction) == 0 || len(reaction) > maxReactionLength || strings.ContainsAny(reaction, " \t\n[]") {
+		return "", fmt.Errorf("%q is not a reaction", reaction)
+	}
+
+	return reaction, nil
+}
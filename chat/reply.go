@@ -0,0 +1,25 @@
+package chat
+
+import (
+	"strings"
+)
+
+// columns the quoted text of a reply may take
+const quoteWidth = 60
+
+// the sender and first line of a replied to message, sent along with the
+// reply so the context is there even for peers who never saw the original
+type ReplyQuote struct {
+	Sender string `json:"sender"`
+	Text   string `json:"text"`
+}
+
+// This one makes the quote of a message: who sent it and its first line,
+// cut short to fit a line of its own
+func NewReplyQuote(sender, text string) *ReplyQuote {
+	if idx := strings.IndexByte(text, '\n'); idx >= 0 {
+		text = text[:idx] + " …"
+	}
+
+	return &ReplyQuote{Sender: sender, Text: TruncateWidth(text, quoteWidth)}
+}
@@ -0,0 +1,42 @@
+package chat
+
+import "github.com/xtopala/p2pchat/p2p"
+
+// This one sanitizes every text of a message received from another peer,
+// before anything gets to show it
+func sanitizeMessage(cm *ChatMessage) {
+	cm.Message = p2p.SanitizeText(cm.Message)
+	cm.SenderName = p2p.SanitizeText(cm.SenderName)
+
+	if cm.Quote != nil {
+		cm.Quote.Sender = p2p.SanitizeText(cm.Quote.Sender)
+		cm.Quote.Text = p2p.SanitizeText(cm.Quote.Text)
+	}
+	if cm.Attachment != nil {
+		cm.Attachment.Name = p2p.SanitizeText(cm.Attachment.Name)
+	}
+	if cm.Preview != nil {
+		cm.Preview.Title = p2p.SanitizeText(cm.Preview.Title)
+		cm.Preview.Description = p2p.SanitizeText(cm.Preview.Description)
+	}
+	if cm.Command != nil {
+		for i, arg := range cm.Command.Args {
+			cm.Command.Args[i] = p2p.SanitizeText(arg)
+		}
+	}
+	if cm.Response != nil {
+		cm.Response.Command = p2p.SanitizeText(cm.Response.Command)
+		cm.Response.Error = p2p.SanitizeText(cm.Response.Error)
+		cm.Response.Text = p2p.SanitizeText(cm.Response.Text)
+		for i := range cm.Response.Fields {
+			cm.Response.Fields[i].Name = p2p.SanitizeText(cm.Response.Fields[i].Name)
+			cm.Response.Fields[i].Value = p2p.SanitizeText(cm.Response.Fields[i].Value)
+		}
+	}
+	if cm.Poll != nil {
+		cm.Poll.Question = p2p.SanitizeText(cm.Poll.Question)
+		for i, option := range cm.Poll.Options {
+			cm.Poll.Options[i] = p2p.SanitizeText(option)
+		}
+	}
+}
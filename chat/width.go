@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// This one returns how many columns a character takes, the way the message
+// list measures it. Characters can be made of several runes, like accented
+// letters and emoji sequences, and the first rune that takes any room tells
+func clusterWidth(runes []rune) int {
+	for _, r := range runes {
+		if width := runewidth.RuneWidth(r); width > 0 {
+			return width
+		}
+	}
+
+	return 0
+}
+
+// This one returns how many columns text takes in the terminal, with wide
+// characters like CJK and most emoji taking two
+func DisplayWidth(text string) int {
+	width := 0
+	chars := uniseg.NewGraphemes(text)
+	for chars.Next() {
+		width += clusterWidth(chars.Runes())
+	}
+
+	return width
+}
+
+// This one cuts text to fit in the given number of columns, ending it with
+// an ellipsis if it had to be cut. Characters are never split, so accents
+// stay on their letters and emoji sequences whole
+func TruncateWidth(text string, width int) string {
+	if DisplayWidth(text) <= width {
+		return text
+	}
+
+	// the ellipsis takes a column of its own
+	out := &strings.Builder{}
+	used := 0
+	chars := uniseg.NewGraphemes(text)
+	for chars.Next() {
+		charWidth := clusterWidth(chars.Runes())
+		if used+charWidth > width-1 {
+			break
+		}
+		used += charWidth
+		out.WriteString(chars.Str())
+	}
+
+	return out.String() + "…"
+}
@@ -0,0 +1,321 @@
+//go:build js && wasm
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"syscall/js"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// client is the chat running in a browser page, a host
+// with no identity or lists kept, and its rooms by name
+type client struct {
+	host *p2p.P2P
+	// object of the page told what happens, with the onMessage,
+	// onLog, onPeerJoin and onReady functions it has
+	listener js.Value
+
+	// lock for the rooms
+	lock  sync.Mutex
+	rooms map[string]*chat.ChatRoom
+}
+
+// the client once started, nil before
+var (
+	current     *client
+	currentLock sync.Mutex
+)
+
+// The page loads the module and talks to the chat through the p2pchat
+// object it leaves behind. Functions doing anything over the network
+// return promises, as the page can't wait for them
+func main() {
+	js.Global().Set("p2pchat", js.ValueOf(map[string]interface{}{
+		"start": js.FuncOf(start),
+		"join":  js.FuncOf(join),
+		"send":  js.FuncOf(send),
+		"peers": js.FuncOf(peers),
+		"leave": js.FuncOf(leave),
+		"stop":  js.FuncOf(stop),
+	}))
+
+	// the functions are called for as long as the page is open
+	select {}
+}
+
+// This one starts the client with the bootstrap peers, separated by commas,
+// and the listener of the page. Browsers only dial WebSockets, so they have
+// to be /ws addresses, like the ones of a supernode. Resolves to our peer ID
+func start(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		if len(args) < 2 || args[0].Type() != js.TypeString || args[1].Type() != js.TypeObject {
+			return nil, fmt.Errorf("expected start(bootstrap, listener)")
+		}
+
+		currentLock.Lock()
+		defer currentLock.Unlock()
+		if current != nil {
+			return nil, fmt.Errorf("the chat is started already")
+		}
+
+		bootstrap, err := p2p.ParseBootstrapPeers(args[0].String())
+		if err != nil {
+			return nil, err
+		}
+		if len(bootstrap) == 0 {
+			return nil, fmt.Errorf("the browser can only reach peers at their /ws addresses, like the ones of a supernode")
+		}
+
+		// the page follows how far starting up got through its logs
+		c := &client{listener: args[1], rooms: make(map[string]*chat.ChatRoom)}
+		host, err := p2p.NewP2P(context.Background(), p2p.Options{
+			Bootstrap: bootstrap,
+			Progress: func(state p2p.StartupState) {
+				c.call("onLog", "", "startup", string(state))
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.host = host
+		go func() {
+			if err := host.AnnounceConnect(); err != nil {
+				if host.Ctx.Err() == nil {
+					c.call("onLog", "", "discovery", err.Error())
+				}
+				return
+			}
+
+			select {
+			case <-host.Ready():
+				c.call("onReady")
+			case <-host.Ctx.Done():
+			}
+		}()
+
+		current = c
+		return host.Host.ID().Pretty(), nil
+	})
+}
+
+// This one joins a room under the username, with the password if it has one
+func join(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		c, err := started()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("expected join(room, username, password)")
+		}
+
+		password := ""
+		if len(args) > 2 && args[2].Type() == js.TypeString {
+			password = args[2].String()
+		}
+
+		return nil, c.join(args[0].String(), args[1].String(), password)
+	})
+}
+
+// This one says the text in a joined room
+func send(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		c, err := started()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 2 {
+			return nil, fmt.Errorf("expected send(room, text)")
+		}
+
+		cr, err := c.room(args[0].String())
+		if err != nil {
+			return nil, err
+		}
+		text := args[1].String()
+		if err := chat.CheckMessageLength(text); err != nil {
+			return nil, err
+		}
+
+		return nil, cr.Send(cr.NewTextMessage(text))
+	})
+}
+
+// This one returns the peer IDs of the others in a joined room, right away
+func peers(this js.Value, args []js.Value) interface{} {
+	c, err := started()
+	if err != nil || len(args) < 1 {
+		return js.ValueOf([]interface{}{})
+	}
+	cr, err := c.room(args[0].String())
+	if err != nil {
+		return js.ValueOf([]interface{}{})
+	}
+
+	var ids []interface{}
+	for _, id := range cr.GetPeers() {
+		ids = append(ids, id.Pretty())
+	}
+
+	return js.ValueOf(ids)
+}
+
+// This one leaves a joined room
+func leave(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		c, err := started()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 1 {
+			return nil, fmt.Errorf("expected leave(room)")
+		}
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+
+		cr, ok := c.rooms[args[0].String()]
+		if !ok {
+			return nil, fmt.Errorf("not in the room %s", args[0].String())
+		}
+		cr.Leave()
+		delete(c.rooms, args[0].String())
+
+		return nil, nil
+	})
+}
+
+// This one leaves every room and stops the client, which can be started again
+func stop(this js.Value, args []js.Value) interface{} {
+	return promise(func() (interface{}, error) {
+		currentLock.Lock()
+		defer currentLock.Unlock()
+
+		if current == nil {
+			return nil, nil
+		}
+
+		current.lock.Lock()
+		for _, cr := range current.rooms {
+			cr.Leave()
+		}
+		current.rooms = nil
+		current.lock.Unlock()
+
+		err := current.host.Close()
+		current = nil
+		return nil, err
+	})
+}
+
+// This one returns the started client
+func started() (*client, error) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	if current == nil {
+		return nil, fmt.Errorf("the chat isn't started")
+	}
+
+	return current, nil
+}
+
+// Method that joins a room, passing what happens there to the listener.
+// Joining a room again changes nothing
+func (c *client) join(roomName, username, password string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// stopped while the page was still joining
+	if c.rooms == nil {
+		return fmt.Errorf("the chat isn't started")
+	}
+	if _, ok := c.rooms[roomName]; ok {
+		return nil
+	}
+
+	cr, err := chat.JoinChatRoom(c.host, username, roomName)
+	if err != nil {
+		return err
+	}
+	if err := cr.SetPassword(password); err != nil {
+		cr.Leave()
+		return err
+	}
+
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		// the page gets the message as it goes over the wire
+		data, err := json.Marshal(msg)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warnln("Message not passed to the page")
+			return
+		}
+		c.call("onMessage", roomName, js.Global().Get("JSON").Call("parse", string(data)))
+	})
+	cr.OnLog(func(log chat.ChatLog) {
+		c.call("onLog", roomName, log.Prefix, log.Msg)
+	})
+	if err := cr.OnPeerJoin(func(id peer.ID) {
+		c.call("onPeerJoin", roomName, id.Pretty())
+	}); err != nil {
+		cr.Leave()
+		return err
+	}
+
+	c.rooms[roomName] = cr
+	return nil
+}
+
+// Method that returns a joined room by name
+func (c *client) room(roomName string) (*chat.ChatRoom, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cr, ok := c.rooms[roomName]
+	if !ok {
+		return nil, fmt.Errorf("not in the room %s", roomName)
+	}
+
+	return cr, nil
+}
+
+// Method that calls a function of the listener, if the page gave it one
+func (c *client) call(name string, args ...interface{}) {
+	if fn := c.listener.Get(name); fn.Type() == js.TypeFunction {
+		fn.Invoke(args...)
+	}
+}
+
+// This one runs the work on its own goroutine, as functions called by
+// the page can't block, and returns a promise of what it comes to
+func promise(work func() (interface{}, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+
+			result, err := work()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+
+		return nil
+	})
+
+	return js.Global().Get("Promise").New(executor)
+}
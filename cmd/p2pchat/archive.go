@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/archive"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// This one runs an archive, staying in rooms without a terminal to keep
+// everything said in them, and handing it to the peers asking for it
+func runArchive(args []string) {
+	flags := newFlagSet("archive", "keep the history of rooms, and hand it to peers catching up")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "archive", "How do we call the archive in the rooms?")
+	roomList := flags.String("rooms", "", "Which rooms are archived, separated by commas?")
+	password := flags.String("password", "", "What is the password of the rooms, if they have one?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	archiveDir := flags.String("archive-dir", state.Path("archive"), "Where is the history of the rooms kept?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	roomNames := splitRooms(*roomList)
+	if len(roomNames) == 0 {
+		logrus.Fatalln("The archive needs -rooms to keep")
+	}
+
+	history, err := chat.OpenHistory(*archiveDir)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Opening the archive failed")
+	}
+
+	stop := notifyStop()
+
+	host := node.startHost()
+	host.RoomKeys.Dir = *roomKeys
+
+	server := archive.NewServer(host, history)
+	rooms := keepRooms(host, server, *username, *password, roomNames)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go server.Run(ctx)
+
+	logrus.WithFields(logrus.Fields{
+		"dir":   *archiveDir,
+		"rooms": len(rooms),
+	}).Infoln("Archiving the rooms")
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping, leaving the rooms")
+
+	server.Close()
+	cancel()
+	for _, cr := range rooms {
+		cr.Leave()
+	}
+	closeHost(host)
+}
+
+// This one splits a comma separated list of room names
+func splitRooms(list string) []string {
+	var roomNames []string
+	for _, roomName := range strings.Split(list, ",") {
+		if roomName = strings.TrimSpace(roomName); len(roomName) > 0 {
+			roomNames = append(roomNames, roomName)
+		}
+	}
+
+	return roomNames
+}
+
+// This one joins the rooms with the given password and has the archive keep
+// them, exiting if one can't be joined. Returns the rooms by name
+func keepRooms(host *p2p.P2P, server *archive.Server, username, password string, roomNames []string) map[string]*chat.ChatRoom {
+	rooms := make(map[string]*chat.ChatRoom)
+	for _, roomName := range roomNames {
+		if _, ok := rooms[roomName]; ok {
+			continue
+		}
+
+		cr, err := chat.JoinChatRoom(host, username, roomName)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  roomName,
+			}).Fatalln("Joining the chatroom failed")
+		}
+		if err := cr.SetPassword(password); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Setting the room password failed")
+		}
+		server.Keep(cr)
+		rooms[roomName] = cr
+	}
+
+	return rooms
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/chat/chattest"
+)
+
+// what the messages of the benchmark start with, followed by
+// their number and when they were sent, in unix nanoseconds
+const benchPrefix = "bench"
+
+// the messages sent and received in a benchmark, and how long they took
+type benchStats struct {
+	lock sync.Mutex
+	// messages sent, each of them meant for every other node
+	sent int
+	// messages received, counting each receiver
+	received int
+	// the same message received again by the same node
+	duplicates int
+	// from sending to receiving, for every message received
+	latencies []time.Duration
+	// messages received already, by receiver, sender and number
+	seen map[string]bool
+}
+
+// This one runs a number of nodes in memory, or over the loopback
+// interface, chatting in a room at a given rate, and reports how long
+// the messages took to arrive and how many never did
+func runBench(args []string) {
+	flags := newFlagSet("bench", "run local nodes chatting in a room, and report how fast and how many messages arrive")
+	nodes := flags.Int("nodes", 5, "How many nodes should chat?")
+	roomName := flags.String("room", "bench", "In what room should they chat?")
+	rate := flags.Float64("rate", 10, "How many messages a second should each node send?")
+	duration := flags.Duration("duration", 10*time.Second, "How long should they send for?")
+	size := flags.Int("size", 64, "How many bytes of text should each message have?")
+	drain := flags.Duration("drain", 3*time.Second, "How long should we wait for the last messages to arrive?")
+	loopback := flags.Bool("loopback", false, "Should the nodes connect over TCP on the loopback interface, instead of in memory?")
+	level := flags.String("log", "warn", "Which log level should the nodes use? (info, warn, error, debug, trace)")
+	parseFlags(flags, args)
+
+	if *nodes < 2 || *rate <= 0 {
+		logrus.Fatalln("The benchmark needs at least 2 nodes, and a rate above zero")
+	}
+	if lvl, err := logrus.ParseLevel(*level); err == nil {
+		logrus.SetLevel(lvl)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newNetwork, transport := chattest.NewNetwork, "in memory"
+	if *loopback {
+		newNetwork, transport = chattest.NewLoopbackNetwork, "loopback TCP"
+	}
+	network, err := newNetwork(ctx, *nodes)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Starting the nodes failed")
+	}
+	defer network.Close()
+
+	// every message counts, none is held back
+	for _, host := range network.Hosts {
+		host.RateLimit = 0
+	}
+
+	rooms, err := network.Join(*roomName)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Joining the chatroom failed")
+	}
+
+	stats := &benchStats{seen: make(map[string]bool)}
+	for _, cr := range rooms {
+		stats.record(cr)
+	}
+
+	fmt.Printf("Benchmarking %d nodes %s, %g messages a second each for %s\n", *nodes, transport, *rate, *duration)
+
+	started := time.Now()
+	var senders sync.WaitGroup
+	for _, cr := range rooms {
+		senders.Add(1)
+		go func(cr *chat.ChatRoom) {
+			defer senders.Done()
+			stats.send(cr, *rate, *duration, *size)
+		}(cr)
+	}
+	senders.Wait()
+	sending := time.Since(started)
+
+	// the last messages are still on their way
+	time.Sleep(*drain)
+
+	for _, cr := range rooms {
+		cr.Leave()
+	}
+
+	stats.report(*nodes, sending)
+}
+
+// Method that counts the benchmark messages the room receives,
+// and how long each of them took from its sender
+func (bs *benchStats) record(cr *chat.ChatRoom) {
+	receiver := cr.SelfID().Pretty()
+
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		received := time.Now()
+
+		fields := strings.Fields(msg.Message)
+		if msg.Type != chat.MessageText || len(fields) < 3 || fields[0] != benchPrefix {
+			return
+		}
+		sentNano, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return
+		}
+
+		bs.lock.Lock()
+		defer bs.lock.Unlock()
+
+		key := receiver + "|" + msg.SenderID + "|" + fields[1]
+		if bs.seen[key] {
+			bs.duplicates++
+			return
+		}
+		bs.seen[key] = true
+
+		bs.received++
+		bs.latencies = append(bs.latencies, received.Sub(time.Unix(0, sentNano)))
+	})
+}
+
+// Method that sends messages to the room at the rate, for the
+// duration, each padded to the size, and counts them
+func (bs *benchStats) send(cr *chat.ChatRoom, rate float64, duration time.Duration, size int) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	for seq := 0; ; seq++ {
+		select {
+		case <-deadline:
+			return
+		case <-ticker.C:
+		}
+
+		text := fmt.Sprintf("%s %d %d ", benchPrefix, seq, time.Now().UnixNano())
+		if len(text) < size {
+			text += strings.Repeat("x", size-len(text))
+		}
+
+		msg := cr.NewTextMessage(text)
+		msg.SenderID = cr.SelfID().Pretty()
+		msg.SenderName = cr.Username
+		msg.Sent = time.Now().Unix()
+
+		if err := cr.Send(msg); err != nil {
+			return
+		}
+
+		bs.lock.Lock()
+		bs.sent++
+		bs.lock.Unlock()
+	}
+}
+
+// Method that prints what was sent and received, the
+// percentiles of how long it took, and what was lost
+func (bs *benchStats) report(nodes int, sending time.Duration) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	expected := bs.sent * (nodes - 1)
+	lost := expected - bs.received
+	loss := 0.0
+	if expected > 0 {
+		loss = float64(lost) / float64(expected) * 100
+	}
+
+	fmt.Printf("sent:          %d messages, %.1f a second\n", bs.sent, float64(bs.sent)/sending.Seconds())
+	fmt.Printf("received:      %d of %d\n", bs.received, expected)
+	fmt.Printf("lost:          %d (%.2f%%)\n", lost, loss)
+	fmt.Printf("duplicates:    %d\n", bs.duplicates)
+
+	if len(bs.latencies) == 0 {
+		return
+	}
+
+	sort.Slice(bs.latencies, func(i, j int) bool {
+		return bs.latencies[i] < bs.latencies[j]
+	})
+	percentile := func(p float64) time.Duration {
+		return bs.latencies[int(p/100*float64(len(bs.latencies)-1))]
+	}
+
+	fmt.Printf("latency p50:   %s\n", percentile(50).Round(time.Microsecond))
+	fmt.Printf("latency p90:   %s\n", percentile(90).Round(time.Microsecond))
+	fmt.Printf("latency p99:   %s\n", percentile(99).Round(time.Microsecond))
+	fmt.Printf("latency max:   %s\n", percentile(100).Round(time.Microsecond))
+}
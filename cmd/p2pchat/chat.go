@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/hooks"
+	"github.com/xtopala/p2pchat/p2p"
+	"github.com/xtopala/p2pchat/tui"
+)
+
+// This one runs the chat in the terminal, the default command
+func runChat(args []string) {
+	// define and parse input flags
+	flags := newFlagSet("chat", "chat in the terminal")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "", "How do we call you?")
+	chatroom := flags.String("room", "", "What topic are interested in?")
+	downloads := flags.String("downloads", ".", "Where should received files go?")
+	graphics := flags.String("graphics", "auto", "Can your terminal draw pictures?")
+	compress := flags.Bool("compress", true, "Should large messages be squeezed?")
+	bell := flags.Bool("bell", false, "Should we ring when someone calls you?")
+	timestamps := flags.Bool("timestamps", false, "Should we show when each message was sent?")
+	keywords := flags.String("keywords", "", "What words should catch your eye, separated by commas?")
+	receipts := flags.Bool("receipts", true, "Should others know you have seen their messages?")
+	previews := flags.Bool("previews", false, "Should we fetch previews of links you send?")
+	keepHistory := flags.Bool("history", false, "Should we keep the messages you see, to search them later?")
+	historyDir := flags.String("history-dir", state.History(), "Where do you keep the messages you see?")
+	scrollback := flags.Int("scrollback", tui.DefaultScrollback, "How many lines should the message list keep to scroll back through, 0 for all of them?")
+	pinsFile := flags.String("pins-file", state.Path("pins.json"), "Where do you remember whose key is whose?")
+	petnamesFile := flags.String("petnames-file", state.Path("petnames.json"), "Where do you keep your own names for peers?")
+	rateLimit := flags.Float64("rate-limit", p2p.DefaultRateLimit, "How many messages a second can a peer send before we stop listening?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	proofOfWork := flags.Int("pow", 0, "How much work should each message cost, in leading zero bits?")
+	password := flags.String("password", "", "What is the password of the room, if it has one?")
+	settingsFile := flags.String("settings-file", state.Settings(), "Where do you keep what you like?")
+	keymap := flags.String("keymap", tui.KeymapDefault, "Which keys do your fingers know, default or vim?")
+	quiet := flags.Bool("quiet", false, "Should we keep the logs out of sight, other than errors?")
+	minimal := flags.Bool("minimal", false, "Is your terminal small, so only messages and the input should show?")
+	themeName := flags.String("theme", tui.DefaultTheme, "What colors do you like, dark, light, high-contrast or a theme file?")
+	hooksDir := flags.String("hooks", state.ConfigPath("hooks"), "Where do you keep the scripts run when things happen in the rooms?")
+	pipe := flags.Bool("pipe", false, "Should messages go to the standard output and lines of the standard input be sent, instead of the terminal interface?")
+	pipeFormat := flags.String("pipe-format", "text", "Should the piped messages be text, or json for the machines?")
+	apiFlags := addAPIFlags(flags)
+	updates := addUpdateFlags(flags)
+	parseFlags(flags, args)
+
+	if *pipe {
+		if *pipeFormat != "text" && *pipeFormat != "json" {
+			logrus.WithFields(logrus.Fields{
+				"format": *pipeFormat,
+			}).Fatalln("Pipe format must be text or json")
+		}
+		// the standard output is for the messages alone
+		logrus.SetOutput(os.Stderr)
+	}
+
+	// saved settings fill in whatever the command line and the environment leave out
+	settings, err := tui.LoadSettings(*settingsFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading settings failed")
+	}
+	if err := settings.FillFlags(flags); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading settings failed")
+	}
+
+	// before anything gets measured
+	tui.FixAmbiguousWidth()
+
+	logToFile := node.setupLogging()
+
+	// from here on, being stopped means leaving the rooms and closing the host first
+	stop := notifyStop()
+
+	// some welcoming display
+	if !*pipe {
+		fmt.Println("P2Pchat is starting... Be with you shortly...")
+		fmt.Println()
+	}
+
+	pins, err := chat.LoadKeyPins(*pinsFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading key pins failed")
+	}
+
+	petnames, err := tui.LoadPetnames(*petnamesFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading petnames failed")
+	}
+
+	th, err := tui.LoadTheme(*themeName)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading the color theme failed")
+	}
+
+	// what we start with is what we like, until told otherwise
+	err = settings.Update(func(s *tui.Settings) {
+		s.Username = *username
+		s.Room = *chatroom
+		s.Theme = *themeName
+		s.Keymap = *keymap
+		s.Timestamps = *timestamps
+		s.Keywords = strings.FieldsFunc(*keywords, func(r rune) bool { return r == ',' || r == ' ' })
+		s.Bell = *bell
+		s.Receipts = *receipts
+		s.Previews = *previews
+		s.Quiet = *quiet
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Saving settings failed")
+	}
+
+	panes := tui.NewPaneLayout(settings.PaneSizes(), func(sizes tui.PaneSizes) error {
+		return settings.Update(func(s *tui.Settings) { s.Panes = sizes })
+	})
+
+	var history *chat.History
+	if *keepHistory {
+		history, err = chat.OpenHistory(*historyDir)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Loading message history failed")
+		}
+	}
+
+	// crete new P2P node host
+	host := node.startHost()
+	host.Files.DownloadDir = *downloads
+	host.RoomKeys.Dir = *roomKeys
+	host.Compression = *compress
+	host.RateLimit = *rateLimit
+	host.ProofOfWork = *proofOfWork
+
+	// join chat room
+	chatApp, _ := chat.JoinChatRoom(host, *username, *chatroom)
+
+	if err := chatApp.SetPassword(*password); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Setting the room password failed")
+	}
+
+	logrus.Infof("Joined the -> %s <- chatroom as -> %s", chatApp.RoomName, chatApp.Username)
+
+	// shown with the logs of the room, once the UI is up
+	updates.checkUpdates(func(notice string) {
+		chatApp.Log(chat.ChatLog{Prefix: "update", Msg: notice})
+	})
+
+	// wait for setup to complete, unless we are stopped already
+	select {
+	case <-time.After(time.Second * 5):
+	case <-stop:
+		chatApp.Leave()
+		closeHost(host)
+		return
+	}
+
+	if *pipe {
+		runPipe(chatApp, *pipeFormat, stop)
+		chatApp.Leave()
+		closeHost(host)
+		return
+	}
+
+	// render Chat UI
+	ui := tui.NewUI(chatApp, th)
+	ui.Settings = settings
+	ui.SetPanes(panes)
+	ui.SetQuiet(*quiet)
+	if *minimal {
+		ui.SetMinimal()
+	}
+	if err := ui.SetKeymap(*keymap); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Setting the keymap failed")
+	}
+	ui.Graphics = tui.DetectGraphics(*graphics)
+	ui.Bell = *bell
+	ui.SetTimestamps(*timestamps)
+	ui.SetKeywords(strings.FieldsFunc(*keywords, func(r rune) bool { return r == ',' || r == ' ' }))
+	ui.Previews = *previews
+	ui.Receipts = *receipts
+	ui.Pins = pins
+	ui.Petnames = petnames
+	ui.History = history
+	ui.Scrollback = *scrollback
+	ui.Hooks = hooks.New(*hooksDir)
+
+	// scripts get to the rooms through the same UI, so what they send shows up
+	ui.API = apiFlags.startAPI(host, ui)
+
+	// the terminal belongs to the UI now, logs only go to the file
+	if logToFile {
+		ui.FileLog = logrus.StandardLogger()
+		logrus.SetOutput(ioutil.Discard)
+	}
+
+	// stopping the UI gives the terminal back, and leaves the rooms
+	go func() {
+		<-stop
+		ui.TerminalApp.Stop()
+	}()
+
+	ui.Run()
+
+	if ui.API != nil {
+		ui.API.Close()
+	}
+	closeHost(host)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/api"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/hooks"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// a chat without a terminal, staying in its rooms and reached through the
+// HTTP API. What happens in the rooms goes to the log
+type daemon struct {
+	host     *p2p.P2P
+	username string
+	// nil while the API is off
+	api *api.Server
+	// run when things happen in the rooms
+	hooks *hooks.Hooks
+
+	// lock for the rooms
+	lock sync.Mutex
+	// joined rooms, in the order they were joined
+	rooms []*chat.ChatRoom
+}
+
+// This one runs the chat without a terminal
+func runDaemon(args []string) {
+	flags := newFlagSet("daemon", "stay in rooms without a terminal, reached through the HTTP API")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "", "How do we call you?")
+	rooms := flags.String("room", "", "What topics are you interested in, separated by commas?")
+	password := flags.String("password", "", "What is the password of the rooms, if they have one?")
+	compress := flags.Bool("compress", true, "Should large messages be squeezed?")
+	rateLimit := flags.Float64("rate-limit", p2p.DefaultRateLimit, "How many messages a second can a peer send before we stop listening?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	proofOfWork := flags.Int("pow", 0, "How much work should each message cost, in leading zero bits?")
+	hooksDir := flags.String("hooks", state.ConfigPath("hooks"), "Where do you keep the scripts run when things happen in the rooms?")
+	apiFlags := addAPIFlags(flags)
+	updates := addUpdateFlags(flags)
+	parseFlags(flags, args)
+
+	node.setupLogging()
+	updates.checkUpdates(func(notice string) {
+		logrus.Warnln(notice)
+	})
+
+	// from here on, being stopped means leaving the rooms and closing the host first
+	stop := notifyStop()
+
+	// the API tells how far the host got while we wait for it
+	host := node.newHost()
+	host.RoomKeys.Dir = *roomKeys
+	host.Compression = *compress
+	host.RateLimit = *rateLimit
+	host.ProofOfWork = *proofOfWork
+
+	d := &daemon{host: host, username: *username, hooks: hooks.New(*hooksDir)}
+	// before any room is joined, so no message misses it
+	d.api = apiFlags.startAPI(host, d)
+	if d.api != nil {
+		logrus.WithFields(logrus.Fields{
+			"addr":   *apiFlags.addr,
+			"socket": *apiFlags.socket,
+		}).Infoln("HTTP API listening")
+	}
+
+	node.waitReady(host)
+
+	// an empty list still joins the default room
+	for _, roomName := range strings.Split(*rooms, ",") {
+		cr, err := d.Join(strings.TrimSpace(roomName))
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  roomName,
+			}).Fatalln("Joining the chatroom failed")
+		}
+
+		if err := cr.SetPassword(*password); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Setting the room password failed")
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"room": cr.RoomName,
+			"user": cr.Username,
+		}).Infoln("Joined the chatroom")
+	}
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping, leaving the rooms")
+
+	if d.api != nil {
+		d.api.Close()
+	}
+	for _, cr := range d.Rooms() {
+		cr.Leave()
+	}
+	closeHost(host)
+}
+
+// Method that returns the joined rooms, in the order they were joined
+func (d *daemon) Rooms() []*chat.ChatRoom {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]*chat.ChatRoom(nil), d.rooms...)
+}
+
+// Method that joins a room, or returns it if it is joined already
+func (d *daemon) Join(roomName string) (*chat.ChatRoom, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, cr := range d.rooms {
+		if cr.RoomName == roomName {
+			return cr, nil
+		}
+	}
+
+	cr, err := chat.JoinChatRoom(d.host, d.username, roomName)
+	if err != nil {
+		return nil, err
+	}
+	d.rooms = append(d.rooms, cr)
+
+	d.forward(cr)
+
+	return cr, nil
+}
+
+// Method that sends a text message to a joined room
+func (d *daemon) Send(cr *chat.ChatRoom, text string) (chat.ChatMessage, error) {
+	text, err := d.sendingHook(cr, text)
+	if err != nil {
+		return chat.ChatMessage{}, err
+	}
+	if err := chat.CheckMessageLength(text); err != nil {
+		return chat.ChatMessage{}, err
+	}
+
+	msg := cr.NewTextMessage(text)
+	msg.SenderID = cr.SelfID().Pretty()
+	msg.SenderName = cr.Username
+	msg.Sent = time.Now().Unix()
+
+	if err := cr.Send(msg); err != nil {
+		return chat.ChatMessage{}, err
+	}
+	d.deliver(cr, msg)
+
+	return msg, nil
+}
+
+// Method that passes the messages of a room on to the API, and its
+// logs to the log, until the room is left
+func (d *daemon) forward(cr *chat.ChatRoom) {
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		// muted peers are still there, we just don't listen
+		if from, err := peer.Decode(msg.SenderID); err == nil && d.host.PeerLists.IsMuted(from) {
+			return
+		}
+		// the message-received hook gets to drop messages
+		if !d.receivedHook(cr, msg) {
+			return
+		}
+		d.deliver(cr, msg)
+	})
+
+	cr.OnLog(func(log chat.ChatLog) {
+		entry := logrus.WithFields(logrus.Fields{
+			"room":   cr.RoomName,
+			"prefix": log.Prefix,
+		})
+		if log.Alert {
+			entry.Warnln(log.Msg)
+		} else {
+			entry.Infoln(log.Msg)
+		}
+	})
+
+	// joins only matter to the peer-joined hook, which can show up any time
+	err := cr.OnPeerJoin(func(id peer.ID) {
+		if d.hooks.Has(hooks.PeerJoined) {
+			go d.joinedHook(cr, id)
+		}
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Errorln("Following peers joining failed")
+	}
+}
+
+// Method that hands a message seen in a room to the API, and logs it
+func (d *daemon) deliver(cr *chat.ChatRoom, msg chat.ChatMessage) {
+	if msg.Type == chat.MessageText || msg.Type == chat.MessageCommand {
+		logrus.WithFields(logrus.Fields{
+			"room":      cr.RoomName,
+			"sender":    msg.SenderName,
+			"sender_id": msg.SenderID,
+		}).Debugln(msg.Message)
+	}
+
+	if d.api != nil {
+		d.api.Deliver(cr.RoomName, msg)
+	}
+}
+
+// Method that runs the hook of an event in a room, if there is one.
+// Returns false when there is none or it couldn't run
+func (d *daemon) runHook(cr *chat.ChatRoom, ev hooks.Event) (hooks.Result, bool) {
+	if !d.hooks.Has(ev.Event) {
+		return hooks.Result{}, false
+	}
+
+	ev.Room = cr.RoomName
+	result, err := d.hooks.Run(cr.Context(), ev)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Errorln("Running a hook failed")
+		return hooks.Result{}, false
+	}
+
+	return result, true
+}
+
+// Method that runs the message-received hook on a message received in a room,
+// sending what it answers to the room. Returns false if the hook rejected it
+func (d *daemon) receivedHook(cr *chat.ChatRoom, msg chat.ChatMessage) bool {
+	if msg.Type != chat.MessageText && msg.Type != chat.MessageCommand {
+		return true
+	}
+
+	result, ok := d.runHook(cr, hooks.Event{Event: hooks.MessageReceived, Message: &msg})
+	if !ok {
+		return true
+	}
+	if result.Rejected {
+		return false
+	}
+
+	if len(result.Output) > 0 {
+		go d.sendHookOutput(cr, result.Output)
+	}
+
+	return true
+}
+
+// Method that runs the message-sending hook on text about to be sent to a room,
+// returning the text to send instead if the hook changed it, or an error if
+// the hook rejected it
+func (d *daemon) sendingHook(cr *chat.ChatRoom, text string) (string, error) {
+	msg := chat.ChatMessage{Type: chat.MessageText, Message: text, SenderID: cr.SelfID().Pretty(), SenderName: cr.Username}
+
+	result, ok := d.runHook(cr, hooks.Event{Event: hooks.MessageSending, Message: &msg})
+	switch {
+	case !ok:
+		return text, nil
+	case result.Rejected && len(result.Output) > 0:
+		return "", fmt.Errorf("the %s hook kept the message back: %s", hooks.MessageSending, result.Output)
+	case result.Rejected:
+		return "", fmt.Errorf("the %s hook kept the message back", hooks.MessageSending)
+	case len(result.Output) > 0:
+		return result.Output, nil
+	default:
+		return text, nil
+	}
+}
+
+// Method that runs the peer-joined hook for a peer that joined a room,
+// sending what it answers to the room
+func (d *daemon) joinedHook(cr *chat.ChatRoom, id peer.ID) {
+	ev := hooks.Event{Event: hooks.PeerJoined, PeerID: id.Pretty()}
+	if prof := d.host.Profiles.Lookup(id); prof != nil {
+		ev.PeerName = prof.Username
+	}
+
+	result, ok := d.runHook(cr, ev)
+	if ok && !result.Rejected && len(result.Output) > 0 {
+		d.sendHookOutput(cr, result.Output)
+	}
+}
+
+// Method that sends what a hook answered to a room, as our own message
+func (d *daemon) sendHookOutput(cr *chat.ChatRoom, text string) {
+	if _, err := d.Send(cr, text); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Errorln("Sending what the hook answered failed")
+	}
+}
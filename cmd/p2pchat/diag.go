@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/xtopala/p2pchat/version"
+)
+
+// This one starts a host, waits for it to find its place in the
+// network, and reports how well it is connected
+func runDiag(args []string) {
+	flags := newFlagSet("diag", "start a host and report how well it is connected")
+	node := addNodeFlags(flags)
+	wait := flags.Duration("wait", 15*time.Second, "How long should we wait for AutoNAT to tell how reachable we are?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	host := node.startHost()
+	defer host.Close()
+	defer stopProfiling()
+
+	// AutoNAT needs a while to make up its mind, we wait until it has
+	deadline := time.After(*wait)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for waiting := true; waiting && host.Reachability() == network.ReachabilityUnknown; {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			waiting = false
+		}
+	}
+
+	fmt.Printf("version:       %s\n", version.Get())
+	fmt.Printf("peer ID:       %s\n", host.Host.ID().Pretty())
+	for i, addr := range host.Host.Addrs() {
+		label := ""
+		if i == 0 {
+			label = "addresses:"
+		}
+		fmt.Printf("%-14s %s\n", label, addr)
+	}
+	fmt.Printf("reachability:  %s\n", host.Reachability())
+	fmt.Printf("connected to:  %d peers\n", len(host.Host.Network().Peers()))
+	fmt.Printf("DHT routing:   %d peers\n", host.KadDHT.RoutingTable().Size())
+	fmt.Printf("known peers:   %d\n", len(host.Host.Peerstore().Peers()))
+
+	// which versions of p2pchat the peers run, as they identified themselves
+	versions := make(map[string]int)
+	for _, id := range host.Host.Network().Peers() {
+		agent, err := host.Host.Peerstore().Get(id, "AgentVersion")
+		if name, ok := agent.(string); err == nil && ok && strings.HasPrefix(name, "p2pchat/") {
+			versions[strings.TrimPrefix(name, "p2pchat/")]++
+		}
+	}
+	var counts []string
+	for v, count := range versions {
+		counts = append(counts, fmt.Sprintf("%s x%d", v, count))
+	}
+	sort.Strings(counts)
+	fmt.Printf("p2pchat peers: %s\n", strings.Join(counts, ", "))
+}
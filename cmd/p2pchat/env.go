@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// prefix of the environment variables standing in for flags
+const envPrefix = "P2PCHAT_"
+
+// This one returns the environment variable standing in for a flag,
+// like P2PCHAT_PEERS_FILE for -peers-file
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// This one sets the flags not given on the command line from their
+// environment variables, so the command line has the last word. Flags
+// set here count as given, so saved settings don't override them either
+func fillFromEnv(flags *flag.FlagSet) error {
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("bad %s: %s", envName(f.Name), setErr)
+		}
+	})
+
+	return err
+}
+
+// This one returns where the state is kept, all in the directory given with
+// -data-dir or its environment variable, in the XDG directories otherwise.
+// It is read before the flags are, as the defaults of the others depend on it
+func stateFromArgs(args []string) p2p.State {
+	dir := os.Getenv(envName("data-dir"))
+	for i, arg := range args {
+		// what comes after isn't flags
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		switch {
+		case name == arg:
+		case name == "data-dir" && i+1 < len(args):
+			dir = args[i+1]
+		case strings.HasPrefix(name, "data-dir="):
+			dir = strings.TrimPrefix(name, "data-dir=")
+		}
+	}
+
+	if len(dir) == 0 {
+		return p2p.DefaultState()
+	}
+
+	return p2p.NewState(dir)
+}
+
+// This one makes the flag set of a subcommand, with a usage telling
+// what it does and where else its flags can come from
+func newFlagSet(name, summary string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	// read already, it's here to be listed and accepted
+	flags.String("data-dir", "", "Where should everything be kept, instead of the config and data directories?")
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage of %s %s, to %s:\n", os.Args[0], name, summary)
+		flags.PrintDefaults()
+		fmt.Fprintf(out, "\nEvery flag can also be set with an environment variable, like %s for -log.\n", envName("log"))
+		fmt.Fprintln(out, "Flags given on the command line win over the environment.")
+	}
+
+	return flags
+}
+
+// This one parses the flags of a subcommand, filling in the
+// ones not given on the command line from the environment
+func parseFlags(flags *flag.FlagSet, args []string) {
+	// exits on errors, as the flag set was made to
+	flags.Parse(args)
+
+	if err := fillFromEnv(flags); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Reading the environment failed")
+	}
+}
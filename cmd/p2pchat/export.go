@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// layout of the days the export is limited to
+const exportDayLayout = "2006-01-02"
+
+// layout of the times in a text export
+const exportTimeLayout = "2006-01-02 15:04:05"
+
+// a kept message as exported, along with its room
+type exportRecord struct {
+	Room string `json:"room"`
+	chat.HistoryRecord
+}
+
+// This one writes the kept message history to the standard output,
+// as JSON lines or as text, for a room or all of them
+func runExport(args []string) {
+	flags := newFlagSet("export", "write the kept message history out as JSON or text")
+	historyDir := flags.String("history-dir", state.History(), "Where do you keep the messages you see?")
+	room := flags.String("room", "", "Which room should be exported, if not all of them?")
+	from := flags.String("from", "", "From which day on, like 2021-06-01?")
+	to := flags.String("to", "", "Up to which day, that one included?")
+	format := flags.String("format", "json", "Should it be json or text?")
+	parseFlags(flags, args)
+
+	if *format != "json" && *format != "text" {
+		logrus.WithFields(logrus.Fields{
+			"format": *format,
+		}).Fatalln("Export format must be json or text")
+	}
+
+	fromTime, toTime, err := parseExportDays(*from, *to)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Reading the export days failed")
+	}
+
+	history, err := chat.OpenHistory(*historyDir)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading message history failed")
+	}
+
+	rooms := history.Rooms()
+	if len(*room) > 0 {
+		rooms = []string{*room}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	encoder := json.NewEncoder(out)
+	for _, roomName := range rooms {
+		for _, rec := range history.Range(roomName, fromTime, toTime) {
+			if *format == "text" {
+				sent := time.Unix(rec.Sent, 0).Format(exportTimeLayout)
+				fmt.Fprintf(out, "%s [%s] %s: %s\n", sent, roomName, rec.SenderName, rec.Text)
+				continue
+			}
+
+			if err := encoder.Encode(exportRecord{Room: roomName, HistoryRecord: rec}); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatalln("Writing the export failed")
+			}
+		}
+	}
+}
+
+// This one parses the days an export is limited to, in local time.
+// The last day is included, and an empty day leaves that end open
+func parseExportDays(from, to string) (time.Time, time.Time, error) {
+	var fromTime, toTime time.Time
+
+	if len(from) > 0 {
+		day, err := time.ParseInLocation(exportDayLayout, from, time.Local)
+		if err != nil {
+			return fromTime, toTime, fmt.Errorf("bad day %s, expected it like 2021-06-01", from)
+		}
+		fromTime = day
+	}
+
+	if len(to) > 0 {
+		day, err := time.ParseInLocation(exportDayLayout, to, time.Local)
+		if err != nil {
+			return fromTime, toTime, fmt.Errorf("bad day %s, expected it like 2021-06-01", to)
+		}
+		toTime = day.AddDate(0, 0, 1)
+	}
+
+	return fromTime, toTime, nil
+}
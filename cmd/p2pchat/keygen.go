@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// This one generates an identity key, for the -identity flag of the other commands
+func runKeygen(args []string) {
+	flags := newFlagSet("keygen", "generate an identity key to stay the same peer across runs")
+	identity := flags.String("identity", state.Identity(), "Where should the new key be kept?")
+	parseFlags(flags, args)
+
+	id, err := p2p.GenerateIdentity(*identity)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Generating the identity key failed")
+	}
+
+	fmt.Printf("peer ID: %s\n", id.Pretty())
+	fmt.Printf("kept in: %s\n", *identity)
+	fmt.Printf("use it with -identity %s\n", *identity)
+}
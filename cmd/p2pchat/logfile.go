@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// a log file that is rotated once it grows too large, keeping the
+// older ones next to it as path.1, path.2 and so on, newest first
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	// lock for the file and its size
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// This one opens the log file for appending, creating it if it's missing
+func openRotatingFile(path string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// Method that opens the file at the path, picking up its size. Expects the lock to be held
+func (rf *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.path), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(rf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+
+	return nil
+}
+
+// Method that writes to the file, rotating it first if the write would make it too large
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// Method that moves the file out of the way, and the older ones one step
+// further, dropping the oldest. Expects the lock to be held
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	if rf.backups > 0 {
+		for i := rf.backups - 1; i > 0; i-- {
+			// missing ones are fine, the file hasn't been rotated that often yet
+			os.Rename(rf.backupPath(i), rf.backupPath(i+1))
+		}
+		if err := os.Rename(rf.path, rf.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+// Method that returns the path of the nth older file
+func (rf *rotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
+
+// Method that closes the file
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	return rf.file.Close()
+}
+
+// a logrus hook writing every entry to a file as well, in its own format,
+// so the file doesn't get the colors meant for the terminal
+type fileHook struct {
+	file      *rotatingFile
+	formatter logrus.Formatter
+}
+
+// Method that tells logrus the hook wants entries of every level
+func (fh *fileHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Method that writes an entry to the file
+func (fh *fileHook) Fire(entry *logrus.Entry) error {
+	line, err := fh.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = fh.file.Write(line)
+	return err
+}
+
+// a logrus hook adding the same fields to every entry, like our peer ID,
+// so logs shipped from many peers can be told apart
+type fieldsHook struct {
+	lock   sync.Mutex
+	fields logrus.Fields
+}
+
+// Method that tells logrus the hook wants entries of every level
+func (fh *fieldsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Method that sets a field added to every entry from now on
+func (fh *fieldsHook) Set(key string, value interface{}) {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	if fh.fields == nil {
+		fh.fields = logrus.Fields{}
+	}
+	fh.fields[key] = value
+}
+
+// Method that adds the fields to an entry, leaving those it has alone
+func (fh *fieldsHook) Fire(entry *logrus.Entry) error {
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	// the entry may share its fields with others, so they are copied
+	data := make(logrus.Fields, len(entry.Data)+len(fh.fields))
+	for key, value := range fh.fields {
+		data[key] = value
+	}
+	for key, value := range entry.Data {
+		data[key] = value
+	}
+	entry.Data = data
+
+	return nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/api"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// a subcommand, with what it does for the usage
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// where the state is kept, known before any flags are defined
+var state p2p.State
+
+// subcommands, in the order the usage lists them
+var commands = []command{
+	{"chat", "chat in the terminal, what runs without a command", runChat},
+	{"daemon", "stay in rooms without a terminal, reached through the HTTP API", runDaemon},
+	{"keygen", "generate an identity key to stay the same peer across runs", runKeygen},
+	{"diag", "start a host and report how well it is connected", runDiag},
+	{"bench", "run local nodes chatting in a room, and report how fast and how many messages arrive", runBench},
+	{"export", "write the kept message history out as JSON or text", runExport},
+	{"xmpp", "relay a room to an XMPP multi-user chat room and back", runXMPP},
+	{"relay", "forward a room to a Slack or Discord webhook, and back", runRelay},
+	{"mqtt", "pass MQTT topics to rooms, and commands of the rooms back", runMQTT},
+	{"nostr", "mirror a room on a Nostr relay, and pass replies back", runNostr},
+	{"archive", "keep the history of rooms, and hand it to peers catching up", runArchive},
+	{"supernode", "relay, bootstrap, rendezvous and archive for a community from one well-connected host", runSupernode},
+	{"version", "print the version, and look for a newer one", runVersion},
+	{"telemetry", "show the usage reports waiting to be sent, or drop them", runTelemetry},
+}
+
+func init() {
+	// set Logrus as soon as main package is initialized
+	logrus.SetFormatter(&logrus.TextFormatter{
+		TimestampFormat: time.RFC822,
+		FullTimestamp:   true,
+		ForceColors:     true,
+	})
+
+	logrus.SetOutput(os.Stdout)
+}
+
+func main() {
+	args := os.Args[1:]
+
+	state = stateFromArgs(args)
+
+	// plain flags, or nothing at all, still mean chatting
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		defer catchCrash()
+		runChat(args)
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			defer catchCrash()
+			cmd.run(args[1:])
+			return
+		}
+	}
+
+	printCommands()
+	if args[0] != "help" {
+		fmt.Fprintf(os.Stderr, "\nunknown command %s\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// This one prints the subcommands, with what they do
+func printCommands() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+// This one returns a channel told when we are asked to stop, by Ctrl+C
+// or the service manager, so we get to clean up before we go
+func notifyStop() chan os.Signal {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	return stop
+}
+
+// This one closes the host, so peers and the DHT stop counting on us,
+// finishing the usage report and the profiles of the run if there are any
+func closeHost(host *p2p.P2P) {
+	finishTelemetry(host)
+	defer stopProfiling()
+
+	if err := host.Close(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Closing the P2P host failed")
+		return
+	}
+
+	logrus.Infoln("Left the network")
+}
+
+// flags of the commands running a P2P host
+type nodeFlags struct {
+	identity          *string
+	peersFile         *string
+	peerCache         *string
+	disconnectBlocked *bool
+	bootstrap         *string
+	discovery         *string
+	loglevel          *string
+	logFile           *string
+	logMaxSize        *int
+	logBackups        *int
+	logFormat         *string
+	pubsubTrace       *string
+	pubsubTraceRemote *string
+	listen            *string
+	telemetry         *string
+	minPeers          *int
+	readyTimeout      *time.Duration
+	cpuProfile        *string
+	memProfile        *string
+	execTrace         *string
+
+	// name of the command, for the usage reports
+	command string
+	// whether the host relays for peers that can't be reached directly,
+	// set by the commands running one for others
+	relayHop bool
+
+	// fields added to every log entry, nil unless logging JSON
+	fields *fieldsHook
+}
+
+// This one defines the flags of the commands running a P2P host
+func addNodeFlags(flags *flag.FlagSet) *nodeFlags {
+	return &nodeFlags{
+		identity:          flags.String("identity", "", "Where do you keep your key, if you want to stay you?"),
+		peersFile:         flags.String("peers-file", state.PeerLists(), "Where do you keep track of who you can't stand?"),
+		peerCache:         flags.String("peer-cache", state.Peerstore(), "Where do you remember the peers you met, to find them faster next time?"),
+		disconnectBlocked: flags.Bool("disconnect-blocked", false, "Should blocked peers be cut off entirely?"),
+		bootstrap:         flags.String("bootstrap", "", "Which peers should we find the others through, instead of the public ones, separated by commas?"),
+		discovery:         flags.String("discovery", "", "How do you want to discover your peers?"),
+		loglevel:          flags.String("log", "info", "How far down does a rabbit hole go?"),
+		logFile:           flags.String("log-file", "", "Where should the logs be kept too, to look into what went wrong later?"),
+		logMaxSize:        flags.Int("log-max-size", 10, "How many megabytes can the log file grow to before it's rotated?"),
+		logBackups:        flags.Int("log-backups", 3, "How many rotated log files should be kept?"),
+		logFormat:         flags.String("log-format", "text", "Should the logs be text, or json for the machines?"),
+		pubsubTrace:       flags.String("pubsub-trace", "", "Where should the GossipSub events be traced to as JSON, if anywhere?"),
+		pubsubTraceRemote: flags.String("pubsub-trace-remote", "", "Which remote tracer should the GossipSub events be sent to, if any?"),
+		listen:            flags.String("listen", "", "Which addresses should we listen on, like /ip4/0.0.0.0/tcp/4001, separated by commas? Any port by default"),
+		telemetry:         flags.String("telemetry", "", "Where should anonymous usage reports go, if anywhere? p2pchat telemetry shows what they say"),
+		minPeers:          flags.Int("min-peers", p2p.DefaultMinPeers, "How many peers should we find before starting?"),
+		readyTimeout:      flags.Duration("ready-timeout", 10*time.Second, "How long should we wait for them before starting anyway?"),
+		cpuProfile:        flags.String("cpuprofile", "", "Where should a CPU profile of the whole run be written, if anywhere?"),
+		memProfile:        flags.String("memprofile", "", "Where should a heap profile be written once we stop, if anywhere?"),
+		execTrace:         flags.String("trace", "", "Where should an execution trace of the whole run be written, if anywhere?"),
+		command:           flags.Name(),
+	}
+}
+
+// Method that sets the log level and format the flags ask for, and the log file
+// if there is one. Returns true if the logs go to a file
+func (nf *nodeFlags) setupLogging() bool {
+	switch *nf.loglevel {
+	case "info", "INFO":
+		logrus.SetLevel(logrus.InfoLevel)
+	case "warn", "WARN":
+		logrus.SetLevel(logrus.WarnLevel)
+	case "error", "ERROR":
+		logrus.SetLevel(logrus.ErrorLevel)
+	case "trace", "TRACE":
+		logrus.SetLevel(logrus.TraceLevel)
+	case "debug", "DEBUG":
+		logrus.SetLevel(logrus.DebugLevel)
+	default:
+		logrus.SetLevel(logrus.InfoLevel)
+	}
+
+	var fileFormatter logrus.Formatter = &logrus.TextFormatter{
+		TimestampFormat: time.RFC3339,
+		FullTimestamp:   true,
+		DisableColors:   true,
+	}
+
+	switch *nf.logFormat {
+	case "text":
+	case "json":
+		fileFormatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339}
+		logrus.SetFormatter(fileFormatter)
+
+		// before the file hook, so the file gets the fields too
+		nf.fields = &fieldsHook{}
+		logrus.AddHook(nf.fields)
+	default:
+		logrus.WithFields(logrus.Fields{
+			"format": *nf.logFormat,
+		}).Fatalln("Log format must be text or json")
+	}
+
+	if len(*nf.logFile) == 0 {
+		return false
+	}
+
+	file, err := openRotatingFile(*nf.logFile, int64(*nf.logMaxSize)*1024*1024, *nf.logBackups)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Opening the log file failed")
+	}
+
+	logrus.AddHook(&fileHook{file: file, formatter: fileFormatter})
+
+	return true
+}
+
+// Method that creates the P2P host the flags describe, starts discovering
+// peers with the chosen method and waits for the host to be ready
+func (nf *nodeFlags) startHost() *p2p.P2P {
+	host := nf.newHost()
+	nf.waitReady(host)
+
+	return host
+}
+
+// Method that creates the P2P host the flags describe, and starts
+// discovering peers with the chosen method, without waiting for them
+func (nf *nodeFlags) newHost() *p2p.P2P {
+	// from the start, so finding the peers is in the profiles too
+	startProfiling(*nf.cpuProfile, *nf.memProfile, *nf.execTrace)
+
+	lists, err := p2p.LoadPeerLists(*nf.peersFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading block and mute lists failed")
+	}
+	lists.Gate = *nf.disconnectBlocked
+
+	bootstrapPeers, err := p2p.ParseBootstrapPeers(*nf.bootstrap)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Reading the bootstrap peers failed")
+	}
+
+	listenAddrs, err := p2p.ParseListenAddrs(*nf.listen)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Reading the listen addresses failed")
+	}
+
+	host, err := p2p.NewP2P(context.Background(), p2p.Options{
+		Identity:  *nf.identity,
+		PeerLists: lists,
+		Bootstrap: bootstrapPeers,
+		Trace:     p2p.PubSubTrace{File: *nf.pubsubTrace, Remote: *nf.pubsubTraceRemote},
+		Listen:    listenAddrs,
+		RelayHop:  nf.relayHop,
+		PeerCache: *nf.peerCache,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Starting the P2P host failed")
+	}
+	if nf.fields != nil {
+		nf.fields.Set("peer", host.Host.ID().Pretty())
+	}
+	host.MinPeers = *nf.minPeers
+
+	// use chosen discovery method to connect peers
+	discovery := "announce"
+	switch *nf.discovery {
+	case "advertise":
+		discovery = "advertise"
+		err = host.AdvertiseConnect()
+	default:
+		err = host.AnnounceConnect()
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Connecting to the service peers failed")
+	}
+
+	startTelemetry(nf.command, discovery, *nf.telemetry)
+
+	return host
+}
+
+// Method that waits for the host to be ready, having someone to talk to,
+// or until we're done waiting. The host logs how far it got meanwhile
+func (nf *nodeFlags) waitReady(host *p2p.P2P) {
+	select {
+	case <-host.Ready():
+	case <-time.After(*nf.readyTimeout):
+		logrus.WithFields(logrus.Fields{
+			"peers": len(host.Host.Network().Peers()),
+			"state": host.Startup(),
+		}).Warnln("No service peers connected yet, starting anyway")
+	}
+}
+
+// flags of the commands serving the HTTP API
+type apiFlags struct {
+	addr      *string
+	socket    *string
+	tokenFile *string
+}
+
+// This one defines the flags of the commands serving the HTTP API
+func addAPIFlags(flags *flag.FlagSet) *apiFlags {
+	return &apiFlags{
+		addr:      flags.String("api", "", "Where should the HTTP API listen, like 127.0.0.1:8042, if at all?"),
+		socket:    flags.String("api-socket", "", "Where should the HTTP API listen on a unix socket, just for you, if at all?"),
+		tokenFile: flags.String("api-token-file", state.Path("api-token"), "Where do you keep the token the HTTP API asks for?"),
+	}
+}
+
+// Method that starts the HTTP API on the given rooms, if the flags ask for it.
+// Returns nil if they don't
+func (af *apiFlags) startAPI(host *p2p.P2P, rooms api.Rooms) *api.Server {
+	if len(*af.addr) == 0 && len(*af.socket) == 0 {
+		return nil
+	}
+
+	token, err := api.LoadToken(*af.tokenFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading the API token failed")
+	}
+
+	server := api.NewServer(*af.addr, token, host, rooms)
+	if len(*af.addr) > 0 {
+		if err := server.Start(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Starting the HTTP API failed")
+		}
+	}
+	if len(*af.socket) > 0 {
+		if err := server.StartSocket(*af.socket); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Starting the HTTP API on the unix socket failed")
+		}
+	}
+
+	return server
+}
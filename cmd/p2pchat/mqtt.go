@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/mqtt"
+)
+
+// This one runs a bridge between an MQTT broker and rooms, passing the
+// messages of topics to the rooms and the commands of the rooms to topics
+func runMQTT(args []string) {
+	flags := newFlagSet("mqtt", "pass MQTT topics to rooms, and commands of the rooms back")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "mqtt", "How do we call the bridge in the rooms?")
+	password := flags.String("password", "", "What is the password of the rooms, if they have one?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	broker := flags.String("mqtt-broker", "tcp://localhost:1883", "Where is the MQTT broker, like tcp://localhost:1883 or tls://broker:8883?")
+	clientID := flags.String("mqtt-client-id", "", "What client identifier should the bridge use, made up if empty?")
+	mqttUser := flags.String("mqtt-user", "", "What user name does the broker know the bridge by, if any?")
+	mqttPassword := flags.String("mqtt-password", "", "What password does the broker want, if any?")
+	topics := flags.String("topics", "", "Which topics go to which rooms, like sensors/+/alert=alerts, separated by commas?")
+	commands := flags.String("commands", "", "Under which topics are the commands of rooms published, like alerts=devices/cmd, separated by commas?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	config := mqtt.Config{
+		Broker:   *broker,
+		ClientID: *clientID,
+		Username: *mqttUser,
+		Password: *mqttPassword,
+		Commands: make(map[string]string),
+	}
+	var roomNames []string
+	for _, pair := range splitPairs(*topics) {
+		if err := mqtt.ValidFilter(pair[0]); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Reading -topics failed")
+		}
+		config.Routes = append(config.Routes, mqtt.Route{Filter: pair[0], Room: pair[1]})
+		roomNames = append(roomNames, pair[1])
+	}
+	for _, pair := range splitPairs(*commands) {
+		if err := mqtt.ValidTopic(pair[1]); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Reading -commands failed")
+		}
+		config.Commands[pair[0]] = pair[1]
+		roomNames = append(roomNames, pair[0])
+	}
+	if len(roomNames) == 0 {
+		logrus.Fatalln("The MQTT bridge needs -topics, -commands or both")
+	}
+
+	stop := notifyStop()
+
+	host := node.startHost()
+	host.RoomKeys.Dir = *roomKeys
+
+	rooms := make(map[string]*chat.ChatRoom)
+	for _, roomName := range roomNames {
+		if _, ok := rooms[roomName]; ok {
+			continue
+		}
+
+		cr, err := chat.JoinChatRoom(host, *username, roomName)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  roomName,
+			}).Fatalln("Joining the chatroom failed")
+		}
+		if err := cr.SetPassword(*password); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Setting the room password failed")
+		}
+		rooms[roomName] = cr
+	}
+
+	bridge := mqtt.NewBridge(config, rooms)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go bridge.Run(ctx)
+
+	logrus.WithFields(logrus.Fields{
+		"broker": *broker,
+		"rooms":  len(rooms),
+	}).Infoln("Bridging MQTT to the rooms")
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping, leaving the rooms")
+
+	bridge.Close()
+	cancel()
+	for _, cr := range rooms {
+		cr.Leave()
+	}
+	closeHost(host)
+}
+
+// This one splits a list like a=b,c=d into its pairs,
+// exiting if one of them is missing a side
+func splitPairs(list string) [][2]string {
+	var pairs [][2]string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+
+		i := strings.LastIndex(item, "=")
+		if i <= 0 || i == len(item)-1 {
+			logrus.WithFields(logrus.Fields{
+				"pair": item,
+			}).Fatalln("Expected a pair of names joined by =")
+		}
+		pairs = append(pairs, [2]string{strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])})
+	}
+
+	return pairs
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/nostr"
+)
+
+// This one runs a bridge cross-posting a room to a Nostr relay,
+// and passing the notes for the room back to it
+func runNostr(args []string) {
+	flags := newFlagSet("nostr", "mirror a room on a Nostr relay, and pass replies back")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "nostr", "How do we call the bridge in the room?")
+	room := flags.String("room", "", "What topic should be mirrored?")
+	password := flags.String("password", "", "What is the password of the room, if it has one?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	relay := flags.String("nostr-relay", "", "Which relay should the room be mirrored on, like wss://relay.example.org?")
+	tag := flags.String("nostr-tag", "", "What hashtag do the notes of the room carry, the room name if empty?")
+	keyFile := flags.String("nostr-key", state.Path("nostr.key"), "Where do you keep the secret key the notes are signed with?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	if !strings.HasPrefix(*relay, "wss://") && !strings.HasPrefix(*relay, "ws://") {
+		logrus.Fatalln("The Nostr bridge needs a -nostr-relay, like wss://relay.example.org")
+	}
+
+	key, err := nostr.LoadKey(*keyFile)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading the Nostr key failed")
+	}
+
+	stop := notifyStop()
+
+	host := node.startHost()
+	host.RoomKeys.Dir = *roomKeys
+
+	cr, err := chat.JoinChatRoom(host, *username, *room)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Joining the chatroom failed")
+	}
+	if err := cr.SetPassword(*password); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Setting the room password failed")
+	}
+
+	if len(*tag) == 0 {
+		*tag = cr.RoomName
+	}
+	bridge := nostr.NewBridge(nostr.Config{Relay: *relay, Tag: strings.ToLower(strings.TrimPrefix(*tag, "#"))}, key, cr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go bridge.Run(ctx)
+
+	logrus.WithFields(logrus.Fields{
+		"room":   cr.RoomName,
+		"relay":  *relay,
+		"pubkey": key.PubKey,
+	}).Infoln("Mirroring the room on Nostr")
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping, leaving the rooms")
+
+	bridge.Close()
+	cancel()
+	cr.Leave()
+	closeHost(host)
+}
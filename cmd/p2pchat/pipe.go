@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// how long the last lines read get to go out once the input ends
+const pipeDrain = 2 * time.Second
+
+// longest line read from the input, well past the longest message
+const maxPipeLine = 64 * 1024
+
+// a message as a pipe writes it in JSON, along with its room
+type pipeRecord struct {
+	Room string `json:"room"`
+	chat.ChatMessage
+}
+
+// This one chats over the standard input and output instead of the terminal
+// interface. What others say in the room is written out a message a line, as
+// text or JSON, and every line read is said in the room, but for /profile
+// taking the profiles of a while. Logs go to the
+// standard error, so the output is the messages alone. Ends with the input,
+// or when stopped
+func runPipe(cr *chat.ChatRoom, format string, stop chan os.Signal) {
+	out := &pipeWriter{out: bufio.NewWriter(os.Stdout), format: format}
+
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		// muted peers are still there, we just don't listen
+		if from, err := peer.Decode(msg.SenderID); err == nil && cr.Host.PeerLists.IsMuted(from) {
+			return
+		}
+		out.write(cr.RoomName, msg)
+	})
+	cr.OnLog(func(log chat.ChatLog) {
+		entry := logrus.WithFields(logrus.Fields{
+			"room":   cr.RoomName,
+			"prefix": log.Prefix,
+		})
+		if log.Alert {
+			entry.Warnln(log.Msg)
+		} else {
+			entry.Infoln(log.Msg)
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Buffer(make([]byte, 4096), maxPipeLine)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+			// not said in the room, it's for us
+			if line == "/profile" || strings.HasPrefix(line, "/profile ") {
+				go pipeProfile(strings.TrimSpace(strings.TrimPrefix(line, "/profile")))
+				continue
+			}
+			if err := chat.CheckMessageLength(line); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Warnln("Line not sent")
+				continue
+			}
+			if err := cr.Send(cr.NewTextMessage(line)); err != nil {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Errorln("Reading the input failed")
+		}
+	}()
+
+	select {
+	case <-done:
+		// the last lines are still on their way out
+		select {
+		case <-time.After(pipeDrain):
+		case <-stop:
+		}
+	case <-stop:
+	}
+}
+
+// This one takes the profiles /profile asks for, for the duration given
+// like 30s, half a minute by default, logging where they went
+func pipeProfile(arg string) {
+	duration := 30 * time.Second
+	if len(arg) > 0 {
+		parsed, err := time.ParseDuration(arg)
+		if err != nil || parsed <= 0 {
+			logrus.WithFields(logrus.Fields{
+				"duration": arg,
+			}).Warnln("Profiling needs a duration, like /profile 30s")
+			return
+		}
+		duration = parsed
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"duration": duration.String(),
+	}).Infoln("Profiling")
+
+	path, err := profileFor(duration)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Profiling failed")
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"file": path,
+	}).Infoln("CPU and heap profiles written")
+}
+
+// the output of a pipe, written to from the goroutines of the room
+type pipeWriter struct {
+	lock   sync.Mutex
+	out    *bufio.Writer
+	format string
+}
+
+// Method that writes a message out on a line of its own, flushing it right
+// away for whoever follows the output. As text only what people say is
+// written, the rest of the messages, like edits and reactions, are in the JSON
+func (pw *pipeWriter) write(room string, msg chat.ChatMessage) {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+
+	if pw.format == "json" {
+		data, err := json.Marshal(pipeRecord{Room: room, ChatMessage: msg})
+		if err != nil {
+			return
+		}
+		pw.out.Write(append(data, '\n'))
+		pw.out.Flush()
+		return
+	}
+
+	if msg.Type != chat.MessageText && msg.Type != chat.MessageCommand {
+		return
+	}
+
+	sent := time.Now()
+	if msg.Sent > 0 {
+		sent = time.Unix(msg.Sent, 0)
+	}
+	// a message is a line, whatever lines it has
+	text := strings.ReplaceAll(msg.Message, "\n", `\n`)
+
+	fmt.Fprintf(pw.out, "%s [%s] %s: %s\n", sent.Format(exportTimeLayout), room, msg.SenderName, text)
+	pw.out.Flush()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the profiles the flags asked for, written until the host is closed,
+// nil unless there are any
+var profiles *profiling
+
+// profiles of the whole run, as pprof and go tool trace read them
+type profiling struct {
+	// CPU profile being written, if asked for
+	cpu *os.File
+	// execution trace being written, if asked for
+	trace *os.File
+	// where the heap profile goes once the run ends, empty for nowhere
+	mem string
+}
+
+// This one starts the CPU profile and the execution trace of the run, where
+// asked for, and remembers where the heap profile goes once it ends
+func startProfiling(cpuPath, memPath, tracePath string) {
+	if len(cpuPath) == 0 && len(memPath) == 0 && len(tracePath) == 0 {
+		return
+	}
+
+	profiles = &profiling{mem: memPath}
+
+	if len(cpuPath) > 0 {
+		file, err := createProfile(cpuPath)
+		if err == nil {
+			if err = pprof.StartCPUProfile(file); err != nil {
+				file.Close()
+			}
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Starting the CPU profile failed")
+		}
+		profiles.cpu = file
+	}
+
+	if len(tracePath) > 0 {
+		file, err := createProfile(tracePath)
+		if err == nil {
+			if err = trace.Start(file); err != nil {
+				file.Close()
+			}
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Starting the execution trace failed")
+		}
+		profiles.trace = file
+	}
+}
+
+// This one stops the profiles of the run and writes the heap profile,
+// if there are any. Called once the host is closed
+func stopProfiling() {
+	if profiles == nil {
+		return
+	}
+
+	if profiles.cpu != nil {
+		pprof.StopCPUProfile()
+		profiles.cpu.Close()
+		logrus.WithFields(logrus.Fields{
+			"file": profiles.cpu.Name(),
+		}).Infoln("CPU profile written")
+	}
+
+	if profiles.trace != nil {
+		trace.Stop()
+		profiles.trace.Close()
+		logrus.WithFields(logrus.Fields{
+			"file": profiles.trace.Name(),
+		}).Infoln("Execution trace written")
+	}
+
+	if len(profiles.mem) > 0 {
+		if err := writeHeapProfile(profiles.mem); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Errorln("Writing the heap profile failed")
+		} else {
+			logrus.WithFields(logrus.Fields{
+				"file": profiles.mem,
+			}).Infoln("Heap profile written")
+		}
+	}
+
+	profiles = nil
+}
+
+// This one takes a CPU profile for the duration, along with a heap profile
+// at its end, into the data directory. Returns where the CPU profile went.
+// Fails if a CPU profile is being taken already, like the one of -cpuprofile
+func profileFor(duration time.Duration) (string, error) {
+	stamp := time.Now().Format("20060102-150405")
+	cpuPath := state.Path(filepath.Join("profiles", fmt.Sprintf("cpu-%s.pprof", stamp)))
+
+	file, err := createProfile(cpuPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := pprof.StartCPUProfile(file); err != nil {
+		os.Remove(cpuPath)
+		return "", err
+	}
+	time.Sleep(duration)
+	pprof.StopCPUProfile()
+
+	heapPath := state.Path(filepath.Join("profiles", fmt.Sprintf("heap-%s.pprof", stamp)))
+	if err := writeHeapProfile(heapPath); err != nil {
+		return cpuPath, err
+	}
+
+	return cpuPath, nil
+}
+
+// This one writes a profile of the memory in use to the file
+func writeHeapProfile(path string) error {
+	file, err := createProfile(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// what is garbage already doesn't count
+	runtime.GC()
+	return pprof.WriteHeapProfile(file)
+}
+
+// This one creates the file of a profile, along with its directory
+func createProfile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/relay"
+)
+
+// This one runs a relay of a room to a Slack or Discord channel,
+// and optionally back from it
+func runRelay(args []string) {
+	flags := newFlagSet("relay", "forward a room to a Slack or Discord webhook, and back")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "relay", "How do we call the relay in the room?")
+	room := flags.String("room", "", "What topic should be relayed?")
+	password := flags.String("password", "", "What is the password of the room, if it has one?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	webhook := flags.String("webhook", "", "Which incoming webhook should the messages be posted to?")
+	kind := flags.String("webhook-kind", "", "Is the webhook slack or discord, if its URL doesn't tell?")
+	listen := flags.String("relay-listen", "", "Where should we listen for outgoing webhooks, like 127.0.0.1:8043, if at all?")
+	token := flags.String("relay-token", "", "What token must outgoing webhooks carry?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	if len(*webhook) == 0 {
+		logrus.Fatalln("The relay needs a -webhook")
+	}
+
+	stop := notifyStop()
+
+	host := node.startHost()
+	host.RoomKeys.Dir = *roomKeys
+
+	cr, err := chat.JoinChatRoom(host, *username, *room)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Joining the chatroom failed")
+	}
+	if err := cr.SetPassword(*password); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Setting the room password failed")
+	}
+
+	rel, err := relay.NewRelay(relay.Config{
+		WebhookURL: *webhook,
+		Kind:       *kind,
+		Listen:     *listen,
+		Token:      *token,
+	}, cr)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Setting up the relay failed")
+	}
+	if err := rel.Start(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Listening for outgoing webhooks failed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go rel.Run(ctx)
+
+	logrus.WithFields(logrus.Fields{
+		"room":   cr.RoomName,
+		"listen": *listen,
+	}).Infoln("Relaying the room to the webhook")
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping, leaving the rooms")
+
+	rel.Close()
+	cancel()
+	cr.Leave()
+	closeHost(host)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/archive"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// where a supernode listens unless told otherwise, ports that stay the
+// same so its addresses can be handed out for bootstrapping, the
+// WebSocket one for the browsers, which can't dial anything else
+const supernodeListen = "/ip4/0.0.0.0/tcp/4001,/ip6/::/tcp/4001,/ip4/0.0.0.0/tcp/4002/ws,/ip6/::/tcp/4002/ws"
+
+// how often a supernode announces the service again,
+// well before its provider records expire
+const reannounceInterval = 12 * time.Hour
+
+// This one runs a supernode, everything one well-connected host can do for
+// a community at once: it relays for peers behind NATs, serves the DHT and
+// bootstraps peers into it, is found by both ways of discovery, and archives
+// the rooms it is told to
+func runSupernode(args []string) {
+	flags := newFlagSet("supernode", "relay, bootstrap, rendezvous and archive for a community from one well-connected host")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "supernode", "How do we call the supernode in the rooms it archives?")
+	roomList := flags.String("rooms", "", "Which rooms are archived, separated by commas? None by default")
+	password := flags.String("password", "", "What is the password of the archived rooms, if they have one?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	archiveDir := flags.String("archive-dir", state.Path("archive"), "Where is the history of the rooms kept?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	// others bootstrap from its address, which has to stay the same
+	if len(*node.listen) == 0 {
+		*node.listen = supernodeListen
+	}
+	if len(*node.identity) == 0 {
+		*node.identity = state.Path("supernode.key")
+	}
+	node.relayHop = true
+
+	var history *chat.History
+	roomNames := splitRooms(*roomList)
+	if len(roomNames) > 0 {
+		var err error
+		if history, err = chat.OpenHistory(*archiveDir); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Opening the archive failed")
+		}
+	}
+
+	stop := notifyStop()
+
+	host := node.startHost()
+	host.RoomKeys.Dir = *roomKeys
+
+	for _, addr := range host.Host.Addrs() {
+		logrus.WithFields(logrus.Fields{
+			"address": fmt.Sprintf("%s/p2p/%s", addr, host.Host.ID().Pretty()),
+		}).Infoln("Peers can bootstrap from")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// found by peers discovering either way, whichever the flags picked
+	go rendezvous(ctx, host, *node.discovery)
+
+	var server *archive.Server
+	var rooms map[string]*chat.ChatRoom
+	if history != nil {
+		server = archive.NewServer(host, history)
+		rooms = keepRooms(host, server, *username, *password, roomNames)
+		go server.Run(ctx)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"rooms": len(rooms),
+	}).Infoln("Supernode running, relaying for peers and serving the DHT")
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping the supernode")
+
+	if server != nil {
+		server.Close()
+	}
+	cancel()
+	for _, cr := range rooms {
+		cr.Leave()
+	}
+	closeHost(host)
+}
+
+// This one makes the supernode found by both ways of discovery, the one
+// the flags didn't pick having started already, and announces the service
+// again now and then, until the context is done
+func rendezvous(ctx context.Context, host *p2p.P2P, discovery string) {
+	var err error
+	if discovery == "advertise" {
+		err = host.AnnounceConnect()
+	} else {
+		err = host.AdvertiseConnect()
+	}
+	if err != nil && ctx.Err() == nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Discovery failed")
+	}
+
+	ticker := time.NewTicker(reannounceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := host.AnnounceConnect(); err != nil && ctx.Err() == nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Warnln("Announcing the service again failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/p2p"
+	"github.com/xtopala/p2pchat/telemetry"
+)
+
+// usage reporting of this run, nil unless the command was told where reports go
+var usage *usageReporting
+
+// the report of this run, and where it waits to be sent
+type usageReporting struct {
+	outbox  *telemetry.Outbox
+	report  *telemetry.Report
+	started time.Time
+}
+
+// This one prints the usage reports waiting to be sent, exactly as they will
+// be, or drops them
+func runTelemetry(args []string) {
+	flags := newFlagSet("telemetry", "show the usage reports waiting to be sent, or drop them")
+	discard := flags.Bool("discard", false, "Should the waiting reports be dropped instead?")
+	parseFlags(flags, args)
+
+	outbox, err := telemetry.LoadOutbox(state.Path("telemetry.json"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Loading the usage reports failed")
+	}
+
+	if *discard {
+		count, err := outbox.Discard()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Dropping the usage reports failed")
+		}
+		fmt.Printf("dropped %d reports\n", count)
+		return
+	}
+
+	payload, err := outbox.Payload()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Reading the usage reports failed")
+	}
+	if payload == nil {
+		fmt.Println("no reports waiting")
+		return
+	}
+
+	fmt.Print(string(payload))
+}
+
+// This one starts reporting the usage of this run, if there is an endpoint
+// to report to, sending what earlier runs left waiting in the background
+func startTelemetry(command, discovery, endpoint string) {
+	if len(endpoint) == 0 {
+		return
+	}
+
+	outbox, err := telemetry.LoadOutbox(state.Path("telemetry.json"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Loading the usage reports failed")
+		return
+	}
+
+	usage = &usageReporting{
+		outbox:  outbox,
+		report:  telemetry.NewReport(command, discovery),
+		started: time.Now(),
+	}
+
+	go func() {
+		count, err := outbox.Send(context.Background(), endpoint)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Debugln("Sending the usage reports failed")
+			return
+		}
+		if count > 0 {
+			logrus.WithFields(logrus.Fields{
+				"reports": count,
+			}).Infoln("Usage reports sent")
+		}
+	}()
+}
+
+// This one finishes the report of this run with how the host did,
+// leaving it for the next run to send
+func finishTelemetry(host *p2p.P2P) {
+	if usage == nil {
+		return
+	}
+
+	usage.report.Reachability = host.Reachability().String()
+	usage.report.Peers = telemetry.Bucket(len(host.Host.Network().Peers()))
+	usage.report.Uptime = telemetry.UptimeBucket(time.Since(usage.started))
+	keepReport()
+}
+
+// This one notes where the command crashed in the report of this run,
+// if there is one, and crashes on. Deferred by main
+func catchCrash() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if usage != nil {
+		usage.report.Crash = telemetry.Signature(r)
+		usage.report.Uptime = telemetry.UptimeBucket(time.Since(usage.started))
+		keepReport()
+	}
+
+	panic(r)
+}
+
+// This one keeps the report of this run, waiting to be sent, once
+func keepReport() {
+	outbox, report := usage.outbox, *usage.report
+	usage = nil
+
+	if err := outbox.Add(report); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Keeping the usage report failed")
+	}
+}
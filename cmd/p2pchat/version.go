@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/version"
+)
+
+// This one prints which version this is, and looks for a newer one if asked to
+func runVersion(args []string) {
+	flags := newFlagSet("version", "print the version, and look for a newer one")
+	check := flags.Bool("check", false, "Should we look for a newer release?")
+	feed := flags.String("update-feed", version.DefaultFeed, "Where should we look for the latest release?")
+	parseFlags(flags, args)
+
+	info := version.Get()
+	fmt.Printf("p2pchat %s\n", info)
+	fmt.Printf("built with %s for %s\n", info.GoVersion, info.Platform)
+
+	if !*check {
+		return
+	}
+
+	notice, err := updateNotice(*feed)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Looking for a newer release failed")
+	}
+	if len(notice) == 0 {
+		notice = "this is the latest release"
+	}
+	fmt.Println(notice)
+}
+
+// flags of the commands telling about newer releases
+type updateFlags struct {
+	check *bool
+	feed  *string
+}
+
+// This one defines the flags of the commands telling about newer releases
+func addUpdateFlags(flags *flag.FlagSet) *updateFlags {
+	return &updateFlags{
+		check: flags.Bool("check-updates", false, "Should we look for a newer release when starting?"),
+		feed:  flags.String("update-feed", version.DefaultFeed, "Where should we look for the latest release?"),
+	}
+}
+
+// Method that looks for a newer release in the background, if the flags ask
+// for it, telling about it if there is one. Failing to look is only logged,
+// as nothing depends on it
+func (uf *updateFlags) checkUpdates(tell func(notice string)) {
+	if !*uf.check {
+		return
+	}
+
+	go func() {
+		notice, err := updateNotice(*uf.feed)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Debugln("Looking for a newer release failed")
+			return
+		}
+		if len(notice) > 0 {
+			tell(notice)
+		}
+	}()
+}
+
+// This one asks the feed for the latest release, returning what to tell
+// about it if it's newer than we are, nothing otherwise
+func updateNotice(feed string) (string, error) {
+	release, err := version.Latest(context.Background(), feed)
+	if err != nil {
+		return "", err
+	}
+
+	current := version.Get().Version
+	if !version.Older(current, release.Tag) {
+		return "", nil
+	}
+
+	notice := fmt.Sprintf("p2pchat %s is out, this is %s", release.Tag, current)
+	if len(release.URL) > 0 {
+		notice += ", see " + release.URL
+	}
+
+	return notice, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/xmpp"
+)
+
+// This one runs a gateway between a room and an XMPP multi-user chat room,
+// connected to the XMPP server as an external component
+func runXMPP(args []string) {
+	flags := newFlagSet("xmpp", "relay a room to an XMPP multi-user chat room and back")
+	node := addNodeFlags(flags)
+	username := flags.String("user", "xmpp", "How do we call the gateway in the room?")
+	room := flags.String("room", "", "What topic should be relayed?")
+	password := flags.String("password", "", "What is the password of the room, if it has one?")
+	roomKeys := flags.String("room-keys", state.RoomKeys(), "Where do you keep the keys of the rooms you created?")
+	server := flags.String("xmpp-server", "localhost:5347", "Where does the XMPP server accept components?")
+	domain := flags.String("xmpp-domain", "", "What is the domain of the component, like p2pchat.example.org?")
+	secret := flags.String("xmpp-secret", "", "What secret does the XMPP server share with the component?")
+	muc := flags.String("xmpp-room", "", "Which XMPP room should be relayed, like lobby@conference.example.org?")
+	nick := flags.String("xmpp-nick", "p2pchat", "How do we call the gateway in the XMPP room?")
+	parseFlags(flags, args)
+
+	node.setupLogging()
+
+	if len(*domain) == 0 || len(*muc) == 0 {
+		logrus.Fatalln("The XMPP gateway needs -xmpp-domain and -xmpp-room")
+	}
+
+	stop := notifyStop()
+
+	host := node.startHost()
+	host.RoomKeys.Dir = *roomKeys
+
+	cr, err := chat.JoinChatRoom(host, *username, *room)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Joining the chatroom failed")
+	}
+	if err := cr.SetPassword(*password); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Setting the room password failed")
+	}
+
+	gateway := xmpp.NewGateway(xmpp.Config{
+		Server: *server,
+		Domain: *domain,
+		Secret: *secret,
+		Room:   *muc,
+		Nick:   *nick,
+	}, cr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go gateway.Run(ctx)
+
+	logrus.WithFields(logrus.Fields{
+		"room":      cr.RoomName,
+		"xmpp-room": *muc,
+	}).Infoln("Relaying the room to XMPP")
+
+	// until we are stopped
+	<-stop
+	logrus.Infoln("Stopping, leaving the rooms")
+
+	gateway.Close()
+	cancel()
+	cr.Leave()
+	closeHost(host)
+}
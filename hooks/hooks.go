@@ -0,0 +1,147 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// events hooks can be run for, each hook is an executable named after its event
+const (
+	MessageReceived = "message-received"
+	MessageSending  = "message-sending"
+	PeerJoined      = "peer-joined"
+	CommandInvoked  = "command-invoked"
+)
+
+// longest a hook may run before it is killed
+const hookTimeout = 10 * time.Second
+
+// most of the output of a hook that is kept
+const maxOutput = 64 * 1024
+
+// Event is what a hook gets on its standard input, as JSON
+type Event struct {
+	Event string `json:"event"`
+	Room  string `json:"room"`
+
+	// message received, or being sent
+	Message *chat.ChatMessage `json:"message,omitempty"`
+	// peer that joined, and the username it announced if we know it
+	PeerID   string `json:"peerId,omitempty"`
+	PeerName string `json:"peerName,omitempty"`
+	// command invoked, and what follows it
+	Command string `json:"command,omitempty"`
+	Args    string `json:"args,omitempty"`
+}
+
+// Result is what came of running a hook
+type Result struct {
+	// what the hook wrote to its standard output, trimmed
+	Output string
+	// set when the hook exited with a failure, to drop what it was run for
+	Rejected bool
+}
+
+// Hooks runs the executables kept in a directory when things happen in the
+// chat, so replies, filters and loggers can be scripted. A nil Hooks runs none
+type Hooks struct {
+	dir string
+}
+
+// Constructor function for hooks kept in the given directory
+func New(dir string) *Hooks {
+	return &Hooks{dir: dir}
+}
+
+// Method that returns the path of the hook of an event
+func (h *Hooks) path(event string) string {
+	return filepath.Join(h.dir, event)
+}
+
+// Method that checks if there is a hook for the event. It is looked for every
+// time, so hooks can be added and removed while chatting
+func (h *Hooks) Has(event string) bool {
+	if h == nil {
+		return false
+	}
+
+	info, err := os.Stat(h.path(event))
+	return err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+// Method that runs the hook of the event, handing it the event as JSON on its
+// standard input, along with the event and the room in the environment.
+// Exiting with a failure is not an error, the result is rejected instead
+func (h *Hooks) Run(ctx context.Context, ev Event) (Result, error) {
+	input, err := json.Marshal(ev)
+	if err != nil {
+		return Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+
+	var stdout, stderr limitedBuffer
+	cmd := exec.CommandContext(ctx, h.path(ev.Event))
+	cmd.Dir = h.dir
+	cmd.Env = append(os.Environ(), "P2PCHAT_EVENT="+ev.Event, "P2PCHAT_ROOM="+ev.Room)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	// a hook killed half way through didn't get to say anything
+	switch ctx.Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		return Result{}, fmt.Errorf("%s hook took longer than %s", ev.Event, hookTimeout)
+	default:
+		return Result{}, fmt.Errorf("%s hook was stopped: %s", ev.Event, ctx.Err())
+	}
+
+	result := Result{Output: strings.TrimSpace(stdout.String())}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.Rejected = true
+		// what went wrong is better than nothing at all
+		if len(result.Output) == 0 {
+			result.Output = strings.TrimSpace(stderr.String())
+		}
+		return result, nil
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("could not run the %s hook: %s", ev.Event, err)
+	}
+
+	return result, nil
+}
+
+// a buffer that keeps the first bytes written to it, and drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+// Method that writes to the buffer while there is room for it
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - lb.Len(); room > 0 {
+		if len(p) > room {
+			lb.Buffer.Write(p[:room])
+		} else {
+			lb.Buffer.Write(p)
+		}
+	}
+
+	// the hook shouldn't fail for talking too much
+	return len(p), nil
+}
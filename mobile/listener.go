@@ -0,0 +1,45 @@
+package mobile
+
+import "github.com/xtopala/p2pchat/chat"
+
+// Message is a message received in a room, in the types gomobile can
+// hand to Java and Swift
+type Message struct {
+	Room string
+	ID   string
+	// kind of the message, empty for plain text, like chat.MessageText
+	Type       string
+	SenderID   string
+	SenderName string
+	Text       string
+	// unix time the message was sent, as the sender's clock tells
+	Sent int64
+	// ID of the message this one refers to, like the one being edited
+	Ref string
+}
+
+// Listener is implemented by the app to hear what happens in the rooms.
+// Its methods are called on goroutines of the node, one at a time for each
+// room, so the app hands them over to its own thread before touching views
+type Listener interface {
+	// called with every message received in a room
+	OnMessage(msg *Message)
+	// called with every line a room or the node logs
+	OnLog(room, prefix, text string)
+	// called with every peer joining a room
+	OnPeerJoin(room, peerID string)
+}
+
+// This one turns a message of a room into one for the app
+func newMessage(room string, msg chat.ChatMessage) *Message {
+	return &Message{
+		Room:       room,
+		ID:         msg.ID,
+		Type:       msg.Type,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		Text:       msg.Message,
+		Sent:       msg.Sent,
+		Ref:        msg.Ref,
+	}
+}
@@ -0,0 +1,215 @@
+package mobile
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// Config is how a node is started. Apps get one with NewConfig,
+// as gomobile has no struct literals
+type Config struct {
+	// directory the node keeps its identity, peer lists and room keys in,
+	// like the files directory of the app, as phones have no config directory
+	StateDir string
+	// peers to find the others through, separated by commas,
+	// the public ones if empty
+	Bootstrap string
+	// how peers are discovered, announce or advertise
+	Discovery string
+}
+
+// Constructor function for a config with the defaults, keeping the state
+// of the node in the given directory
+func NewConfig(stateDir string) *Config {
+	return &Config{StateDir: stateDir, Discovery: "announce"}
+}
+
+// Node is a chat peer running inside a mobile app, the p2p and chat layers
+// of p2pchat behind a few methods gomobile can bind. Rooms go by their names
+type Node struct {
+	host     *p2p.P2P
+	listener Listener
+
+	// lock for the rooms
+	lock  sync.Mutex
+	rooms map[string]*chat.ChatRoom
+}
+
+// This one starts a node with the config, telling the listener what happens.
+// It returns once the host is up, discovering peers in the background
+func Start(config *Config, listener Listener) (*Node, error) {
+	if config == nil || len(config.StateDir) == 0 {
+		return nil, fmt.Errorf("the node needs a directory to keep its state in")
+	}
+	if listener == nil {
+		return nil, fmt.Errorf("the node needs a listener")
+	}
+	if err := os.MkdirAll(config.StateDir, 0700); err != nil {
+		return nil, err
+	}
+
+	bootstrap, err := p2p.ParseBootstrapPeers(config.Bootstrap)
+	if err != nil {
+		return nil, err
+	}
+
+	state := p2p.NewState(config.StateDir)
+	lists, err := p2p.LoadPeerLists(state.PeerLists())
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := p2p.NewP2P(context.Background(), p2p.Options{
+		Identity:  state.Identity(),
+		PeerLists: lists,
+		Bootstrap: bootstrap,
+		RoomKeys:  state.RoomKeys(),
+		PeerCache: state.Peerstore(),
+		// the app follows how far starting up got through the logs
+		Progress: func(state p2p.StartupState) {
+			listener.OnLog("", "startup", string(state))
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		host:     host,
+		listener: listener,
+		rooms:    make(map[string]*chat.ChatRoom),
+	}
+
+	go func() {
+		var err error
+		if config.Discovery == "advertise" {
+			err = host.AdvertiseConnect()
+		} else {
+			err = host.AnnounceConnect()
+		}
+		if err != nil && host.Ctx.Err() == nil {
+			listener.OnLog("", "discovery", err.Error())
+		}
+	}()
+
+	return n, nil
+}
+
+// Method that returns the peer ID of the node, which others know it by
+func (n *Node) PeerID() string {
+	return n.host.Host.ID().Pretty()
+}
+
+// Method that joins a room under the username, with its password if it has
+// one, or an empty one. Joining a room again changes nothing
+func (n *Node) Join(roomName, username, password string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.rooms[roomName]; ok {
+		return nil
+	}
+
+	cr, err := chat.JoinChatRoom(n.host, username, roomName)
+	if err != nil {
+		return err
+	}
+	if err := cr.SetPassword(password); err != nil {
+		cr.Leave()
+		return err
+	}
+
+	// the room name is the one the app knows it by
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		n.listener.OnMessage(newMessage(roomName, msg))
+	})
+	cr.OnLog(func(log chat.ChatLog) {
+		n.listener.OnLog(roomName, log.Prefix, log.Msg)
+	})
+	if err := cr.OnPeerJoin(func(id peer.ID) {
+		n.listener.OnPeerJoin(roomName, id.Pretty())
+	}); err != nil {
+		cr.Leave()
+		return err
+	}
+
+	n.rooms[roomName] = cr
+	return nil
+}
+
+// Method that says the text in a joined room
+func (n *Node) Send(roomName, text string) error {
+	cr, err := n.room(roomName)
+	if err != nil {
+		return err
+	}
+	if err := chat.CheckMessageLength(text); err != nil {
+		return err
+	}
+
+	return cr.Send(cr.NewTextMessage(text))
+}
+
+// Method that returns the peer IDs of the others in a joined room,
+// one a line, as gomobile can't hand over lists of strings
+func (n *Node) Peers(roomName string) (string, error) {
+	cr, err := n.room(roomName)
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, id := range cr.GetPeers() {
+		ids = append(ids, id.Pretty())
+	}
+
+	return strings.Join(ids, "\n"), nil
+}
+
+// Method that leaves a joined room
+func (n *Node) Leave(roomName string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	cr, ok := n.rooms[roomName]
+	if !ok {
+		return fmt.Errorf("not in the room %s", roomName)
+	}
+
+	cr.Leave()
+	delete(n.rooms, roomName)
+	return nil
+}
+
+// Method that leaves every room and shuts the node down, for when the app
+// goes away. A stopped node can't be started again, Start makes a new one
+func (n *Node) Stop() error {
+	n.lock.Lock()
+	for roomName, cr := range n.rooms {
+		cr.Leave()
+		delete(n.rooms, roomName)
+	}
+	n.lock.Unlock()
+
+	return n.host.Close()
+}
+
+// Method that returns a joined room by name
+func (n *Node) room(roomName string) (*chat.ChatRoom, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	cr, ok := n.rooms[roomName]
+	if !ok {
+		return nil, fmt.Errorf("not in the room %s", roomName)
+	}
+
+	return cr, nil
+}
@@ -0,0 +1,366 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// how long connecting to the broker may take
+const dialTimeout = 15 * time.Second
+
+// seconds of quiet the broker allows before dropping us,
+// we ping it twice as often
+const keepAlive = 60
+
+// how long to wait before connecting again after losing the broker,
+// doubling after each failed try up to the longest wait
+const (
+	reconnectMinWait = 2 * time.Second
+	reconnectMaxWait = time.Minute
+)
+
+// Route passes the messages published on topics matching the filter to a room
+type Route struct {
+	// topic filter, like sensors/+/alert
+	Filter string
+	// name of the room the messages go to
+	Room string
+}
+
+// Config is how the bridge reaches the broker, and what goes where
+type Config struct {
+	// address of the broker, like tcp://localhost:1883 or tls://broker:8883
+	Broker string
+	// identifier of the bridge at the broker, made up if empty
+	ClientID string
+	// credentials, if the broker wants them
+	Username string
+	Password string
+
+	// topics passed on to rooms
+	Routes []Route
+	// topic the bot commands of a room are published under, by room name
+	Commands map[string]string
+}
+
+// Bridge passes what is published on MQTT topics to chat rooms, like the
+// alerts of sensors, and publishes the bot commands said in the rooms back,
+// for devices to act on. It reconnects when the broker goes away
+type Bridge struct {
+	config Config
+	rooms  map[string]*chat.ChatRoom
+	// where the bridge logs, the logger of the host the rooms are on
+	log *logrus.Entry
+
+	// lock for the connection, nil while it's down
+	lock sync.Mutex
+	conn net.Conn
+}
+
+// Constructor function for a bridge between the broker and the rooms, by name
+func NewBridge(config Config, rooms map[string]*chat.ChatRoom) *Bridge {
+	if len(config.ClientID) == 0 {
+		id := make([]byte, 4)
+		rand.Read(id)
+		config.ClientID = "p2pchat-" + hex.EncodeToString(id)
+	}
+
+	log := logrus.NewEntry(logrus.StandardLogger())
+	for _, cr := range rooms {
+		log = cr.Host.Logger
+		break
+	}
+
+	return &Bridge{config: config, rooms: rooms, log: log}
+}
+
+// Method that runs the bridge until the context is done, passing
+// messages both ways and keeping the broker connected
+func (b *Bridge) Run(ctx context.Context) {
+	for _, cr := range b.rooms {
+		b.watch(ctx, cr)
+	}
+
+	b.connectLoop(ctx)
+}
+
+// Method that connects to the broker and serves the connection,
+// connecting again when it's lost, until the context is done
+func (b *Bridge) connectLoop(ctx context.Context) {
+	wait := reconnectMinWait
+	for {
+		started := time.Now()
+		err := b.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a connection that held for a while starts the waits over
+		if time.Since(started) > reconnectMaxWait {
+			wait = reconnectMinWait
+		}
+		b.log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"wait":  wait.String(),
+		}).Warnln("MQTT connection lost, connecting again")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+	}
+}
+
+// Method that connects to the broker, subscribes to the
+// topics and reads from it until the connection fails
+func (b *Bridge) serve(ctx context.Context) error {
+	conn, err := b.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the context going away closes the connection, ending the reads,
+	// and while it's up the broker gets pinged so it keeps us
+	stop := make(chan struct{})
+	defer close(stop)
+	go b.keepAlive(ctx, conn, stop)
+
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := writePacket(conn, packetConnect, 0, connectBody(b.config.ClientID, b.config.Username, b.config.Password, keepAlive)); err != nil {
+		return err
+	}
+	ack, err := readPacket(reader)
+	if err != nil {
+		return err
+	}
+	if err := checkConnAck(ack); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	b.lock.Lock()
+	b.conn = conn
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		b.conn = nil
+		b.lock.Unlock()
+	}()
+
+	filters := b.filters()
+	if len(filters) > 0 {
+		if err := b.send(packetSubscribe, 0x02, subscribeBody(1, filters)); err != nil {
+			return err
+		}
+	}
+	b.log.WithFields(logrus.Fields{
+		"broker": b.config.Broker,
+		"topics": strings.Join(filters, ","),
+	}).Infoln("Connected to the MQTT broker")
+
+	return b.read(conn, reader, filters)
+}
+
+// Method that dials the broker, over TLS if its address says so
+func (b *Bridge) dial(ctx context.Context) (net.Conn, error) {
+	broker, err := url.Parse(b.config.Broker)
+	if err != nil || len(broker.Host) == 0 {
+		return nil, fmt.Errorf("bad broker address %s, expected one like tcp://localhost:1883", b.config.Broker)
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch broker.Scheme {
+	case "tcp", "mqtt":
+		return dialer.DialContext(ctx, "tcp", broker.Host)
+	case "tls", "ssl", "mqtts":
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: broker.Hostname()}}
+		return tlsDialer.DialContext(ctx, "tcp", broker.Host)
+	default:
+		return nil, fmt.Errorf("unknown broker scheme %s, expected tcp or tls", broker.Scheme)
+	}
+}
+
+// Method that pings the broker while the connection is up, and closes
+// the connection when the context is done or the connection is stopped
+func (b *Bridge) keepAlive(ctx context.Context, conn net.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(keepAlive / 2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.send(packetPingReq, 0, nil)
+		}
+	}
+}
+
+// Method that returns the topic filters to subscribe to, each once
+func (b *Bridge) filters() []string {
+	var filters []string
+	seen := make(map[string]bool)
+	for _, route := range b.config.Routes {
+		if !seen[route.Filter] {
+			seen[route.Filter] = true
+			filters = append(filters, route.Filter)
+		}
+	}
+
+	return filters
+}
+
+// Method that reads packets from the broker, until the connection fails.
+// The broker answers our pings, so hearing nothing means it's gone
+func (b *Bridge) read(conn net.Conn, reader *bufio.Reader, filters []string) error {
+	for {
+		conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2 * time.Second))
+		p, err := readPacket(reader)
+		if err != nil {
+			return err
+		}
+
+		switch p.kind {
+		case packetPublish:
+			msg, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			if msg.qos == 1 {
+				b.send(packetPubAck, 0, appendUint16(nil, msg.id))
+			}
+			b.toRooms(msg)
+
+		case packetSubAck:
+			refused, err := checkSubAck(p, 1, filters)
+			if err != nil {
+				return err
+			}
+			if len(refused) > 0 {
+				b.log.WithFields(logrus.Fields{
+					"topics": strings.Join(refused, ","),
+				}).Warnln("The MQTT broker refused some of the topics")
+			}
+		}
+	}
+}
+
+// Method that passes a message published on a topic to the rooms routed
+// from it, with the topic it came on. Retained messages are old news,
+// sent again on every subscription, so they aren't passed on
+func (b *Bridge) toRooms(msg publish) {
+	if msg.retained {
+		return
+	}
+
+	payload := strings.TrimSpace(string(msg.payload))
+	if !utf8.Valid(msg.payload) {
+		payload = fmt.Sprintf("(%d bytes of binary)", len(msg.payload))
+	}
+	text := fmt.Sprintf("[%s] %s", msg.topic, payload)
+	if err := chat.CheckMessageLength(text); err != nil {
+		b.log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"topic": msg.topic,
+		}).Warnln("MQTT message not passed on")
+		return
+	}
+
+	// a message matching several filters of a room goes there once
+	sent := make(map[string]bool)
+	for _, route := range b.config.Routes {
+		cr, ok := b.rooms[route.Room]
+		if !ok || sent[route.Room] || !matchTopic(route.Filter, msg.topic) {
+			continue
+		}
+		sent[route.Room] = true
+
+		cr.Send(cr.NewTextMessage(text))
+	}
+}
+
+// Method that handles the messages of a room until the context is done,
+// publishing the bot commands said there if the room has a topic for them
+func (b *Bridge) watch(ctx context.Context, cr *chat.ChatRoom) {
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		if ctx.Err() == nil {
+			b.toBroker(cr, msg)
+		}
+	})
+	cr.OnLog(func(log chat.ChatLog) {
+		b.log.WithFields(logrus.Fields{
+			"room":   cr.RoomName,
+			"prefix": log.Prefix,
+		}).Debugln(log.Msg)
+	})
+}
+
+// Method that publishes a bot command said in a room, on the topic of the
+// room followed by the name of the command, with its arguments as the
+// payload. The command is answered with where it went, or why it didn't
+func (b *Bridge) toBroker(cr *chat.ChatRoom, msg chat.ChatMessage) {
+	prefix, ok := b.config.Commands[cr.RoomName]
+	if !ok || msg.Type != chat.MessageCommand || msg.Command == nil {
+		return
+	}
+	// muted peers are still there, we just don't pass them on
+	if from, err := peer.Decode(msg.SenderID); err == nil && cr.Host.PeerLists.IsMuted(from) {
+		return
+	}
+
+	topic := strings.TrimSuffix(prefix, "/") + "/" + msg.Command.Name
+	response := &chat.BotResponse{Command: msg.Command.Name}
+	if err := b.send(packetPublish, 0, publishBody(topic, []byte(strings.Join(msg.Command.Args, " ")))); err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Text = "published on " + topic
+	}
+
+	cr.Send(chat.ChatMessage{
+		Type:     chat.MessageResponse,
+		ID:       chat.NewMessageID(),
+		Ref:      msg.ID,
+		Response: response,
+	})
+}
+
+// Method that writes a packet to the broker
+func (b *Bridge) send(kind, flags byte, body []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.conn == nil {
+		return fmt.Errorf("not connected to the MQTT broker")
+	}
+
+	return writePacket(b.conn, kind, flags, body)
+}
+
+// Method that disconnects from the broker, if connected
+func (b *Bridge) Close() {
+	b.send(packetDisconnect, 0, nil)
+}
@@ -0,0 +1,294 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// types of the control packets the bridge speaks, MQTT 3.1.1
+const (
+	packetConnect    = 1
+	packetConnAck    = 2
+	packetPublish    = 3
+	packetPubAck     = 4
+	packetSubscribe  = 8
+	packetSubAck     = 9
+	packetPingReq    = 12
+	packetPingResp   = 13
+	packetDisconnect = 14
+)
+
+// level of the protocol, 4 being MQTT 3.1.1
+const protocolLevel = 4
+
+// most bytes the remaining length of a packet takes
+const maxRemainingBytes = 4
+
+// largest packet the bridge takes from the broker
+const maxPacketSize = 256 * 1024
+
+// why a broker refuses a connection, by the code it answers
+var connectRefusals = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// a control packet, its type and flags from the first byte and what follows
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// a message published on a topic
+type publish struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retained bool
+	id       uint16
+}
+
+// This one writes a packet with its fixed header
+func writePacket(w io.Writer, kind, flags byte, body []byte) error {
+	header := []byte{kind<<4 | flags&0x0f}
+
+	// the remaining length, seven bits a byte
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		if length /= 128; length > 0 {
+			digit |= 0x80
+		}
+		header = append(header, digit)
+		if length == 0 {
+			break
+		}
+	}
+
+	if _, err := w.Write(append(header, body...)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// This one reads the next packet
+func readPacket(r *bufio.Reader) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+
+	length, shift := 0, 0
+	for i := 0; ; i++ {
+		if i == maxRemainingBytes {
+			return packet{}, fmt.Errorf("malformed packet length")
+		}
+
+		digit, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(digit&0x7f) << shift
+		shift += 7
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacketSize {
+		return packet{}, fmt.Errorf("packet of %d bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+
+	return packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+// This one appends a string, prefixed with its length
+func appendString(b []byte, s string) []byte {
+	b = appendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// This one appends a number in two bytes
+func appendUint16(b []byte, n uint16) []byte {
+	return append(b, byte(n>>8), byte(n))
+}
+
+// This one reads a string prefixed with its length, returning what follows it
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("malformed string")
+	}
+
+	length := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+length {
+		return "", nil, fmt.Errorf("malformed string")
+	}
+
+	return string(b[2 : 2+length]), b[2+length:], nil
+}
+
+// This one makes the body of a connect packet, with a clean
+// session and the credentials if there are any
+func connectBody(clientID, username, password string, keepAlive uint16) []byte {
+	var flags byte = 0x02
+	if len(username) > 0 {
+		flags |= 0x80
+		if len(password) > 0 {
+			flags |= 0x40
+		}
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = appendUint16(body, keepAlive)
+	body = appendString(body, clientID)
+	if flags&0x80 != 0 {
+		body = appendString(body, username)
+	}
+	if flags&0x40 != 0 {
+		body = appendString(body, password)
+	}
+
+	return body
+}
+
+// This one checks the answer of the broker to our connect packet
+func checkConnAck(p packet) error {
+	if p.kind != packetConnAck || len(p.body) != 2 {
+		return fmt.Errorf("expected a connack, got packet type %d", p.kind)
+	}
+
+	if code := p.body[1]; code != 0 {
+		reason, ok := connectRefusals[code]
+		if !ok {
+			reason = fmt.Sprintf("code %d", code)
+		}
+		return fmt.Errorf("broker refused the connection: %s", reason)
+	}
+
+	return nil
+}
+
+// This one makes the body of a subscribe packet, for the
+// topic filters at most once each
+func subscribeBody(id uint16, filters []string) []byte {
+	body := appendUint16(nil, id)
+	for _, filter := range filters {
+		body = appendString(body, filter)
+		body = append(body, 0)
+	}
+
+	return body
+}
+
+// This one checks the answer of the broker to our subscribe packet,
+// returning the filters it refused
+func checkSubAck(p packet, id uint16, filters []string) ([]string, error) {
+	if len(p.body) < 2 || binary.BigEndian.Uint16(p.body) != id {
+		return nil, fmt.Errorf("malformed suback")
+	}
+
+	var refused []string
+	for i, code := range p.body[2:] {
+		if code == 0x80 && i < len(filters) {
+			refused = append(refused, filters[i])
+		}
+	}
+
+	return refused, nil
+}
+
+// This one reads a message out of a publish packet
+func parsePublish(p packet) (publish, error) {
+	msg := publish{qos: (p.flags >> 1) & 0x03, retained: p.flags&0x01 != 0}
+
+	topic, rest, err := readString(p.body)
+	if err != nil {
+		return publish{}, err
+	}
+	msg.topic = topic
+
+	if msg.qos > 0 {
+		if len(rest) < 2 {
+			return publish{}, fmt.Errorf("malformed publish")
+		}
+		msg.id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	msg.payload = rest
+
+	return msg, nil
+}
+
+// This one makes the body of a publish packet, sent at most once
+func publishBody(topic string, payload []byte) []byte {
+	return append(appendString(nil, topic), payload...)
+}
+
+// This one checks that a topic filter is one a broker takes, with
+// wildcards standing for whole levels and # only at the end
+func ValidFilter(filter string) error {
+	if len(filter) == 0 {
+		return fmt.Errorf("empty topic filter")
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#" && i != len(levels)-1:
+			return fmt.Errorf("# must be the last level of %s", filter)
+		case level != "#" && level != "+" && strings.ContainsAny(level, "#+"):
+			return fmt.Errorf("wildcards must take a whole level of %s", filter)
+		}
+	}
+
+	return nil
+}
+
+// This one checks that a topic can be published on, with no wildcards
+func ValidTopic(topic string) error {
+	if len(topic) == 0 {
+		return fmt.Errorf("empty topic")
+	}
+	if strings.ContainsAny(topic, "#+") {
+		return fmt.Errorf("topic %s can't have wildcards", topic)
+	}
+
+	return nil
+}
+
+// This one checks if a topic matches a filter. Wildcards don't match
+// the topics starting with $, which brokers keep for themselves
+func matchTopic(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filters := strings.Split(filter, "/")
+	topics := strings.Split(topic, "/")
+	for i, level := range filters {
+		if level == "#" {
+			return true
+		}
+		if i == len(topics) {
+			return false
+		}
+		if level != "+" && level != topics[i] {
+			return false
+		}
+	}
+
+	return len(filters) == len(topics)
+}
@@ -0,0 +1,332 @@
+package nostr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// how long connecting to the relay may take
+const dialTimeout = 15 * time.Second
+
+// how often the relay is pinged, and how long it may stay quiet
+const (
+	pingInterval = 30 * time.Second
+	readTimeout  = 90 * time.Second
+)
+
+// how long to wait before connecting again after losing the relay,
+// doubling after each failed try up to the longest wait
+const (
+	reconnectMinWait = 2 * time.Second
+	reconnectMaxWait = time.Minute
+)
+
+// most event IDs remembered to drop the ones the relay sends twice
+const maxSeen = 1000
+
+// the subscription the bridge asks the relay for
+const subscriptionID = "p2pchat"
+
+// Config is which relay the bridge posts to, and what it reads back
+type Config struct {
+	// address of the relay, like wss://relay.example.org
+	Relay string
+	// hashtag the notes of the room carry, and that notes are read back by
+	Tag string
+}
+
+// Bridge cross-posts the messages of a room to a Nostr relay as text notes
+// with a hashtag, giving the room a public mirror that stays around. Notes
+// carrying the hashtag, or replying to the bridge, are said in the room.
+// It reconnects when the relay goes away
+type Bridge struct {
+	config Config
+	key    *Key
+	room   *chat.ChatRoom
+
+	// lock for the connection, nil while it's down
+	lock sync.Mutex
+	conn *websocket.Conn
+
+	// notes already passed on, and the time of the latest,
+	// so reconnecting doesn't bring them again
+	seen  map[string]bool
+	since int64
+}
+
+// Constructor function for a bridge between the room and the relay,
+// posting as the key
+func NewBridge(config Config, key *Key, cr *chat.ChatRoom) *Bridge {
+	return &Bridge{
+		config: config,
+		key:    key,
+		room:   cr,
+		seen:   make(map[string]bool),
+		since:  time.Now().Unix(),
+	}
+}
+
+// Method that runs the bridge until the context is done, passing
+// messages both ways and keeping the relay connected
+func (b *Bridge) Run(ctx context.Context) {
+	b.room.OnMessage(func(msg chat.ChatMessage) {
+		if ctx.Err() == nil {
+			b.toNostr(msg)
+		}
+	})
+	b.room.OnLog(func(log chat.ChatLog) {
+		b.room.Host.Logger.WithFields(logrus.Fields{
+			"room":   b.room.RoomName,
+			"prefix": log.Prefix,
+		}).Debugln(log.Msg)
+	})
+
+	b.connectLoop(ctx)
+}
+
+// Method that connects to the relay and serves the connection,
+// connecting again when it's lost, until the context is done
+func (b *Bridge) connectLoop(ctx context.Context) {
+	wait := reconnectMinWait
+	for {
+		started := time.Now()
+		err := b.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a connection that held for a while starts the waits over
+		if time.Since(started) > reconnectMaxWait {
+			wait = reconnectMinWait
+		}
+		b.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"wait":  wait.String(),
+		}).Warnln("Nostr relay connection lost, connecting again")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+	}
+}
+
+// Method that connects to the relay, subscribes to the notes
+// for the room and reads from it until the connection fails
+func (b *Bridge) serve(ctx context.Context) error {
+	dialer := websocket.Dialer{HandshakeTimeout: dialTimeout}
+	conn, _, err := dialer.DialContext(ctx, b.config.Relay, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the context going away closes the connection, ending the reads,
+	// and while it's up the relay gets pinged so it keeps us
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return
+			case <-stop:
+				return
+			case <-ticker.C:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(dialTimeout))
+			}
+		}
+	}()
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	b.lock.Lock()
+	b.conn = conn
+	since := b.since
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		b.conn = nil
+		b.lock.Unlock()
+	}()
+
+	// notes with the hashtag, and replies to the bridge
+	byTag := map[string]interface{}{"kinds": []int{KindTextNote}, "#t": []string{b.config.Tag}, "since": since}
+	byReply := map[string]interface{}{"kinds": []int{KindTextNote}, "#p": []string{b.key.PubKey}, "since": since}
+	if err := b.send([]interface{}{"REQ", subscriptionID, byTag, byReply}); err != nil {
+		return err
+	}
+	b.room.Host.Logger.WithFields(logrus.Fields{
+		"relay": b.config.Relay,
+		"tag":   b.config.Tag,
+	}).Infoln("Connected to the Nostr relay")
+
+	return b.read(conn)
+}
+
+// Method that reads what the relay sends, until the connection fails
+func (b *Bridge) read(conn *websocket.Conn) error {
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var frame []json.RawMessage
+		var kind string
+		if json.Unmarshal(data, &frame) != nil || len(frame) == 0 || json.Unmarshal(frame[0], &kind) != nil {
+			continue
+		}
+
+		switch {
+		case kind == "EVENT" && len(frame) == 3:
+			var ev Event
+			if err := json.Unmarshal(frame[2], &ev); err != nil {
+				continue
+			}
+			b.toRoom(&ev)
+
+		case kind == "OK" && len(frame) == 4:
+			var id, reason string
+			var accepted bool
+			json.Unmarshal(frame[1], &id)
+			json.Unmarshal(frame[2], &accepted)
+			json.Unmarshal(frame[3], &reason)
+			if !accepted {
+				b.room.Host.Logger.WithFields(logrus.Fields{
+					"event":  id,
+					"reason": reason,
+				}).Warnln("The Nostr relay refused a note")
+			}
+
+		case kind == "NOTICE" && len(frame) == 2:
+			var notice string
+			json.Unmarshal(frame[1], &notice)
+			b.room.Host.Logger.WithFields(logrus.Fields{
+				"notice": notice,
+			}).Infoln("The Nostr relay says")
+
+		case kind == "CLOSED" && len(frame) >= 2:
+			return fmt.Errorf("relay closed the subscription")
+		}
+	}
+}
+
+// Method that passes a note on to the room, with the start of the key of
+// whoever posted it. Our own notes, notes seen already and notes that
+// aren't what they say are dropped
+func (b *Bridge) toRoom(ev *Event) {
+	if ev.Kind != KindTextNote || ev.PubKey == b.key.PubKey || len(strings.TrimSpace(ev.Content)) == 0 {
+		return
+	}
+
+	b.lock.Lock()
+	if b.seen[ev.ID] {
+		b.lock.Unlock()
+		return
+	}
+	if len(b.seen) >= maxSeen {
+		b.seen = make(map[string]bool)
+	}
+	b.seen[ev.ID] = true
+	if ev.CreatedAt > b.since {
+		b.since = ev.CreatedAt
+	}
+	b.lock.Unlock()
+
+	if err := ev.Verify(); err != nil {
+		b.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Nostr note not passed on")
+		return
+	}
+
+	author := ev.PubKey
+	if len(author) > 8 {
+		author = author[:8]
+	}
+
+	text := fmt.Sprintf("<%s> %s", author, ev.Content)
+	if err := chat.CheckMessageLength(text); err != nil {
+		b.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"event": ev.ID,
+		}).Warnln("Nostr note not passed on")
+		return
+	}
+
+	b.room.Send(b.room.NewTextMessage(text))
+}
+
+// Method that posts a message of the room to the relay as a note, with the
+// name of whoever sent it and the hashtag. Dropped while the relay isn't
+// connected, as the mirror is only as complete as the relay makes it
+func (b *Bridge) toNostr(msg chat.ChatMessage) {
+	if msg.Type != chat.MessageText && msg.Type != chat.MessageCommand {
+		return
+	}
+	// muted peers are still there, we just don't pass them on
+	if from, err := peer.Decode(msg.SenderID); err == nil && b.room.Host.PeerLists.IsMuted(from) {
+		return
+	}
+
+	content := fmt.Sprintf("<%s> %s", msg.SenderName, msg.Message)
+	ev, err := b.key.NewNote(content, [][]string{{"t", b.config.Tag}})
+	if err != nil {
+		b.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Signing the note failed")
+		return
+	}
+
+	if err := b.send([]interface{}{"EVENT", ev}); err != nil {
+		b.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Debugln("Message not posted to the Nostr relay")
+	}
+}
+
+// Method that writes a frame to the relay
+func (b *Bridge) send(frame interface{}) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.conn == nil {
+		return fmt.Errorf("not connected to the Nostr relay")
+	}
+
+	b.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	return b.conn.WriteJSON(frame)
+}
+
+// Method that ends the subscription and closes the connection, if connected
+func (b *Bridge) Close() {
+	b.send([]interface{}{"CLOSE", subscriptionID})
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.conn != nil {
+		b.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+}
@@ -0,0 +1,161 @@
+package nostr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// kind of the events the bridge posts and reads, short text notes
+const KindTextNote = 1
+
+// Event is a Nostr event, signed by the key of whoever posted it
+type Event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// Method that returns the hash identifying the event, of its fields
+// put in an array in the order every client agrees on
+func (ev *Event) hash() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	// the array is hashed as it is, not made safe for HTML
+	encoder.SetEscapeHTML(false)
+
+	tags := ev.Tags
+	if tags == nil {
+		tags = [][]string{}
+	}
+	if err := encoder.Encode([]interface{}{0, ev.PubKey, ev.CreatedAt, ev.Kind, tags, ev.Content}); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return sum[:], nil
+}
+
+// Method that checks the event is what its ID and signature say
+func (ev *Event) Verify() error {
+	hash, err := ev.hash()
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash) != ev.ID {
+		return fmt.Errorf("event %s doesn't match its ID", ev.ID)
+	}
+
+	pubkey, err := hex.DecodeString(ev.PubKey)
+	if err != nil {
+		return fmt.Errorf("bad public key of event %s", ev.ID)
+	}
+	sig, err := hex.DecodeString(ev.Sig)
+	if err != nil || !schnorrVerify(pubkey, hash, sig) {
+		return fmt.Errorf("bad signature of event %s", ev.ID)
+	}
+
+	return nil
+}
+
+// Key is the secret key the bridge signs its events with
+type Key struct {
+	secret *big.Int
+	// x of the public key, in hex, which is how Nostr names it
+	PubKey string
+}
+
+// This one loads the key kept in hex in the given file, generating and
+// saving a new one if the file doesn't exist yet, so the mirror keeps
+// being posted by the same author. An empty path just generates a throwaway key
+func LoadKey(path string) (*Key, error) {
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			return parseKey(strings.TrimSpace(string(data)))
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	secret := make([]byte, 32)
+	for {
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if n := new(big.Int).SetBytes(secret); n.Sign() > 0 && n.Cmp(curve.N) < 0 {
+			break
+		}
+	}
+
+	key, err := parseKey(hex.EncodeToString(secret))
+	if err != nil || len(path) == 0 {
+		return key, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	return key, ioutil.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0600)
+}
+
+// This one reads a secret key given in hex
+func parseKey(text string) (*Key, error) {
+	data, err := hex.DecodeString(text)
+	if err != nil || len(data) != 32 {
+		return nil, fmt.Errorf("expected a secret key of 64 hex digits")
+	}
+
+	secret := new(big.Int).SetBytes(data)
+	if secret.Sign() == 0 || secret.Cmp(curve.N) >= 0 {
+		return nil, fmt.Errorf("secret key out of range")
+	}
+
+	px, _ := curve.ScalarBaseMult(data)
+	return &Key{secret: secret, PubKey: hex.EncodeToString(pad32(px))}, nil
+}
+
+// Method that makes a text note with the given content and tags,
+// signed with the key
+func (k *Key) NewNote(content string, tags [][]string) (*Event, error) {
+	ev := &Event{
+		PubKey:    k.PubKey,
+		CreatedAt: time.Now().Unix(),
+		Kind:      KindTextNote,
+		Tags:      tags,
+		Content:   content,
+	}
+
+	hash, err := ev.hash()
+	if err != nil {
+		return nil, err
+	}
+
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		return nil, err
+	}
+	sig, err := schnorrSign(k.secret, hash, aux)
+	if err != nil {
+		return nil, err
+	}
+
+	ev.ID = hex.EncodeToString(hash)
+	ev.Sig = hex.EncodeToString(sig)
+	return ev, nil
+}
@@ -0,0 +1,26 @@
+package nostr
+
+// This one returns the public key of the secret key given in hex,
+// as the x alone in hex
+func PublicKey(secret string) (string, error) {
+	key, err := parseKey(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return key.PubKey, nil
+}
+
+// This one signs a 32 byte message with the secret key given in hex,
+// with the auxiliary randomness mixed into the nonce
+func SchnorrSign(secret string, msg, aux []byte) ([]byte, error) {
+	key, err := parseKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return schnorrSign(key.secret, msg, aux)
+}
+
+// This one checks a BIP-340 signature of a 32 byte message
+var SchnorrVerify = schnorrVerify
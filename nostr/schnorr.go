@@ -0,0 +1,130 @@
+package nostr
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+// the curve Nostr keys live on
+var curve = btcec.S256()
+
+// This one hashes the data with the tag in front, twice, as BIP-340 does
+// to keep the hashes of its different uses apart
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+// This one returns the number in 32 bytes, padded with zeros in front
+func pad32(n *big.Int) []byte {
+	b := make([]byte, 32)
+	return n.FillBytes(b)
+}
+
+// This one returns the point of the curve with the given x and an even y,
+// if there is one
+func liftX(x *big.Int) (*big.Int, *big.Int, error) {
+	p := curve.P
+	if x.Cmp(p) >= 0 {
+		return nil, nil, fmt.Errorf("x is not on the curve")
+	}
+
+	// y² = x³ + 7
+	c := new(big.Int).Exp(x, big.NewInt(3), p)
+	c.Add(c, big.NewInt(7)).Mod(c, p)
+
+	y := new(big.Int).Exp(c, curve.QPlus1Div4(), p)
+	if new(big.Int).Exp(y, big.NewInt(2), p).Cmp(c) != 0 {
+		return nil, nil, fmt.Errorf("x is not on the curve")
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(p, y)
+	}
+
+	return x, y, nil
+}
+
+// This one signs a 32 byte message with the secret, as BIP-340 says,
+// with the auxiliary randomness mixed into the nonce
+func schnorrSign(secret *big.Int, msg, aux []byte) ([]byte, error) {
+	n := curve.N
+	if secret.Sign() == 0 || secret.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("secret key out of range")
+	}
+
+	// the public key is the x alone, so the secret is the one giving an even y
+	px, py := curve.ScalarBaseMult(pad32(secret))
+	d := new(big.Int).Set(secret)
+	if py.Bit(0) == 1 {
+		d.Sub(n, d)
+	}
+
+	t := pad32(d)
+	for i, b := range taggedHash("BIP0340/aux", aux) {
+		t[i] ^= b
+	}
+
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, pad32(px), msg))
+	k.Mod(k, n)
+	if k.Sign() == 0 {
+		return nil, fmt.Errorf("nonce came out zero")
+	}
+
+	rx, ry := curve.ScalarBaseMult(pad32(k))
+	if ry.Bit(0) == 1 {
+		k.Sub(n, k)
+	}
+
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", pad32(rx), pad32(px), msg))
+	e.Mod(e, n)
+
+	s := e.Mul(e, d)
+	s.Add(s, k).Mod(s, n)
+
+	return append(pad32(rx), pad32(s)...), nil
+}
+
+// This one checks a BIP-340 signature of a 32 byte message,
+// by the public key given as its x alone
+func schnorrVerify(pubkey, msg, sig []byte) bool {
+	if len(pubkey) != 32 || len(sig) != 64 {
+		return false
+	}
+
+	px, py, err := liftX(new(big.Int).SetBytes(pubkey))
+	if err != nil {
+		return false
+	}
+
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(curve.P) >= 0 || s.Cmp(curve.N) >= 0 {
+		return false
+	}
+
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", sig[:32], pubkey, msg))
+	e.Mod(e, curve.N)
+
+	// R = sG - eP
+	sx, sy := curve.ScalarBaseMult(pad32(s))
+	ex, ey := curve.ScalarMult(px, py, pad32(e))
+	ey.Sub(curve.P, ey).Mod(ey, curve.P)
+	rx, ry := curve.Add(sx, sy, ex, ey)
+
+	if rx.Sign() == 0 && ry.Sign() == 0 {
+		return false
+	}
+
+	return ry.Bit(0) == 0 && rx.Cmp(r) == 0
+}
@@ -0,0 +1,255 @@
+package p2p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	host "github.com/libp2p/go-libp2p-host"
+)
+
+// protocol ID of the attachment download stream
+const attachmentProtocol = protocol.ID("/p2pchat/attachment/1.0.0")
+
+// largest attachment that can be shared with a room
+const maxAttachmentSize = 1 << 20
+
+// largest image that can be attached to a room
+const maxImageSize = maxAttachmentSize
+
+// mime type of long text shared as an attachment
+const PasteMime = "text/plain"
+
+// attachment metadata carried inside a chat message,
+// the content itself is downloaded from the sender
+type Attachment struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Hash string `json:"hash"`
+	Mime string `json:"mime"`
+}
+
+// attachment download response header
+type attachmentHeader struct {
+	OK   bool  `json:"ok"`
+	Size int64 `json:"size"`
+}
+
+// an attachment shared by us, either a file or content kept in memory
+type sharedAttachment struct {
+	path string
+	data []byte
+}
+
+// AttachmentStore serves attachments shared by this node
+// and downloads the ones shared by other peers
+type AttachmentStore struct {
+	host host.Host
+
+	// attachments shared by us, by content hash
+	lock   sync.RWMutex
+	shared map[string]sharedAttachment
+}
+
+// Constructor function for a new Attachment Store,
+// which registers the attachment stream handler on the given host
+func NewAttachmentStore(nodeHost host.Host) *AttachmentStore {
+	as := &AttachmentStore{
+		host:   nodeHost,
+		shared: make(map[string]sharedAttachment),
+	}
+
+	nodeHost.SetStreamHandler(attachmentProtocol, as.handleStream)
+
+	return as
+}
+
+// Method that validates the image on the given path
+// and starts sharing it, returning its attachment metadata
+func (as *AttachmentStore) ShareImage(path string) (*Attachment, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxImageSize {
+		return nil, fmt.Errorf("image is %s, the limit is %s", FormatSize(int64(len(data))), FormatSize(maxImageSize))
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a supported image", filepath.Base(path))
+	}
+
+	hash := sha256.Sum256(data)
+	att := &Attachment{
+		Name: filepath.Base(path),
+		Size: int64(len(data)),
+		Hash: hex.EncodeToString(hash[:]),
+		Mime: "image/" + format,
+	}
+
+	as.lock.Lock()
+	as.shared[att.Hash] = sharedAttachment{path: path}
+	as.lock.Unlock()
+
+	return att, nil
+}
+
+// Method that starts sharing long text as a paste,
+// kept in memory, returning its attachment metadata
+func (as *AttachmentStore) SharePaste(text string) (*Attachment, error) {
+	data := []byte(text)
+	if len(data) > maxAttachmentSize {
+		return nil, fmt.Errorf("paste is %s, the limit is %s", FormatSize(int64(len(data))), FormatSize(maxAttachmentSize))
+	}
+
+	hash := sha256.Sum256(data)
+	att := &Attachment{
+		Size: int64(len(data)),
+		Hash: hex.EncodeToString(hash[:]),
+		Mime: PasteMime,
+	}
+	att.Name = fmt.Sprintf("paste-%s.txt", att.Hash[:8])
+
+	as.lock.Lock()
+	as.shared[att.Hash] = sharedAttachment{data: data}
+	as.lock.Unlock()
+
+	return att, nil
+}
+
+// Method that stops sharing an attachment
+func (as *AttachmentStore) Unshare(hash string) {
+	as.lock.Lock()
+	defer as.lock.Unlock()
+
+	delete(as.shared, hash)
+}
+
+// Method that downloads an attachment from the peer that shared it
+func (as *AttachmentStore) Fetch(ctx context.Context, from peer.ID, att *Attachment) ([]byte, error) {
+	if att.Size > maxAttachmentSize {
+		return nil, fmt.Errorf("%s is too large", att.Name)
+	}
+
+	stream, err := as.host.NewStream(ctx, from, attachmentProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if _, err := io.WriteString(stream, att.Hash+"\n"); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(stream)
+
+	header := attachmentHeader{}
+	if err := readJSONLine(reader, &header); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if !header.OK {
+		return nil, fmt.Errorf("%s is no longer shared", att.Name)
+	}
+	if header.Size != att.Size {
+		stream.Reset()
+		return nil, fmt.Errorf("%s has unexpected size", att.Name)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(reader, att.Size))
+	if err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+	if hex.EncodeToString(hash[:]) != att.Hash {
+		return nil, fmt.Errorf("hash mismatch for %s", att.Name)
+	}
+
+	return data, nil
+}
+
+// This one serves the attachments requested by other peers
+func (as *AttachmentStore) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(stream, 128)).ReadString('\n')
+	if err != nil {
+		stream.Reset()
+		return
+	}
+
+	as.lock.RLock()
+	shared, ok := as.shared[line[:len(line)-1]]
+	as.lock.RUnlock()
+
+	if !ok {
+		writeJSONLine(stream, attachmentHeader{OK: false})
+		return
+	}
+
+	// pastes are served straight from memory
+	if shared.data != nil {
+		if err := writeJSONLine(stream, attachmentHeader{OK: true, Size: int64(len(shared.data))}); err != nil {
+			stream.Reset()
+			return
+		}
+		if _, err := stream.Write(shared.data); err != nil {
+			stream.Reset()
+		}
+		return
+	}
+
+	file, err := os.Open(shared.path)
+	if err != nil {
+		writeJSONLine(stream, attachmentHeader{OK: false})
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		writeJSONLine(stream, attachmentHeader{OK: false})
+		return
+	}
+
+	if err := writeJSONLine(stream, attachmentHeader{OK: true, Size: info.Size()}); err != nil {
+		stream.Reset()
+		return
+	}
+
+	if _, err := io.Copy(stream, file); err != nil {
+		stream.Reset()
+	}
+}
+
+// This one formats a byte size for humans
+func FormatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%dKB", size>>10)
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
@@ -0,0 +1,167 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// how long to wait before dialing the bootstrap peers again when none of
+// them could be reached, doubling after each failed round up to the longest wait
+const (
+	bootstrapMinWait = 5 * time.Second
+	bootstrapMaxWait = 5 * time.Minute
+)
+
+// This one returns the bootstrap peers, followed by the peers
+// the DHT routed through on earlier runs
+func bootstrapPeers(bootstrap, cached []peer.AddrInfo) []peer.AddrInfo {
+	peers := make([]peer.AddrInfo, 0, len(bootstrap)+len(cached))
+	peers = append(peers, bootstrap...)
+
+	return append(peers, cached...)
+}
+
+// Method that connects to the bootstrap peers, returning as soon as one of
+// them is connected. If none could be, the host goes on without them, and
+// they are tried again in the background until one is
+func (p2p *P2P) connectBootstrap(peers []peer.AddrInfo) {
+	if len(peers) == 0 {
+		return
+	}
+
+	err := p2p.dialBootstrap(peers)
+	if err == nil {
+		return
+	}
+
+	p2p.bootstrapLock.Lock()
+	p2p.bootstrapRetrying = true
+	p2p.bootstrapLock.Unlock()
+
+	p2p.Logger.WithFields(logrus.Fields{
+		"error": err.Error(),
+	}).Warnln("No bootstrap peer reached, trying again in the background")
+
+	go p2p.retryBootstrap(peers)
+}
+
+// Method that dials the bootstrap peers all at once. Returns as soon as one of
+// them is connected, leaving the rest to connect in the background, or with
+// an error if none was
+func (p2p *P2P) dialBootstrap(peers []peer.AddrInfo) error {
+	results := make(chan error, len(peers))
+	for _, peerInfo := range peers {
+		go func(peerInfo peer.AddrInfo) {
+			results <- p2p.Host.Connect(p2p.Ctx, peerInfo)
+		}(peerInfo)
+	}
+
+	var firstErr error
+	for tried := 1; tried <= len(peers); tried++ {
+		err := <-results
+		if err == nil {
+			go countBootstrap(results, len(peers)-tried, len(peers), p2p.Logger)
+			return nil
+		}
+
+		// we can skip this error for now,
+		// it just signals that our packages are stale, and we need new
+		// bootstrap addresses from IPFS
+		// TODO: update and use new packages
+		if firstErr == nil && err.Error() != noAddressError {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("connecting to the %d bootstrap peers failed: %w", len(peers), firstErr)
+	}
+	return fmt.Errorf("none of the %d bootstrap peers has an address to reach", len(peers))
+}
+
+// This one waits for the remaining dials of the bootstrap peers, after the
+// first one connected, and tells how many of them all connected in the end
+func countBootstrap(results <-chan error, remaining, total int, log *logrus.Entry) {
+	connected := 1
+	for i := 0; i < remaining; i++ {
+		if err := <-results; err == nil {
+			connected++
+		}
+	}
+
+	log.Debugf("Connected to %d out of %d Bootstrap Peers", connected, total)
+}
+
+// Method that dials the bootstrap peers again and again, waiting longer
+// after each failed round, until one of them or anyone else the DHT can
+// route through is connected. The service peers are then looked for again,
+// as looking without the DHT found nobody
+func (p2p *P2P) retryBootstrap(peers []peer.AddrInfo) {
+	wait := bootstrapMinWait
+	for {
+		select {
+		case <-p2p.Ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		// peers met some other way route just as well
+		if p2p.KadDHT.RoutingTable().Size() > 0 {
+			break
+		}
+		err := p2p.dialBootstrap(peers)
+		if err == nil {
+			break
+		}
+
+		if wait *= 2; wait > bootstrapMaxWait {
+			wait = bootstrapMaxWait
+		}
+		p2p.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"wait":  wait.String(),
+		}).Debugln("No bootstrap peer reached again")
+	}
+
+	p2p.bootstrapLock.Lock()
+	p2p.bootstrapRetrying = false
+	rediscover := p2p.rediscover
+	p2p.rediscover = nil
+	p2p.bootstrapLock.Unlock()
+
+	p2p.Logger.Infoln("Reached the bootstrap peers")
+
+	if rediscover == nil {
+		return
+	}
+	if err := rediscover(); err != nil && p2p.Ctx.Err() == nil {
+		p2p.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Looking for peers again failed")
+	}
+}
+
+// Method that returns true while none of the bootstrap peers could be
+// reached yet, and they are being tried again in the background
+func (p2p *P2P) BootstrapRetrying() bool {
+	p2p.bootstrapLock.Lock()
+	defer p2p.bootstrapLock.Unlock()
+
+	return p2p.bootstrapRetrying
+}
+
+// Method that has looking for the service peers run again once the
+// bootstrap peers are reached, if they are still being tried. Returns
+// true if it will, false if the DHT is as bootstrapped as it gets
+func (p2p *P2P) whenBootstrapped(rediscover func() error) bool {
+	p2p.bootstrapLock.Lock()
+	defer p2p.bootstrapLock.Unlock()
+
+	if p2p.bootstrapRetrying {
+		p2p.rediscover = rediscover
+	}
+	return p2p.bootstrapRetrying
+}
@@ -0,0 +1,152 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	host "github.com/libp2p/go-libp2p-host"
+)
+
+// protocol ID of the direct conversation invitation stream
+const dmProtocol = protocol.ID("/p2pchat/dm/1.0.0")
+
+// how long an invitation may take to be delivered
+const DMTimeout = 10 * time.Second
+
+// bytes of the random secret of a direct conversation
+const dmSecretSize = 32
+
+// an invitation to a direct conversation, sent straight to the peer
+type DMInvite struct {
+	// room of the conversation, named after both peers
+	Room string `json:"room"`
+	// random password of the room. It only ever travels over
+	// the direct stream, which is encrypted end to end
+	Secret string `json:"secret"`
+
+	// peer that sent the invitation, set by the receiver
+	From peer.ID `json:"-"`
+}
+
+// the answer to an invitation
+type dmAnswer struct {
+	Error string `json:"error,omitempty"`
+}
+
+// DirectMessages invites peers to direct conversations. A conversation is a
+// room of its own, locked with a random password only the two peers know,
+// so nobody else can read or join it
+type DirectMessages struct {
+	host  host.Host
+	lists *PeerLists
+
+	// invitations from other peers, for the user to see
+	Invites chan *DMInvite
+}
+
+// Constructor function for a new Direct Messages service,
+// which registers the invitation stream handler on the given host
+func NewDirectMessages(nodeHost host.Host, lists *PeerLists) *DirectMessages {
+	dm := &DirectMessages{
+		host:    nodeHost,
+		lists:   lists,
+		Invites: make(chan *DMInvite),
+	}
+
+	nodeHost.SetStreamHandler(dmProtocol, dm.handleStream)
+
+	return dm
+}
+
+// This one returns the room name of the direct conversation between two
+// peers, the same whichever of them asks
+func DMRoomName(a, b peer.ID) string {
+	ids := []string{a.Pretty(), b.Pretty()}
+	sort.Strings(ids)
+
+	sum := sha256.Sum256([]byte(ids[0] + "|" + ids[1]))
+	return "dm-" + hex.EncodeToString(sum[:8])
+}
+
+// Method that invites a peer to a direct conversation with a new secret,
+// and returns the invitation once the peer got it
+func (dm *DirectMessages) Invite(ctx context.Context, to peer.ID) (*DMInvite, error) {
+	secret := make([]byte, dmSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	invite := &DMInvite{
+		Room:   DMRoomName(dm.host.ID(), to),
+		Secret: hex.EncodeToString(secret),
+		From:   dm.host.ID(),
+	}
+
+	stream, err := dm.host.NewStream(ctx, to, dmProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := writeJSONLine(stream, invite); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	answer := dmAnswer{}
+	if err := readJSONLine(bufio.NewReader(stream), &answer); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	if len(answer.Error) > 0 {
+		return nil, fmt.Errorf("%s", answer.Error)
+	}
+
+	return invite, nil
+}
+
+// This one handles the incomming invitations
+func (dm *DirectMessages) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(DMTimeout))
+	from := stream.Conn().RemotePeer()
+
+	invite := &DMInvite{}
+	if err := readJSONLine(bufio.NewReader(stream), invite); err != nil {
+		stream.Reset()
+		return
+	}
+
+	// the room has to be the one of the two of us, so nobody can pull us elsewhere
+	if invite.Room != DMRoomName(dm.host.ID(), from) || len(invite.Secret) == 0 {
+		writeJSONLine(stream, dmAnswer{Error: "bad invitation"})
+		return
+	}
+
+	if dm.lists.IsBlocked(from) || dm.lists.IsMuted(from) {
+		writeJSONLine(stream, dmAnswer{Error: "not taking direct messages"})
+		return
+	}
+
+	invite.From = from
+	select {
+	case dm.Invites <- invite:
+		writeJSONLine(stream, dmAnswer{})
+	case <-time.After(DMTimeout):
+		writeJSONLine(stream, dmAnswer{Error: "busy, try again later"})
+	}
+}
@@ -0,0 +1,9 @@
+package p2p
+
+// a line for the log pane, from the services of the host or the rooms
+type Log struct {
+	Prefix string
+	Msg    string
+	// the first line is a warning that must not be missed
+	Alert bool
+}
@@ -0,0 +1,836 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p"
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	connmgr "github.com/libp2p/go-libp2p-connmgr"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	discovery "github.com/libp2p/go-libp2p-discovery"
+	host "github.com/libp2p/go-libp2p-host"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	tls "github.com/libp2p/go-libp2p-tls"
+	yamux "github.com/libp2p/go-libp2p-yamux"
+	"github.com/mr-tron/base58/base58"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/version"
+)
+
+const serviceName = "awesome/p2pchat"
+const noAddressError = "no good addresses"
+
+// how long dialing a known peer again may take
+const reconnectTimeout = 15 * time.Second
+
+// default sustained rate of messages a single peer may send per second
+const DefaultRateLimit = 5.0
+
+// Options is what a new host is made with. Empty fields are left to defaults
+type Options struct {
+	// file the host identity is kept in, created if it's missing.
+	// Without one the host gets a new identity on every run
+	Identity string
+	// block and mute lists, gating the connections of blocked peers if they
+	// are told to. Empty lists kept nowhere without any
+	PeerLists *PeerLists
+	// peers the DHT is bootstrapped from, the libp2p ones without any.
+	// Each address ends with the peer ID, like /ip4/1.2.3.4/tcp/4001/p2p/QmPeer
+	Bootstrap []multiaddr.Multiaddr
+	// where the events of GossipSub are traced, if anywhere
+	Trace PubSubTrace
+	// addresses the host listens on, every interface on any port without any,
+	// or none in a browser
+	Listen []multiaddr.Multiaddr
+	// whether the host relays connections for peers that can't be reached
+	// directly, and lets them know through the DHT that it does
+	RelayHop bool
+	// file the peers we met are remembered in, so the next start dials them
+	// right away instead of waiting on the bootstrap peers. Nobody is
+	// remembered without one
+	PeerCache string
+	// directory the creation keys of the rooms we create are kept in,
+	// the rooms directory of the user data directory without one
+	RoomKeys string
+	// where the host logs, the standard logger without one. Hosts sharing
+	// a process tell theirs apart with a logger each, like with a field
+	Logger *logrus.Entry
+	// told about each state the host reaches starting up, in order, from
+	// whichever goroutine reached it, which waits for it. Nobody is told
+	// without one
+	Progress func(StartupState)
+}
+
+// Method that returns the logger of the options, or the standard one
+func (opts Options) logger() *logrus.Entry {
+	if opts.Logger == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+
+	return opts.Logger
+}
+
+type P2P struct {
+	// host context layer, done once the host is closed
+	Ctx context.Context
+	// host context cancellation function
+	cancel context.CancelFunc
+
+	// libp2p host
+	Host host.Host
+
+	// Kademlia DHT routing table, nil on hosts made elsewhere
+	KadDHT *dht.IpfsDHT
+
+	// peer discovery service, nil without the DHT
+	Discovery *discovery.RoutingDiscovery
+
+	// PubSub handler
+	PubSub *pubsub.PubSub
+
+	// direct file transfer service
+	Files *FileTransfer
+
+	// image attachment service
+	Attachments *AttachmentStore
+
+	// profile handshake service
+	Profiles *ProfileService
+
+	// local block and mute lists
+	PeerLists *PeerLists
+
+	// creation keys of the rooms we created
+	RoomKeys *RoomKeyring
+
+	// room password challenge-response service
+	Auth *RoomAuth
+
+	// direct conversation invitation service
+	DMs *DirectMessages
+
+	// compress large messages for peers that support it
+	Compression bool
+	// messages per second a single peer may send to a room, zero for no limit
+	RateLimit float64
+	// leading zero bits of the proof-of-work stamps rooms demand, zero for none
+	ProofOfWork int
+	// peers found through discovery the host waits for before it is ready
+	MinPeers int
+
+	// where the host and whatever runs on it log
+	Logger *logrus.Entry
+
+	// tracers of the GossipSub events, nil without any
+	tracers multiTracer
+
+	// makes closing the host happen once
+	closeOnce sync.Once
+	closeErr  error
+
+	// file the peers we met are remembered in, empty for none
+	peerCache string
+
+	// how far the host got starting up
+	startup *startup
+
+	// closed once the host is ready, see Ready
+	ready     chan struct{}
+	readyOnce sync.Once
+	// lock for the peers found through discovery and connected
+	readyLock  sync.Mutex
+	discovered map[peer.ID]bool
+
+	// lock for retrying the bootstrap peers
+	bootstrapLock sync.Mutex
+	// set while none of the bootstrap peers could be reached yet
+	bootstrapRetrying bool
+	// looks for the service peers again once they are reached
+	rediscover func() error
+
+	// how reachable we are from outside, as AutoNAT finds out
+	reachability network.Reachability
+	// lock for the reachability
+	reachabilityLock sync.Mutex
+}
+
+// Constructor for a new P2P object.
+
+// Constructed libp2p host is secured with TLS encrypted transportation
+// over a TCP transport connection using a Yamux Stream Multiplexer and
+// usese a UPnP for the NAT traversal.
+
+// On this host we bootstrap a Kademlia DHT using default peers offered by libp2p.
+// Peer Discovery service is created from such DHT.
+// The PubSub handler is created last on the host, using previously created Discover service.
+
+// The host lives until it is closed, or the given context is done, which stops
+// its services but still leaves the host to be closed. Failing to start
+// returns an error, with whatever was started already shut down again.
+// Not reaching any bootstrap peer isn't failing, peers nearby can still
+// be chatted with, and the bootstrap peers are tried again in the background.
+func NewP2P(ctx context.Context, opts Options) (*P2P, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	log := opts.logger()
+
+	startup := newStartup(opts)
+	startup.advance(StartupIdentity)
+
+	bootstrapAddrs := opts.Bootstrap
+	if len(bootstrapAddrs) == 0 {
+		bootstrapAddrs = dht.DefaultBootstrapPeers
+	}
+	// bootstrap peers are dialed by their ID, addresses without one are no use
+	bootstrap, err := peer.AddrInfosFromP2pAddrs(bootstrapAddrs...)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("bad bootstrap peer address: %w", err)
+	}
+	lists := opts.PeerLists
+	if lists == nil {
+		lists, _ = LoadPeerLists("")
+	}
+
+	// setup a P2P node
+	node, kadDHT, err := setupNode(ctx, opts, lists, bootstrap)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	log.Debugln("Created the P2P Node and Kademlia DHT")
+
+	// what is started from here on goes down along with the node
+	fail := func(err error) (*P2P, error) {
+		cancel()
+		kadDHT.Close()
+		node.Close()
+		return nil, err
+	}
+
+	// peers met on earlier runs are dialed along with the bootstrap peers,
+	// the ones running p2pchat once the host is there to count them
+	cached, err := loadPeerCache(opts.PeerCache)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Loading the peers met before failed")
+	}
+	var routingPeers, chatPeers []peer.AddrInfo
+	for _, cp := range cached {
+		info, ok := cp.addrInfo()
+		switch {
+		case !ok:
+		case cp.Chat:
+			chatPeers = append(chatPeers, info)
+		default:
+			routingPeers = append(routingPeers, info)
+		}
+	}
+
+	// create a peer discovery service
+	routingDiscovery := discovery.NewRoutingDiscovery(kadDHT)
+
+	log.Debugln("Peer Discovery service created")
+
+	p2p, err := newP2P(ctx, cancel, node, routingDiscovery, lists, startup, opts)
+	if err != nil {
+		return fail(err)
+	}
+	p2p.KadDHT = kadDHT
+	p2p.Discovery = routingDiscovery
+	p2p.peerCache = opts.PeerCache
+
+	p2p.startup.advance(StartupDialing)
+	go p2p.dialChatPeers(chatPeers)
+	// not reaching them leaves the DHT empty for now, not the host down
+	p2p.connectBootstrap(bootstrapPeers(bootstrap, routingPeers))
+
+	// bootstrap the Kad-DHT, with whoever could be reached to start from
+	p2p.startup.advance(StartupBootstrapping)
+	if err := bootstrapDHT(ctx, kadDHT, log); err != nil {
+		return fail(err)
+	}
+
+	log.Debugln("Bootstraped the Kademlia DHT")
+
+	return p2p, nil
+}
+
+// Constructor for a P2P object on a libp2p host made elsewhere, like the
+// in-memory hosts of a mocknet. There is no DHT on it, and so no discovery,
+// connecting the host to its peers is left to the caller. The identity
+// and bootstrap peers of the options aren't used, the host has them already.
+// Closing the P2P object closes the host
+func NewP2PFromHost(ctx context.Context, node host.Host, opts Options) (*P2P, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	lists := opts.PeerLists
+	if lists == nil {
+		lists, _ = LoadPeerLists("")
+	}
+
+	p2p, err := newP2P(ctx, cancel, node, nil, lists, newStartup(opts), opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// there is no discovery to wait for
+	p2p.setReady()
+
+	return p2p, nil
+}
+
+// This one creates the PubSub handler and the services on the node,
+// with discovery for PubSub if there is any, keeping track of the
+// startup of the host with the given tracker
+func newP2P(ctx context.Context, cancel context.CancelFunc, node host.Host, routingDiscovery *discovery.RoutingDiscovery, lists *PeerLists, startup *startup, opts Options) (*P2P, error) {
+	log := opts.logger()
+
+	// create PubSub handler
+	pubsub, tracers, err := setupPubSub(ctx, node, routingDiscovery, opts.Trace)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugln("PubSub handler created")
+
+	// create file transfer service
+	files := NewFileTransfer(ctx, node)
+
+	log.Debugln("File Transfer service created")
+
+	// create attachment service
+	attachments := NewAttachmentStore(node)
+
+	log.Debugln("Attachment service created")
+
+	// create profile service
+	profiles := NewProfileService(ctx, node)
+
+	log.Debugln("Profile service created")
+
+	// create room password service
+	auth := NewRoomAuth(node)
+
+	log.Debugln("Room Auth service created")
+
+	// create direct conversation service
+	dms := NewDirectMessages(node, lists)
+
+	log.Debugln("Direct Messages service created")
+
+	roomKeys := opts.RoomKeys
+	if len(roomKeys) == 0 {
+		roomKeys = DefaultState().RoomKeys()
+	}
+
+	p2p := &P2P{
+		Ctx:    ctx,
+		cancel: cancel,
+		Host:   node,
+		PubSub: pubsub,
+		Files:  files,
+
+		Attachments: attachments,
+		Profiles:    profiles,
+		PeerLists:   lists,
+		RoomKeys:    &RoomKeyring{Dir: roomKeys},
+		Auth:        auth,
+		DMs:         dms,
+		Compression: true,
+		RateLimit:   DefaultRateLimit,
+		MinPeers:    DefaultMinPeers,
+		Logger:      log,
+		tracers:     tracers,
+		startup:     startup,
+		ready:       make(chan struct{}),
+		discovered:  make(map[peer.ID]bool),
+	}
+
+	go p2p.watchReachability()
+
+	return p2p, nil
+}
+
+// Method that keeps track of how reachable we are from outside,
+// as AutoNAT tells every time it changes its mind
+func (p2p *P2P) watchReachability() {
+	sub, err := p2p.Host.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		p2p.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warnln("Watching reachability failed")
+		return
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case ev, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+
+			p2p.reachabilityLock.Lock()
+			p2p.reachability = ev.(event.EvtLocalReachabilityChanged).Reachability
+			p2p.reachabilityLock.Unlock()
+
+		case <-p2p.Ctx.Done():
+			return
+		}
+	}
+}
+
+// Method that shuts the host down, stopping PubSub and discovery, then the
+// DHT, before closing the connections to every peer. Closing it again
+// does nothing, and returns what the first time did
+func (p2p *P2P) Close() error {
+	p2p.closeOnce.Do(func() {
+		// while the connections are still up, to tell who we met
+		if err := p2p.savePeerCache(); err != nil {
+			p2p.Logger.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warnln("Remembering the peers met failed")
+		}
+
+		p2p.cancel()
+		// what the tracers still hold is written out
+		p2p.tracers.Close()
+
+		var dhtErr error
+		if p2p.KadDHT != nil {
+			dhtErr = p2p.KadDHT.Close()
+		}
+		if err := p2p.Host.Close(); err != nil {
+			p2p.closeErr = err
+		}
+		if dhtErr != nil {
+			p2p.closeErr = dhtErr
+		}
+	})
+
+	return p2p.closeErr
+}
+
+// Method that returns how reachable we are from outside, unknown until AutoNAT finds out
+func (p2p *P2P) Reachability() network.Reachability {
+	p2p.reachabilityLock.Lock()
+	defer p2p.reachabilityLock.Unlock()
+
+	return p2p.reachability
+}
+
+// Method of P2P that connects to service peers using
+// the Advertise functionality of Peer Discovery Service
+// to advertise the service and the discover all peers advertising the same.
+// The peer discovery is handled by a go routine that will read peer addresses
+// from a channel, and advertising goes on in the background meanwhile
+func (p2p *P2P) AdvertiseConnect() error {
+	if p2p.Discovery == nil {
+		return fmt.Errorf("the host has no DHT to discover peers with")
+	}
+
+	p2p.startup.advance(StartupAnnouncing)
+
+	// the advertisement goes to the peers the DHT routes through, made
+	// again once the bootstrap peers are reached if they weren't yet
+	if !p2p.whenBootstrapped(p2p.AdvertiseConnect) {
+		if err := p2p.waitRouting(); err != nil {
+			return err
+		}
+	}
+
+	// find all that advertise the same, while we advertise too
+	peerchan, err := p2p.Discovery.FindPeers(p2p.Ctx, serviceName)
+	if err != nil {
+		return fmt.Errorf("discovering peers failed: %w", err)
+	}
+
+	p2p.Logger.Traceln("PeerChat Service peers discovered")
+
+	// conect peers as they are being discovered
+	go p2p.connectDiscovered(p2p.Ctx, peerchan)
+
+	// advertise the availability of the service on this node, which takes
+	// until the closest peers have it, so others can find us meanwhile
+	go func() {
+		ttl, err := p2p.Discovery.Advertise(p2p.Ctx, serviceName)
+		if err != nil {
+			p2p.announceFailed(fmt.Errorf("advertising the service failed: %w", err))
+			return
+		}
+
+		p2p.Logger.Debugln("PeerChat service advertised")
+		p2p.Logger.Debugf("Service Time-to-Live is %s", ttl)
+	}()
+
+	p2p.Logger.Traceln("Peer Connection Hander started")
+	return nil
+}
+
+// Method of P2P that connects to service peers using
+// the Provide functionallity of the Kademlia FHT directly to
+// announce the ability to provide the service and then discovers
+// all peers that provide the same.
+// The peer discovery is handled by a go routine that will read peer
+// addresses from a channel, and announcing goes on in the background meanwhile
+func (p2p *P2P) AnnounceConnect() error {
+	if p2p.KadDHT == nil {
+		return fmt.Errorf("the host has no DHT to discover peers with")
+	}
+
+	// generate Service CID
+	cid, err := generateCID(serviceName)
+	if err != nil {
+		return err
+	}
+
+	p2p.Logger.Traceln("Service CID generated")
+
+	p2p.startup.advance(StartupAnnouncing)
+
+	// the announcement goes to the peers the DHT routes through, made
+	// again once the bootstrap peers are reached if they weren't yet
+	if !p2p.whenBootstrapped(p2p.AnnounceConnect) {
+		if err := p2p.waitRouting(); err != nil {
+			return err
+		}
+	}
+
+	// find other providers for the service CID, while we announce too
+	peerChan := p2p.KadDHT.FindProvidersAsync(p2p.Ctx, cid, 0)
+
+	p2p.Logger.Traceln("PeerChat Service peers discovered")
+
+	go p2p.connectDiscovered(p2p.Ctx, peerChan)
+
+	// announce that this host can provide the service CID, which takes
+	// until the closest peers know, so others can find us meanwhile
+	go func() {
+		if err := p2p.KadDHT.Provide(p2p.Ctx, cid, true); err != nil {
+			p2p.announceFailed(fmt.Errorf("announcing the service failed: %w", err))
+			return
+		}
+
+		p2p.Logger.Debugln("PeerChat Service announced")
+	}()
+
+	p2p.Logger.Debugln("Peer Connection Handler started")
+	return nil
+}
+
+// Method that logs announcing, or advertising, the service failing, unless
+// the host went down meanwhile. Peers already found still get connected
+func (p2p *P2P) announceFailed(err error) {
+	if p2p.Ctx.Err() != nil {
+		return
+	}
+
+	p2p.Logger.WithFields(logrus.Fields{
+		"error": err.Error(),
+	}).Warnln("Others may not find us")
+}
+
+// Method of P2P that tries to get back in touch with the peers after losing
+// all of them: it dials the peers it knew again and looks for others
+// providing the service, in case their addresses changed
+func (p2p *P2P) Reconnect() error {
+	for _, id := range p2p.Host.Peerstore().PeersWithAddrs() {
+		if id == p2p.Host.ID() || p2p.Host.Network().Connectedness(id) == network.Connected {
+			continue
+		}
+
+		go func(info peer.AddrInfo) {
+			ctx, cancel := context.WithTimeout(p2p.Ctx, reconnectTimeout)
+			defer cancel()
+
+			p2p.Host.Connect(ctx, info)
+		}(p2p.Host.Peerstore().PeerInfo(id))
+	}
+
+	// hosts without the DHT only know the peers they were given
+	if p2p.Discovery == nil {
+		return nil
+	}
+
+	peerchan, err := p2p.Discovery.FindPeers(p2p.Ctx, serviceName)
+	if err != nil {
+		return fmt.Errorf("discovering peers failed: %w", err)
+	}
+	go p2p.connectDiscovered(p2p.Ctx, peerchan)
+
+	return nil
+}
+
+// This one generates a CID object from a given string.
+// SHA256 is used to hash the string and generate a Multihash.
+// The Multihash is then base58 encoded and used to create the CID
+func generateCID(name string) (cid.Cid, error) {
+	// hash the service content ID
+	hash := sha256.Sum256([]byte(name))
+	// append the hash with the hashing codec ID for SHA2-256 (0x12),
+	// the digest size (0x20) and the hash of the service content ID
+	finalHash := append([]byte{0x12, 0x20}, hash[:]...)
+	// encode the full hash to Base58
+	b58 := base58.Encode(finalHash)
+
+	// generate Multihash from the base58 string
+	multiHash, err := multihash.FromB58String(string(b58))
+	if err != nil {
+		return cid.Cid{}, fmt.Errorf("generating the service CID failed: %w", err)
+	}
+
+	// generate a CID from the Multihash
+	cidValue := cid.NewCidV1(12, multiHash)
+	return cidValue, nil
+}
+
+// This one loads the host private key from the given file, generating
+// and saving a new one if the file doesn't exist yet.
+// An empty path just generates a throwaway key
+func loadIdentity(path string) (crypto.PrivKey, error) {
+	if len(path) > 0 {
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			return crypto.UnmarshalPrivateKey(data)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	pvtkey, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+	if err != nil || len(path) == 0 {
+		return pvtkey, err
+	}
+
+	data, err := crypto.MarshalPrivateKey(pvtkey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	return pvtkey, ioutil.WriteFile(path, data, 0600)
+}
+
+// This one generates a new host identity and keeps it in the given file,
+// refusing to replace one that is there already. Returns its peer ID
+func GenerateIdentity(path string) (peer.ID, error) {
+	if _, err := os.Stat(path); err == nil {
+		return "", fmt.Errorf("%s exists already, remove it first to replace it", path)
+	}
+
+	pvtkey, err := loadIdentity(path)
+	if err != nil {
+		return "", err
+	}
+
+	return peer.IDFromPrivateKey(pvtkey)
+}
+
+// This one is used to generate p2p configuration options and
+// to create libp2p node object for the given context
+func setupNode(ctx context.Context, opts Options, lists *PeerLists, bootstrap []peer.AddrInfo) (host.Host, *dht.IpfsDHT, error) {
+	// host identity options
+	log := opts.logger()
+
+	pvtkey, err := loadIdentity(opts.Identity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading the host identity failed: %w", err)
+	}
+	identity := libp2p.Identity(pvtkey)
+
+	log.Traceln("P2P Indentity configuration generated")
+
+	// TLS secured TCP transport
+	tlsTransport, err := tls.New(pvtkey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("setting up the TLS transport failed: %w", err)
+	}
+	security := libp2p.Security(tls.ID, tlsTransport)
+	transport := transportOptions()
+
+	log.Traceln("P2P Security and Transport configuration generated")
+
+	// host listener addresses
+	listenAddrs := opts.Listen
+	if len(listenAddrs) == 0 {
+		for _, addr := range defaultListenAddrs() {
+			mulAddr, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("setting up the listener address failed: %w", err)
+			}
+			listenAddrs = append(listenAddrs, mulAddr)
+		}
+	}
+	listener := libp2p.ListenAddrs(listenAddrs...)
+	if len(listenAddrs) == 0 {
+		listener = libp2p.NoListenAddrs
+	}
+
+	log.Traceln("P2P Address Listener configuration generated")
+
+	// stream multiplexer and connection manager
+	muxer := libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport)
+	conn := libp2p.ConnectionManager(connmgr.NewConnManager(100, 400, time.Minute))
+
+	// NAT traversal and relay options
+	nat := natOptions()
+	relay := libp2p.EnableAutoRelay()
+	if opts.RelayHop {
+		relay = libp2p.ChainOptions(libp2p.EnableRelay(circuit.OptHop), relay)
+	}
+	// nothing reaches a host listening nowhere, through relays either
+	if len(listenAddrs) == 0 {
+		relay = libp2p.ChainOptions()
+	}
+
+	// keep blocked peers out
+	gater := libp2p.ConnectionGater(lists)
+
+	// peers learn which version we run when they identify us
+	agent := libp2p.UserAgent(version.UserAgent())
+
+	log.Traceln("P2P Stream Multiplexer and Connection Manager configurations generated")
+
+	var kadDHT *dht.IpfsDHT
+	// routing configuration with KadDHT
+	routing := libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+		var err error
+		kadDHT, err = setupKadDHT(ctx, h, bootstrap, log)
+		return kadDHT, err
+	})
+
+	log.Traceln("P2P Routing configuration generated")
+
+	nodeOpts := libp2p.ChainOptions(identity, listener, security, transport, muxer, conn, nat, routing, relay, gater, agent)
+
+	// create a new libp2p node with created options
+	node, err := libp2p.New(ctx, nodeOpts)
+	if err != nil {
+		if kadDHT != nil {
+			kadDHT.Close()
+		}
+		return nil, nil, fmt.Errorf("creating the P2P node failed: %w", err)
+	}
+
+	return node, kadDHT, nil
+}
+
+// This one generates a Kademlia DHT object
+func setupKadDHT(ctx context.Context, nodeHost host.Host, bootstrap []peer.AddrInfo, log *logrus.Entry) (*dht.IpfsDHT, error) {
+	// DHT mode option, server unless in a browser
+	mode := dht.Mode(dhtMode)
+	// DHT bootstrap peers option
+	dhtPeers := dht.BootstrapPeers(bootstrap...)
+
+	log.Trace("DHT Configuration generated")
+
+	// start a Kademlia DHT on the node in server mode
+	kadDHT, err := dht.New(ctx, nodeHost, mode, dhtPeers)
+	if err != nil {
+		return nil, fmt.Errorf("creating the Kademlia DHT failed: %w", err)
+	}
+
+	return kadDHT, nil
+}
+
+// This bootstraps a given Kademlia DHT to satisfy the IPFS router interface
+func bootstrapDHT(ctx context.Context, kadDHT *dht.IpfsDHT, log *logrus.Entry) error {
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		return fmt.Errorf("bootstrapping the Kademlia DHT failed: %w", err)
+	}
+
+	log.Trace("Kademlia DHT is in Bootstrap Mode")
+	return nil
+}
+
+// This one parses a comma separated list of bootstrap peer addresses,
+// each with the peer ID, like /ip4/1.2.3.4/tcp/4001/p2p/QmPeer
+func ParseBootstrapPeers(list string) ([]multiaddr.Multiaddr, error) {
+	var addrs []multiaddr.Multiaddr
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		addr, err := multiaddr.NewMultiaddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("bad bootstrap peer %s: %w", field, err)
+		}
+		if _, err := peer.AddrInfoFromP2pAddr(addr); err != nil {
+			return nil, fmt.Errorf("bootstrap peer %s has no peer ID: %w", field, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// This one parses a comma separated list of addresses to listen on,
+// like /ip4/0.0.0.0/tcp/4001
+func ParseListenAddrs(list string) ([]multiaddr.Multiaddr, error) {
+	var addrs []multiaddr.Multiaddr
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if len(field) == 0 {
+			continue
+		}
+
+		addr, err := multiaddr.NewMultiaddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("bad listen address %s: %w", field, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, nil
+}
+
+// This one generates a PubSub handler object, along
+// with the tracers of its events if the trace asks for any
+func setupPubSub(ctx context.Context, nodeHost host.Host, routingDiscovery *discovery.RoutingDiscovery, trace PubSubTrace) (*pubsub.PubSub, multiTracer, error) {
+	var options []pubsub.Option
+	if routingDiscovery != nil {
+		options = append(options, pubsub.WithDiscovery(routingDiscovery))
+	}
+
+	tracers, err := startTracers(ctx, nodeHost, trace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating the PubSub tracers failed: %w", err)
+	}
+	if len(tracers) > 0 {
+		options = append(options, pubsub.WithEventTracer(tracers))
+	}
+
+	// new PubSub service which uses a GossipSub router
+	pubSubHandler, err := pubsub.NewGossipSub(ctx, nodeHost, options...)
+	if err != nil {
+		tracers.Close()
+		return nil, nil, fmt.Errorf("creating the GossipSub handler failed: %w", err)
+	}
+
+	return pubSubHandler, tracers, nil
+}
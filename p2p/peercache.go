@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// most peers remembered for the next start, and most of them
+// only routing for the DHT rather than running p2pchat
+const (
+	maxCachedPeers   = 200
+	maxCachedRouting = 100
+)
+
+// how long a remembered peer is worth dialing
+const peerCacheTTL = 7 * 24 * time.Hour
+
+// a peer remembered for the next start, with the addresses it had
+type cachedPeer struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+	// the peer runs p2pchat, rather than only routing for the DHT
+	Chat bool `json:"chat,omitempty"`
+	// when the peer was last connected, in unix seconds
+	Seen int64 `json:"seen"`
+}
+
+// This one loads the peers remembered in the file, leaving out the ones
+// not seen for too long. A missing file or an empty path remembers nobody
+func loadPeerCache(path string) ([]cachedPeer, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cached []cachedPeer
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+
+	fresh := cached[:0]
+	for _, cp := range cached {
+		if time.Since(time.Unix(cp.Seen, 0)) < peerCacheTTL {
+			fresh = append(fresh, cp)
+		}
+	}
+
+	return fresh, nil
+}
+
+// Method that returns the address information of the remembered peer,
+// leaving out addresses that don't parse
+func (cp cachedPeer) addrInfo() (peer.AddrInfo, bool) {
+	id, err := peer.Decode(cp.ID)
+	if err != nil {
+		return peer.AddrInfo{}, false
+	}
+
+	info := peer.AddrInfo{ID: id}
+	for _, field := range cp.Addrs {
+		if addr, err := multiaddr.NewMultiaddr(field); err == nil {
+			info.Addrs = append(info.Addrs, addr)
+		}
+	}
+
+	return info, len(info.Addrs) > 0
+}
+
+// Method that remembers the p2pchat peers we are connected to, and the peers
+// the DHT routes through, in the peer cache file for the next start. Peers
+// remembered before and not seen since are kept until they are too old, so
+// a run that never got online doesn't forget everyone
+func (p2p *P2P) savePeerCache() error {
+	if len(p2p.peerCache) == 0 {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	seen := make(map[peer.ID]bool)
+	var cached []cachedPeer
+	remember := func(id peer.ID, chat bool) {
+		if seen[id] || id == p2p.Host.ID() {
+			return
+		}
+
+		cp := cachedPeer{ID: id.Pretty(), Chat: chat, Seen: now}
+		for _, addr := range p2p.Host.Peerstore().Addrs(id) {
+			cp.Addrs = append(cp.Addrs, addr.String())
+		}
+		if len(cp.Addrs) > 0 {
+			seen[id] = true
+			cached = append(cached, cp)
+		}
+	}
+
+	for _, id := range p2p.Host.Network().Peers() {
+		agent, err := p2p.Host.Peerstore().Get(id, "AgentVersion")
+		if name, ok := agent.(string); err == nil && ok && strings.HasPrefix(name, "p2pchat/") {
+			remember(id, true)
+		}
+	}
+	if p2p.KadDHT != nil {
+		for i, id := range p2p.KadDHT.RoutingTable().ListPeers() {
+			if i == maxCachedRouting {
+				break
+			}
+			remember(id, false)
+		}
+	}
+
+	// what was remembered before still counts, until it's too old
+	previous, _ := loadPeerCache(p2p.peerCache)
+	for _, cp := range previous {
+		if id, err := peer.Decode(cp.ID); err == nil && !seen[id] {
+			seen[id] = true
+			cached = append(cached, cp)
+		}
+	}
+
+	if len(cached) > maxCachedPeers {
+		cached = cached[:maxCachedPeers]
+	}
+
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p2p.peerCache), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p2p.peerCache, data, 0600)
+}
@@ -0,0 +1,254 @@
+package p2p
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PeerLists are the local block, mute, verified and watch lists, persisted as
+// JSON. Blocked peers have their messages dropped, muted ones only hidden, and
+// watched ones are announced when they come online
+type PeerLists struct {
+	lock sync.RWMutex
+	path string
+
+	// peers by their base58 encoded IDs
+	Blocked  map[string]bool `json:"blocked"`
+	Muted    map[string]bool `json:"muted"`
+	Verified map[string]bool `json:"verified"`
+	Watched  map[string]bool `json:"watched"`
+
+	// refuse connections to and from blocked peers
+	Gate bool `json:"-"`
+}
+
+// This one loads the peer lists from the given file,
+// starting with empty lists if the file doesn't exist yet.
+// An empty path keeps the lists in memory only, like in a browser
+func LoadPeerLists(path string) (*PeerLists, error) {
+	pl := &PeerLists{
+		path:     path,
+		Blocked:  make(map[string]bool),
+		Muted:    make(map[string]bool),
+		Verified: make(map[string]bool),
+		Watched:  make(map[string]bool),
+	}
+
+	if len(path) == 0 {
+		return pl, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, pl); err != nil {
+		return nil, err
+	}
+
+	// lists missing from the file unmarshal into nil maps
+	if pl.Blocked == nil {
+		pl.Blocked = make(map[string]bool)
+	}
+	if pl.Muted == nil {
+		pl.Muted = make(map[string]bool)
+	}
+	if pl.Verified == nil {
+		pl.Verified = make(map[string]bool)
+	}
+	if pl.Watched == nil {
+		pl.Watched = make(map[string]bool)
+	}
+
+	return pl, nil
+}
+
+// Method that writes the lists to disk, expects the lock to be held
+func (pl *PeerLists) save() error {
+	if len(pl.path) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(pl, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pl.path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(pl.path, data, 0600)
+}
+
+// Method that adds or removes a peer from one of the lists and saves them
+func (pl *PeerLists) set(list map[string]bool, id peer.ID, on bool) error {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	if on {
+		list[id.Pretty()] = true
+	} else {
+		delete(list, id.Pretty())
+	}
+
+	return pl.save()
+}
+
+// Method that blocks a peer
+func (pl *PeerLists) Block(id peer.ID) error {
+	return pl.set(pl.Blocked, id, true)
+}
+
+// Method that unblocks a peer
+func (pl *PeerLists) Unblock(id peer.ID) error {
+	return pl.set(pl.Blocked, id, false)
+}
+
+// Method that mutes a peer
+func (pl *PeerLists) Mute(id peer.ID) error {
+	return pl.set(pl.Muted, id, true)
+}
+
+// Method that unmutes a peer
+func (pl *PeerLists) Unmute(id peer.ID) error {
+	return pl.set(pl.Muted, id, false)
+}
+
+// Method that marks a peer as verified, after comparing safety numbers
+func (pl *PeerLists) Verify(id peer.ID) error {
+	return pl.set(pl.Verified, id, true)
+}
+
+// Method that takes back the verification of a peer
+func (pl *PeerLists) Unverify(id peer.ID) error {
+	return pl.set(pl.Verified, id, false)
+}
+
+// Method that watches a peer
+func (pl *PeerLists) Watch(id peer.ID) error {
+	return pl.set(pl.Watched, id, true)
+}
+
+// Method that stops watching a peer
+func (pl *PeerLists) Unwatch(id peer.ID) error {
+	return pl.set(pl.Watched, id, false)
+}
+
+// Method that returns the watched peers
+func (pl *PeerLists) WatchedPeers() []peer.ID {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	ids := make([]peer.ID, 0, len(pl.Watched))
+	for listed := range pl.Watched {
+		if p, err := peer.Decode(listed); err == nil {
+			ids = append(ids, p)
+		}
+	}
+
+	return ids
+}
+
+// Method that checks if a peer was verified
+func (pl *PeerLists) IsVerified(id peer.ID) bool {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	return pl.Verified[id.Pretty()]
+}
+
+// Method that checks if a peer is blocked
+func (pl *PeerLists) IsBlocked(id peer.ID) bool {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	return pl.Blocked[id.Pretty()]
+}
+
+// Method that checks if a peer is muted
+func (pl *PeerLists) IsMuted(id peer.ID) bool {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	return pl.Muted[id.Pretty()]
+}
+
+// Method that finds a listed peer by its full ID or its suffix
+func (pl *PeerLists) Find(id string) (peer.ID, bool) {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	for _, list := range []map[string]bool{pl.Blocked, pl.Muted} {
+		for listed := range list {
+			if len(id) == 0 || !strings.HasSuffix(listed, id) {
+				continue
+			}
+			if p, err := peer.Decode(listed); err == nil {
+				return p, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Method that returns the short IDs of all blocked and muted peers
+func (pl *PeerLists) Summary() ([]string, []string) {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	short := func(list map[string]bool) []string {
+		ids := make([]string, 0, len(list))
+		for listed := range list {
+			if len(listed) > 8 {
+				listed = listed[len(listed)-8:]
+			}
+			ids = append(ids, listed)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	return short(pl.Blocked), short(pl.Muted)
+}
+
+// Method that refuses dialing blocked peers. The lists double as
+// a connection gater, which keeps blocked peers out when gating is enabled
+func (pl *PeerLists) InterceptPeerDial(id peer.ID) bool {
+	return !pl.Gate || !pl.IsBlocked(id)
+}
+
+// Method that refuses dialing addresses of blocked peers
+func (pl *PeerLists) InterceptAddrDial(id peer.ID, _ multiaddr.Multiaddr) bool {
+	return pl.InterceptPeerDial(id)
+}
+
+// Method that accepts every inbound connection, the peer isn't known yet
+func (pl *PeerLists) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+// Method that refuses secured connections from blocked peers
+func (pl *PeerLists) InterceptSecured(_ network.Direction, id peer.ID, _ network.ConnMultiaddrs) bool {
+	return pl.InterceptPeerDial(id)
+}
+
+// Method that accepts every upgraded connection, blocked ones never get here
+func (pl *PeerLists) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
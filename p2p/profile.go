@@ -0,0 +1,189 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	host "github.com/libp2p/go-libp2p-host"
+)
+
+// name of peers who didn't pick one
+const DefaultUsername = "anon"
+
+// protocol ID of the profile handshake stream
+const profileProtocol = protocol.ID("/p2pchat/profile/1.0.0")
+
+// how long a fetched profile is considered fresh
+const profileTTL = time.Minute
+
+// how long a single profile handshake may take
+const profileTimeout = 10 * time.Second
+
+// optional features announced in the profile handshake
+const (
+	FeatureGzip = "gzip"
+)
+
+// features supported by this client
+var supportedFeatures = []string{FeatureGzip}
+
+// what peers tell each other about themselves
+type Profile struct {
+	Username string   `json:"username"`
+	Features []string `json:"features"`
+}
+
+// Method that checks if the profile announces a feature
+func (p *Profile) Supports(feature string) bool {
+	for _, f := range p.Features {
+		if f == feature {
+			return true
+		}
+	}
+
+	return false
+}
+
+// a profile cache entry, profile is nil for peers
+// that do not speak the profile protocol
+type cachedProfile struct {
+	profile *Profile
+	fetched time.Time
+	pending bool
+}
+
+// ProfileService exchanges profiles with other peers, and caches theirs
+type ProfileService struct {
+	host host.Host
+	// context of the host, refreshing profiles stops once it's done
+	ctx context.Context
+
+	// lock for the username and the cache
+	lock     sync.Mutex
+	username string
+	cache    map[peer.ID]*cachedProfile
+}
+
+// Constructor function for a new Profile Service,
+// which registers the profile stream handler on the given host
+func NewProfileService(ctx context.Context, nodeHost host.Host) *ProfileService {
+	ps := &ProfileService{
+		host:     nodeHost,
+		ctx:      ctx,
+		username: DefaultUsername,
+		cache:    make(map[peer.ID]*cachedProfile),
+	}
+
+	nodeHost.SetStreamHandler(profileProtocol, ps.handleStream)
+
+	return ps
+}
+
+// Method for updating the username we announce
+func (ps *ProfileService) SetUsername(username string) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	ps.username = username
+}
+
+// Method that returns our own profile
+func (ps *ProfileService) Self() *Profile {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	return &Profile{Username: ps.username, Features: supportedFeatures}
+}
+
+// Method that returns the cached profile of a peer, or nil if we don't
+// have one yet. Missing and stale profiles are refreshed in the background
+func (ps *ProfileService) Lookup(id peer.ID) *Profile {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	entry, ok := ps.cache[id]
+	if !ok {
+		entry = &cachedProfile{}
+		ps.cache[id] = entry
+	}
+
+	if !entry.pending && time.Since(entry.fetched) > profileTTL {
+		entry.pending = true
+		go func() {
+			ctx, cancel := context.WithTimeout(ps.ctx, profileTimeout)
+			defer cancel()
+			ps.Fetch(ctx, id)
+		}()
+	}
+
+	return entry.profile
+}
+
+// Method that performs the profile handshake with a peer,
+// sending our profile and caching theirs
+func (ps *ProfileService) Fetch(ctx context.Context, id peer.ID) (*Profile, error) {
+	theirs, err := ps.handshake(ctx, id)
+
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+
+	// failures are cached too, so peers without the protocol aren't hammered
+	ps.cache[id] = &cachedProfile{profile: theirs, fetched: time.Now()}
+
+	return theirs, err
+}
+
+// This one does the actual profile exchange over a new stream
+func (ps *ProfileService) handshake(ctx context.Context, id peer.ID) (*Profile, error) {
+	stream, err := ps.host.NewStream(ctx, id, profileProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := writeJSONLine(stream, ps.Self()); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	theirs := &Profile{}
+	if err := readJSONLine(bufio.NewReader(stream), theirs); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+	theirs.Username = SanitizeText(theirs.Username)
+
+	return theirs, nil
+}
+
+// This one answers profile handshakes started by other peers
+func (ps *ProfileService) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(profileTimeout))
+
+	theirs := &Profile{}
+	if err := readJSONLine(bufio.NewReader(stream), theirs); err != nil {
+		stream.Reset()
+		return
+	}
+	theirs.Username = SanitizeText(theirs.Username)
+
+	// they just told us who they are, no need to ask back
+	ps.lock.Lock()
+	ps.cache[stream.Conn().RemotePeer()] = &cachedProfile{profile: theirs, fetched: time.Now()}
+	ps.lock.Unlock()
+
+	if err := writeJSONLine(stream, ps.Self()); err != nil {
+		stream.Reset()
+	}
+}
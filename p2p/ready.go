@@ -0,0 +1,109 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// how long announcing the service waits for the DHT to know peers to route
+// through, before trying anyway, and how often it looks in the meantime
+const (
+	routingTimeout = 30 * time.Second
+	routingPoll    = 100 * time.Millisecond
+)
+
+// DefaultMinPeers is how many p2pchat peers the host
+// has to be connected to before it is ready
+const DefaultMinPeers = 1
+
+// Method that returns a channel closed once the host is ready to chat, being
+// connected to at least MinPeers p2pchat peers, found through discovery or
+// met on earlier runs. Hosts without the DHT are ready right away, connecting
+// them is left to whoever made them. The first host in the network is never
+// ready, so whoever waits should give up at some point
+func (p2p *P2P) Ready() <-chan struct{} {
+	return p2p.ready
+}
+
+// Method that marks the host ready
+func (p2p *P2P) setReady() {
+	p2p.readyOnce.Do(func() {
+		close(p2p.ready)
+		p2p.startup.advance(StartupJoined)
+	})
+}
+
+// Method that waits until the DHT knows peers to route through, so the
+// service can be announced. Gives up waiting after a while, leaving
+// announcing to fail on its own. Returns an error if the host went down
+func (p2p *P2P) waitRouting() error {
+	deadline := time.After(routingTimeout)
+	ticker := time.NewTicker(routingPoll)
+	defer ticker.Stop()
+
+	for p2p.KadDHT.RoutingTable().Size() == 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			p2p.Logger.Debugln("The DHT found no peers to route through")
+			return nil
+		case <-p2p.Ctx.Done():
+			return fmt.Errorf("waiting for the DHT was cut short: %w", p2p.Ctx.Err())
+		}
+	}
+
+	p2p.Logger.Debugf("The DHT routes through %d peers", p2p.KadDHT.RoutingTable().Size())
+	return nil
+}
+
+// Method that connects to the peers providing the service as they are
+// discovered, marking the host ready once enough of them are connected
+func (p2p *P2P) connectDiscovered(ctx context.Context, peerchan <-chan peer.AddrInfo) {
+	p2p.startup.advance(StartupDiscovering)
+
+	// hosts wanting nobody in particular are ready once they look
+	if p2p.MinPeers <= 0 {
+		p2p.setReady()
+	}
+
+	for info := range peerchan {
+		if info.ID == p2p.Host.ID() {
+			continue
+		}
+
+		if err := p2p.Host.Connect(ctx, info); err == nil {
+			p2p.chatPeerConnected(info.ID)
+		}
+	}
+}
+
+// Method that dials the p2pchat peers met on earlier runs, all at once,
+// so rooms can fill up before discovery finds anyone
+func (p2p *P2P) dialChatPeers(peers []peer.AddrInfo) {
+	for _, info := range peers {
+		go func(info peer.AddrInfo) {
+			ctx, cancel := context.WithTimeout(p2p.Ctx, reconnectTimeout)
+			defer cancel()
+
+			if err := p2p.Host.Connect(ctx, info); err == nil {
+				p2p.chatPeerConnected(info.ID)
+			}
+		}(info)
+	}
+}
+
+// Method that counts a connected p2pchat peer, marking the
+// host ready once enough of them are connected
+func (p2p *P2P) chatPeerConnected(id peer.ID) {
+	p2p.readyLock.Lock()
+	p2p.discovered[id] = true
+	enough := len(p2p.discovered) >= p2p.MinPeers
+	p2p.readyLock.Unlock()
+
+	if enough {
+		p2p.setReady()
+	}
+}
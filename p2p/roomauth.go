@@ -0,0 +1,399 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	host "github.com/libp2p/go-libp2p-host"
+	"golang.org/x/crypto/scrypt"
+)
+
+// protocol ID of the room password challenge-response stream
+const roomAuthProtocol = protocol.ID("/p2pchat/room-auth/1.0.0")
+
+// how long a single challenge-response may take
+const RoomAuthTimeout = 10 * time.Second
+
+// how long to wait before challenging a peer that failed again
+const roomAuthRetry = 30 * time.Second
+
+// how often the peers of a password protected room are checked for new faces
+const RoomAuthInterval = 5 * time.Second
+
+// bytes of the random nonces in challenges
+const roomAuthNonceSize = 16
+
+// scrypt cost parameters for deriving the room secret from the password
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// a chat message payload encrypted with the room password
+type SealedPayload struct {
+	Nonce []byte `json:"nonce"`
+	// encrypted serialized chat message
+	Data []byte `json:"data"`
+}
+
+// keys derived from a room password, one to prove we know
+// the password and one to seal messages with
+type RoomSecret struct {
+	auth []byte
+	seal cipher.AEAD
+}
+
+// This one derives the secret of a room from its password. The topic
+// name is the salt, so the same password makes different secrets in different rooms
+func DeriveRoomSecret(topicName, password string) (*RoomSecret, error) {
+	key, err := scrypt.Key([]byte(password), []byte("p2pchat-room-password|"+topicName), scryptN, scryptR, scryptP, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key[32:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RoomSecret{auth: key[:32], seal: aead}, nil
+}
+
+// Method that computes the proof a peer knows the password, binding
+// it to the role, the room, the challenge nonce and both peers
+func (rs *RoomSecret) proof(role, room string, nonce []byte, prover, verifier peer.ID) []byte {
+	mac := hmac.New(sha256.New, rs.auth)
+	fmt.Fprintf(mac, "p2pchat-room-auth|%s|%s|%s|%s|", role, room, prover.Pretty(), verifier.Pretty())
+	mac.Write(nonce)
+
+	return mac.Sum(nil)
+}
+
+// Method that encrypts a serialized chat message
+func (rs *RoomSecret) Seal(payload []byte) (*SealedPayload, error) {
+	nonce := make([]byte, rs.seal.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return &SealedPayload{Nonce: nonce, Data: rs.seal.Seal(nil, nonce, payload, nil)}, nil
+}
+
+// Method that decrypts a sealed chat message
+func (rs *RoomSecret) Open(sp *SealedPayload) ([]byte, error) {
+	if len(sp.Nonce) != rs.seal.NonceSize() {
+		return nil, fmt.Errorf("bad nonce")
+	}
+
+	return rs.seal.Open(nil, sp.Nonce, sp.Data, nil)
+}
+
+// RoomGate keeps the password secret of a room and the
+// peers that have proven they know it
+type RoomGate struct {
+	lock sync.Mutex
+	// nil while the room has no password
+	secret *RoomSecret
+	// peers that answered a challenge
+	admitted map[peer.ID]bool
+	// when peers were last challenged
+	challenged map[peer.ID]time.Time
+	// peers whose failed challenges we already told the user about
+	failed map[peer.ID]bool
+	// whether we told the user about messages we can't open
+	warned bool
+}
+
+// Constructor function for a new, open Room Gate
+func NewRoomGate() *RoomGate {
+	return &RoomGate{
+		admitted:   make(map[peer.ID]bool),
+		challenged: make(map[peer.ID]time.Time),
+		failed:     make(map[peer.ID]bool),
+	}
+}
+
+// Method that sets the room secret, nil to open the room.
+// Everyone admitted with an older password has to prove themselves again
+func (rg *RoomGate) SetSecret(secret *RoomSecret) {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	rg.secret = secret
+	rg.admitted = make(map[peer.ID]bool)
+	rg.challenged = make(map[peer.ID]time.Time)
+	rg.failed = make(map[peer.ID]bool)
+	rg.warned = false
+}
+
+// Method that returns the room secret, nil if the room has no password
+func (rg *RoomGate) Secret() *RoomSecret {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	return rg.secret
+}
+
+// Method that checks if the peer may talk in the room, anyone can in open rooms
+func (rg *RoomGate) Admits(id peer.ID) bool {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	return rg.secret == nil || rg.admitted[id]
+}
+
+// Method that admits a peer that proved it knows the password
+func (rg *RoomGate) admit(id peer.ID, secret *RoomSecret) {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	// the password might have changed while we were at it
+	if rg.secret == secret {
+		rg.admitted[id] = true
+	}
+}
+
+// Method that decides if a peer should be challenged now,
+// peers are not challenged again until a while after the last try
+func (rg *RoomGate) ShouldChallenge(id peer.ID, now time.Time) bool {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	if rg.secret == nil || rg.admitted[id] {
+		return false
+	}
+
+	if last, ok := rg.challenged[id]; ok && now.Sub(last) < roomAuthRetry {
+		return false
+	}
+
+	rg.challenged[id] = now
+	return true
+}
+
+// Method that returns true only the first time it is called for
+// a password, so messages we can't open are reported once
+func (rg *RoomGate) WarnOnce() bool {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	if rg.warned {
+		return false
+	}
+
+	rg.warned = true
+	return true
+}
+
+// Method that returns true only for the first failed challenge
+// of a peer, so peers that keep failing are reported once
+func (rg *RoomGate) FirstFailure(id peer.ID) bool {
+	rg.lock.Lock()
+	defer rg.lock.Unlock()
+
+	if rg.failed[id] {
+		return false
+	}
+
+	rg.failed[id] = true
+	return true
+}
+
+// a step of the challenge-response, every field is optional
+type authMessage struct {
+	// topic name of the room, sent by the challenger first
+	Room string `json:"room,omitempty"`
+	// random challenge for the other side
+	Nonce []byte `json:"nonce,omitempty"`
+	// answer to the challenge of the other side
+	Proof []byte `json:"proof,omitempty"`
+	// set when the other side gave up
+	Error string `json:"error,omitempty"`
+}
+
+// RoomAuth runs the password challenge-response for the rooms we are in.
+// Both sides prove they know the password, without ever sending it
+type RoomAuth struct {
+	host host.Host
+
+	// lock for the gates
+	lock sync.Mutex
+	// gates of the rooms we are in, by topic name
+	gates map[string]*RoomGate
+}
+
+// Constructor function for a new Room Auth service,
+// which registers the challenge stream handler on the given host
+func NewRoomAuth(nodeHost host.Host) *RoomAuth {
+	ra := &RoomAuth{
+		host:  nodeHost,
+		gates: make(map[string]*RoomGate),
+	}
+
+	nodeHost.SetStreamHandler(roomAuthProtocol, ra.handleStream)
+
+	return ra
+}
+
+// Method that makes the gate of a room answer challenges
+func (ra *RoomAuth) Register(topicName string, gate *RoomGate) {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+
+	ra.gates[topicName] = gate
+}
+
+// Method that stops the gate of a room from answering challenges,
+// unless the room was joined again with a new gate in the meantime
+func (ra *RoomAuth) Unregister(topicName string, gate *RoomGate) {
+	ra.lock.Lock()
+	defer ra.lock.Unlock()
+
+	if ra.gates[topicName] == gate {
+		delete(ra.gates, topicName)
+	}
+}
+
+// Method that challenges a peer in the room to prove it knows the password,
+// and proves that we know it too. Both sides admit each other on success
+func (ra *RoomAuth) Challenge(ctx context.Context, topicName string, gate *RoomGate, id peer.ID) error {
+	secret := gate.Secret()
+	if secret == nil {
+		return nil
+	}
+
+	stream, err := ra.host.NewStream(ctx, id, roomAuthProtocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	ours := make([]byte, roomAuthNonceSize)
+	if _, err := rand.Read(ours); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	if err := writeJSONLine(stream, authMessage{Room: topicName, Nonce: ours}); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	reader := bufio.NewReader(stream)
+
+	answer := authMessage{}
+	if err := readJSONLine(reader, &answer); err != nil {
+		stream.Reset()
+		return err
+	}
+	if len(answer.Error) > 0 {
+		return fmt.Errorf("%s", answer.Error)
+	}
+
+	self := ra.host.ID()
+	if !hmac.Equal(answer.Proof, secret.proof("member", topicName, ours, id, self)) {
+		writeJSONLine(stream, authMessage{Error: "wrong password"})
+		return fmt.Errorf("wrong password")
+	}
+
+	proof := secret.proof("newcomer", topicName, answer.Nonce, self, id)
+	if err := writeJSONLine(stream, authMessage{Proof: proof}); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	result := authMessage{}
+	if err := readJSONLine(reader, &result); err != nil {
+		stream.Reset()
+		return err
+	}
+	if len(result.Error) > 0 {
+		return fmt.Errorf("%s", result.Error)
+	}
+
+	gate.admit(id, secret)
+	return nil
+}
+
+// This one answers challenges started by other peers
+func (ra *RoomAuth) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(RoomAuthTimeout))
+	reader := bufio.NewReader(stream)
+
+	challenge := authMessage{}
+	if err := readJSONLine(reader, &challenge); err != nil {
+		stream.Reset()
+		return
+	}
+
+	ra.lock.Lock()
+	gate := ra.gates[challenge.Room]
+	ra.lock.Unlock()
+
+	if len(challenge.Nonce) != roomAuthNonceSize {
+		writeJSONLine(stream, authMessage{Error: "bad challenge"})
+		return
+	}
+
+	var secret *RoomSecret
+	if gate != nil {
+		secret = gate.Secret()
+	}
+	if secret == nil {
+		writeJSONLine(stream, authMessage{Error: "not in a password protected room of that name"})
+		return
+	}
+
+	ours := make([]byte, roomAuthNonceSize)
+	if _, err := rand.Read(ours); err != nil {
+		stream.Reset()
+		return
+	}
+
+	self, them := ra.host.ID(), stream.Conn().RemotePeer()
+	proof := secret.proof("member", challenge.Room, challenge.Nonce, self, them)
+	if err := writeJSONLine(stream, authMessage{Nonce: ours, Proof: proof}); err != nil {
+		stream.Reset()
+		return
+	}
+
+	answer := authMessage{}
+	if err := readJSONLine(reader, &answer); err != nil {
+		stream.Reset()
+		return
+	}
+	if len(answer.Error) > 0 {
+		return
+	}
+
+	if !hmac.Equal(answer.Proof, secret.proof("newcomer", challenge.Room, ours, them, self)) {
+		writeJSONLine(stream, authMessage{Error: "wrong password"})
+		return
+	}
+
+	gate.admit(them, secret)
+	writeJSONLine(stream, authMessage{})
+}
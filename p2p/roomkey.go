@@ -0,0 +1,124 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// separates the room name from the creation key fingerprint
+const fingerprintSeparator = "#"
+
+// bytes of the key hash kept in a fingerprint
+const fingerprintSize = 15
+
+// encoding of fingerprints, lower case so they are easy to type
+var fingerprintEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// RoomKeyring keeps the creation keys of the rooms we created,
+// one key file per room named after its fingerprint
+type RoomKeyring struct {
+	Dir string
+}
+
+// This one returns the fingerprint of a room creation key
+func RoomFingerprint(pubkey crypto.PubKey) (string, error) {
+	data, err := crypto.MarshalPublicKey(pubkey)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return fingerprintEncoding.EncodeToString(hash[:fingerprintSize]), nil
+}
+
+// This one splits a room name into its plain name and the fingerprint
+// of its creation key, which is empty for rooms without one
+func SplitRoomName(roomName string) (string, string) {
+	i := strings.LastIndex(roomName, fingerprintSeparator)
+	if i < 0 {
+		return roomName, ""
+	}
+
+	return roomName[:i], roomName[i+len(fingerprintSeparator):]
+}
+
+// Method that generates the creation key of a new room and keeps it,
+// returning the full room name peers can join it with
+func (rk *RoomKeyring) Create(name string) (string, error) {
+	if len(name) == 0 || strings.Contains(name, fingerprintSeparator) {
+		return "", fmt.Errorf("room names can't be empty or contain %q", fingerprintSeparator)
+	}
+
+	pvtkey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, err := RoomFingerprint(pvtkey.GetPublic())
+	if err != nil {
+		return "", err
+	}
+
+	data, err := crypto.MarshalPrivateKey(pvtkey)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(rk.Dir, 0700); err != nil {
+		return "", err
+	}
+
+	if err := ioutil.WriteFile(rk.path(fingerprint), data, 0600); err != nil {
+		return "", err
+	}
+
+	return name + fingerprintSeparator + fingerprint, nil
+}
+
+// Method that loads the creation key with the given fingerprint,
+// returning nil if the room isn't one of ours
+func (rk *RoomKeyring) Load(fingerprint string) (crypto.PrivKey, error) {
+	if len(fingerprint) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(rk.path(fingerprint))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+// Method that returns when the room with the given fingerprint was created,
+// which is when its key was written. Zero if the room isn't one of ours
+func (rk *RoomKeyring) Created(fingerprint string) time.Time {
+	if len(fingerprint) == 0 {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(rk.path(fingerprint))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// Method that returns the key file of a fingerprint, which
+// never leaves the keyring directory whatever the fingerprint
+func (rk *RoomKeyring) path(fingerprint string) string {
+	return filepath.Join(rk.Dir, filepath.Base(fingerprint)+".key")
+}
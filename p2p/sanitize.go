@@ -0,0 +1,25 @@
+package p2p
+
+import (
+	"strings"
+	"unicode"
+)
+
+// This one strips what could mess with the terminal from text other peers
+// sent: control characters, other than new lines and tabs, and the unicode
+// overrides that flip the direction of the text around them
+func SanitizeText(text string) string {
+	text = strings.ToValidUTF8(text, "�")
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r):
+			return -1
+		case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+			return -1
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,106 @@
+package p2p
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartupState is how far a host got starting up. The states follow
+// each other in the order below, and never go back
+type StartupState string
+
+const (
+	// loading the identity, or generating one
+	StartupIdentity StartupState = "identity"
+	// dialing the bootstrap peers and the peers met before
+	StartupDialing StartupState = "dialing"
+	// bootstrapping the DHT
+	StartupBootstrapping StartupState = "bootstrapping"
+	// announcing, or advertising, the service
+	StartupAnnouncing StartupState = "announcing"
+	// looking for the peers providing the service
+	StartupDiscovering StartupState = "discovering"
+	// connected to enough peers to chat, see Ready
+	StartupJoined StartupState = "joined"
+)
+
+// the startup states in the order they follow each other,
+// with what is logged as each of them is reached
+var startupStates = []struct {
+	state StartupState
+	log   string
+}{
+	{StartupIdentity, "Loading the identity"},
+	{StartupDialing, "Dialing the bootstrap peers"},
+	{StartupBootstrapping, "Bootstrapping the Kademlia DHT"},
+	{StartupAnnouncing, "Announcing the service"},
+	{StartupDiscovering, "Looking for service peers"},
+	{StartupJoined, "Service Peers connected"},
+}
+
+// startup tracks how far a host got starting up, logging each state it
+// reaches and telling whoever follows the progress
+type startup struct {
+	log *logrus.Entry
+	// told about each state reached, nil for nobody
+	progress func(StartupState)
+
+	// lock for the state
+	lock sync.Mutex
+	// index of the state reached in startupStates, -1 before any
+	reached int
+	// held while a state reached is reported, taken before the lock
+	// for the state is let go, so the states are told in order
+	reporting sync.Mutex
+}
+
+// Constructor function for the startup of a host, before any state
+func newStartup(opts Options) *startup {
+	return &startup{log: opts.logger(), progress: opts.Progress, reached: -1}
+}
+
+// Method that moves the startup on to the state, unless it got that far
+// already. Each state reached is logged, and told to the progress function,
+// in the order they were reached whichever goroutines reach them
+func (su *startup) advance(state StartupState) {
+	su.lock.Lock()
+	index := su.reached
+	for i, s := range startupStates {
+		if s.state == state {
+			index = i
+		}
+	}
+	if index <= su.reached {
+		su.lock.Unlock()
+		return
+	}
+	su.reached = index
+	su.reporting.Lock()
+	su.lock.Unlock()
+	defer su.reporting.Unlock()
+
+	su.log.WithFields(logrus.Fields{
+		"state": state,
+	}).Infoln(startupStates[index].log)
+
+	if su.progress != nil {
+		su.progress(state)
+	}
+}
+
+// Method that returns the state reached, the first one before any
+func (su *startup) state() StartupState {
+	su.lock.Lock()
+	defer su.lock.Unlock()
+
+	if su.reached < 0 {
+		return startupStates[0].state
+	}
+	return startupStates[su.reached].state
+}
+
+// Method that returns how far the host got starting up
+func (p2p *P2P) Startup() StartupState {
+	return p2p.startup.state()
+}
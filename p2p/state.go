@@ -0,0 +1,101 @@
+package p2p
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// name of the application directory inside the config and data directories
+const stateDirName = "p2pchat"
+
+// State is where what is remembered across runs is kept, laid out the way
+// the XDG base directories say: what the user edits, like the settings and
+// hooks, in the config directory, and what is written for
+// them, like keys, lists and history, in the data directory
+type State struct {
+	ConfigDir string
+	DataDir   string
+}
+
+// This one returns the state layout of the user, $XDG_CONFIG_HOME/p2pchat and
+// $XDG_DATA_HOME/p2pchat, or ~/.config/p2pchat and ~/.local/share/p2pchat when
+// they aren't set. Systems without XDG directories, like Windows and macOS,
+// keep both in the user config directory, and without even that everything
+// is kept in the working directory
+func DefaultState() State {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return NewState("")
+	}
+
+	return State{
+		ConfigDir: filepath.Join(configDir, stateDirName),
+		DataDir:   filepath.Join(userDataDir(configDir), stateDirName),
+	}
+}
+
+// Constructor function for a state layout keeping everything in one directory,
+// like the one given with -data-dir, so a second profile keeps nothing in common
+func NewState(dir string) State {
+	return State{ConfigDir: dir, DataDir: dir}
+}
+
+// This one returns the user data directory, next to the config one
+func userDataDir(configDir string) string {
+	switch runtime.GOOS {
+	case "windows", "darwin", "ios", "plan9":
+		return configDir
+	}
+
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return configDir
+	}
+
+	return filepath.Join(home, ".local", "share")
+}
+
+// Method that returns the path of a file or directory in the data directory
+func (s State) Path(name string) string {
+	return filepath.Join(s.DataDir, name)
+}
+
+// Method that returns the path of a file or directory in the config directory
+func (s State) ConfigPath(name string) string {
+	return filepath.Join(s.ConfigDir, name)
+}
+
+// Method that returns the file the host identity is kept in
+func (s State) Identity() string {
+	return s.Path("identity.key")
+}
+
+// Method that returns the file the peers met are remembered in, for the next start
+func (s State) Peerstore() string {
+	return s.Path("peerstore.json")
+}
+
+// Method that returns the file the block, mute, verified and watch lists are kept in
+func (s State) PeerLists() string {
+	return s.Path("peers.json")
+}
+
+// Method that returns the directory the creation keys of our rooms are kept in
+func (s State) RoomKeys() string {
+	return s.Path("rooms")
+}
+
+// Method that returns the directory the messages seen are kept in
+func (s State) History() string {
+	return s.Path("history")
+}
+
+// Method that returns the file the preferences are saved to
+func (s State) Settings() string {
+	return s.ConfigPath("settings.json")
+}
@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	host "github.com/libp2p/go-libp2p-host"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// PubSubTrace says where the events of GossipSub are traced to, like the
+// peers grafted to and pruned from the mesh and the duplicate messages,
+// for looking into how the mesh behaves. Empty fields trace nowhere
+type PubSubTrace struct {
+	// file the events are written to as JSON, one a line
+	File string
+	// address of a remote tracer collecting the events, like /ip4/.../p2p/Qm...
+	Remote string
+}
+
+// a tracer that can be closed, flushing what it still holds
+type closingTracer interface {
+	pubsub.EventTracer
+	Close()
+}
+
+// tracers that all get every event
+type multiTracer []closingTracer
+
+// Method that hands the event to every tracer
+func (mt multiTracer) Trace(evt *pb.TraceEvent) {
+	for _, t := range mt {
+		t.Trace(evt)
+	}
+}
+
+// Method that closes every tracer
+func (mt multiTracer) Close() {
+	for _, t := range mt {
+		t.Close()
+	}
+}
+
+// This one starts the tracers the trace asks for, nil if it asks for none
+func startTracers(ctx context.Context, nodeHost host.Host, trace PubSubTrace) (multiTracer, error) {
+	var tracers multiTracer
+
+	if len(trace.File) > 0 {
+		tracer, err := pubsub.NewJSONTracer(trace.File)
+		if err != nil {
+			return nil, err
+		}
+		tracers = append(tracers, tracer)
+	}
+
+	if len(trace.Remote) > 0 {
+		addr, err := multiaddr.NewMultiaddr(trace.Remote)
+		if err != nil {
+			tracers.Close()
+			return nil, fmt.Errorf("bad remote tracer address %s: %w", trace.Remote, err)
+		}
+		info, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			tracers.Close()
+			return nil, fmt.Errorf("remote tracer address %s has no peer ID: %w", trace.Remote, err)
+		}
+
+		tracer, err := pubsub.NewRemoteTracer(ctx, nodeHost, *info)
+		if err != nil {
+			tracers.Close()
+			return nil, err
+		}
+		tracers = append(tracers, tracer)
+	}
+
+	return tracers, nil
+}
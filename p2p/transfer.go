@@ -0,0 +1,675 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	host "github.com/libp2p/go-libp2p-host"
+)
+
+// protocol ID of the direct file transfer stream
+const fileProtocol = protocol.ID("/p2pchat/file/1.0.0")
+
+// size of a single chunk written to or read from the transfer stream
+const fileChunkSize = 64 * 1024
+
+// how long an incoming offer waits for the user to decide
+const fileOfferTimeout = 2 * time.Minute
+
+// suffix of partially received files, kept around for resuming
+const filePartSuffix = ".part"
+
+// how long the sender of a file transfer stream has to send its offer
+const fileHeaderTimeout = 30 * time.Second
+
+// how long a transfer under way waits for more data before giving up
+const fileIdleTimeout = time.Minute
+
+// longest line read from a stream, the headers and answers are far shorter
+const maxJSONLine = 8 * 1024
+
+// most numbered names tried for a file before giving up
+const maxUniqueNames = 1000
+
+// how often a transfer reports its progress
+const transferReportInterval = 250 * time.Millisecond
+
+// file offer sent by the sender when the transfer stream is opened
+type fileHeader struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Hash       string `json:"hash"`
+	SenderName string `json:"senderName"`
+}
+
+// receivers answer to a file offer, with the offset
+// from which the sender should resume the transfer
+type fileAnswer struct {
+	Accept bool  `json:"accept"`
+	Offset int64 `json:"offset"`
+}
+
+// final transfer status reported back by the receiver
+type fileResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// progress of a transfer in either direction, for the UI to show
+type TransferEvent struct {
+	// number of the transfer, for cancelling it
+	ID   int
+	Name string
+	// peer on the other end, and whether we are the sender
+	Peer    peer.ID
+	Sending bool
+
+	Size int64
+	Done int64
+	// bytes a second, since the transfer started
+	Rate float64
+
+	// the transfer is over, whichever way it went
+	Finished bool
+}
+
+// Method that returns how long the rest of the transfer should take,
+// zero if there is no telling yet
+func (te TransferEvent) ETA() time.Duration {
+	if te.Rate <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(te.Size-te.Done) / te.Rate * float64(time.Second))
+}
+
+// an incoming file offer waiting for the users decision
+type FileOffer struct {
+	fileHeader
+
+	// peer that is offering the file
+	From peer.ID
+
+	// the channel on which the decision is delivered
+	decision chan bool
+}
+
+// Method that accepts or declines the offer
+func (fo *FileOffer) Answer(accept bool) {
+	select {
+	case fo.decision <- accept:
+	default:
+	}
+}
+
+// FileTransfer handles direct peer-to-peer file transfers
+type FileTransfer struct {
+	host host.Host
+	// context of the host, received files stop coming once it's done
+	ctx context.Context
+
+	// directory where received files are stored
+	DownloadDir string
+
+	// the channel for incomming file offers
+	Offers chan *FileOffer
+	// the channel for transfer progress and errors
+	Logs chan Log
+	// the channel for the progress of transfers under way
+	Progress chan TransferEvent
+
+	// transfers under way by number, to cancel them
+	active map[int]context.CancelFunc
+	nextID int
+	// partial files being written to by a transfer under way
+	receiving map[string]bool
+	// lock for the transfers under way
+	lock sync.Mutex
+}
+
+// Constructor function for a new File Transfer service,
+// which registers the file stream handler on the given host
+func NewFileTransfer(ctx context.Context, nodeHost host.Host) *FileTransfer {
+	ft := &FileTransfer{
+		host:        nodeHost,
+		ctx:         ctx,
+		DownloadDir: ".",
+		Offers:      make(chan *FileOffer),
+		Logs:        make(chan Log),
+		Progress:    make(chan TransferEvent),
+		active:      make(map[int]context.CancelFunc),
+		receiving:   make(map[string]bool),
+	}
+
+	nodeHost.SetStreamHandler(fileProtocol, ft.handleStream)
+
+	return ft
+}
+
+// Method that sends the file on the given path to a peer.
+// It blocks until the peer either declines, or the whole file is transferred
+func (ft *FileTransfer) SendFile(ctx context.Context, to peer.ID, path, username string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+
+	// hash the whole file upfront, the receiver verifies it at the end
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return err
+	}
+
+	header := fileHeader{
+		Name:       filepath.Base(path),
+		Size:       info.Size(),
+		Hash:       hex.EncodeToString(hasher.Sum(nil)),
+		SenderName: username,
+	}
+
+	stream, err := ft.host.NewStream(ctx, to, fileProtocol)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+
+	if err := writeJSONLine(stream, header); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	ft.log("sendfile", fmt.Sprintf("offered %s to %s, waiting for answer", header.Name, ShortID(to)))
+
+	answer := fileAnswer{}
+	if err := readJSONLine(reader, &answer); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	if !answer.Accept {
+		return fmt.Errorf("%s declined %s", ShortID(to), header.Name)
+	}
+
+	if answer.Offset < 0 || answer.Offset > header.Size {
+		stream.Reset()
+		return fmt.Errorf("invalid resume offset %d", answer.Offset)
+	}
+
+	if answer.Offset > 0 {
+		ft.log("sendfile", fmt.Sprintf("resuming %s at %d bytes", header.Name, answer.Offset))
+	}
+
+	if _, err := file.Seek(answer.Offset, io.SeekStart); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	progress := ft.track(ctx, stream, header.Name, to, true, header.Size, answer.Offset)
+	defer progress.finish()
+
+	if err := copyChunks(stream, file, progress); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	// signal the end of data, and wait for the verdict
+	if err := stream.CloseWrite(); err != nil {
+		stream.Reset()
+		return err
+	}
+
+	result := fileResult{}
+	if err := readJSONLine(reader, &result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("%s rejected %s: %s", ShortID(to), header.Name, result.Error)
+	}
+
+	ft.log("sendfile", fmt.Sprintf("%s delivered to %s", header.Name, ShortID(to)))
+
+	return nil
+}
+
+// This one handles the incomming file transfer streams
+func (ft *FileTransfer) handleStream(stream network.Stream) {
+	defer stream.Close()
+
+	from := stream.Conn().RemotePeer()
+	reader := bufio.NewReader(stream)
+
+	// the offer comes right away, or not at all
+	stream.SetReadDeadline(time.Now().Add(fileHeaderTimeout))
+
+	header := fileHeader{}
+	if err := readJSONLine(reader, &header); err != nil {
+		ft.log("fileerr", fmt.Sprintf("bad file offer from %s", ShortID(from)))
+		stream.Reset()
+		return
+	}
+
+	// the user takes their time deciding
+	stream.SetReadDeadline(time.Time{})
+
+	// never trust the sender with the path, nor with hidden files
+	header.Name = SanitizeText(filepath.Base(header.Name))
+	header.SenderName = SanitizeText(header.SenderName)
+	if len(header.Name) == 0 || strings.HasPrefix(header.Name, ".") || header.Name == string(filepath.Separator) || header.Size < 0 || !validFileHash(header.Hash) {
+		ft.log("fileerr", fmt.Sprintf("bad file offer from %s", ShortID(from)))
+		stream.Reset()
+		return
+	}
+
+	offer := &FileOffer{
+		fileHeader: header,
+		From:       from,
+		decision:   make(chan bool, 1),
+	}
+
+	// hand the offer over to the user, and wait for the decision
+	accepted := false
+	select {
+	case ft.Offers <- offer:
+		select {
+		case accepted = <-offer.decision:
+		case <-time.After(fileOfferTimeout):
+			ft.log("recvfile", fmt.Sprintf("offer for %s timed out", header.Name))
+		}
+	case <-time.After(fileOfferTimeout):
+	}
+
+	if !accepted {
+		writeJSONLine(stream, fileAnswer{Accept: false})
+		return
+	}
+
+	// the same file from the same sender resumes, anything else gets a part of its own
+	partPath := filepath.Join(ft.DownloadDir, partFileName(from, header.Hash))
+	if !ft.reserve(partPath) {
+		ft.log("fileerr", fmt.Sprintf("%s is already coming from %s", header.Name, ShortID(from)))
+		writeJSONLine(stream, fileAnswer{Accept: false})
+		return
+	}
+	defer ft.release(partPath)
+
+	part, offset, hasher, err := openPartFile(partPath, header.Size)
+	if err != nil {
+		ft.log("fileerr", fmt.Sprintf("could not open %s: %s", partPath, err))
+		writeJSONLine(stream, fileAnswer{Accept: false})
+		return
+	}
+	defer part.Close()
+
+	if err := writeJSONLine(stream, fileAnswer{Accept: true, Offset: offset}); err != nil {
+		stream.Reset()
+		return
+	}
+
+	progress := ft.track(ft.ctx, stream, header.Name, from, false, header.Size, offset)
+	defer progress.finish()
+
+	// the data may take its time coming, but not stall
+	data := io.LimitReader(&idleReader{stream: stream, reader: reader}, header.Size-offset)
+	if err := copyChunks(io.MultiWriter(part, hasher), data, progress); err != nil {
+		// keep the partial file, the sender can resume later
+		ft.log("fileerr", fmt.Sprintf("transfer of %s interrupted: %s", header.Name, err))
+		stream.Reset()
+		return
+	}
+
+	if progress.event.Done != header.Size {
+		ft.log("fileerr", fmt.Sprintf("transfer of %s interrupted at %d bytes", header.Name, progress.event.Done))
+		stream.Reset()
+		return
+	}
+
+	if hex.EncodeToString(hasher.Sum(nil)) != header.Hash {
+		// corrupted data is of no use for resuming either
+		part.Close()
+		os.Remove(partPath)
+		ft.log("fileerr", fmt.Sprintf("hash mismatch for %s, file discarded", header.Name))
+		writeJSONLine(stream, fileResult{OK: false, Error: "hash mismatch"})
+		return
+	}
+
+	// a file of the same name is kept, the new one gets a number added
+	final, err := CreateUniqueFile(ft.DownloadDir, header.Name)
+	if err != nil {
+		ft.log("fileerr", fmt.Sprintf("could not save %s: %s", header.Name, err))
+		writeJSONLine(stream, fileResult{OK: false, Error: "could not save file"})
+		return
+	}
+	final.Close()
+
+	finalPath := final.Name()
+	if err := os.Rename(partPath, finalPath); err != nil {
+		os.Remove(finalPath)
+		ft.log("fileerr", fmt.Sprintf("could not save %s: %s", finalPath, err))
+		writeJSONLine(stream, fileResult{OK: false, Error: "could not save file"})
+		return
+	}
+
+	writeJSONLine(stream, fileResult{OK: true})
+	ft.log("recvfile", fmt.Sprintf("saved %s", finalPath))
+}
+
+// Method that sends a log message without blocking the transfer
+func (ft *FileTransfer) log(prefix, msg string) {
+	select {
+	case ft.Logs <- Log{Prefix: prefix, Msg: msg}:
+	case <-time.After(time.Second):
+	}
+}
+
+// This one returns the name of the partial file for a file offered by
+// the sender, which is hidden, and the same whenever the same file comes
+// from the same sender again, so that the transfer resumes
+func partFileName(from peer.ID, fileHash string) string {
+	return "." + from.Pretty() + "-" + fileHash + filePartSuffix
+}
+
+// This one reports if the offered hash is a SHA-256 sum in hex
+func validFileHash(fileHash string) bool {
+	sum, err := hex.DecodeString(fileHash)
+	return err == nil && len(sum) == sha256.Size
+}
+
+// Method that claims a partial file for a transfer, reporting
+// false if another transfer under way is writing to it already
+func (ft *FileTransfer) reserve(partPath string) bool {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	if ft.receiving[partPath] {
+		return false
+	}
+	ft.receiving[partPath] = true
+
+	return true
+}
+
+// Method that lets go of a partial file claimed by a transfer
+func (ft *FileTransfer) release(partPath string) {
+	ft.lock.Lock()
+	delete(ft.receiving, partPath)
+	ft.lock.Unlock()
+}
+
+// idleReader reads from a transfer stream, giving the sender a while
+// from each read to the next before the stream times out
+type idleReader struct {
+	stream network.Stream
+	reader io.Reader
+}
+
+// Method that reads from the stream, pushing its deadline forward first
+func (ir *idleReader) Read(p []byte) (int, error) {
+	ir.stream.SetReadDeadline(time.Now().Add(fileIdleTimeout))
+	return ir.reader.Read(p)
+}
+
+// This one opens a partially received file for appending and returns
+// the resume offset along with a hasher already fed with the existing data
+func openPartFile(path string, size int64) (*os.File, int64, hash.Hash, error) {
+	part, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	hasher := sha256.New()
+	offset, err := io.Copy(hasher, part)
+	if err != nil {
+		part.Close()
+		return nil, 0, nil, err
+	}
+
+	// a part larger than the offered file is not ours to resume
+	if offset > size {
+		if err := part.Truncate(0); err != nil {
+			part.Close()
+			return nil, 0, nil, err
+		}
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			part.Close()
+			return nil, 0, nil, err
+		}
+		offset = 0
+		hasher.Reset()
+	}
+
+	return part, offset, hasher, nil
+}
+
+// tracks and reports progress of a single transfer
+type transferProgress struct {
+	ft      *FileTransfer
+	event   TransferEvent
+	started time.Time
+	offset  int64
+	// when progress was last reported
+	reported time.Time
+
+	// cancelled along with the transfer
+	ctx  context.Context
+	stop context.CancelFunc
+	// closed once the transfer is over, cancelling it then cuts nothing
+	over chan struct{}
+}
+
+// Method that starts tracking a transfer over the given stream, which is
+// cut if the transfer is cancelled. Finish it once the transfer is over
+func (ft *FileTransfer) track(ctx context.Context, stream network.Stream, name string, with peer.ID, sending bool, size, offset int64) *transferProgress {
+	ctx, stop := context.WithCancel(ctx)
+
+	ft.lock.Lock()
+	ft.nextID++
+	id := ft.nextID
+	ft.active[id] = stop
+	ft.lock.Unlock()
+
+	tp := &transferProgress{
+		ft:      ft,
+		event:   TransferEvent{ID: id, Name: name, Peer: with, Sending: sending, Size: size, Done: offset},
+		started: time.Now(),
+		offset:  offset,
+		ctx:     ctx,
+		stop:    stop,
+		over:    make(chan struct{}),
+	}
+
+	// a stream waiting on the other peer only notices when it's cut
+	go func() {
+		select {
+		case <-ctx.Done():
+			select {
+			case <-tp.over:
+			default:
+				stream.Reset()
+			}
+		case <-tp.over:
+		}
+	}()
+
+	tp.report(false)
+
+	return tp
+}
+
+// Method that cancels a transfer under way. Reports if there was one
+func (ft *FileTransfer) Cancel(id int) bool {
+	ft.lock.Lock()
+	stop, ok := ft.active[id]
+	ft.lock.Unlock()
+
+	if ok {
+		stop()
+	}
+
+	return ok
+}
+
+// Method that adds transferred bytes, reporting them every now and then
+func (tp *transferProgress) add(n int64) {
+	tp.event.Done += n
+
+	if time.Since(tp.reported) >= transferReportInterval {
+		tp.report(false)
+	}
+}
+
+// Method that reports how the transfer is going. Progress may be dropped
+// when the UI is busy, the end of the transfer never is
+func (tp *transferProgress) report(finished bool) {
+	tp.reported = time.Now()
+
+	if elapsed := time.Since(tp.started).Seconds(); elapsed > 0 {
+		tp.event.Rate = float64(tp.event.Done-tp.offset) / elapsed
+	}
+	tp.event.Finished = finished
+
+	if !finished {
+		select {
+		case tp.ft.Progress <- tp.event:
+		default:
+		}
+		return
+	}
+
+	select {
+	case tp.ft.Progress <- tp.event:
+	case <-time.After(time.Second):
+	}
+}
+
+// Method that stops tracking the transfer, once it is over
+func (tp *transferProgress) finish() {
+	tp.ft.lock.Lock()
+	delete(tp.ft.active, tp.event.ID)
+	tp.ft.lock.Unlock()
+
+	close(tp.over)
+	tp.stop()
+	tp.report(true)
+}
+
+// This one copies data from src to dst in fixed size chunks
+func copyChunks(dst io.Writer, src io.Reader, progress *transferProgress) error {
+	buf := make([]byte, fileChunkSize)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return progress.cancelled(werr)
+			}
+			progress.add(int64(n))
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return progress.cancelled(err)
+		}
+	}
+}
+
+// Method that tells a cancelled transfer apart from one that failed
+func (tp *transferProgress) cancelled(err error) error {
+	if tp.ctx.Err() != nil {
+		return fmt.Errorf("cancelled")
+	}
+
+	return err
+}
+
+// This one writes a newline delimited JSON value
+func writeJSONLine(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// This one reads a newline delimited JSON value, of a few KB at most
+func readJSONLine(r *bufio.Reader, v interface{}) error {
+	// a byte at a time, what follows the line stays in the reader
+	limited := io.LimitReader(r, maxJSONLine)
+	line := make([]byte, 0, 256)
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(limited, b); err != nil {
+			if err == io.EOF && len(line) == maxJSONLine {
+				return fmt.Errorf("line longer than %s", FormatSize(maxJSONLine))
+			}
+			return err
+		}
+
+		line = append(line, b[0])
+		if b[0] == '\n' {
+			break
+		}
+	}
+
+	return json.Unmarshal(line, v)
+}
+
+// This one creates a new file in the directory, never replacing one that is
+// there already. If the name is taken, a number is added to it, the way
+// "name (1).ext" is the first one tried. The file is returned open for writing
+func CreateUniqueFile(dir, name string) (*os.File, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	for i := 0; i < maxUniqueNames; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+
+	return nil, fmt.Errorf("too many files named like %s", name)
+}
+
+// This one shortens a peer ID for display
+func ShortID(id peer.ID) string {
+	pretty := id.Pretty()
+	if len(pretty) <= 8 {
+		return pretty
+	}
+
+	return pretty[len(pretty)-8:]
+}
@@ -0,0 +1,32 @@
+//go:build !js
+
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-tcp-transport"
+	websocket "github.com/libp2p/go-ws-transport"
+)
+
+// hosts outside the browser serve the DHT to the others
+const dhtMode = dht.ModeServer
+
+// This one returns the transports of the host, TCP, and WebSocket
+// for the browsers dialing us when we listen on a /ws address
+func transportOptions() libp2p.Option {
+	return libp2p.ChainOptions(
+		libp2p.Transport(tcp.NewTCPTransport),
+		libp2p.Transport(websocket.New),
+	)
+}
+
+// This one returns the addresses the host listens on when none are given
+func defaultListenAddrs() []string {
+	return []string{"/ip4/0.0.0.0/tcp/0"}
+}
+
+// This one returns how the host gets through NAT, mapping its ports on the router
+func natOptions() libp2p.Option {
+	return libp2p.NATPortMap()
+}
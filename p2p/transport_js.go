@@ -0,0 +1,28 @@
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	websocket "github.com/libp2p/go-ws-transport"
+)
+
+// hosts in the browser can't be dialed, so they only ask the DHT
+const dhtMode = dht.ModeClient
+
+// This one returns the transports of the host. Browsers only dial
+// WebSockets, so peers are reached at their /ws addresses
+func transportOptions() libp2p.Option {
+	return libp2p.Transport(websocket.New)
+}
+
+// This one returns the addresses the host listens on when none are given,
+// none, as nothing can listen in a browser
+func defaultListenAddrs() []string {
+	return nil
+}
+
+// This one returns how the host gets through NAT, not at all,
+// as the browser only dials out
+func natOptions() libp2p.Option {
+	return libp2p.ChainOptions()
+}
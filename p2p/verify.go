@@ -0,0 +1,81 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// groups of five digits in a safety number
+const safetyGroups = 6
+
+// emojis in a safety string, each one stands for six bits
+const safetyEmojis = 8
+
+// sixty four easily told apart emojis, one per six bits of the safety hash
+var safetyAlphabet = []string{
+	"🐶", "🐱", "🐭", "🐹", "🐰", "🦊", "🐻", "🐼",
+	"🐨", "🐯", "🦁", "🐮", "🐷", "🐸", "🐵", "🐔",
+	"🐧", "🐦", "🦆", "🦉", "🐺", "🐴", "🦄", "🐝",
+	"🐛", "🦋", "🐌", "🐞", "🐢", "🐍", "🐙", "🦀",
+	"🐠", "🐬", "🐳", "🦈", "🐊", "🦒", "🐘", "🦔",
+	"🌵", "🌲", "🍀", "🍁", "🍄", "🌻", "🌙", "⭐",
+	"🔥", "🌈", "⛄", "💧", "🍎", "🍋", "🍌", "🍉",
+	"🍇", "🍓", "🥕", "🌽", "🍕", "🎈", "🔑", "⚓",
+}
+
+// This one returns the public key of a peer, from the peerstore or from the ID itself
+func PeerPublicKey(p2p *P2P, id peer.ID) (crypto.PubKey, error) {
+	if key := p2p.Host.Peerstore().PubKey(id); key != nil {
+		return key, nil
+	}
+
+	key, err := id.ExtractPublicKey()
+	if err != nil || key == nil {
+		return nil, fmt.Errorf("the key of %s is not known yet", ShortID(id))
+	}
+
+	return key, nil
+}
+
+// This one derives the safety number and emoji string two peers compare.
+// Both keys go in sorted, so both sides compute the very same thing
+func SafetyString(ours, theirs crypto.PubKey) (string, string, error) {
+	a, err := crypto.MarshalPublicKey(ours)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := crypto.MarshalPublicKey(theirs)
+	if err != nil {
+		return "", "", err
+	}
+
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte("p2pchat-safety-number"))
+	hash.Write(a)
+	hash.Write(b)
+	sum := hash.Sum(nil)
+
+	groups := make([]string, safetyGroups)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%05d", binary.BigEndian.Uint32(sum[i*4:])%100000)
+	}
+
+	// the emojis come from the rest of the hash, six bits at a time
+	bits := binary.BigEndian.Uint64(sum[safetyGroups*4:])
+	emojis := make([]string, safetyEmojis)
+	for i := range emojis {
+		emojis[i] = safetyAlphabet[(bits>>(uint(i)*6))&63]
+	}
+
+	return strings.Join(groups, " "), strings.Join(emojis, " "), nil
+}
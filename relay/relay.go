@@ -0,0 +1,330 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// kinds of incoming webhooks messages are posted to
+const (
+	KindSlack   = "slack"
+	KindDiscord = "discord"
+)
+
+// longest message Discord takes, in characters
+const discordMaxLength = 2000
+
+// messages waiting to be posted, more are dropped
+const queueSize = 100
+
+// how long posting a message may take
+const postTimeout = 10 * time.Second
+
+// longest a rate limited post waits before trying again
+const maxRetryWait = 30 * time.Second
+
+// largest body of an outgoing webhook request
+const maxBodySize = 64 * 1024
+
+// Config is where a relay posts the messages of its room, and
+// where it listens for messages to bring back to the room
+type Config struct {
+	// URL of the incoming webhook of the channel
+	WebhookURL string
+	// slack or discord, told by the webhook URL if empty
+	Kind string
+	// address to listen for outgoing webhooks on, empty not to
+	Listen string
+	// token outgoing webhooks must carry
+	Token string
+}
+
+// Relay forwards the messages of a room to a Slack or Discord channel through
+// an incoming webhook, and optionally brings what is said there back to the
+// room, through an outgoing webhook or anything else posting to it
+type Relay struct {
+	config Config
+	room   *chat.ChatRoom
+	client *http.Client
+	// messages waiting to be posted
+	queue chan chat.ChatMessage
+	// nil unless outgoing webhooks are listened for
+	server *http.Server
+}
+
+// Constructor function for a relay of the room to the webhook
+func NewRelay(config Config, cr *chat.ChatRoom) (*Relay, error) {
+	webhook, err := url.Parse(config.WebhookURL)
+	if err != nil || (webhook.Scheme != "https" && webhook.Scheme != "http") {
+		return nil, fmt.Errorf("bad webhook URL %s", config.WebhookURL)
+	}
+
+	if len(config.Kind) == 0 {
+		config.Kind = KindSlack
+		if strings.Contains(webhook.Host, "discord") {
+			config.Kind = KindDiscord
+		}
+	}
+	if config.Kind != KindSlack && config.Kind != KindDiscord {
+		return nil, fmt.Errorf("unknown webhook kind %s, expected slack or discord", config.Kind)
+	}
+	if len(config.Listen) > 0 && len(config.Token) == 0 {
+		return nil, fmt.Errorf("listening for outgoing webhooks needs a token")
+	}
+
+	return &Relay{
+		config: config,
+		room:   cr,
+		client: &http.Client{Timeout: postTimeout},
+		queue:  make(chan chat.ChatMessage, queueSize),
+	}, nil
+}
+
+// Method that starts listening for outgoing webhooks, if the relay is to
+func (r *Relay) Start() error {
+	if len(r.config.Listen) == 0 {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", r.config.Listen)
+	if err != nil {
+		return err
+	}
+
+	r.server = &http.Server{Handler: http.HandlerFunc(r.handleOutgoing), ReadHeaderTimeout: postTimeout}
+	go r.server.Serve(listener)
+
+	return nil
+}
+
+// Method that stops listening for outgoing webhooks
+func (r *Relay) Close() error {
+	if r.server == nil {
+		return nil
+	}
+
+	return r.server.Close()
+}
+
+// Method that relays the messages of the room until the context is done
+func (r *Relay) Run(ctx context.Context) {
+	r.room.OnMessage(func(msg chat.ChatMessage) {
+		if ctx.Err() == nil {
+			r.enqueue(msg)
+		}
+	})
+	r.room.OnLog(func(log chat.ChatLog) {
+		r.room.Host.Logger.WithFields(logrus.Fields{
+			"room":   r.room.RoomName,
+			"prefix": log.Prefix,
+		}).Debugln(log.Msg)
+	})
+
+	r.post(ctx)
+}
+
+// Method that queues a message of the room to be posted to the webhook,
+// if it is one to pass on, dropping it if too many are waiting already
+func (r *Relay) enqueue(msg chat.ChatMessage) {
+	if msg.Type != chat.MessageText && msg.Type != chat.MessageCommand {
+		return
+	}
+	// muted peers are still there, we just don't pass them on
+	if from, err := peer.Decode(msg.SenderID); err == nil && r.room.Host.PeerLists.IsMuted(from) {
+		return
+	}
+
+	select {
+	case r.queue <- msg:
+	default:
+		r.room.Host.Logger.WithFields(logrus.Fields{
+			"room": r.room.RoomName,
+		}).Warnln("Too many messages waiting for the webhook, dropped one")
+	}
+}
+
+// Method that posts the queued messages to the webhook, one
+// at a time so they arrive in order, until the context is done
+func (r *Relay) post(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case msg := <-r.queue:
+			if err := r.postMessage(ctx, msg); err != nil {
+				r.room.Host.Logger.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"room":  r.room.RoomName,
+				}).Warnln("Posting to the webhook failed")
+			}
+		}
+	}
+}
+
+// Method that posts a message to the webhook, waiting and trying once
+// more if the webhook says we are posting too fast
+func (r *Relay) postMessage(ctx context.Context, msg chat.ChatMessage) error {
+	payload, err := json.Marshal(r.payload(msg))
+	if err != nil {
+		return err
+	}
+
+	for try := 0; ; try++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.WebhookURL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodySize))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode != http.StatusTooManyRequests || try > 0:
+			return fmt.Errorf("webhook answered %s", resp.Status)
+		}
+
+		wait := time.Second
+		if seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && seconds > 0 {
+			wait = time.Duration(seconds * float64(time.Second))
+		}
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Method that makes what is posted to the webhook for a message,
+// in the shape the kind of webhook expects
+func (r *Relay) payload(msg chat.ChatMessage) interface{} {
+	if r.config.Kind == KindDiscord {
+		text := msg.Message
+		if runes := []rune(text); len(runes) > discordMaxLength {
+			text = string(runes[:discordMaxLength-1]) + "…"
+		}
+
+		return map[string]interface{}{
+			"username": msg.SenderName,
+			"content":  text,
+			// nobody there gets pinged by what peers say here
+			"allowed_mentions": map[string]interface{}{"parse": []string{}},
+		}
+	}
+
+	// slack takes these three as markup, everything else as it is
+	escaper := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	return map[string]string{
+		"username": msg.SenderName,
+		"text":     escaper.Replace(msg.Message),
+	}
+}
+
+// Method that brings a message posted by an outgoing webhook back to the
+// room, with the name of whoever sent it. Takes the form Slack posts, with
+// user_name and text, or JSON with username and text or content
+//
+//	POST /?token=<token>
+func (r *Relay) handleOutgoing(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "only POST works here", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "could not read the body", http.StatusBadRequest)
+		return
+	}
+
+	var token, username, text string
+	var isBot bool
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/json" {
+		var post struct {
+			Token    string `json:"token"`
+			Username string `json:"username"`
+			Text     string `json:"text"`
+			Content  string `json:"content"`
+		}
+		if err := json.Unmarshal(body, &post); err != nil {
+			http.Error(w, "expected {\"username\": \"name\", \"text\": \"message\"}", http.StatusBadRequest)
+			return
+		}
+		token, username, text = post.Token, post.Username, post.Text
+		if len(text) == 0 {
+			text = post.Content
+		}
+	} else {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "could not read the form", http.StatusBadRequest)
+			return
+		}
+		token, username, text = form.Get("token"), form.Get("user_name"), form.Get("text")
+		// what we post comes back from slack as a bot message
+		isBot = len(form.Get("bot_id")) > 0 || username == "slackbot"
+
+		unescaper := strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+		text = unescaper.Replace(text)
+	}
+
+	if query := req.URL.Query().Get("token"); len(query) > 0 {
+		token = query
+	}
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.config.Token)) != 1 {
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+
+	if text = strings.TrimSpace(text); isBot || len(text) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(username) == 0 {
+		username = "someone"
+	}
+
+	text = fmt.Sprintf("<%s> %s", username, text)
+	if err := chat.CheckMessageLength(text); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.room.Send(r.room.NewTextMessage(text)); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,267 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtopala/p2pchat/version"
+)
+
+// how long sending the reports may take
+const sendTimeout = 10 * time.Second
+
+// most reports kept waiting, the oldest are dropped past it
+const maxReports = 20
+
+// most functions of the stack a crash signature names
+const maxFrames = 5
+
+// Report is what one run says about itself. It is coarse on purpose: counts
+// are rounded into ranges and crashes come down to where they happened, so
+// nothing in it tells who sent it, who they talk to or what about
+type Report struct {
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	// the command that ran, like chat or daemon
+	Command string `json:"command"`
+	// how peers were discovered, announce or advertise
+	Discovery string `json:"discovery"`
+	// whether the host could be reached from outside, as AutoNAT found
+	Reachability string `json:"reachability,omitempty"`
+	// range of the peers connected when the run ended
+	Peers string `json:"peers,omitempty"`
+	// range of how long the run went on
+	Uptime string `json:"uptime,omitempty"`
+	// where the run crashed, if it did
+	Crash string `json:"crash,omitempty"`
+}
+
+// Constructor function for the report of a run of the command, with
+// what this build is filled in
+func NewReport(command, discovery string) *Report {
+	info := version.Get()
+	return &Report{
+		Version:   info.Version,
+		Platform:  info.Platform,
+		Command:   command,
+		Discovery: discovery,
+	}
+}
+
+// Outbox keeps the reports until they are sent, in a file the user can read
+// first. What the file holds is exactly what is sent, nothing is added on the way
+type Outbox struct {
+	lock sync.Mutex
+	path string
+
+	Reports []Report `json:"reports"`
+}
+
+// This one loads the reports waiting in the given file, none if it doesn't exist yet
+func LoadOutbox(path string) (*Outbox, error) {
+	outbox := &Outbox{path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return outbox, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, outbox); err != nil {
+		return nil, fmt.Errorf("reading the waiting reports failed: %w", err)
+	}
+
+	return outbox, nil
+}
+
+// Method that adds a report to wait for the next send, dropping
+// the oldest ones past the most kept
+func (o *Outbox) Add(report Report) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.Reports = append(o.Reports, report)
+	if len(o.Reports) > maxReports {
+		o.Reports = o.Reports[len(o.Reports)-maxReports:]
+	}
+
+	return o.save()
+}
+
+// Method that returns what would be sent, as it would be sent,
+// nil when no reports are waiting
+func (o *Outbox) Payload() ([]byte, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.Reports) == 0 {
+		return nil, nil
+	}
+
+	return o.encode()
+}
+
+// Method that sends the waiting reports to the endpoint and forgets them once
+// it took them. Returns how many were sent
+func (o *Outbox) Send(ctx context.Context, endpoint string) (int, error) {
+	o.lock.Lock()
+	count := len(o.Reports)
+	payload, err := o.encode()
+	o.lock.Unlock()
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.UserAgent())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("sending the reports failed: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("sending the reports failed: %s", resp.Status)
+	}
+
+	// reports added while sending wait for the next time
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if count > len(o.Reports) {
+		count = len(o.Reports)
+	}
+	o.Reports = o.Reports[count:]
+	if len(o.Reports) > 0 {
+		return count, o.save()
+	}
+
+	err = os.Remove(o.path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+
+	return count, err
+}
+
+// Method that forgets the waiting reports without sending them.
+// Returns how many there were
+func (o *Outbox) Discard() (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	count := len(o.Reports)
+	o.Reports = nil
+
+	err := os.Remove(o.path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+
+	return count, err
+}
+
+// Method that returns the reports as they are kept and sent, readable,
+// expects the lock to be held
+func (o *Outbox) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	// the crash signatures read better with their < left alone
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(o); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Method that writes the reports to their file, expects the lock to be held
+func (o *Outbox) save() error {
+	data, err := o.encode()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(o.path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(o.path, data, 0600)
+}
+
+// This one returns the range a count falls in
+func Bucket(n int) string {
+	switch {
+	case n <= 1:
+		return fmt.Sprint(n)
+	case n < 5:
+		return "2-4"
+	case n < 10:
+		return "5-9"
+	case n < 50:
+		return "10-49"
+	case n < 100:
+		return "50-99"
+	default:
+		return "100+"
+	}
+}
+
+// This one returns the range a run of the given length falls in
+func UptimeBucket(d time.Duration) string {
+	switch {
+	case d < 10*time.Minute:
+		return "<10m"
+	case d < time.Hour:
+		return "10m-1h"
+	case d < 24*time.Hour:
+		return "1h-1d"
+	default:
+		return "1d+"
+	}
+}
+
+// This one returns the signature of a panic, to be called from the function
+// deferred to recover it: the type of what panicked and the innermost functions
+// it went through, like runtime.boundsError at tui.(*UI).handleCommand < tui.(*UI).Run.
+// What panicked isn't in it, it might say something about the user
+func Signature(value interface{}) string {
+	// past the function that recovered, the panic, and the runtime
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+
+	var funcs []string
+	for len(funcs) < maxFrames {
+		frame, more := frames.Next()
+		if name := frame.Function; len(name) > 0 && !strings.HasPrefix(name, "runtime.") {
+			funcs = append(funcs, name[strings.LastIndex(name, "/")+1:])
+		}
+		if !more {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%T at %s", value, strings.Join(funcs, " < "))
+}
@@ -0,0 +1,97 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// Method that returns the rooms joined at the moment, in the order of their tabs
+func (ui *UI) Rooms() []*chat.ChatRoom {
+	ui.tabsLock.Lock()
+	defer ui.tabsLock.Unlock()
+
+	rooms := make([]*chat.ChatRoom, len(ui.tabs))
+	for i, tab := range ui.tabs {
+		rooms[i] = tab.room
+	}
+
+	return rooms
+}
+
+// Method that joins a room in a new tab, leaving the room in view as it is,
+// or returns the room if we are in it already
+func (ui *UI) Join(roomName string) (*chat.ChatRoom, error) {
+	if tab := ui.findTab(roomName); tab != nil {
+		return tab.room, nil
+	}
+
+	tab, err := ui.joinTab(roomName)
+	if err != nil {
+		return nil, err
+	}
+	ui.Log(chat.ChatLog{Prefix: "api", Msg: fmt.Sprintf("joined room %s", tab.Name())})
+
+	return tab.room, nil
+}
+
+// Method that sends a text message to a joined room as if it was typed there,
+// returning the message as the room sees it
+func (ui *UI) Send(cr *chat.ChatRoom, text string) (chat.ChatMessage, error) {
+	tab := ui.findTab(cr.RoomName)
+	if tab == nil {
+		return chat.ChatMessage{}, fmt.Errorf("not in room %s", cr.RoomName)
+	}
+
+	text, err := ui.sendingHook(cr, text)
+	if err != nil {
+		return chat.ChatMessage{}, err
+	}
+	if err := chat.CheckMessageLength(text); err != nil {
+		return chat.ChatMessage{}, err
+	}
+
+	msg := cr.NewTextMessage(text)
+	msg.SenderID = cr.SelfID().Pretty()
+	msg.SenderName = cr.Username
+	msg.Sent = time.Now().Unix()
+
+	if err := cr.Send(msg); err != nil {
+		return chat.ChatMessage{}, err
+	}
+	ui.deliverOwn(cr, msg)
+	ui.roomEvents <- roomEvent{tab: tab, msg: &msg, self: true}
+
+	return msg, nil
+}
+
+// Method that hands a message received in a room to the API, if it's on
+func (ui *UI) deliver(cr *chat.ChatRoom, msg chat.ChatMessage) {
+	if ui.API == nil {
+		return
+	}
+
+	// muted peers are still there, we just don't look
+	if from, err := peer.Decode(msg.SenderID); err == nil && ui.Host.PeerLists.IsMuted(from) {
+		return
+	}
+
+	ui.API.Deliver(cr.RoomName, msg)
+}
+
+// Method that hands one of our own messages to the API, as the room will see it
+func (ui *UI) deliverOwn(cr *chat.ChatRoom, msg chat.ChatMessage) {
+	if ui.API == nil {
+		return
+	}
+
+	msg.SenderID = cr.SelfID().Pretty()
+	msg.SenderName = cr.Username
+	if msg.Sent == 0 {
+		msg.Sent = time.Now().Unix()
+	}
+
+	ui.API.Deliver(cr.RoomName, msg)
+}
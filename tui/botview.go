@@ -0,0 +1,45 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// This one formats a bot command for the message list
+func formatBotCommand(cmd *chat.BotCommand) string {
+	line := fmt.Sprintf("[::b]⚙ %s%s[::-]", chat.BotPrefix, cmd.Name)
+	if len(cmd.Args) > 0 {
+		line += " " + tview.Escape(strings.Join(cmd.Args, " "))
+	}
+
+	return line
+}
+
+// This one formats a bot response as a small card beneath its header.
+// Responses come from other peers, so everything in them is escaped
+func formatBotResponse(resp *chat.BotResponse, th *Theme) string {
+	lines := []string{fmt.Sprintf("[::b]⚙ %s%s[::-]", chat.BotPrefix, tview.Escape(resp.Command))}
+
+	if len(resp.Error) > 0 {
+		lines = append(lines, fmt.Sprintf("    [%s]│ %s[-]", th.Alert, tview.Escape(resp.Error)))
+	}
+
+	if len(resp.Text) > 0 {
+		for _, line := range strings.Split(resp.Text, "\n") {
+			lines = append(lines, fmt.Sprintf("    [%s]│[-] %s", th.Dim, tview.Escape(line)))
+		}
+	}
+
+	for i, field := range resp.Fields {
+		if i == chat.MaxResponseFields {
+			lines = append(lines, fmt.Sprintf("    [%s]│ …[-]", th.Dim))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("    [%s]│ %s:[-] %s", th.Dim, tview.Escape(field.Name), tview.Escape(field.Value)))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,377 @@
+package tui
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// a single line of the message list, kept around
+// so it can be re-rendered when it changes
+type bufferEntry struct {
+	// chat message ID, empty for logs
+	ID string
+
+	SenderID   string
+	SenderName string
+	Text       string
+
+	// ID of the message this one replies to, and the quote of it
+	// that came with the reply
+	ReplyTo string
+	Quote   *chat.ReplyQuote
+
+	// image shared with the message
+	Attachment *p2p.Attachment
+	// preview of the link in the message
+	Preview *chat.LinkPreview
+	// bot command or bot response carried by the message
+	Command  *chat.BotCommand
+	Response *chat.BotResponse
+
+	// poll asked with the message, and the votes by voter
+	Poll  *chat.Poll
+	Votes map[string]int
+
+	// message was sent by us
+	Self bool
+	// message was edited by its author
+	Edited bool
+	// message was retracted by its author
+	Deleted bool
+	// message mentions us
+	Mentioned bool
+	// when the message disappears, zero if it doesn't
+	Expires time.Time
+	// when the message was sent, or the log written
+	Time time.Time
+
+	// reactions to the message, senders by emoji
+	Reactions map[string][]string
+	// peers who have seen our message
+	SeenBy []string
+
+	// log prefix, set only for log lines
+	LogPrefix string
+	// the first line of the log is a warning that must not be missed
+	Alert bool
+}
+
+// This one returns when a message was sent, as the sender tells. Messages
+// that don't tell, or tell of a time yet to come, were sent just now
+func sentTime(msg chat.ChatMessage) time.Time {
+	now := time.Now()
+	if msg.Sent <= 0 {
+		return now
+	}
+
+	sent := time.Unix(msg.Sent, 0)
+	if sent.After(now) {
+		return now
+	}
+
+	return sent
+}
+
+// This one returns when a received or sent message disappears, zero if it doesn't
+func expiry(msg chat.ChatMessage) time.Time {
+	if msg.TTL <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(time.Duration(msg.TTL) * time.Second)
+}
+
+// Method that checks if the entry is a log line
+func (be *bufferEntry) IsLog() bool {
+	return len(be.LogPrefix) > 0
+}
+
+// Method that checks if the entry is a text message that can still be edited
+func (be *bufferEntry) IsEditable() bool {
+	return len(be.ID) > 0 && be.Attachment == nil && be.Poll == nil && be.Command == nil && be.Response == nil && !be.Deleted
+}
+
+// Method that checks if the entry is a poll which can be voted on
+func (be *bufferEntry) IsPoll() bool {
+	return be.Poll != nil && !be.Deleted
+}
+
+// Method that checks if the entry is a message which was not retracted
+func (be *bufferEntry) IsActive() bool {
+	return len(be.ID) > 0 && !be.Deleted
+}
+
+// Method that records a reaction, each sender counts once per emoji
+func (be *bufferEntry) AddReaction(emoji, senderID string) bool {
+	if be.Reactions == nil {
+		be.Reactions = make(map[string][]string)
+	}
+
+	for _, id := range be.Reactions[emoji] {
+		if id == senderID {
+			return false
+		}
+	}
+
+	be.Reactions[emoji] = append(be.Reactions[emoji], senderID)
+	return true
+}
+
+// Method that records a peer who has seen the message, each peer counts once
+func (be *bufferEntry) AddSeen(peerID string) bool {
+	for _, id := range be.SeenBy {
+		if id == peerID {
+			return false
+		}
+	}
+
+	be.SeenBy = append(be.SeenBy, peerID)
+	return true
+}
+
+// Method that records a vote, each voter counts once and can change their mind
+func (be *bufferEntry) Vote(voterID string, choice int) bool {
+	if be.Poll == nil || choice < 1 || choice > len(be.Poll.Options) {
+		return false
+	}
+
+	if be.Votes == nil {
+		be.Votes = make(map[string]int)
+	}
+
+	if be.Votes[voterID] == choice {
+		return false
+	}
+
+	be.Votes[voterID] = choice
+	return true
+}
+
+// messageBuffer holds everything shown in the message list
+type messageBuffer struct {
+	lock    sync.RWMutex
+	entries []*bufferEntry
+	byID    map[string]*bufferEntry
+	// entries dropped from the start to stay within the scrollback
+	trimmed int
+}
+
+// Constructor function for a new message buffer
+func newMessageBuffer() *messageBuffer {
+	return &messageBuffer{byID: make(map[string]*bufferEntry)}
+}
+
+// Method that appends an entry to the buffer. An entry reusing the ID of
+// another sender's message is shown, but can't be found by that ID, so
+// edits and retractions keep going to the message of the real author
+func (mb *messageBuffer) Append(entry *bufferEntry) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	mb.entries = append(mb.entries, entry)
+	if len(entry.ID) == 0 {
+		return
+	}
+	if known, ok := mb.byID[entry.ID]; ok && known.SenderID != entry.SenderID {
+		return
+	}
+	mb.byID[entry.ID] = entry
+}
+
+// Method that finds a chat message entry by its ID
+func (mb *messageBuffer) Get(id string) (*bufferEntry, bool) {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	entry, ok := mb.byID[id]
+	return entry, ok
+}
+
+// Method that updates an entry while holding the buffer lock
+func (mb *messageBuffer) Update(entry *bufferEntry, update func(*bufferEntry)) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	update(entry)
+}
+
+// Method that returns the newest chat message sent by us which matches the filter
+func (mb *messageBuffer) LastSelf(filter func(*bufferEntry) bool) (*bufferEntry, bool) {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	for i := len(mb.entries) - 1; i >= 0; i-- {
+		entry := mb.entries[i]
+		if entry.Self && filter(entry) {
+			return entry, true
+		}
+	}
+
+	return nil, false
+}
+
+// Method that returns the newest chat message which matches the filter
+func (mb *messageBuffer) Last(filter func(*bufferEntry) bool) (*bufferEntry, bool) {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	for i := len(mb.entries) - 1; i >= 0; i-- {
+		entry := mb.entries[i]
+		if !entry.IsLog() && filter(entry) {
+			return entry, true
+		}
+	}
+
+	return nil, false
+}
+
+// Method that returns the sender name and text of a message, for quoting it
+func (mb *messageBuffer) Quote(id string) (string, string, bool) {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	entry, ok := mb.byID[id]
+	if !ok || entry.Deleted {
+		return "", "", false
+	}
+
+	return entry.SenderName, entry.Text, true
+}
+
+// Method that follows the replies up to the message that started the thread
+func (mb *messageBuffer) ThreadRoot(id string) string {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	// a bounded walk, in case someone replies in circles
+	for hops := 0; hops < len(mb.entries); hops++ {
+		entry, ok := mb.byID[id]
+		if !ok || len(entry.ReplyTo) == 0 {
+			return id
+		}
+		id = entry.ReplyTo
+	}
+
+	return id
+}
+
+// Method that finds our own message by its full ID or an ID prefix
+func (mb *messageBuffer) FindSelf(id string) (*bufferEntry, bool) {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	for i := len(mb.entries) - 1; i >= 0; i-- {
+		entry := mb.entries[i]
+		if entry.Self && len(entry.ID) > 0 && strings.HasPrefix(entry.ID, id) {
+			return entry, true
+		}
+	}
+
+	return nil, false
+}
+
+// Method that returns a copy of all entries, oldest first
+func (mb *messageBuffer) Entries() []bufferEntry {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	entries := make([]bufferEntry, len(mb.entries))
+	for i, entry := range mb.entries {
+		entries[i] = *entry
+
+		// reactions, receipts and votes keep changing, so they are copied too
+		if entry.Reactions != nil {
+			entries[i].Reactions = make(map[string][]string, len(entry.Reactions))
+			for emoji, senders := range entry.Reactions {
+				entries[i].Reactions[emoji] = append([]string(nil), senders...)
+			}
+		}
+		if entry.SeenBy != nil {
+			entries[i].SeenBy = append([]string(nil), entry.SeenBy...)
+		}
+		if entry.Votes != nil {
+			entries[i].Votes = make(map[string]int, len(entry.Votes))
+			for voter, choice := range entry.Votes {
+				entries[i].Votes[voter] = choice
+			}
+		}
+	}
+
+	return entries
+}
+
+// Method that removes the entries which expired by now, returning them
+func (mb *messageBuffer) Expire(now time.Time) []*bufferEntry {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	var expired []*bufferEntry
+	entries := mb.entries[:0]
+	for _, entry := range mb.entries {
+		if entry.Expires.IsZero() || now.Before(entry.Expires) {
+			entries = append(entries, entry)
+			continue
+		}
+
+		expired = append(expired, entry)
+		if mb.byID[entry.ID] == entry {
+			delete(mb.byID, entry.ID)
+		}
+	}
+
+	// the tail still holds the expired entries
+	for i := len(entries); i < len(mb.entries); i++ {
+		mb.entries[i] = nil
+	}
+	mb.entries = entries
+
+	return expired
+}
+
+// Method that drops the oldest entries past the limit. It waits for a tenth
+// more entries than the limit first, so the buffer is trimmed every so often
+// rather than with every entry. Returns the dropped entries, none without a limit
+func (mb *messageBuffer) Trim(limit int) []*bufferEntry {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if limit <= 0 || len(mb.entries) <= limit+limit/10 {
+		return nil
+	}
+
+	count := len(mb.entries) - limit
+	dropped := append([]*bufferEntry(nil), mb.entries[:count]...)
+	for _, entry := range dropped {
+		if len(entry.ID) > 0 && mb.byID[entry.ID] == entry {
+			delete(mb.byID, entry.ID)
+		}
+	}
+
+	// copied over, so the dropped entries aren't held on to
+	mb.entries = append([]*bufferEntry(nil), mb.entries[count:]...)
+	mb.trimmed += count
+
+	return dropped
+}
+
+// Method that returns how many entries were dropped to stay within the scrollback
+func (mb *messageBuffer) Trimmed() int {
+	mb.lock.RLock()
+	defer mb.lock.RUnlock()
+
+	return mb.trimmed
+}
+
+// Method that empties the buffer
+func (mb *messageBuffer) Clear() {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	mb.entries = nil
+	mb.byID = make(map[string]*bufferEntry)
+	mb.trimmed = 0
+}
@@ -0,0 +1,100 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// a Tab completion in progress, cycling through its candidates
+type completion struct {
+	// input text before the word being completed
+	head string
+	// words the completed one can become, in order
+	candidates []string
+	// candidate shown last
+	index int
+	// input text after the last completion, anything else starts over
+	last string
+}
+
+// This one starts completing the last word of the input text with the
+// candidates its prefix matches. Returns nil if nothing matches
+func newCompletion(text string, candidates func(word string, first bool) []string) *completion {
+	i := strings.LastIndex(text, " ") + 1
+	head, word := text[:i], text[i:]
+	if len(word) == 0 {
+		return nil
+	}
+
+	var matches []string
+	for _, candidate := range candidates(word, len(strings.TrimSpace(head)) == 0) {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(word)) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	sort.Strings(matches)
+	return &completion{head: head, candidates: matches, index: -1}
+}
+
+// Method that returns the input text with the next candidate in place
+func (c *completion) Next() string {
+	c.index = (c.index + 1) % len(c.candidates)
+	c.last = c.head + c.candidates[c.index] + " "
+
+	return c.last
+}
+
+// This one returns the names of every command, aliases included
+func commandNames() []string {
+	var names []string
+	for _, cmd := range helpCommands {
+		for _, field := range strings.Fields(cmd.usage) {
+			if strings.HasPrefix(field, "/") {
+				names = append(names, field)
+			}
+		}
+	}
+
+	return names
+}
+
+// Method that returns what the word being typed could be completed to,
+// commands at the start of the input and @usernames of the room peers
+func (ui *UI) completionCandidates(word string, first bool) []string {
+	if first && strings.HasPrefix(word, "/") {
+		return commandNames()
+	}
+
+	if !strings.HasPrefix(word, "@") {
+		return nil
+	}
+
+	collisions := ui.UsernameCollisions()
+	seen := make(map[string]bool)
+
+	var names []string
+	for _, p := range ui.GetPeers() {
+		prof := ui.Host.Profiles.Lookup(p)
+		// names that can't be mentioned are no use completed
+		if prof == nil || chat.MentionPattern.FindString("@"+prof.Username) != "@"+prof.Username {
+			continue
+		}
+
+		name := prof.Username
+		if collisions[name] {
+			name = chat.Disambiguate(name, p.Pretty())
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, "@"+name)
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,78 @@
+package tui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// how often to look for peers again while a room has none left
+const reconnectEvery = 30 * time.Second
+
+// Method that keeps an eye on the connection of every joined room. A room is
+// disconnected when its subscription is lost, or when every peer in it went
+// away, and is back once peers are. The room in view gets a banner for as
+// long as it's disconnected, and we look for peers again meanwhile.
+// Runs on the event loop
+func (ui *UI) syncConnection() {
+	ui.tabsLock.Lock()
+	tabs := append([]*roomTab(nil), ui.tabs...)
+	active := ui.active
+	ui.tabsLock.Unlock()
+
+	lost := false
+	anyLost := false
+	for _, tab := range tabs {
+		peers := len(tab.room.GetPeers())
+		disconnected := tab.room.SubscriptionLost() || (tab.hadPeers && peers == 0)
+		if peers > 0 {
+			tab.hadPeers = true
+		}
+
+		if tab.disconnected && !disconnected {
+			// the recovery goes where the silence was
+			go func(tab *roomTab) {
+				ui.Log(chat.ChatLog{Prefix: "net", Msg: fmt.Sprintf("back in touch with %s", tab.Name())})
+			}(tab)
+		}
+		tab.disconnected = disconnected
+
+		anyLost = anyLost || disconnected
+		if tab == active {
+			lost = disconnected
+		}
+	}
+
+	if anyLost && time.Since(ui.lastReconnect) >= reconnectEvery {
+		ui.lastReconnect = time.Now()
+		go func() {
+			if err := ui.Host.Reconnect(); err != nil {
+				ui.Log(chat.ChatLog{Prefix: "neterr", Msg: fmt.Sprintf("could not look for peers: %s", err)})
+			}
+		}()
+	}
+
+	ui.TerminalApp.QueueUpdate(func() {
+		ui.showBanner(lost)
+	})
+}
+
+// Method that shows the disconnected banner above the messages, or
+// hides it. Runs on the UI goroutine, since it changes the layout
+func (ui *UI) showBanner(shown bool) {
+	if shown == ui.bannerShown {
+		return
+	}
+	ui.bannerShown = shown
+	ui.draws.Request()
+
+	if !shown {
+		ui.layout.ResizeItem(ui.banner, 0, 0)
+		return
+	}
+
+	th := ui.theme
+	ui.banner.SetText(fmt.Sprintf("[%s:%s] disconnected — reconnecting… [-:-]", th.Text, th.Alert))
+	ui.layout.ResizeItem(ui.banner, 1, 0)
+}
@@ -0,0 +1,94 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// Method that starts a direct conversation with a peer,
+// or shows the one we already have
+func (ui *UI) startDM(id peer.ID) {
+	if id == ui.SelfID() {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "talking to yourself takes no direct messages"})
+		return
+	}
+
+	if tab := ui.findTab(p2p.DMRoomName(ui.SelfID(), id)); tab != nil {
+		ui.requestTab(tab, false)
+		return
+	}
+
+	ui.Log(chat.ChatLog{Prefix: "dm", Msg: fmt.Sprintf("inviting %s to a direct conversation", ui.peerName(id))})
+
+	ctx, cancel := context.WithTimeout(ui.Host.Ctx, p2p.DMTimeout)
+	defer cancel()
+
+	invite, err := ui.Host.DMs.Invite(ctx, id)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "dmerr", Msg: fmt.Sprintf("could not invite %s: %s", ui.peerName(id), err)})
+		return
+	}
+
+	ui.openDM(invite, id, true)
+}
+
+// Method that joins the conversation another peer invited us to, without
+// pulling us away from the room in view
+func (ui *UI) acceptDM(invite *p2p.DMInvite) {
+	if ui.openDM(invite, invite.From, false) {
+		ui.Log(chat.ChatLog{Prefix: "dm", Msg: fmt.Sprintf("%s started a direct conversation with you, it has a tab of its own", ui.peerName(invite.From))})
+	}
+}
+
+// Method that joins the room of a direct conversation in a tab of its own,
+// and shows it if asked to. A conversation we already have takes the new
+// secret, as the peer started over. Reports if a new tab was opened
+func (ui *UI) openDM(invite *p2p.DMInvite, with peer.ID, show bool) bool {
+	if tab := ui.findTab(invite.Room); tab != nil {
+		if err := tab.room.SetPassword(invite.Secret); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "dmerr", Msg: fmt.Sprintf("could not lock the conversation: %s", err)})
+		}
+		if show {
+			ui.requestTab(tab, false)
+		}
+		return false
+	}
+
+	cr, err := chat.JoinChatRoom(ui.Host, ui.Username, invite.Room)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "dmerr", Msg: fmt.Sprintf("could not join the conversation: %s", err)})
+		return false
+	}
+	cr.TTL = ui.TTL
+	// the conversation stays connected while the peer is away from it
+	cr.ProtectPeer(with)
+
+	// nothing is said before the room is locked
+	if err := cr.SetPassword(invite.Secret); err != nil {
+		cr.Leave()
+		ui.Log(chat.ChatLog{Prefix: "dmerr", Msg: fmt.Sprintf("could not lock the conversation: %s", err)})
+		return false
+	}
+
+	tab := ui.addTab(cr, "@"+ui.peerName(with))
+
+	if show {
+		ui.requestTab(tab, false)
+	}
+
+	return true
+}
+
+// Method that returns the name a peer goes by, its short ID if we don't know it,
+// along with our own name for it
+func (ui *UI) peerName(id peer.ID) string {
+	if prof := ui.Host.Profiles.Lookup(id); prof != nil && len(prof.Username) > 0 {
+		return ui.withPetname(id, prof.Username)
+	}
+
+	return ui.withPetname(id, p2p.ShortID(id))
+}
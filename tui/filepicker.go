@@ -0,0 +1,103 @@
+package tui
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// Method that shows a file browser above the chat, starting in the directory
+// a file was last picked from. Enter opens directories and picks files,
+// Backspace goes up a directory and Esc closes it. The picked file goes to
+// the given function on a goroutine of its own, so it can take its time.
+// Runs on the UI goroutine
+func (ui *UI) pickFile(title string, pick func(path string)) {
+	if len(ui.pickDir) == 0 {
+		ui.pickDir, _ = os.Getwd()
+	}
+
+	list := tview.NewList().
+		ShowSecondaryText(false).
+		SetHighlightFullLine(true)
+	list.SetBorder(true)
+
+	done := func() {
+		ui.pages.RemovePage("pick-file")
+		ui.TerminalApp.SetFocus(ui.inputField)
+	}
+
+	var open func(dir string)
+	open = func(dir string) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			go func() {
+				ui.Log(chat.ChatLog{Prefix: "fileerr", Msg: fmt.Sprintf("could not open %s: %s", dir, err)})
+			}()
+			return
+		}
+		ui.pickDir = dir
+
+		// directories first, and nothing hidden
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].IsDir() && !entries[j].IsDir()
+		})
+
+		list.Clear()
+		list.SetTitle(fmt.Sprintf("%s — %s — Esc to close", title, tview.Escape(dir)))
+
+		if parent := filepath.Dir(dir); parent != dir {
+			list.AddItem("../", "", 0, func() { open(parent) })
+		}
+
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				list.AddItem(tview.Escape(entry.Name()+"/"), "", 0, func() { open(path) })
+				continue
+			}
+
+			// only regular files can be sent, not devices or sockets
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+
+			label := fmt.Sprintf("%s [%s]%s[-]", tview.Escape(entry.Name()), ui.theme.Dim, p2p.FormatSize(entry.Size()))
+			list.AddItem(label, "", 0, func() {
+				done()
+				go pick(path)
+			})
+		}
+	}
+
+	list.SetDoneFunc(done)
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyBackspace2 {
+			open(filepath.Dir(ui.pickDir))
+			return nil
+		}
+		return event
+	})
+
+	open(ui.pickDir)
+
+	// a box in the middle, the chat stays in sight around it
+	modal := tview.NewGrid().
+		SetColumns(0, 70, 0).
+		SetRows(0, 20, 0).
+		AddItem(list, 1, 1, 1, 1, 0, 0, true)
+
+	ui.pages.AddPage("pick-file", modal, true, true)
+	ui.TerminalApp.SetFocus(list)
+}
@@ -0,0 +1,90 @@
+package tui
+
+import (
+	"strings"
+)
+
+// This one checks if a message contains the text being looked for,
+// ignoring case. Only messages that are regions can be jumped to
+func findMatch(entry bufferEntry, query string) bool {
+	if entry.IsLog() || entry.Deleted || !messageRegionPattern.MatchString(entry.ID) {
+		return false
+	}
+
+	return strings.Contains(strings.ToLower(entry.Text), strings.ToLower(query))
+}
+
+// Method that looks for the text in the messages in view, marking the ones
+// containing it and highlighting the newest of them. Empty text stops looking.
+// Returns the number of matches. Runs on the UI goroutine, as input handlers do
+func (ui *UI) findText(query string) int {
+	ui.renderLock.Lock()
+	ui.findQuery = query
+	ui.findMatches = nil
+	if len(query) > 0 {
+		for _, entry := range ui.buffer.Entries() {
+			if ui.inView(entry) && findMatch(entry, query) {
+				ui.findMatches = append(ui.findMatches, entry.ID)
+			}
+		}
+	}
+	ui.findIndex = len(ui.findMatches) - 1
+	matches := len(ui.findMatches)
+	ui.renderLock.Unlock()
+
+	ui.rerender()
+	ui.showFound()
+
+	return matches
+}
+
+// Method that moves the highlight to another match, older ones for negative
+// offsets, wrapping around. Runs on the UI goroutine, as input handlers do
+func (ui *UI) findNext(offset int) {
+	ui.renderLock.Lock()
+	if count := len(ui.findMatches); count > 0 {
+		ui.findIndex = ((ui.findIndex+offset)%count + count) % count
+	}
+	ui.renderLock.Unlock()
+
+	ui.showFound()
+}
+
+// Method that stops looking, and goes back to the latest messages
+func (ui *UI) stopFind() {
+	ui.findText("")
+	ui.scrollToLatest()
+}
+
+// Method that returns the text being looked for, which match is
+// highlighted counting from the oldest one, and how many there are
+func (ui *UI) findState() (string, int, int) {
+	ui.renderLock.Lock()
+	defer ui.renderLock.Unlock()
+
+	return ui.findQuery, ui.findIndex + 1, len(ui.findMatches)
+}
+
+// Method that highlights the current match and scrolls to it
+func (ui *UI) showFound() {
+	ui.renderLock.Lock()
+	current := ""
+	if ui.findIndex >= 0 && ui.findIndex < len(ui.findMatches) {
+		current = ui.findMatches[ui.findIndex]
+	}
+	ui.renderLock.Unlock()
+
+	if len(current) == 0 {
+		ui.messageList.Highlight()
+		ui.updateTitle()
+		return
+	}
+
+	ui.messageList.Highlight(current).ScrollToHighlight()
+
+	// the list stays on the match while new messages arrive
+	ui.scrollLock.Lock()
+	ui.scrolledBack = true
+	ui.scrollLock.Unlock()
+	ui.updateTitle()
+}
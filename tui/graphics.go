@@ -0,0 +1,211 @@
+package tui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// terminal graphics protocols used for image previews
+const (
+	graphicsNone  = "none"
+	graphicsKitty = "kitty"
+	graphicsSixel = "sixel"
+)
+
+// widest preview we draw, in pixels
+const maxPreviewWidth = 480
+
+// widest and tallest image we decode for a preview, in pixels. Larger
+// ones would take far more memory than the image file itself
+const maxImageSide = 4096
+
+// size of a single kitty graphics payload chunk
+const kittyChunkSize = 4096
+
+// This one resolves the graphics protocol to use, detecting
+// the terminal capabilities when set to "auto"
+func DetectGraphics(mode string) string {
+	switch mode {
+	case graphicsKitty, graphicsSixel, graphicsNone:
+		return mode
+	}
+
+	term := os.Getenv("TERM")
+	termProgram := os.Getenv("TERM_PROGRAM")
+
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", strings.Contains(term, "kitty"),
+		termProgram == "WezTerm", termProgram == "ghostty":
+		return graphicsKitty
+	case strings.Contains(term, "sixel"), strings.HasPrefix(term, "foot"),
+		strings.HasPrefix(term, "mlterm"), strings.HasPrefix(term, "contour"):
+		return graphicsSixel
+	default:
+		return graphicsNone
+	}
+}
+
+// This one draws the encoded image to w using the given graphics protocol
+func drawImage(w io.Writer, data []byte, mode string) error {
+	// the header tells how large the image is before it's decoded
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if config.Width > maxImageSide || config.Height > maxImageSide {
+		return fmt.Errorf("image is %dx%d pixels, the limit is %dx%d", config.Width, config.Height, maxImageSide, maxImageSide)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	img = scaleImage(img, maxPreviewWidth)
+
+	switch mode {
+	case graphicsKitty:
+		return drawKitty(w, img)
+	case graphicsSixel:
+		return drawSixel(w, img)
+	default:
+		return fmt.Errorf("terminal does not support image previews")
+	}
+}
+
+// This one scales the image down to the given width, keeping its aspect ratio
+func scaleImage(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= width {
+		return img
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// nearest neighbour is plenty for a preview
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			srcX := bounds.Min.X + x*bounds.Dx()/width
+			srcY := bounds.Min.Y + y*bounds.Dy()/height
+			scaled.Set(x, y, img.At(srcX, srcY))
+		}
+	}
+
+	return scaled
+}
+
+// This one draws the image with the kitty graphics protocol,
+// transmitting it as PNG in base64 encoded chunks
+func drawKitty(w io.Writer, img image.Image) error {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return err
+	}
+
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for first := true; len(payload) > 0; first = false {
+		chunk := payload
+		if len(chunk) > kittyChunkSize {
+			chunk = chunk[:kittyChunkSize]
+		}
+		payload = payload[len(chunk):]
+
+		more := 0
+		if len(payload) > 0 {
+			more = 1
+		}
+
+		var err error
+		if first {
+			_, err = fmt.Fprintf(w, "\x1b_Gf=100,a=T,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			_, err = fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// This one draws the image as sixels, after quantizing it to a 256 color palette
+func drawSixel(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, bounds.Dx(), bounds.Dy()), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, bounds.Min)
+
+	out := &bytes.Buffer{}
+	out.WriteString("\x1bPq")
+
+	// palette definitions, with components in percent
+	for i, c := range paletted.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	width, height := paletted.Rect.Dx(), paletted.Rect.Dy()
+	for band := 0; band < height; band += 6 {
+		// which colors appear in this band at all
+		used := make(map[uint8]bool)
+		for y := band; y < band+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				used[paletted.ColorIndexAt(x, y)] = true
+			}
+		}
+
+		for idx := range used {
+			fmt.Fprintf(out, "#%d", idx)
+
+			// run length encode the sixels of this color
+			var last byte
+			run := 0
+			flush := func() {
+				switch {
+				case run > 3:
+					fmt.Fprintf(out, "!%d%c", run, last)
+				case run > 0:
+					out.WriteString(strings.Repeat(string(last), run))
+				}
+			}
+
+			for x := 0; x < width; x++ {
+				var bits byte
+				for bit := 0; bit < 6 && band+bit < height; bit++ {
+					if paletted.ColorIndexAt(x, band+bit) == idx {
+						bits |= 1 << bit
+					}
+				}
+
+				sixel := bits + 63
+				if sixel == last && run > 0 {
+					run++
+					continue
+				}
+				flush()
+				last, run = sixel, 1
+			}
+			flush()
+
+			// back to the start of the band for the next color
+			out.WriteByte('$')
+		}
+
+		out.WriteByte('-')
+	}
+
+	out.WriteString("\x1b\\\n")
+
+	_, err := out.WriteTo(w)
+	return err
+}
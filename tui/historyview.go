@@ -0,0 +1,238 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/archive"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// messages on each page of the history browser
+const historyPageSize = 50
+
+// This one parses the date range of the history command, days like
+// 2006-01-02. The range ends with the last day given, and stays open
+// at either end without one
+func parseDateRange(args []string) (time.Time, time.Time, error) {
+	var from, to time.Time
+	if len(args) > 2 {
+		return from, to, fmt.Errorf("usage: /history [room] [from] [to]")
+	}
+
+	for i, arg := range args {
+		day, err := time.ParseInLocation(dayLayout, arg, time.Local)
+		if err != nil {
+			return from, to, fmt.Errorf("dates look like %s", dayLayout)
+		}
+		if i == 0 {
+			from = day
+		} else {
+			to = day.AddDate(0, 0, 1)
+		}
+	}
+
+	if !to.IsZero() && !to.After(from) {
+		return from, to, fmt.Errorf("the range ends before it starts")
+	}
+
+	return from, to, nil
+}
+
+// Method that handles the history command: without arguments it lists the
+// rooms with kept messages, with a room and optional dates it browses them
+func (ui *UI) handleHistory(arg string) {
+	if ui.History == nil {
+		ui.Log(chat.ChatLog{Prefix: "history", Msg: "no history is kept, start with -history to keep one"})
+		return
+	}
+
+	rooms := ui.History.Rooms()
+	if len(rooms) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "history", Msg: "no messages kept yet"})
+		return
+	}
+
+	args := strings.Fields(arg)
+	if len(args) == 0 {
+		ui.queueUpdateDraw(func() {
+			ui.showHistoryRooms(rooms)
+		})
+		return
+	}
+
+	// the room can be left out for the one in view
+	room := ui.RoomName
+	if _, err := time.Parse(dayLayout, args[0]); err != nil {
+		room, args = args[0], args[1:]
+	}
+
+	// rooms go by their names, the fingerprint of created ones is optional
+	found := ""
+	for _, kept := range rooms {
+		name, _ := p2p.SplitRoomName(kept)
+		if kept == room || name == room {
+			found = kept
+		}
+	}
+	if len(found) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no messages kept for %s", room)})
+		return
+	}
+
+	from, to, err := parseDateRange(args)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+		return
+	}
+
+	records := ui.History.Range(found, from, to)
+	if len(records) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "history", Msg: fmt.Sprintf("no messages kept for %s in that range", room)})
+		return
+	}
+
+	ui.queueUpdateDraw(func() {
+		ui.showHistory(found, records)
+	})
+}
+
+// Method that lists the rooms with kept messages, Enter browses the
+// selected one and Esc closes the list. Runs on the UI goroutine
+func (ui *UI) showHistoryRooms(rooms []string) {
+	list := tview.NewList().
+		SetHighlightFullLine(true)
+	list.SetBorder(true).
+		SetTitle("History — Enter to browse a room, Esc to close")
+
+	for _, room := range rooms {
+		room := room
+		records := ui.History.Range(room, time.Time{}, time.Time{})
+		if len(records) == 0 {
+			continue
+		}
+
+		name, _ := p2p.SplitRoomName(room)
+		first := time.Unix(records[0].Sent, 0).Format(dayLayout)
+		last := time.Unix(records[len(records)-1].Sent, 0).Format(dayLayout)
+		span := fmt.Sprintf("  %d messages, %s to %s", len(records), first, last)
+
+		list.AddItem(tview.Escape(name), span, 0, func() {
+			ui.pages.RemovePage("history-rooms")
+			ui.showHistory(room, records)
+		})
+	}
+
+	list.SetDoneFunc(func() {
+		ui.pages.RemovePage("history-rooms")
+		ui.TerminalApp.SetFocus(ui.inputField)
+	})
+
+	ui.pages.AddPage("history-rooms", list, true, true)
+	ui.TerminalApp.SetFocus(list)
+}
+
+// Method that browses kept messages a page at a time, starting with the
+// latest. PageUp and PageDown, or the left and right arrows, turn the
+// pages, and Esc closes the browser. Runs on the UI goroutine
+func (ui *UI) showHistory(room string, records []chat.HistoryRecord) {
+	name, _ := p2p.SplitRoomName(room)
+	pages := (len(records) + historyPageSize - 1) / historyPageSize
+	page := pages - 1
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true)
+	view.SetBorder(true)
+
+	render := func() {
+		from := page * historyPageSize
+		to := from + historyPageSize
+		if to > len(records) {
+			to = len(records)
+		}
+
+		text := &strings.Builder{}
+		day := ""
+		for _, rec := range records[from:to] {
+			sent := time.Unix(rec.Sent, 0)
+			if sent.Format(dayLayout) != day {
+				day = sent.Format(dayLayout)
+				fmt.Fprintf(text, "[%s]%s[-]\n", ui.theme.Dim, formatDay(sent))
+			}
+			fmt.Fprintf(text, "%s [%s]<%s>:[-] %s\n", sent.Format("15:04"), ui.theme.peerColor(rec.SenderID), tview.Escape(rec.SenderName), tview.Escape(rec.Text))
+		}
+
+		view.SetText(text.String())
+		view.ScrollToEnd()
+		view.SetTitle(tview.Escape(fmt.Sprintf("%s — page %d of %d, PageUp and PageDown to turn, Esc to close", name, page+1, pages)))
+	}
+
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyPgUp, tcell.KeyLeft:
+			if page > 0 {
+				page--
+				render()
+			}
+			return nil
+		case tcell.KeyPgDn, tcell.KeyRight:
+			if page < pages-1 {
+				page++
+				render()
+			}
+			return nil
+		case tcell.KeyEscape:
+			ui.pages.RemovePage("history")
+			ui.TerminalApp.SetFocus(ui.inputField)
+			return nil
+		}
+		return event
+	})
+
+	render()
+	ui.pages.AddPage("history", view, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
+
+// Method that handles the sync command, fetching what an archive peer kept
+// of the room in view since the newest message we kept, into the history
+func (ui *UI) handleSync(arg string) {
+	if ui.History == nil {
+		ui.Log(chat.ChatLog{Prefix: "sync", Msg: "no history is kept, start with -history to keep one"})
+		return
+	}
+
+	if len(arg) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /sync <archive peer>"})
+		return
+	}
+
+	target, err := ui.FindPeer(arg)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no peer matching %s in the room", arg)})
+		return
+	}
+
+	// the tab in view may change while the archive answers
+	cr := ui.ChatRoom
+	var since time.Time
+	if kept := ui.History.Range(cr.RoomName, time.Time{}, time.Time{}); len(kept) > 0 {
+		since = time.Unix(kept[len(kept)-1].Sent, 0)
+	}
+
+	ui.Log(chat.ChatLog{Prefix: "sync", Msg: fmt.Sprintf("asking %s for the history of %s", p2p.ShortID(target), cr.RoomName)})
+	go func() {
+		count, err := archive.Sync(cr.Context(), ui.Host.Host, target, cr.RoomName, since, ui.History)
+		if err != nil {
+			cr.Log(chat.ChatLog{Prefix: "syncerr", Msg: fmt.Sprintf("could not sync with %s: %s", p2p.ShortID(target), err)})
+			return
+		}
+
+		cr.Log(chat.ChatLog{Prefix: "sync", Msg: fmt.Sprintf("got %d messages from %s, /history to browse them", count, p2p.ShortID(target))})
+	}()
+}
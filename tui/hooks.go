@@ -0,0 +1,111 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/hooks"
+)
+
+// Method that runs the hook of an event in a room, if there is one. Returns
+// false when there is none or it couldn't run, which goes to the log of the room
+func (ui *UI) runHook(cr *chat.ChatRoom, ev hooks.Event) (hooks.Result, bool) {
+	if !ui.Hooks.Has(ev.Event) {
+		return hooks.Result{}, false
+	}
+
+	ev.Room = cr.RoomName
+	result, err := ui.Hooks.Run(cr.Context(), ev)
+	if err != nil {
+		cr.Log(chat.ChatLog{Prefix: "hookerr", Msg: err.Error()})
+		return hooks.Result{}, false
+	}
+
+	return result, true
+}
+
+// Method that runs the message-received hook on a message received in a room,
+// sending what it answers to the room. Returns false if the hook rejected the
+// message, which is then kept out of sight
+func (ui *UI) receivedHook(cr *chat.ChatRoom, msg chat.ChatMessage) bool {
+	if !isShownMessage(msg.Type) {
+		return true
+	}
+	// muted peers don't get answers either
+	if from, err := peer.Decode(msg.SenderID); err == nil && ui.Host.PeerLists.IsMuted(from) {
+		return true
+	}
+
+	result, ok := ui.runHook(cr, hooks.Event{Event: hooks.MessageReceived, Message: &msg})
+	if !ok {
+		return true
+	}
+	if result.Rejected {
+		return false
+	}
+
+	if len(result.Output) > 0 {
+		go ui.sendHookOutput(cr, result.Output)
+	}
+
+	return true
+}
+
+// Method that runs the message-sending hook on text about to be sent to a room,
+// returning the text to send instead if the hook changed it, or an error if
+// the hook rejected it
+func (ui *UI) sendingHook(cr *chat.ChatRoom, text string) (string, error) {
+	msg := chat.ChatMessage{Type: chat.MessageText, Message: text, SenderID: cr.SelfID().Pretty(), SenderName: cr.Username}
+
+	result, ok := ui.runHook(cr, hooks.Event{Event: hooks.MessageSending, Message: &msg})
+	switch {
+	case !ok:
+		return text, nil
+	case result.Rejected && len(result.Output) > 0:
+		return "", fmt.Errorf("the %s hook kept the message back: %s", hooks.MessageSending, result.Output)
+	case result.Rejected:
+		return "", fmt.Errorf("the %s hook kept the message back", hooks.MessageSending)
+	case len(result.Output) > 0:
+		return result.Output, nil
+	default:
+		return text, nil
+	}
+}
+
+// Method that runs the peer-joined hook for a peer that joined a room,
+// sending what it answers to the room
+func (ui *UI) joinedHook(cr *chat.ChatRoom, id peer.ID) {
+	ev := hooks.Event{Event: hooks.PeerJoined, PeerID: id.Pretty()}
+	if prof := ui.Host.Profiles.Lookup(id); prof != nil {
+		ev.PeerName = prof.Username
+	}
+
+	result, ok := ui.runHook(cr, ev)
+	if ok && !result.Rejected && len(result.Output) > 0 {
+		ui.sendHookOutput(cr, result.Output)
+	}
+}
+
+// Method that runs a command typed in the input, once the command-invoked
+// hook had its say. What the hook answers goes to the log, and if it
+// rejected the command the command doesn't run
+func (ui *UI) invokeCommand(cmd uiCommand) {
+	result, ok := ui.runHook(ui.ChatRoom, hooks.Event{Event: hooks.CommandInvoked, Command: cmd.cmdtype, Args: cmd.cmdarg})
+	if ok && len(result.Output) > 0 {
+		ui.Log(chat.ChatLog{Prefix: "hook", Msg: result.Output})
+	}
+	if ok && result.Rejected {
+		return
+	}
+
+	cmd.hooked = ok
+	ui.handleCommand(cmd)
+}
+
+// Method that sends what a hook answered to a room, as our own message
+func (ui *UI) sendHookOutput(cr *chat.ChatRoom, text string) {
+	if _, err := ui.Send(cr, text); err != nil {
+		cr.Log(chat.ChatLog{Prefix: "hookerr", Msg: fmt.Sprintf("could not send what the hook answered: %s", err)})
+	}
+}
@@ -0,0 +1,105 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// keymaps the chat can be used with
+const (
+	KeymapDefault = "default"
+	KeymapVim     = "vim"
+)
+
+// label of the input while the message list is in normal mode
+const normalLabel = "-- NORMAL -- "
+
+// Method that switches to another keymap. With the vim one, Esc leaves the
+// input for normal mode, where the message list takes the keys. Runs on the
+// UI goroutine, or before the application does
+func (ui *UI) SetKeymap(name string) error {
+	switch name {
+	case KeymapDefault, "":
+		ui.vimKeys = false
+	case KeymapVim:
+		ui.vimKeys = true
+	default:
+		return fmt.Errorf("no keymap %s, use %s or %s", name, KeymapDefault, KeymapVim)
+	}
+
+	return nil
+}
+
+// Method that returns the keymap in use. Runs on the UI goroutine
+func (ui *UI) keymap() string {
+	if ui.vimKeys {
+		return KeymapVim
+	}
+
+	return KeymapDefault
+}
+
+// Method that handles the keys of normal mode: j and k scroll a line, Ctrl+D
+// and Ctrl+U half a page, gg goes to the oldest message and G to the latest.
+// / finds text, n and N go through the matches, the arrows pick a message and
+// r replies to it, : starts a command, and i, a or Enter go back to typing.
+// Runs on the UI goroutine, as input handlers do
+func (ui *UI) normalKey(event *tcell.EventKey, insert func(text string), find func(), reply func()) *tcell.EventKey {
+	_, _, _, height := ui.messageList.GetInnerRect()
+
+	// g only counts twice in a row
+	pendingG := ui.pendingG
+	ui.pendingG = false
+
+	switch event.Key() {
+	case tcell.KeyCtrlD:
+		ui.scrollMessages(height / 2)
+	case tcell.KeyCtrlU:
+		ui.scrollMessages(-height / 2)
+	case tcell.KeyPgDn:
+		ui.scrollMessages(height)
+	case tcell.KeyPgUp:
+		ui.scrollMessages(-height)
+	case tcell.KeyUp:
+		ui.selectMessage(-1)
+	case tcell.KeyDown:
+		ui.selectMessage(1)
+	case tcell.KeyCtrlF:
+		find()
+	case tcell.KeyEnter:
+		insert("")
+	case tcell.KeyEscape:
+		ui.stopFind()
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case 'j':
+			ui.scrollMessages(1)
+		case 'k':
+			ui.scrollMessages(-1)
+		case 'g':
+			if !pendingG {
+				ui.pendingG = true
+				break
+			}
+			row, _ := ui.messageList.GetScrollOffset()
+			ui.scrollMessages(-row)
+		case 'G':
+			ui.stopFind()
+		case '/':
+			find()
+		case 'n':
+			ui.findNext(-1)
+		case 'N':
+			ui.findNext(1)
+		case 'r':
+			reply()
+		case ':':
+			insert("/")
+		case 'i', 'a':
+			insert("")
+		}
+	}
+
+	return nil
+}
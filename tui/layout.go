@@ -0,0 +1,240 @@
+package tui
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// columns of the peer list and rows of the log pane, borders included
+const (
+	defaultPeerWidth = 20
+	minPeerWidth     = 10
+	maxPeerWidth     = 60
+
+	defaultLogHeight = 8
+	minLogHeight     = 3
+	maxLogHeight     = 30
+)
+
+// how much a pane grows or shrinks with each key press
+const paneStep = 2
+
+// how big the peer list and the log pane are, and whether they show at all
+type PaneSizes struct {
+	PeerWidth int  `json:"peerWidth"`
+	HidePeers bool `json:"hidePeers"`
+	LogHeight int  `json:"logHeight"`
+	HideLogs  bool `json:"hideLogs"`
+}
+
+// the sizes the panes start with
+var defaultPaneSizes = PaneSizes{PeerWidth: defaultPeerWidth, LogHeight: defaultLogHeight}
+
+// Method that keeps the sizes within bounds, as sizes from an edited
+// settings file may be anything
+func (ps PaneSizes) clamped() PaneSizes {
+	ps.PeerWidth = clampSize(ps.PeerWidth, minPeerWidth, maxPeerWidth)
+	ps.LogHeight = clampSize(ps.LogHeight, minLogHeight, maxLogHeight)
+
+	return ps
+}
+
+// PaneLayout is the layout of the panes as the user changes it,
+// saved with the rest of the settings so the chat looks the same the next time
+type PaneLayout struct {
+	lock sync.Mutex
+	PaneSizes
+
+	// saves the changed sizes, nothing is saved without it
+	store func(PaneSizes) error
+}
+
+// Constructor function for a new pane layout with the given sizes,
+// saving them with the given function whenever they change
+func NewPaneLayout(sizes PaneSizes, store func(PaneSizes) error) *PaneLayout {
+	return &PaneLayout{PaneSizes: sizes.clamped(), store: store}
+}
+
+// This one keeps a size between the given bounds
+func clampSize(size, min, max int) int {
+	if size < min {
+		return min
+	}
+	if size > max {
+		return max
+	}
+
+	return size
+}
+
+// Method that returns the width of the peer list, zero while it is hidden
+func (pl *PaneLayout) peerWidth() int {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	if pl.HidePeers {
+		return 0
+	}
+	return pl.PeerWidth
+}
+
+// Method that returns the height of the log pane, zero while it is hidden
+func (pl *PaneLayout) logHeight() int {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	if pl.HideLogs {
+		return 0
+	}
+	return pl.LogHeight
+}
+
+// Method that reports if the log pane is shown
+func (pl *PaneLayout) logsShown() bool {
+	return pl.logHeight() > 0
+}
+
+// Method that changes the layout under the lock and saves it
+func (pl *PaneLayout) change(change func()) error {
+	pl.lock.Lock()
+	change()
+	sizes := pl.PaneSizes
+	pl.lock.Unlock()
+
+	if pl.store == nil {
+		return nil
+	}
+	return pl.store(sizes)
+}
+
+// This one grows or shrinks a pane. Shrinking it past its smallest size
+// hides it, and growing a hidden pane shows it again at that size
+func resizePane(size *int, hidden *bool, delta, min, max int) {
+	if *hidden {
+		if delta > 0 {
+			*hidden = false
+			*size = min
+		}
+		return
+	}
+
+	if *size+delta < min {
+		*hidden = true
+		*size = min
+		return
+	}
+
+	*size = clampSize(*size+delta, min, max)
+}
+
+// Method that grows the peer list by the given columns, or shrinks it if negative
+func (pl *PaneLayout) resizePeers(delta int) error {
+	return pl.change(func() {
+		resizePane(&pl.PeerWidth, &pl.HidePeers, delta, minPeerWidth, maxPeerWidth)
+	})
+}
+
+// Method that grows the log pane by the given rows, or shrinks it if negative
+func (pl *PaneLayout) resizeLogs(delta int) error {
+	return pl.change(func() {
+		resizePane(&pl.LogHeight, &pl.HideLogs, delta, minLogHeight, maxLogHeight)
+	})
+}
+
+// Method that hides the peer list if it is shown, and shows it otherwise
+func (pl *PaneLayout) togglePeers() error {
+	return pl.change(func() { pl.HidePeers = !pl.HidePeers })
+}
+
+// Method that hides the log pane if it is shown, and shows it otherwise
+func (pl *PaneLayout) toggleLogs() error {
+	return pl.change(func() { pl.HideLogs = !pl.HideLogs })
+}
+
+// Method that goes back to the default layout
+func (pl *PaneLayout) reset() error {
+	return pl.change(func() {
+		pl.PaneSizes = defaultPaneSizes
+	})
+}
+
+// Method that describes the layout, for the log
+func (pl *PaneLayout) String() string {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	describe := func(size int, unit string, hidden bool) string {
+		if hidden {
+			return "hidden"
+		}
+		return fmt.Sprintf("%d %s", size, unit)
+	}
+
+	return fmt.Sprintf("peer list %s, log pane %s",
+		describe(pl.PeerWidth, "columns", pl.HidePeers), describe(pl.LogHeight, "rows", pl.HideLogs))
+}
+
+// Method that changes the layout of the panes and shows the change.
+// Runs on the UI goroutine, since it changes the layout
+func (ui *UI) changePanes(change func() error) {
+	if err := change(); err != nil {
+		go func() {
+			ui.Log(chat.ChatLog{Prefix: "layouterr", Msg: fmt.Sprintf("could not save the layout: %s", err)})
+		}()
+	}
+
+	ui.applyPanes()
+}
+
+// Method that sizes the panes as the layout has them. Runs on the UI
+// goroutine, or before the application does
+func (ui *UI) applyPanes() {
+	width := ui.panes.peerWidth()
+	ui.columns.ResizeItem(ui.peerList, width, 1)
+	if width == 0 && ui.peerList.HasFocus() {
+		ui.TerminalApp.SetFocus(ui.inputField)
+	}
+
+	height := ui.panes.logHeight()
+	if ui.isQuiet() {
+		height = 0
+	}
+	ui.layout.ResizeItem(ui.logList, height, 1)
+
+	// a pane coming back has nothing unseen
+	if height > 0 {
+		ui.logsLock.Lock()
+		ui.unseenLogs = 0
+		ui.logsLock.Unlock()
+		ui.logList.ScrollToEnd()
+	}
+
+	ui.updateTitle()
+}
+
+// Method that replaces the layout of the panes, like with the one saved
+// with the settings the last time. Call it before running the UI
+func (ui *UI) SetPanes(panes *PaneLayout) {
+	ui.panes = panes
+	ui.applyPanes()
+}
+
+// Method that drops everything but the messages and the input, for small
+// terminals and tmux splits. Ctrl and the arrows still bring the peer list
+// and the log pane back, without saving it, so the saved layout stays as
+// it was for the next time. Call it before running the UI
+func (ui *UI) SetMinimal() {
+	for _, item := range ui.chrome {
+		ui.layout.ResizeItem(item, 0, 0)
+	}
+
+	ui.panes.lock.Lock()
+	sizes := ui.panes.PaneSizes
+	ui.panes.lock.Unlock()
+
+	sizes.HidePeers, sizes.HideLogs = true, true
+	ui.panes = NewPaneLayout(sizes, nil)
+	ui.applyPanes()
+}
@@ -0,0 +1,94 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// This one renders the text of a message for the message list, with
+// *bold*, _italic_ and `code` spans styled. Everything else is escaped,
+// so the text can't carry style tags of its own
+func renderMarkdown(text string, th *Theme) string {
+	out := &strings.Builder{}
+
+	// start of the text not written out yet
+	plain := 0
+	for i := 0; i < len(text); i++ {
+		marker := text[i]
+		if marker != '`' && marker != '*' && marker != '_' {
+			continue
+		}
+
+		end := spanEnd(text, i)
+		if end < 0 {
+			continue
+		}
+
+		out.WriteString(tview.Escape(text[plain:i]))
+
+		inner := tview.Escape(text[i+1 : end])
+		switch marker {
+		case '`':
+			fmt.Fprintf(out, "[%s]%s[-]", th.Code, inner)
+		case '*':
+			fmt.Fprintf(out, "[::b]%s[::-]", inner)
+		case '_':
+			fmt.Fprintf(out, "[::i]%s[::-]", inner)
+		}
+
+		i = end
+		plain = end + 1
+	}
+
+	out.WriteString(tview.Escape(text[plain:]))
+
+	return out.String()
+}
+
+// This one returns where the span opened by the marker at the given index
+// closes, or -1 if it doesn't. Spans don't cross lines, and * and _ only
+// count around words, so snake_case names and 2*3*4 stay as they are
+func spanEnd(text string, start int) int {
+	marker := text[start]
+
+	if marker == '`' {
+		for j := start + 1; j < len(text) && text[j] != '\n'; j++ {
+			if text[j] == '`' {
+				if j == start+1 {
+					return -1
+				}
+				return j
+			}
+		}
+		return -1
+	}
+
+	// the opening marker follows no word, and some text follows it
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	if start > 0 && (chat.IsWordRune(before) || before == rune(marker)) {
+		return -1
+	}
+	if after, _ := utf8.DecodeRuneInString(text[start+1:]); start+1 == len(text) || unicode.IsSpace(after) || after == rune(marker) {
+		return -1
+	}
+
+	for j := start + 2; j < len(text) && text[j] != '\n'; j++ {
+		if text[j] != marker {
+			continue
+		}
+
+		// the closing marker follows some text, and no word follows it
+		inside, _ := utf8.DecodeLastRuneInString(text[:j])
+		next, _ := utf8.DecodeRuneInString(text[j+1:])
+		if !unicode.IsSpace(inside) && (j+1 == len(text) || !chat.IsWordRune(next)) {
+			return j
+		}
+	}
+
+	return -1
+}
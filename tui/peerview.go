@@ -0,0 +1,176 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// Method that returns how a peer is shown in the peer list, by name
+// where we know it, with the ID suffix when the name is taken twice
+func (ui *UI) peerLabel(id peer.ID, collisions map[string]bool) string {
+	label := p2p.ShortID(id)
+	if prof := ui.Host.Profiles.Lookup(id); prof != nil && len(prof.Username) > 0 {
+		label = prof.Username
+		if collisions[label] {
+			label = chat.Disambiguate(label, id.Pretty())
+		}
+	}
+	label = ui.withPetname(id, label)
+	label = tview.Escape(label)
+
+	if ui.Host.PeerLists.IsVerified(id) {
+		label += " ✓"
+	}
+
+	return label
+}
+
+// Method that shows what we know about a peer, until Esc is pressed.
+// Runs on the UI goroutine, as the peer list calls it
+func (ui *UI) showPeer(id peer.ID) {
+	store := ui.Host.Host.Peerstore()
+
+	username := "unknown, the peer did not share a profile"
+	if prof := ui.Host.Profiles.Lookup(id); prof != nil {
+		username = tview.Escape(prof.Username)
+	}
+
+	agent := "unknown"
+	if version, err := store.Get(id, "AgentVersion"); err == nil {
+		if version, ok := version.(string); ok && len(version) > 0 {
+			agent = tview.Escape(version)
+		}
+	}
+
+	var addrs []string
+	for _, conn := range ui.Host.Host.Network().ConnsToPeer(id) {
+		addrs = append(addrs, conn.RemoteMultiaddr().String())
+	}
+	address := "not connected directly, messages come through other peers"
+	if len(addrs) > 0 {
+		address = strings.Join(addrs, "\n              ")
+	}
+
+	latency := "unknown"
+	if ewma := store.LatencyEWMA(id); ewma > 0 {
+		latency = ewma.Round(time.Millisecond / 10).String()
+	}
+
+	verified := fmt.Sprintf("no, /verify %s to compare safety numbers", p2p.ShortID(id))
+	if ui.Host.PeerLists.IsVerified(id) {
+		verified = "yes"
+	}
+
+	var marks []string
+	if ui.Host.PeerLists.IsBlocked(id) {
+		marks = append(marks, "blocked")
+	}
+	if ui.Host.PeerLists.IsMuted(id) {
+		marks = append(marks, "muted")
+	}
+	if ui.Moderation.IsSilenced(id) {
+		marks = append(marks, "silenced by a moderator")
+	}
+
+	text := &strings.Builder{}
+	fmt.Fprintf(text, "[%s]Peer ID[-]       %s\n", ui.theme.Log, id.Pretty())
+	fmt.Fprintf(text, "[%s]Username[-]      %s\n", ui.theme.Log, username)
+	if ui.Petnames != nil {
+		petname, ok := ui.Petnames.Lookup(id)
+		if !ok {
+			petname = fmt.Sprintf("none, /alias %s \"name\" to give one", p2p.ShortID(id))
+		}
+		fmt.Fprintf(text, "[%s]Petname[-]       %s\n", ui.theme.Log, tview.Escape(petname))
+	}
+	fmt.Fprintf(text, "[%s]Agent[-]         %s\n", ui.theme.Log, agent)
+	fmt.Fprintf(text, "[%s]Address[-]       %s\n", ui.theme.Log, address)
+	fmt.Fprintf(text, "[%s]Latency[-]       %s\n", ui.theme.Log, latency)
+	fmt.Fprintf(text, "[%s]Verified[-]      %s\n", ui.theme.Log, verified)
+	if len(marks) > 0 {
+		fmt.Fprintf(text, "[%s]Ignored[-]       %s\n", ui.theme.Log, strings.Join(marks, ", "))
+	}
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(text.String())
+	view.SetBorder(true).
+		SetTitle(fmt.Sprintf("%s — Esc to close", p2p.ShortID(id)))
+	view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			ui.pages.RemovePage("peer")
+			ui.TerminalApp.SetFocus(ui.peerList)
+		}
+	})
+
+	ui.pages.AddPage("peer", view, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
+
+// Method that offers what can be done with a peer picked from the peer list,
+// until one is chosen or Esc is pressed. Runs on the UI goroutine
+func (ui *UI) showPeerActions(id peer.ID) {
+	lists := ui.Host.PeerLists
+
+	// the list commands already know how to do it, and log how it went
+	command := func(cmdtype string) func() {
+		return func() {
+			ui.pages.RemovePage("peer-actions")
+			ui.TerminalApp.SetFocus(ui.inputField)
+			go ui.handleCommand(uiCommand{cmdtype: cmdtype, cmdarg: id.Pretty()})
+		}
+	}
+
+	block, mute := command("/block"), command("/mute")
+	blockText, muteText := "Block", "Mute"
+	if lists.IsBlocked(id) {
+		block, blockText = command("/unblock"), "Unblock"
+	}
+	if lists.IsMuted(id) {
+		mute, muteText = command("/unmute"), "Unmute"
+	}
+
+	actions := tview.NewList().
+		ShowSecondaryText(false).
+		SetHighlightFullLine(true).
+		AddItem("Profile and connection details", "", 'p', func() {
+			ui.pages.RemovePage("peer-actions")
+			ui.showPeer(id)
+		}).
+		AddItem("Direct message", "", 'd', func() {
+			ui.pages.RemovePage("peer-actions")
+			ui.TerminalApp.SetFocus(ui.inputField)
+			go ui.startDM(id)
+		}).
+		AddItem("Send a file", "", 'f', func() {
+			ui.pages.RemovePage("peer-actions")
+			ui.pickFile(fmt.Sprintf("send to %s", tview.Escape(ui.peerName(id))), func(path string) {
+				ui.handleCommand(uiCommand{cmdtype: "/send", cmdarg: id.Pretty() + " " + path})
+			})
+		}).
+		AddItem(blockText, "", 'b', block).
+		AddItem(muteText, "", 'm', mute).
+		AddItem("Verify safety number", "", 'v', command("/verify"))
+
+	actions.SetBorder(true).
+		SetTitle(fmt.Sprintf("%s — Esc to close", tview.Escape(ui.peerName(id))))
+	actions.SetDoneFunc(func() {
+		ui.pages.RemovePage("peer-actions")
+		ui.TerminalApp.SetFocus(ui.peerList)
+	})
+
+	// a small box in the middle, the chat stays in sight around it
+	modal := tview.NewGrid().
+		SetColumns(0, 40, 0).
+		SetRows(0, 8, 0).
+		AddItem(actions, 1, 1, 1, 1, 0, 0, true)
+
+	ui.pages.AddPage("peer-actions", modal, true, true)
+	ui.TerminalApp.SetFocus(actions)
+}
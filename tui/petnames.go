@@ -0,0 +1,173 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// columns a petname may take
+const maxPetnameWidth = 40
+
+// Petnames are our own names for peers, persisted as JSON. They are never
+// sent anywhere, and show next to whatever the peers call themselves
+type Petnames struct {
+	lock sync.RWMutex
+	path string
+
+	// petnames by base58 encoded peer IDs
+	Names map[string]string `json:"names"`
+}
+
+// This one loads the petnames from the given file,
+// starting with none if the file doesn't exist yet
+func LoadPetnames(path string) (*Petnames, error) {
+	pn := &Petnames{path: path, Names: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return pn, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, pn); err != nil {
+		return nil, err
+	}
+
+	if pn.Names == nil {
+		pn.Names = make(map[string]string)
+	}
+
+	return pn, nil
+}
+
+// Method that writes the petnames to disk, expects the lock to be held
+func (pn *Petnames) save() error {
+	data, err := json.MarshalIndent(pn, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pn.path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(pn.path, data, 0600)
+}
+
+// Method that names a peer, or forgets its name when the name is empty
+func (pn *Petnames) Set(id peer.ID, name string) error {
+	pn.lock.Lock()
+	defer pn.lock.Unlock()
+
+	if len(name) == 0 {
+		delete(pn.Names, id.Pretty())
+	} else {
+		pn.Names[id.Pretty()] = name
+	}
+
+	return pn.save()
+}
+
+// Method that returns our name for a peer, if we gave it one
+func (pn *Petnames) Lookup(id peer.ID) (string, bool) {
+	pn.lock.RLock()
+	defer pn.lock.RUnlock()
+
+	name, ok := pn.Names[id.Pretty()]
+	return name, ok
+}
+
+// Method that returns every petname, as lines of the peer ID and the name
+func (pn *Petnames) List() []string {
+	pn.lock.RLock()
+	defer pn.lock.RUnlock()
+
+	lines := make([]string, 0, len(pn.Names))
+	for id, name := range pn.Names {
+		lines = append(lines, fmt.Sprintf("%s: %s", id, name))
+	}
+	sort.Strings(lines)
+
+	return lines
+}
+
+// Method that adds our name for a peer to the name it goes by, like
+// alice (Marko's laptop). Without petnames there is nothing to add
+func (ui *UI) withPetname(id peer.ID, name string) string {
+	if ui.Petnames == nil {
+		return name
+	}
+
+	if petname, ok := ui.Petnames.Lookup(id); ok {
+		return fmt.Sprintf("%s (%s)", name, petname)
+	}
+
+	return name
+}
+
+// Method that handles the alias command: a peer and a name gives the peer
+// that name, a peer alone forgets it, and nothing at all lists the names
+func (ui *UI) handleAlias(arg string) {
+	if ui.Petnames == nil {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "petnames are not kept"})
+		return
+	}
+
+	fields, err := chat.SplitQuoted(arg)
+	if err != nil || len(fields) > 2 {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: `usage: /alias <peer> ["name"]`})
+		return
+	}
+
+	if len(fields) == 0 {
+		names := ui.Petnames.List()
+		if len(names) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "alias", Msg: "you have not named anyone yet"})
+			return
+		}
+		ui.Log(chat.ChatLog{Prefix: "alias", Msg: "your names for peers:\n" + strings.Join(names, "\n")})
+		return
+	}
+
+	target, err := ui.FindPeer(fields[0])
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+		return
+	}
+
+	name := ""
+	if len(fields) == 2 {
+		name = strings.TrimSpace(p2p.SanitizeText(strings.ReplaceAll(fields[1], "\n", " ")))
+		if chat.DisplayWidth(name) > maxPetnameWidth {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("a petname can be at most %d characters", maxPetnameWidth)})
+			return
+		}
+	}
+
+	if err := ui.Petnames.Set(target, name); err != nil {
+		ui.Log(chat.ChatLog{Prefix: "aliaserr", Msg: fmt.Sprintf("could not save the petnames: %s", err)})
+		return
+	}
+
+	if len(name) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "alias", Msg: fmt.Sprintf("forgot your name for %s", p2p.ShortID(target))})
+	} else {
+		ui.Log(chat.ChatLog{Prefix: "alias", Msg: fmt.Sprintf("%s is %s to you now", p2p.ShortID(target), name)})
+	}
+
+	// every place the peer shows up gets the new name
+	ui.syncPeerList()
+	ui.rerender()
+}
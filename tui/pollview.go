@@ -0,0 +1,47 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// width of the tally bar of the most voted option
+const pollBarWidth = 20
+
+// This one formats a poll with its live tally, one option per line
+func formatPoll(p *chat.Poll, votes map[string]int, ownVote int, th *Theme) string {
+	counts := make([]int, len(p.Options))
+	most := 0
+	for _, choice := range votes {
+		if choice < 1 || choice > len(counts) {
+			continue
+		}
+
+		counts[choice-1]++
+		if counts[choice-1] > most {
+			most = counts[choice-1]
+		}
+	}
+
+	lines := []string{fmt.Sprintf("[::b]poll:[::-] %s", tview.Escape(p.Question))}
+	for i, option := range p.Options {
+		bar := ""
+		if most > 0 {
+			bar = strings.Repeat("█", counts[i]*pollBarWidth/most)
+		}
+
+		marker := " "
+		if ownVote == i+1 {
+			marker = "✓"
+		}
+
+		lines = append(lines, fmt.Sprintf("    %s %d. %s [%s]%s %d[-]", marker, i+1, tview.Escape(option), th.Dim, bar, counts[i]))
+	}
+
+	lines = append(lines, fmt.Sprintf("    [%s]/vote <number> to vote[-]", th.Dim))
+
+	return strings.Join(lines, "\n")
+}
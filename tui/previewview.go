@@ -0,0 +1,19 @@
+package tui
+
+import (
+	"fmt"
+
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// This one formats a compact preview block, to go under the message.
+// Previews come from other peers, so they are cleaned up again and escaped
+func formatPreview(preview *chat.LinkPreview, th *Theme) string {
+	block := fmt.Sprintf("\n    [%s]│[-] [::b]%s[::-]", th.Dim, tview.Escape(chat.CleanPreviewText(preview.Title, chat.MaxPreviewTitle)))
+	if len(preview.Description) > 0 {
+		block += fmt.Sprintf("\n    [%s]│ %s[-]", th.Dim, tview.Escape(chat.CleanPreviewText(preview.Description, chat.MaxPreviewDescription)))
+	}
+
+	return block
+}
@@ -0,0 +1,70 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// how long an error stays in the status bar in quiet mode
+const flashDuration = 5 * time.Second
+
+// This one checks if a log tells of something that went wrong,
+// which quiet mode still shows
+func isErrorLog(log chat.ChatLog) bool {
+	switch log.Prefix {
+	case "badcmd", "toolong", "flood":
+		return true
+	}
+
+	return log.Alert || strings.HasSuffix(log.Prefix, "err")
+}
+
+// Method that switches quiet mode on or off. Quiet mode hides the log pane
+// and doesn't count the logs, for a conversation with nothing else in
+// between. Errors still show for a moment, in the status bar. Runs on the UI
+// goroutine, or before the application does
+func (ui *UI) SetQuiet(on bool) {
+	ui.logsLock.Lock()
+	ui.quiet = on
+	ui.unseenLogs = 0
+	ui.logsLock.Unlock()
+
+	ui.applyPanes()
+}
+
+// Method that reports if quiet mode is on
+func (ui *UI) isQuiet() bool {
+	ui.logsLock.Lock()
+	defer ui.logsLock.Unlock()
+
+	return ui.quiet
+}
+
+// Method that shows an error in place of the status for a moment
+func (ui *UI) flashError(log chat.ChatLog) {
+	// the first line has to do, it's a single line bar
+	text := log.Msg
+	if idx := strings.IndexByte(text, '\n'); idx >= 0 {
+		text = text[:idx]
+	}
+
+	ui.flashLock.Lock()
+	ui.flash = fmt.Sprintf("[%s:%s] %s: %s [-:-]", ui.theme.Text, ui.theme.Alert, tview.Escape(log.Prefix), tview.Escape(p2p.SanitizeText(text)))
+	ui.flashUntil = time.Now().Add(flashDuration)
+	ui.flashLock.Unlock()
+
+	go ui.queueUpdateDraw(ui.syncStatus)
+}
+
+// Method that returns the error flashing in the status bar, if any
+func (ui *UI) flashing() (string, bool) {
+	ui.flashLock.Lock()
+	defer ui.flashLock.Unlock()
+
+	return ui.flash, time.Now().Before(ui.flashUntil)
+}
@@ -0,0 +1,32 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// This one formats aggregated reaction counts, most popular first
+func formatReactions(reactions map[string][]string) string {
+	emojis := make([]string, 0, len(reactions))
+	for emoji := range reactions {
+		emojis = append(emojis, emoji)
+	}
+
+	sort.Slice(emojis, func(i, j int) bool {
+		ci, cj := len(reactions[emojis[i]]), len(reactions[emojis[j]])
+		if ci != cj {
+			return ci > cj
+		}
+		return emojis[i] < emojis[j]
+	})
+
+	parts := make([]string, len(emojis))
+	for i, emoji := range emojis {
+		parts[i] = fmt.Sprintf("%s %d", tview.Escape(emoji), len(reactions[emoji]))
+	}
+
+	return strings.Join(parts, "  ")
+}
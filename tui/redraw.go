@@ -0,0 +1,57 @@
+package tui
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// shortest time between two draws of the terminal, for at most 30 a second
+const drawInterval = time.Second / 30
+
+// drawThrottle coalesces the redraws asked for while something changes,
+// so a busy room or a slow terminal isn't drawn again for every line.
+// The first request draws a moment later, with whatever changed meanwhile
+type drawThrottle struct {
+	app *tview.Application
+	// set while a draw is coming up
+	pending int32
+}
+
+// Method that asks for the terminal to be drawn again soon
+func (dt *drawThrottle) Request() {
+	if !atomic.CompareAndSwapInt32(&dt.pending, 0, 1) {
+		return
+	}
+
+	time.AfterFunc(drawInterval, func() {
+		// changes from here on need another draw
+		atomic.StoreInt32(&dt.pending, 0)
+		dt.app.Draw()
+	})
+}
+
+// Method that runs the update on the UI goroutine, and draws the
+// terminal again soon after, along with whatever else changes
+func (ui *UI) queueUpdateDraw(update func()) {
+	ui.TerminalApp.QueueUpdate(func() {
+		update()
+		ui.draws.Request()
+	})
+}
+
+// Method that updates the title on the UI goroutine soon, from anywhere.
+// Asking again before it's done updates it only once, so a busy room
+// doesn't queue an update for every line
+func (ui *UI) queueTitleUpdate() {
+	if !atomic.CompareAndSwapInt32(&ui.titlePending, 0, 1) {
+		return
+	}
+
+	// the UI goroutine itself asks too, and must not wait on its own queue
+	go ui.queueUpdateDraw(func() {
+		atomic.StoreInt32(&ui.titlePending, 0)
+		ui.updateTitle()
+	})
+}
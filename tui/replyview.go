@@ -0,0 +1,68 @@
+package tui
+
+import (
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// Method that returns the quote of a message in the buffer, nil if it's gone
+func (ui *UI) quoteOf(id string) *chat.ReplyQuote {
+	name, text, ok := ui.buffer.Quote(id)
+	if !ok {
+		return nil
+	}
+
+	return chat.NewReplyQuote(name, text)
+}
+
+// Method that returns the message selected in the message list, empty if
+// none is. It's the one highlighted, be it picked with the arrows or found
+func (ui *UI) selectedMessage() string {
+	if highlights := ui.messageList.GetHighlights(); len(highlights) > 0 {
+		return highlights[0]
+	}
+
+	return ""
+}
+
+// Method that moves the selection to another message in view, older ones
+// for negative offsets. Without a selection, the latest message is selected.
+// Runs on the UI goroutine, as input handlers do
+func (ui *UI) selectMessage(offset int) bool {
+	ui.renderLock.Lock()
+	var ids []string
+	for _, entry := range ui.buffer.Entries() {
+		if ui.inView(entry) && !entry.IsLog() && !entry.Deleted && messageRegionPattern.MatchString(entry.ID) {
+			ids = append(ids, entry.ID)
+		}
+	}
+	ui.renderLock.Unlock()
+
+	if len(ids) == 0 {
+		return false
+	}
+
+	next := len(ids) - 1
+	if current := ui.selectedMessage(); len(current) > 0 {
+		for i, id := range ids {
+			if id == current {
+				next = i + offset
+			}
+		}
+	}
+	if next < 0 {
+		next = 0
+	}
+	if next >= len(ids) {
+		next = len(ids) - 1
+	}
+
+	ui.messageList.Highlight(ids[next]).ScrollToHighlight()
+
+	// the list stays on the selection while new messages arrive
+	ui.scrollLock.Lock()
+	ui.scrolledBack = true
+	ui.scrollLock.Unlock()
+	ui.updateTitle()
+
+	return true
+}
@@ -0,0 +1,143 @@
+package tui
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// Settings are the preferences of the user, as last used, persisted as JSON
+// so they survive restarts. Flags given on the command line win over them
+type Settings struct {
+	lock sync.Mutex
+	path string
+	// the file was there to load
+	loaded bool
+
+	Username   string    `json:"username"`
+	Room       string    `json:"room"`
+	Theme      string    `json:"theme"`
+	Keymap     string    `json:"keymap"`
+	Timestamps bool      `json:"timestamps"`
+	Keywords   []string  `json:"keywords"`
+	Bell       bool      `json:"bell"`
+	Receipts   bool      `json:"receipts"`
+	Previews   bool      `json:"previews"`
+	Quiet      bool      `json:"quiet"`
+	Panes      PaneSizes `json:"panes"`
+}
+
+// This one loads the settings from the given file,
+// starting with the defaults if the file doesn't exist yet
+func LoadSettings(path string) (*Settings, error) {
+	s := &Settings{path: path, Panes: defaultPaneSizes}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	s.loaded = true
+
+	return s, nil
+}
+
+// Method that writes the settings to disk, expects the lock to be held
+func (s *Settings) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.path, data, 0600)
+}
+
+// Method that changes the settings and saves them
+func (s *Settings) Update(change func(*Settings)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	change(s)
+
+	return s.save()
+}
+
+// Method that returns the saved pane sizes
+func (s *Settings) PaneSizes() PaneSizes {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.Panes
+}
+
+// Method that fills the flags not given on the command line with the saved
+// settings, so the command line always has the last word
+func (s *Settings) FillFlags(flags *flag.FlagSet) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.loaded {
+		return nil
+	}
+
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	saved := map[string]string{
+		"user":       s.Username,
+		"room":       s.Room,
+		"theme":      s.Theme,
+		"keymap":     s.Keymap,
+		"timestamps": strconv.FormatBool(s.Timestamps),
+		"keywords":   strings.Join(s.Keywords, ","),
+		"bell":       strconv.FormatBool(s.Bell),
+		"receipts":   strconv.FormatBool(s.Receipts),
+		"previews":   strconv.FormatBool(s.Previews),
+		"quiet":      strconv.FormatBool(s.Quiet),
+	}
+
+	for name, value := range saved {
+		if given[name] {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("bad %s setting: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Method that saves a change to the settings, logging if it could not be
+// saved. Without settings there is nothing to save
+func (ui *UI) saveSettings(change func(*Settings)) {
+	if ui.Settings == nil {
+		return
+	}
+
+	if err := ui.Settings.Update(change); err != nil {
+		go func() {
+			ui.Log(chat.ChatLog{Prefix: "settingserr", Msg: fmt.Sprintf("could not save the settings: %s", err)})
+		}()
+	}
+}
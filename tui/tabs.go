@@ -0,0 +1,493 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/hooks"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// most events kept for a room while it isn't in view
+const maxPendingEvents = 1000
+
+// most room events handled as a single batch. Busy rooms queue up events
+// while the last batch is printed, and the next batch takes them all
+const maxEventBatch = 256
+
+// a joined room, with its own message list
+type roomTab struct {
+	room   *chat.ChatRoom
+	buffer *messageBuffer
+	// name shown for the room instead of its own, like @alice for direct conversations
+	title string
+	// root message ID of the thread in view, empty shows everything
+	threadRoot string
+
+	// lock for the events below
+	lock sync.Mutex
+	// messages and logs that arrived while the room was not in view,
+	// shown when it is, as if they were just arriving
+	pending []roomEvent
+	// messages among the pending events
+	unread int
+	// set when one of the pending messages mentions us
+	mentioned bool
+	// set when anything else happened, like edits, reactions or logs
+	activity bool
+
+	// peers were in the room at some point, and the room has lost its
+	// subscription or all of them since. Touched by the event loop only
+	hadPeers     bool
+	disconnected bool
+}
+
+// a chat message or a log from one of the joined rooms
+type roomEvent struct {
+	tab *roomTab
+	msg *chat.ChatMessage
+	log *chat.ChatLog
+	// the message is our own, sent without typing it, like through the API
+	self bool
+}
+
+// a request to show a room, or to leave it
+type tabEvent struct {
+	tab   *roomTab
+	leave bool
+}
+
+// Constructor function for a new tab of a joined room
+func newRoomTab(cr *chat.ChatRoom) *roomTab {
+	return &roomTab{room: cr, buffer: newMessageBuffer()}
+}
+
+// Method that keeps an event for when the room is in view,
+// returns true if the event is a message mentioning us
+func (rt *roomTab) queue(ev roomEvent, isMentioned func(chat.ChatMessage) bool) bool {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if len(rt.pending) == maxPendingEvents {
+		rt.pending = rt.pending[1:]
+	}
+	rt.pending = append(rt.pending, ev)
+
+	if ev.msg == nil || ev.self || !isShownMessage(ev.msg.Type) {
+		rt.activity = true
+		return false
+	}
+
+	rt.unread++
+	if isMentioned(*ev.msg) {
+		rt.mentioned = true
+		return true
+	}
+
+	return false
+}
+
+// Method that takes the events kept while the room was not in view
+func (rt *roomTab) takePending() []roomEvent {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	pending := rt.pending
+	rt.pending = nil
+	rt.unread = 0
+	rt.mentioned = false
+	rt.activity = false
+
+	return pending
+}
+
+// Method that returns how many messages are waiting, if one mentions us,
+// and if anything else happened in the room since it was in view
+func (rt *roomTab) Unread() (int, bool, bool) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	return rt.unread, rt.mentioned, rt.activity
+}
+
+// This one checks if messages of the type show up in the message list on
+// their own, rather than changing one that is already there
+func isShownMessage(msgType string) bool {
+	switch msgType {
+	case chat.MessageText, chat.MessageImage, chat.MessagePaste, chat.MessagePoll, chat.MessageCommand, chat.MessageResponse:
+		return true
+	default:
+		return false
+	}
+}
+
+// Method that passes the messages and logs of a joined room on to
+// the event loop, until the room is left
+func (ui *UI) forwardRoom(tab *roomTab) {
+	cr := tab.room
+
+	cr.OnMessage(func(msg chat.ChatMessage) {
+		// the message-received hook gets to hide messages
+		if !ui.receivedHook(cr, msg) {
+			return
+		}
+		ui.deliver(cr, msg)
+		ui.queueRoomEvent(roomEvent{tab: tab, msg: &msg})
+	})
+
+	cr.OnLog(func(log chat.ChatLog) {
+		ui.recordLog(cr, log)
+		ui.queueRoomEvent(roomEvent{tab: tab, log: &log})
+	})
+
+	// joins only matter to the peer-joined hook, which can show up any time
+	err := cr.OnPeerJoin(func(id peer.ID) {
+		if ui.Hooks.Has(hooks.PeerJoined) {
+			go ui.joinedHook(cr, id)
+		}
+	})
+	if err != nil {
+		cr.Log(chat.ChatLog{Prefix: "hookerr", Msg: fmt.Sprintf("could not follow peers joining: %s", err)})
+	}
+}
+
+// Method that hands an event of a room to the event loop,
+// unless the room is left before the loop takes it
+func (ui *UI) queueRoomEvent(ev roomEvent) {
+	select {
+	case ui.roomEvents <- ev:
+	case <-ev.tab.room.Context().Done():
+	}
+}
+
+// Method that takes the events queued up after the first one,
+// up to a batch of them, without waiting for more
+func (ui *UI) takeRoomEvents(first roomEvent) []roomEvent {
+	events := []roomEvent{first}
+	for len(events) < maxEventBatch {
+		select {
+		case ev := <-ui.roomEvents:
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+
+	return events
+}
+
+// Method that shows the events of the room in view, or keeps them for later.
+// What they print goes to the message list at once, and the tabs and the
+// title are brought up to date once, however many there are. Runs on the
+// event loop
+func (ui *UI) handleRoomEvents(events []roomEvent) {
+	ui.renderLock.Lock()
+	ui.batch = &strings.Builder{}
+	ui.renderLock.Unlock()
+
+	active := ui.activeTab()
+	queued, waiting := false, false
+	for _, ev := range events {
+		if ev.tab != active {
+			if ev.tab.queue(ev, ui.isMentioned) {
+				ui.ringBell()
+			}
+			queued = true
+			// the title counts what is waiting elsewhere
+			waiting = waiting || (ev.msg != nil && isShownMessage(ev.msg.Type))
+			continue
+		}
+
+		switch {
+		case ev.msg != nil && ev.self:
+			ui.showSelfMessage(*ev.msg)
+		case ev.msg != nil:
+			ui.printChatMessage(*ev.msg)
+		}
+		if ev.log != nil {
+			ui.showLogMessage(*ev.log)
+		}
+	}
+
+	ui.renderLock.Lock()
+	if ui.batch.Len() > 0 {
+		fmt.Fprint(ui.messageList, ui.batch.String())
+	}
+	ui.batch = nil
+	ui.renderLock.Unlock()
+
+	if queued {
+		ui.renderTabs()
+	}
+	if waiting {
+		ui.queueUpdateDraw(ui.updateTitle)
+	}
+}
+
+// Method that returns the tab of the room in view
+func (ui *UI) activeTab() *roomTab {
+	ui.tabsLock.Lock()
+	defer ui.tabsLock.Unlock()
+
+	return ui.active
+}
+
+// Method that returns the tab of a joined room, nil if we are not in it
+func (ui *UI) findTab(roomName string) *roomTab {
+	ui.tabsLock.Lock()
+	defer ui.tabsLock.Unlock()
+
+	for _, tab := range ui.tabs {
+		if tab.room.RoomName == roomName {
+			return tab
+		}
+	}
+
+	return nil
+}
+
+// Method that returns the tab next to the one in view, by the
+// given offset and wrapping around. Nil if there is only the one
+func (ui *UI) nextTab(offset int) *roomTab {
+	ui.tabsLock.Lock()
+	defer ui.tabsLock.Unlock()
+
+	if len(ui.tabs) < 2 {
+		return nil
+	}
+
+	for i, tab := range ui.tabs {
+		if tab == ui.active {
+			next := (i + offset) % len(ui.tabs)
+			if next < 0 {
+				next += len(ui.tabs)
+			}
+			return ui.tabs[next]
+		}
+	}
+
+	return nil
+}
+
+// Method that returns the tab at the given position, counting from one
+func (ui *UI) tabAt(position int) *roomTab {
+	ui.tabsLock.Lock()
+	defer ui.tabsLock.Unlock()
+
+	if position < 1 || position > len(ui.tabs) {
+		return nil
+	}
+
+	return ui.tabs[position-1]
+}
+
+// Method that asks the event loop to show a room, without waiting for it,
+// so it can be called from the UI goroutine too
+func (ui *UI) requestTab(tab *roomTab, leave bool) {
+	if tab == nil {
+		return
+	}
+
+	go func() {
+		ui.tabEvents <- tabEvent{tab: tab, leave: leave}
+	}()
+}
+
+// Method that joins a room in a new tab and shows it,
+// or just shows it if we are in the room already
+func (ui *UI) openRoom(roomName string) {
+	if tab := ui.findTab(roomName); tab != nil {
+		ui.requestTab(tab, false)
+		return
+	}
+
+	ui.Log(chat.ChatLog{Prefix: "roomchange", Msg: fmt.Sprintf("joining new room: %s", roomName)})
+
+	tab, err := ui.joinTab(roomName)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "jumperr", Msg: fmt.Sprintf("could not join room: %s", err)})
+		return
+	}
+
+	ui.requestTab(tab, false)
+}
+
+// Method that joins a room in a new tab, without showing it
+func (ui *UI) joinTab(roomName string) (*roomTab, error) {
+	cr, err := chat.JoinChatRoom(ui.Host, ui.Username, roomName)
+	if err != nil {
+		return nil, err
+	}
+	cr.TTL = ui.TTL
+
+	return ui.addTab(cr, ""), nil
+}
+
+// Method that adds a tab for a room we just joined, after the others
+func (ui *UI) addTab(cr *chat.ChatRoom, title string) *roomTab {
+	tab := newRoomTab(cr)
+	tab.title = title
+
+	ui.tabsLock.Lock()
+	ui.tabs = append(ui.tabs, tab)
+	ui.tabsLock.Unlock()
+
+	ui.forwardRoom(tab)
+	ui.renderTabs()
+
+	return tab
+}
+
+// Method that returns the name shown for the room of a tab
+func (rt *roomTab) Name() string {
+	if len(rt.title) > 0 {
+		return rt.title
+	}
+
+	name, _ := p2p.SplitRoomName(rt.room.RoomName)
+	return name
+}
+
+// Method that leaves the room in view for the next one, unless it is the last
+func (ui *UI) leaveRoom() {
+	next := ui.nextTab(1)
+	if next == nil {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "this is the only room you are in, /quit to leave it"})
+		return
+	}
+
+	ui.requestTab(ui.activeTab(), true)
+}
+
+// Method that shows the room of the tab, or leaves it for the next one.
+// Runs on the event loop, so no message lands in the wrong list midway
+func (ui *UI) handleTabEvent(ev tabEvent) {
+	if ev.leave {
+		next := ui.nextTab(1)
+		if next == nil || ev.tab != ui.activeTab() {
+			return
+		}
+
+		ui.activateTab(next)
+
+		ui.tabsLock.Lock()
+		for i, tab := range ui.tabs {
+			if tab == ev.tab {
+				ui.tabs = append(ui.tabs[:i], ui.tabs[i+1:]...)
+				break
+			}
+		}
+		ui.tabsLock.Unlock()
+
+		ev.tab.room.Leave()
+		ui.printLogMessage(chat.ChatLog{Prefix: "roomchange", Msg: fmt.Sprintf("left room %s", ev.tab.Name())})
+		ui.renderTabs()
+		return
+	}
+
+	ui.activateTab(ev.tab)
+}
+
+// Method that puts the room of the tab in view, showing whatever
+// arrived for it in the meantime. Runs on the event loop
+func (ui *UI) activateTab(tab *roomTab) {
+	current := ui.activeTab()
+	if tab == current {
+		return
+	}
+
+	// what we have seen so far was seen in the room we are leaving
+	ui.sendReceipts()
+
+	ui.renderLock.Lock()
+	current.threadRoot = ui.threadRoot
+	ui.ChatRoom = tab.room
+	ui.buffer = tab.buffer
+	ui.threadRoot = tab.threadRoot
+	// what we were looking for was in the other room
+	ui.findQuery = ""
+	ui.findMatches = nil
+	ui.renderLock.Unlock()
+
+	ui.tabsLock.Lock()
+	ui.active = tab
+	ui.tabsLock.Unlock()
+
+	ui.scrollLock.Lock()
+	ui.scrolledBack = false
+	ui.unseenBelow = false
+	ui.scrollLock.Unlock()
+
+	ui.messageList.Highlight()
+	ui.rerender()
+	ui.messageList.ScrollToEnd()
+
+	// the list only has the focus while going through matches
+	ui.TerminalApp.QueueUpdate(func() {
+		if ui.messageList.HasFocus() {
+			ui.TerminalApp.SetFocus(ui.inputField)
+		}
+	})
+
+	if pending := tab.takePending(); len(pending) > 0 {
+		ui.handleRoomEvents(pending)
+	}
+
+	ui.renderTabs()
+	ui.queueTitleUpdate()
+}
+
+// Method that draws the tab bar, with the room in view
+// highlighted and the unread counts of the others
+func (ui *UI) renderTabs() {
+	ui.tabsLock.Lock()
+	tabs := append([]*roomTab(nil), ui.tabs...)
+	active := ui.active
+	ui.tabsLock.Unlock()
+
+	th := ui.theme
+	parts := make([]string, len(tabs))
+	for i, tab := range tabs {
+		label := fmt.Sprintf(" %d %s ", i+1, tview.Escape(tab.Name()))
+
+		// rooms with new messages stand out, the rest only get a dot for activity
+		switch unread, mentioned, activity := tab.Unread(); {
+		case tab == active:
+			label = fmt.Sprintf("[%s:%s]%s[-:-]", th.MentionText, th.ActiveTab, label)
+		case mentioned:
+			label = fmt.Sprintf("[%s::b]%s(%d @)[-::-] ", th.Mention, label, unread)
+		case unread > 0:
+			label = fmt.Sprintf("[%s::b]%s(%d)[-::-] ", th.Unread, label, unread)
+		case activity:
+			label = fmt.Sprintf("[%s]%s•[-] ", th.Dim, label)
+		default:
+			label = fmt.Sprintf("[%s]%s[-]", th.Dim, label)
+		}
+
+		parts[i] = label
+	}
+
+	ui.tabBar.SetText(strings.Join(parts, "│"))
+}
+
+// Method that counts the messages waiting in the rooms not in view
+func (ui *UI) unreadElsewhere() int {
+	ui.tabsLock.Lock()
+	tabs := append([]*roomTab(nil), ui.tabs...)
+	active := ui.active
+	ui.tabsLock.Unlock()
+
+	total := 0
+	for _, tab := range tabs {
+		if tab != active {
+			unread, _, _ := tab.Unread()
+			total += unread
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,230 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// theme the UI starts with, unless told otherwise
+const DefaultTheme = "dark"
+
+// colors of the UI, as color names like "green" or hex codes like "#ff8800".
+// Theme files set any of them, the rest come from the dark theme
+type Theme struct {
+	// the application title at the top
+	Title string `json:"title"`
+	// borders of the boxes, and their titles
+	Border   string `json:"border"`
+	BoxTitle string `json:"boxTitle"`
+	// text and background everywhere
+	Text       string `json:"text"`
+	Background string `json:"background"`
+
+	// the input label, and what we type
+	Input     string `json:"input"`
+	InputText string `json:"inputText"`
+
+	// names of the senders, ours and everyone else's. Each peer gets
+	// a color of its own from the peer colors, if there are any
+	Self       string   `json:"self"`
+	Peer       string   `json:"peer"`
+	PeerColors []string `json:"peerColors"`
+	// prefixes of logs
+	Log string `json:"log"`
+	// secondary text, like edit marks, reactions and quotes
+	Dim string `json:"dim"`
+	// code spans in messages
+	Code string `json:"code"`
+	// warnings that must not be missed
+	Alert string `json:"alert"`
+
+	// background of mentions and the text on it
+	Mention     string `json:"mention"`
+	MentionText string `json:"mentionText"`
+	// background of messages found with Ctrl+F
+	Match string `json:"match"`
+
+	// commands in the usage bar and help, and what they do
+	Command string `json:"command"`
+	Usage   string `json:"usage"`
+
+	// background of the tab in view, and the labels of tabs with unread messages
+	ActiveTab string `json:"activeTab"`
+	Unread    string `json:"unread"`
+}
+
+// the built-in themes
+var themes = map[string]Theme{
+	"dark": {
+		Title:       "hotpink",
+		Border:      "green",
+		BoxTitle:    "papayawhip",
+		Text:        "white",
+		Background:  "black",
+		Input:       "green",
+		InputText:   "white",
+		Self:        "blue",
+		Peer:        "green",
+		PeerColors:  []string{"green", "aqua", "fuchsia", "orange", "lime", "violet", "gold", "tomato", "lightskyblue", "springgreen", "orchid", "khaki"},
+		Log:         "yellow",
+		Dim:         "gray",
+		Code:        "lightsalmon",
+		Alert:       "red",
+		Mention:     "yellow",
+		MentionText: "black",
+		Match:       "aqua",
+		Command:     "red",
+		Usage:       "green",
+		ActiveTab:   "green",
+		Unread:      "white",
+	},
+	"light": {
+		Title:       "darkmagenta",
+		Border:      "darkgreen",
+		BoxTitle:    "navy",
+		Text:        "black",
+		Background:  "white",
+		Input:       "darkgreen",
+		InputText:   "black",
+		Self:        "navy",
+		Peer:        "darkgreen",
+		PeerColors:  []string{"darkgreen", "teal", "purple", "maroon", "olive", "darkblue", "saddlebrown", "darkcyan", "crimson", "darkolivegreen", "mediumvioletred", "darkslateblue"},
+		Log:         "darkgoldenrod",
+		Dim:         "gray",
+		Code:        "brown",
+		Alert:       "red",
+		Mention:     "yellow",
+		MentionText: "black",
+		Match:       "lightblue",
+		Command:     "maroon",
+		Usage:       "darkgreen",
+		ActiveTab:   "lightgreen",
+		Unread:      "black",
+	},
+	"high-contrast": {
+		Title:       "yellow",
+		Border:      "white",
+		BoxTitle:    "yellow",
+		Text:        "white",
+		Background:  "black",
+		Input:       "yellow",
+		InputText:   "white",
+		Self:        "aqua",
+		Peer:        "lime",
+		PeerColors:  []string{"lime", "yellow", "fuchsia", "orange", "white", "red"},
+		Log:         "yellow",
+		Dim:         "silver",
+		Code:        "aqua",
+		Alert:       "red",
+		Mention:     "yellow",
+		MentionText: "black",
+		Match:       "aqua",
+		Command:     "yellow",
+		Usage:       "white",
+		ActiveTab:   "yellow",
+		Unread:      "yellow",
+	},
+}
+
+// This one returns the names of the built-in themes
+func themeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// This one loads a built-in theme by name, or a theme file from the given path.
+// Colors the file leaves out are those of the dark theme
+func LoadTheme(nameOrPath string) (*Theme, error) {
+	if len(nameOrPath) == 0 {
+		nameOrPath = DefaultTheme
+	}
+
+	if th, ok := themes[nameOrPath]; ok {
+		return &th, nil
+	}
+
+	data, err := ioutil.ReadFile(nameOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("no theme %s, use %s or a theme file: %s", nameOrPath, strings.Join(themeNames(), ", "), err)
+	}
+
+	th := themes[DefaultTheme]
+	if err := json.Unmarshal(data, &th); err != nil {
+		return nil, fmt.Errorf("bad theme file %s: %s", nameOrPath, err)
+	}
+
+	if err := th.validate(); err != nil {
+		return nil, fmt.Errorf("bad theme file %s: %s", nameOrPath, err)
+	}
+
+	return &th, nil
+}
+
+// Method that checks every color of the theme is one the terminal knows
+func (th *Theme) validate() error {
+	value := reflect.ValueOf(*th)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("json")
+
+		colors := []string{value.Field(i).String()}
+		if list, ok := value.Field(i).Interface().([]string); ok {
+			colors = list
+		}
+
+		// the color goes into style tags as well, where brackets and colons mean something
+		for _, color := range colors {
+			if strings.ContainsAny(color, "[]:") || tcell.GetColor(color) == tcell.ColorDefault {
+				return fmt.Errorf("unknown color %q for %s", color, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Method that returns the color of a peer, the same every time for the
+// same peer ID, so who said what can be told apart at a glance
+func (th *Theme) peerColor(id string) string {
+	if len(th.PeerColors) == 0 || len(id) == 0 {
+		return th.Peer
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+
+	return th.PeerColors[hash.Sum32()%uint32(len(th.PeerColors))]
+}
+
+// Method that returns the terminal color of a theme color
+func (th *Theme) color(name string) tcell.Color {
+	return tcell.GetColor(name)
+}
+
+// Method that makes the theme the default of every element created from now
+// on, modals included. The chat elements are colored by NewUI themselves
+func (th *Theme) apply() {
+	tview.Styles.PrimitiveBackgroundColor = th.color(th.Background)
+	tview.Styles.ContrastBackgroundColor = th.color(th.ActiveTab)
+	tview.Styles.MoreContrastBackgroundColor = th.color(th.Mention)
+	tview.Styles.BorderColor = th.color(th.Border)
+	tview.Styles.TitleColor = th.color(th.BoxTitle)
+	tview.Styles.GraphicsColor = th.color(th.Border)
+	tview.Styles.PrimaryTextColor = th.color(th.Text)
+	tview.Styles.SecondaryTextColor = th.color(th.Log)
+	tview.Styles.TertiaryTextColor = th.color(th.Peer)
+	tview.Styles.InverseTextColor = th.color(th.MentionText)
+	tview.Styles.ContrastSecondaryTextColor = th.color(th.Dim)
+}
@@ -0,0 +1,97 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// width of the progress bar of a transfer
+const transferBarWidth = 20
+
+// columns the name of a transferred file may take
+const transferNameWidth = 30
+
+// Method that keeps track of a transfer under way, forgetting it once it is
+// over, and shows the transfers as they are now
+func (ui *UI) updateTransfer(ev p2p.TransferEvent) {
+	ui.transfersLock.Lock()
+	if ev.Finished {
+		delete(ui.transfers, ev.ID)
+	} else {
+		ui.transfers[ev.ID] = ev
+	}
+	ui.transfersLock.Unlock()
+
+	ui.queueUpdateDraw(ui.renderTransfers)
+}
+
+// Method that returns the number of the latest transfer under way
+func (ui *UI) latestTransfer() (int, bool) {
+	ui.transfersLock.Lock()
+	defer ui.transfersLock.Unlock()
+
+	latest := 0
+	for id := range ui.transfers {
+		if id > latest {
+			latest = id
+		}
+	}
+
+	return latest, latest > 0
+}
+
+// Method that shows a line for each transfer under way above the status bar,
+// and no line at all without any. Runs on the UI goroutine, since it
+// changes the layout
+func (ui *UI) renderTransfers() {
+	ui.transfersLock.Lock()
+	transfers := make([]p2p.TransferEvent, 0, len(ui.transfers))
+	for _, ev := range ui.transfers {
+		transfers = append(transfers, ev)
+	}
+	ui.transfersLock.Unlock()
+
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].ID < transfers[j].ID })
+
+	lines := make([]string, len(transfers))
+	for i, ev := range transfers {
+		lines[i] = ui.formatTransfer(ev)
+	}
+
+	ui.transferList.SetText(strings.Join(lines, "\n"))
+	ui.layout.ResizeItem(ui.transferList, len(lines), 1)
+}
+
+// Method that formats a single transfer, with how far along it is,
+// how fast it goes, when it should be done, and how to cancel it
+func (ui *UI) formatTransfer(ev p2p.TransferEvent) string {
+	th := ui.theme
+
+	// long names would push the rest out of sight
+	name := tview.Escape(chat.TruncateWidth(ev.Name, transferNameWidth))
+	direction := fmt.Sprintf("↓ %s from %s", name, tview.Escape(ui.peerName(ev.Peer)))
+	if ev.Sending {
+		direction = fmt.Sprintf("↑ %s to %s", name, tview.Escape(ui.peerName(ev.Peer)))
+	}
+
+	percent := int64(100)
+	if ev.Size > 0 {
+		percent = ev.Done * 100 / ev.Size
+	}
+	filled := int(percent) * transferBarWidth / 100
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", transferBarWidth-filled)
+
+	eta := "--"
+	if left := ev.ETA(); left > 0 {
+		eta = left.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf(" %s %s %3d%% [%s]%s/s, %s left, /cancel %d[-]",
+		direction, bar, percent, th.Dim, p2p.FormatSize(int64(ev.Rate)), eta, ev.ID)
+}
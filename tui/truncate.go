@@ -0,0 +1,61 @@
+package tui
+
+import (
+	"github.com/rivo/uniseg"
+)
+
+// messages longer than this are cut short in the message list,
+// the rest is a /expand away
+const (
+	maxShownChars = 1000
+	maxShownLines = 20
+)
+
+// This one cuts text too long for the message list short, at whichever of
+// the line or character limits comes first, never in the middle of a
+// character made of several runes. Returns the text to show, and if
+// anything was cut
+func truncateText(text string) (string, bool) {
+	lines, chars := 0, 0
+
+	clusters := uniseg.NewGraphemes(text)
+	for clusters.Next() {
+		start, _ := clusters.Positions()
+		if chars == maxShownChars {
+			return text[:start], true
+		}
+		chars++
+
+		if clusters.Str() != "\n" {
+			continue
+		}
+		lines++
+		if lines == maxShownLines {
+			return text[:start], true
+		}
+	}
+
+	return text, false
+}
+
+// Method that returns the message /expand shows, the match highlighted
+// with Ctrl+F if it was cut short, or else the latest message that was
+func (ui *UI) expandable() (*bufferEntry, bool) {
+	ui.renderLock.Lock()
+	current := ""
+	if ui.findIndex >= 0 && ui.findIndex < len(ui.findMatches) {
+		current = ui.findMatches[ui.findIndex]
+	}
+	ui.renderLock.Unlock()
+
+	cut := func(entry *bufferEntry) bool {
+		_, cut := truncateText(entry.Text)
+		return cut && !entry.Deleted
+	}
+
+	if entry, ok := ui.buffer.Get(current); ok && cut(entry) {
+		return entry, true
+	}
+
+	return ui.buffer.Last(cut)
+}
@@ -0,0 +1,3214 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/api"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/hooks"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// UI represents what user sees in a Chat Room
+type UI struct {
+	*chat.ChatRoom
+
+	// tview application
+	TerminalApp *tview.Application
+	// redraws of the terminal, coalesced
+	draws *drawThrottle
+	// set while an update of the title is coming up
+	titlePending int32
+
+	// user message input queue
+	MsgInputs chan string
+	// user command input queue
+	CmdInputs chan uiCommand
+
+	// UI element that lists the joined rooms
+	tabBar *tview.TextView
+	// UI element with the state of the connection, above the input
+	statusBar *tview.TextView
+	// UI element that tells the room in view is disconnected, above the messages
+	banner *tview.TextView
+	// the banner is shown, touched on the UI goroutine only
+	bannerShown bool
+	// when we last looked for peers again, touched by the event loop only
+	lastReconnect time.Time
+	// where the watched peers were last seen, touched by the event loop only
+	watching map[peer.ID]watchedState
+	// UI element with the file transfers under way
+	transferList *tview.TextView
+	// UI element that lists peers, selectable for their details
+	peerList *tview.List
+	// peers in the order they are listed, and how, touched on the UI goroutine only
+	listedPeers  []peer.ID
+	listedLabels []string
+	// UI element with chat messages
+	messageList *tview.TextView
+	// UI element with logs, beneath the messages
+	logList *tview.TextView
+	// UI element for user input
+	inputField *tview.InputField
+	// UI pages, for showing modals above the chat
+	pages *tview.Pages
+	// colors of everything
+	theme *Theme
+	// the vim keymap is in use, and g was pressed once in normal mode.
+	// Touched on the UI goroutine only
+	vimKeys  bool
+	pendingG bool
+	// directory the file picker shows first, touched on the UI goroutine only
+	pickDir string
+	// everything on the chat page, and the messages next to the peer list,
+	// for the panes to be resized
+	layout  *tview.Flex
+	columns *tview.Flex
+	// sizes of the peer list and the log pane
+	panes *PaneLayout
+	// the title, tab bar, status bar and usage, which the minimal mode drops
+	chrome []tview.Primitive
+
+	// file transfers under way by number
+	transfers map[int]p2p.TransferEvent
+	// lock for the transfers
+	transfersLock sync.Mutex
+
+	// logs that arrived while the log pane was hidden
+	unseenLogs int
+	// the log pane stays hidden and only errors show, in the status bar
+	quiet bool
+	// lock for the unseen logs and quiet mode
+	logsLock sync.Mutex
+	// error in place of the status, until it's time to go back
+	flash      string
+	flashUntil time.Time
+	flashLock  sync.Mutex
+
+	// joined rooms in the order they were joined, and the one in view
+	tabs   []*roomTab
+	active *roomTab
+	// lock for the tabs
+	tabsLock sync.Mutex
+	// messages and logs from every joined room
+	roomEvents chan roomEvent
+	// rooms to show or leave
+	tabEvents chan tabEvent
+
+	// everything shown in the message list, the buffer of the room in view
+	buffer *messageBuffer
+	// root message ID of the thread in view, empty shows everything
+	threadRoot string
+	// lock that keeps message list writes in order
+	renderLock sync.Mutex
+	// what is printed while handling a batch of room events, written to the
+	// message list at once when the batch is done. Nil outside of a batch
+	batch *strings.Builder
+	// usernames held by more than one peer, shown with a peer ID suffix
+	collisions map[string]bool
+	// show when each message was sent
+	timestamps bool
+	// day of the last message in the list, to tell when the next one
+	// starts another, and when we started
+	lastDay string
+	started time.Time
+
+	// text looked for in the messages in view, empty when not looking
+	findQuery string
+	// IDs of the messages containing it, oldest first, and the one highlighted.
+	// Kept under the render lock, like the message list
+	findMatches []string
+	findIndex   int
+
+	// set while the message list is scrolled back from the latest line
+	scrolledBack bool
+	// set when messages arrived below while scrolled back
+	unseenBelow bool
+	// line the last scroll down asked for, zero if none is pending
+	scrollTarget int
+	// lock for the scroll state
+	scrollLock sync.Mutex
+
+	// ring the terminal bell when we are mentioned
+	Bell bool
+	// words that highlight a message like a mention does
+	keywords []string
+	// lock for the keywords
+	keywordsLock sync.Mutex
+	// set when the bell should ring on the next draw
+	bellPending int32
+
+	// fetch previews of links in our messages
+	Previews bool
+
+	// let others know which of their messages we have seen
+	Receipts bool
+	// IDs of messages seen since the last receipt went out
+	pendingReceipts []string
+	receiptsLock    sync.Mutex
+
+	// messages kept on disk and searchable, nil to keep nothing
+	History *chat.History
+	// most messages and logs the message list keeps, zero for no limit
+	Scrollback int
+
+	// HTTP API the messages of the joined rooms are handed to, nil if it's off
+	API *api.Server
+
+	// hooks run when things happen in the rooms, nil to run none
+	Hooks *hooks.Hooks
+
+	// where the lines of the log pane are written as well, nil to keep them to the pane
+	FileLog *logrus.Logger
+
+	// preferences saved as they change, nil to save nothing
+	Settings *Settings
+
+	// identity keys pinned by username, nil to not pin at all
+	Pins *chat.KeyPins
+	// our own names for peers, nil to not keep any
+	Petnames *Petnames
+	// key changes we already warned about, by username and peer
+	keyWarnings map[string]bool
+
+	// terminal graphics protocol used for image previews
+	Graphics string
+	// images shared in the room, newest last
+	images []sharedImage
+	// lock for the shared images
+	imagesLock sync.Mutex
+}
+
+// most message IDs a single receipt carries, and most waiting for one.
+// Receipts go out once a second, however many messages were seen
+const (
+	maxReceiptRefs     = 100
+	maxPendingReceipts = 5 * maxReceiptRefs
+)
+
+// lines a single turn of the mouse wheel scrolls
+const wheelLines = 3
+
+// messages shown on each side of a search result
+const searchContext = 5
+
+// layout of the days messages are told apart by
+const dayLayout = "2006-01-02"
+
+// lines the log pane keeps to scroll back through
+const maxLogLines = 1000
+
+// DefaultScrollback is how many messages and logs the message list
+// of the room in view keeps, older ones are dropped
+const DefaultScrollback = 5000
+
+// message IDs safe to use as a message list region, IDs come from peers
+var messageRegionPattern = regexp.MustCompile(`^[0-9a-zA-Z]{1,64}$`)
+
+// an image or paste someone shared in the Chat Room
+type sharedImage struct {
+	from peer.ID
+	att  *p2p.Attachment
+}
+
+// what the peer list commands did, for the log
+var listVerbs = map[string]string{
+	"/block":   "blocked",
+	"/mute":    "muted",
+	"/unblock": "unblocked",
+	"/unmute":  "unmuted",
+}
+
+// a command and what it does, for the usage bar and /help
+type commandHelp struct {
+	usage string
+	desc  string
+}
+
+// every command there is, as /help lists them
+var helpCommands = []commandHelp{
+	{"/help [command]", "list the commands, or explain one"},
+	{"/quit", "quit the chat"},
+	{"/clear", "clear the chat"},
+	{"/room [create] <roomname>", "join or create a chat room, or show one you are in"},
+	{"/leave", "leave the room in view"},
+	{"Alt+N, Alt+P", "show the next or previous room, as do Ctrl+N and Ctrl+P, Alt+1..9 shows one by number"},
+	{"/user <username>", "change user name"},
+	{"/peers", "list the peers in the room"},
+	{"Ctrl+O", "pick a peer from the peer list, Enter shows what can be done"},
+	{"/dm <peer>", "start a direct conversation with a peer"},
+	{"/edit <text>", "edit your last message, or press up on an empty input"},
+	{"/delete [id]", "delete your last message, or the one with the ID"},
+	{"/seen", "who has seen your last message"},
+	{"/react <emoji>", "react to the last message"},
+	{"/reply <text>", "reply to the last message, or pick one with Alt+Up and the arrows and press r"},
+	{"/thread [off]", "show the latest thread, or everything again"},
+	{`/poll "question" <options>`, "ask the room"},
+	{"/vote <number>", "vote in the last poll"},
+	{"/expand", "read all of a message cut short, the one found with Ctrl+F or the latest"},
+	{"/find <text>", "find text in the messages in view, or Ctrl+F as you type, n and N move between matches"},
+	{"/search <words>", "search the history of every room"},
+	{"/history [room] [from] [to]", "browse the history of a room a page at a time, days like 2006-01-02, without a room list them"},
+	{"/sync <peer>", "fetch what an archive peer kept of the room since your newest kept message"},
+	{"/topic [topic | description]", "show or set the room topic"},
+	{"/mod [claim | grant | revoke | mute | kick | ttl]", "moderate the room"},
+	{"/password <password|off>", "lock the room"},
+	{"/ttl <duration|off>", "make your messages disappear"},
+	{"/block /mute <peer>", "ignore a peer"},
+	{"/unblock /unmute <peer>", "stop ignoring"},
+	{"/logs", "show or hide the log pane"},
+	{"/quiet on|off", "hide the logs, errors still flash in the status bar"},
+	{"/keymap [default | vim]", "show or switch the keymap, with vim Esc goes to normal mode: j k gg G, / n N to find, : for commands, i to type"},
+	{"/layout [peers | logs | reset]", "show or hide the peer list and the log pane, Ctrl and the arrows resize them"},
+	{"/timestamps on|off", "show when messages were sent"},
+	{"/keywords [words | off]", "show or set the words that highlight messages"},
+	{"/lists", "show ignored peers"},
+	{"/watch [peer]", "hear when a peer comes online or joins a room, or list the peers watched"},
+	{"/unwatch <peer>", "stop watching a peer"},
+	{"/verify <peer> [confirm | revoke]", "compare safety numbers"},
+	{`/alias [<peer> ["name"]]`, "give a peer a name of your own, without a name forget it, without a peer list them"},
+	{"/repin <name>#<peer>", "accept the new key of a peer"},
+	{"/send <peer> [path]", "send a file, picking it without a path"},
+	{"/cancel [number]", "cancel a file transfer, the latest without a number"},
+	{"/image <path>", "share an image"},
+	{"/save [name]", "download an image"},
+	{"/view [name]", "preview an image or paste"},
+}
+
+// the few commands that fit in the usage bar
+var usageCommands = []commandHelp{
+	{"/help", "all commands"},
+	{"/room <roomname>", "join chat room"},
+	{"Alt+1..9", "show room"},
+	{"/user <username>", "change user name"},
+	{"/peers", "who is here"},
+	{"/clear", "clear the chat"},
+	{"/quit", "quit the chat"},
+}
+
+// This one formats commands for the usage bar
+func formatUsage(commands []commandHelp, th *Theme) string {
+	parts := make([]string, len(commands))
+	for i, cmd := range commands {
+		parts[i] = fmt.Sprintf("[%s]%s[%s] - %s", th.Command, tview.Escape(cmd.usage), th.Usage, cmd.desc)
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// representation of a UI command
+type uiCommand struct {
+	cmdtype string
+	cmdarg  string
+	// ID of the message the command is about, when it was picked in the message list
+	cmdref string
+	// set when the command-invoked hook ran, and so answered commands we don't know
+	hooked bool
+}
+
+// Constructor function for a new UI
+func NewUI(cr *chat.ChatRoom, th *Theme) *UI {
+	// modals made later take their colors from the theme too
+	th.apply()
+
+	// we need a new Tview app, drawn again at most so often
+	tapp := tview.NewApplication()
+	draws := &drawThrottle{app: tapp}
+
+	// we need our message anc commands channels
+	cmdchan := make(chan uiCommand)
+	msgchan := make(chan string)
+
+	// the UI itself, referenced by the input handlers below
+	ui := &UI{}
+
+	// a nice title for our chat application
+	titlebox := tview.NewTextView().
+		SetText("PtwoP Chat").
+		SetTextColor(th.color(th.Title)).
+		SetTextAlign(tview.AlignCenter)
+	// these can't be done in the same chain call,
+	// since border setters return a different type, a Box type pointer, duuuh
+	titlebox.
+		SetBorder(true).
+		SetBorderColor(th.color(th.Border))
+
+	// message list in a box to display messages and logs
+	messageList := tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetChangedFunc(draws.Request)
+
+	messageList.
+		SetBorder(true).
+		SetBorderColor(th.color(th.Border)).
+		SetTitle(fmt.Sprintf("ChatRoom: %s", cr.RoomName)).
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(th.color(th.BoxTitle))
+
+	// log pane beneath the messages, so logs don't get in between them
+	logList := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true).
+		SetMaxLines(maxLogLines).
+		SetChangedFunc(draws.Request)
+
+	logList.
+		SetBorder(true).
+		SetBorderColor(th.color(th.Border)).
+		SetTitle("Logs — /logs to hide").
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(th.color(th.BoxTitle))
+
+	// usage intructions
+	usage := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(formatUsage(usageCommands, th))
+
+	usage.
+		SetBorder(true).
+		SetBorderColor(th.color(th.Border)).
+		SetTitle("Usage").
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(th.color(th.BoxTitle)).
+		SetBorderPadding(0, 0, 1, 0)
+
+	// joined rooms, one line above the messages
+	tabBar := tview.NewTextView().
+		SetDynamicColors(true).
+		SetChangedFunc(draws.Request)
+
+	// shown above the messages while the room in view is disconnected
+	banner := tview.NewTextView().
+		SetDynamicColors(true).
+		SetTextAlign(tview.AlignCenter)
+
+	// connection state, one line above the input
+	statusBar := tview.NewTextView().
+		SetDynamicColors(true)
+
+	// file transfers under way, a line each above the status bar
+	transferList := tview.NewTextView().
+		SetDynamicColors(true)
+
+	// peer list displayed in a box, Ctrl+O or a click picks a peer
+	peerList := tview.NewList().
+		ShowSecondaryText(false).
+		SetHighlightFullLine(true).
+		SetSelectedFocusOnly(true)
+	peerList.
+		SetBorder(true).
+		SetBorderColor(th.color(th.Border)).
+		SetTitle("Peers").
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(th.color(th.BoxTitle))
+
+	// text input box
+	inputField := tview.NewInputField().
+		SetLabel(fmt.Sprintf("%s > ", cr.Username)).
+		SetLabelColor(th.color(th.Input)).
+		SetFieldWidth(0).
+		SetFieldTextColor(th.color(th.InputText)).
+		SetFieldBackgroundColor(th.color(th.Background))
+
+	inputField.
+		SetBorder(true).
+		SetBorderColor(th.color(th.Border)).
+		SetTitle("Input").
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(th.color(th.BoxTitle)).
+		SetBorderPadding(0, 0, 1, 0)
+
+	// set while the input holds an edit of our last message
+	editing := false
+	stopEditing := func() {
+		editing = false
+		inputField.SetLabel(fmt.Sprintf("%s > ", ui.Username))
+	}
+
+	// set while the input holds a reply to the message picked in the message list
+	replyingTo := ""
+	stopReplying := func() {
+		replyingTo = ""
+		inputField.SetLabel(fmt.Sprintf("%s > ", ui.Username))
+	}
+
+	// set while Tab cycles through the completions of the last word
+	var completing *completion
+
+	// set while the input holds the text to find in the message list,
+	// with whatever was typed before put aside
+	finding := false
+	draft := ""
+	startFinding := func() {
+		if !finding {
+			draft = inputField.GetText()
+			if editing || len(replyingTo) > 0 {
+				stopEditing()
+				stopReplying()
+				draft = ""
+			}
+		}
+
+		query, _, _ := ui.findState()
+		finding = true
+		inputField.SetLabel("find > ")
+		inputField.SetText(query)
+		tapp.SetFocus(inputField)
+	}
+	stopFinding := func() {
+		finding = false
+		inputField.SetLabel(fmt.Sprintf("%s > ", ui.Username))
+		inputField.SetText(draft)
+	}
+
+	// with the vim keymap, Esc leaves the input for normal mode,
+	// and i or : come back to it, the latter to start a command
+	enterNormal := func() {
+		inputField.SetLabel(normalLabel)
+		tapp.SetFocus(messageList)
+	}
+	leaveNormal := func() {
+		if inputField.GetLabel() == normalLabel {
+			inputField.SetLabel(fmt.Sprintf("%s > ", ui.Username))
+		}
+	}
+	insert := func(text string) {
+		leaveNormal()
+		if len(text) > 0 {
+			inputField.SetText(text)
+		}
+		tapp.SetFocus(inputField)
+	}
+
+	// r on a message picked in the message list starts a reply to it,
+	// the message stays highlighted while the reply is typed
+	startReply := func() {
+		id := ui.selectedMessage()
+		entry, ok := ui.buffer.Get(id)
+		if !ok || !entry.IsActive() {
+			return
+		}
+
+		if editing {
+			stopEditing()
+			inputField.SetText("")
+		}
+		replyingTo = id
+		insert("")
+		inputField.SetLabel(fmt.Sprintf("reply to %s > ", tview.Escape(chat.TruncateWidth(entry.SenderName, 20))))
+	}
+
+	// the message list is found through as the text is typed
+	inputField.SetChangedFunc(func(text string) {
+		if finding {
+			ui.findText(text)
+		}
+	})
+
+	// Tab completes, page keys scroll, and the up arrow on an
+	// empty input picks our last message for editing
+	inputField.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// the mouse may have brought us back from normal mode
+		leaveNormal()
+
+		// Alt+number shows the room with that number, Alt+N and Alt+P (or
+		// Ctrl+N and Ctrl+P) the next and previous ones. Ctrl+Tab is only an
+		// alias, most terminals never send it
+		if event.Key() == tcell.KeyRune && event.Modifiers()&tcell.ModAlt != 0 {
+			switch r := event.Rune(); {
+			case r >= '1' && r <= '9':
+				ui.requestTab(ui.tabAt(int(r-'0')), false)
+				return nil
+			case r == 'n':
+				ui.requestTab(ui.nextTab(1), false)
+				return nil
+			case r == 'p':
+				ui.requestTab(ui.nextTab(-1), false)
+				return nil
+			}
+		}
+		if event.Key() == tcell.KeyTab && event.Modifiers()&tcell.ModCtrl != 0 {
+			ui.requestTab(ui.nextTab(1), false)
+			return nil
+		}
+
+		// Alt+Up picks the latest message, the arrows then move to the others
+		if event.Key() == tcell.KeyUp && event.Modifiers()&tcell.ModAlt != 0 {
+			if ui.selectMessage(0) {
+				if ui.vimKeys {
+					enterNormal()
+				} else {
+					tapp.SetFocus(messageList)
+				}
+			}
+			return nil
+		}
+
+		// Ctrl and the arrows move the edges of the peer list and the log
+		// pane, and shrinking either past its smallest size hides it
+		if event.Modifiers()&tcell.ModCtrl != 0 {
+			resize := map[tcell.Key]func() error{
+				tcell.KeyLeft:  func() error { return ui.panes.resizePeers(paneStep) },
+				tcell.KeyRight: func() error { return ui.panes.resizePeers(-paneStep) },
+				tcell.KeyUp:    func() error { return ui.panes.resizeLogs(paneStep) },
+				tcell.KeyDown:  func() error { return ui.panes.resizeLogs(-paneStep) },
+			}
+			if change, ok := resize[event.Key()]; ok {
+				ui.changePanes(change)
+				return nil
+			}
+		}
+
+		switch event.Key() {
+		case tcell.KeyCtrlF:
+			startFinding()
+			return nil
+
+		case tcell.KeyCtrlO:
+			if peerList.GetItemCount() > 0 && ui.panes.peerWidth() > 0 {
+				tapp.SetFocus(peerList)
+			}
+			return nil
+
+		case tcell.KeyCtrlN:
+			ui.requestTab(ui.nextTab(1), false)
+			return nil
+		case tcell.KeyCtrlP:
+			ui.requestTab(ui.nextTab(-1), false)
+			return nil
+
+		case tcell.KeyTab:
+			// pressing Tab again moves on to the next candidate
+			text := inputField.GetText()
+			if completing == nil || text != completing.last {
+				completing = newCompletion(text, ui.completionCandidates)
+			}
+			if completing != nil {
+				inputField.SetText(completing.Next())
+			}
+			return nil
+
+		// the message list scrolls while we keep typing
+		case tcell.KeyPgUp:
+			_, _, _, height := messageList.GetInnerRect()
+			ui.scrollMessages(-height)
+			return nil
+		case tcell.KeyPgDn:
+			_, _, _, height := messageList.GetInnerRect()
+			ui.scrollMessages(height)
+			return nil
+		}
+
+		if event.Key() != tcell.KeyUp || editing || finding || len(replyingTo) > 0 || len(inputField.GetText()) > 0 {
+			return event
+		}
+
+		last, ok := ui.buffer.LastSelf((*bufferEntry).IsEditable)
+		if !ok {
+			return event
+		}
+
+		editing = true
+		inputField.SetLabel("edit > ")
+		inputField.SetText(last.Text)
+		return nil
+	})
+
+	// define here what should happen when the input is done
+	inputField.SetDoneFunc(func(key tcell.Key) {
+		// enter moves on to the matches, escape stops looking
+		if finding {
+			switch key {
+			case tcell.KeyEnter:
+				stopFinding()
+				if _, _, count := ui.findState(); count > 0 && ui.vimKeys {
+					enterNormal()
+				} else if count > 0 {
+					tapp.SetFocus(messageList)
+				} else {
+					ui.stopFind()
+				}
+			case tcell.KeyEscape:
+				stopFinding()
+				ui.stopFind()
+			}
+			return
+		}
+
+		// escape drops the edit in progress, or the reply
+		if key == tcell.KeyEscape && editing {
+			stopEditing()
+			inputField.SetText("")
+			return
+		}
+		if key == tcell.KeyEscape && len(replyingTo) > 0 {
+			stopReplying()
+			ui.scrollToLatest()
+			return
+		}
+
+		if key == tcell.KeyEscape && ui.vimKeys {
+			enterNormal()
+			return
+		}
+
+		// check if trigger was caused by a Return(Enter) press
+		if key != tcell.KeyEnter {
+			return
+		}
+
+		// back to the latest messages, after scrolling or jumping to older ones
+		ui.scrollToLatest()
+
+		// read the input text
+		line := inputField.GetText()
+		// no point printing empty messages
+		if len(line) == 0 {
+			return
+		}
+
+		// check for command inputs
+		if editing {
+			stopEditing()
+			cmdchan <- uiCommand{cmdtype: "/edit", cmdarg: line}
+
+		} else if len(replyingTo) > 0 {
+			cmdchan <- uiCommand{cmdtype: "/reply", cmdarg: line, cmdref: replyingTo}
+			stopReplying()
+
+		} else if strings.HasPrefix(line, "/") {
+			// everything after the command name is its argument
+			cmdparts := strings.SplitN(line, " ", 2)
+			if len(cmdparts) == 1 {
+				cmdparts = append(cmdparts, "")
+			}
+
+			// send the command
+			cmdchan <- uiCommand{cmdtype: cmdparts[0], cmdarg: strings.TrimSpace(cmdparts[1])}
+
+		} else {
+			// send the message
+			msgchan <- line
+		}
+
+		// reset the input field
+		inputField.SetText("")
+	})
+
+	// while there are matches to go through, the message list takes the keys.
+	// n moves to the older match and N to the newer one, Ctrl+F changes what
+	// to find, and anything else stops looking
+	messageList.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if ui.vimKeys {
+			return ui.normalKey(event, insert, startFinding, startReply)
+		}
+
+		switch {
+		case event.Key() == tcell.KeyUp:
+			ui.selectMessage(-1)
+		case event.Key() == tcell.KeyDown:
+			ui.selectMessage(1)
+		case event.Key() == tcell.KeyRune && event.Rune() == 'r':
+			startReply()
+		case event.Key() == tcell.KeyRune && event.Rune() == 'n':
+			ui.findNext(-1)
+		case event.Key() == tcell.KeyRune && event.Rune() == 'N':
+			ui.findNext(1)
+		case event.Key() == tcell.KeyCtrlF:
+			startFinding()
+		case event.Key() == tcell.KeyPgUp:
+			_, _, _, height := messageList.GetInnerRect()
+			ui.scrollMessages(-height)
+		case event.Key() == tcell.KeyPgDn:
+			_, _, _, height := messageList.GetInnerRect()
+			ui.scrollMessages(height)
+		default:
+			ui.stopFind()
+			tapp.SetFocus(inputField)
+		}
+		return nil
+	})
+
+	// enter shows what can be done with the peer, escape goes back to typing
+	peerList.SetSelectedFunc(func(index int, _ string, _ string, _ rune) {
+		if index < len(ui.listedPeers) {
+			ui.showPeerActions(ui.listedPeers[index])
+		}
+	})
+	peerList.SetDoneFunc(func() {
+		tapp.SetFocus(inputField)
+	})
+
+	// flex container for message and peer boxes
+	msgAndPeers := tview.NewFlex().
+		SetDirection(tview.FlexColumn).
+		AddItem(messageList, 0, 1, false).
+		AddItem(peerList, defaultPeerWidth, 1, false)
+
+	// flexbox to fit all inside
+	flex := tview.NewFlex().
+		SetDirection(tview.FlexRow).
+		AddItem(titlebox, 3, 1, false).
+		AddItem(tabBar, 1, 1, false).
+		AddItem(banner, 0, 0, false).
+		AddItem(msgAndPeers, 0, 8, false).
+		AddItem(logList, defaultLogHeight, 1, false).
+		AddItem(transferList, 0, 1, false).
+		AddItem(statusBar, 1, 1, false).
+		AddItem(inputField, 3, 1, true).
+		AddItem(usage, 3, 1, false)
+
+	// pages hold the chat flex, and any modals above it
+	pages := tview.NewPages().
+		AddPage("chat", flex, true, true)
+
+	// set the pages as the app root
+	tapp.SetRoot(pages, true)
+
+	// the bell rings from the draw loop, which always has the current screen
+	tapp.SetAfterDrawFunc(func(screen tcell.Screen) {
+		if atomic.CompareAndSwapInt32(&ui.bellPending, 1, 0) {
+			screen.Beep()
+		}
+
+		ui.checkScrollEnd()
+	})
+
+	// the mouse wheel scrolls the message list, while clicks outside
+	// the input and the peer list are dropped so it never loses the focus
+	tapp.EnableMouse(true)
+	tapp.SetMouseCapture(func(event *tcell.EventMouse, action tview.MouseAction) (*tcell.EventMouse, tview.MouseAction) {
+		// modals above the chat get the mouse as usual
+		if front, _ := pages.GetFrontPage(); front != "chat" {
+			return event, action
+		}
+
+		switch action {
+		case tview.MouseScrollUp, tview.MouseScrollDown:
+			if !messageList.InRect(event.Position()) {
+				return event, action
+			}
+			if action == tview.MouseScrollUp {
+				ui.scrollMessages(-wheelLines)
+			} else {
+				ui.scrollMessages(wheelLines)
+			}
+			return nil, action
+
+		case tview.MouseLeftClick, tview.MouseLeftDown, tview.MouseLeftUp:
+			if peerList.InRect(event.Position()) {
+				return event, action
+			}
+			if !inputField.InRect(event.Position()) {
+				return nil, action
+			}
+			// going back to the input is done going through the matches
+			if action == tview.MouseLeftClick && messageList.HasFocus() {
+				ui.stopFind()
+			}
+		}
+
+		return event, action
+	})
+
+	// return newly created UI
+	// the room we start in is the first tab
+	tab := newRoomTab(cr)
+
+	*ui = UI{
+		ChatRoom:     cr,
+		TerminalApp:  tapp,
+		draws:        draws,
+		tabBar:       tabBar,
+		statusBar:    statusBar,
+		banner:       banner,
+		transferList: transferList,
+		transfers:    make(map[int]p2p.TransferEvent),
+		peerList:     peerList,
+		messageList:  messageList,
+		logList:      logList,
+		layout:       flex,
+		columns:      msgAndPeers,
+		chrome:       []tview.Primitive{titlebox, tabBar, statusBar, usage},
+		panes:        NewPaneLayout(defaultPaneSizes, nil),
+		inputField:   inputField,
+		pages:        pages,
+		tabs:         []*roomTab{tab},
+		active:       tab,
+		started:      time.Now(),
+		roomEvents:   make(chan roomEvent, maxEventBatch),
+		tabEvents:    make(chan tabEvent),
+		buffer:       tab.buffer,
+		keyWarnings:  make(map[string]bool),
+		Graphics:     graphicsNone,
+		theme:        th,
+		MsgInputs:    msgchan,
+		CmdInputs:    cmdchan,
+	}
+
+	ui.forwardRoom(tab)
+	ui.renderTabs()
+	ui.syncStatus()
+	ui.rerender()
+
+	return ui
+}
+
+// Method that starts the UI app
+func (ui *UI) Run() error {
+	go ui.eventHandler()
+	defer ui.Close()
+
+	return ui.TerminalApp.Run()
+}
+
+// Method that leaves every joined room, once the UI is done.
+// Whatever we have seen goes out as receipts first
+func (ui *UI) Close() {
+	ui.sendReceipts()
+
+	ui.tabsLock.Lock()
+	defer ui.tabsLock.Unlock()
+
+	for _, tab := range ui.tabs {
+		tab.room.Leave()
+	}
+}
+
+// Method that sends a message to the room and prints it as our own
+func (ui *UI) sendMessage(msg string) {
+	ui.sendReply(msg, "")
+}
+
+// Method that sends a reply to the given message, or a plain message
+// when there is nothing to reply to, and prints it as our own
+func (ui *UI) sendReply(msg string, replyTo string) {
+	msg, err := ui.sendingHook(ui.ChatRoom, msg)
+	if err != nil {
+		ui.printLogMessage(chat.ChatLog{Prefix: "hook", Msg: err.Error()})
+		return
+	}
+
+	// long text is better off as a paste, if the user agrees
+	if err := chat.CheckMessageLength(msg); err != nil {
+		ui.printLogMessage(chat.ChatLog{Prefix: "toolong", Msg: fmt.Sprintf("%s, not sent", err)})
+		ui.promptPaste(msg, replyTo)
+		return
+	}
+
+	chatMsg := ui.NewTextMessage(msg)
+	if len(replyTo) > 0 {
+		chatMsg.ReplyTo = replyTo
+		chatMsg.Quote = ui.quoteOf(replyTo)
+	}
+
+	// send the message to outbound queue
+	if err := ui.ChatRoom.Send(chatMsg); err != nil {
+		ui.printLogMessage(chat.ChatLog{Prefix: "puberr", Msg: err.Error()})
+		return
+	}
+	// add message to the message box as a message from myself
+	ui.printSelfMessage(chatMsg)
+
+	// the preview follows the message, once the page is fetched
+	if url := chat.FindURL(chatMsg.Message); ui.Previews && len(url) > 0 {
+		go ui.sendPreview(chatMsg.ID, url)
+	}
+}
+
+// Method that fetches the preview of a link in one of our messages,
+// and sends it to the room as a follow up of the message
+func (ui *UI) sendPreview(id, url string) {
+	ctx, cancel := context.WithTimeout(ui.Context(), chat.PreviewTimeout)
+	defer cancel()
+
+	preview, err := chat.FetchPreview(ctx, url)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "preview", Msg: err.Error()})
+		return
+	}
+
+	// the message might be gone by now, along with the room
+	entry, ok := ui.buffer.Get(id)
+	if !ok || !entry.IsActive() {
+		return
+	}
+
+	ui.ChatRoom.Send(chat.ChatMessage{Type: chat.MessagePreview, Ref: id, Preview: preview})
+
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		e.Preview = preview
+	})
+	ui.rerender()
+}
+
+// Method that returns the disappearing timer of our next message, in seconds
+func (ui *UI) messageTTL() int64 {
+	return int64(ui.MessageTTL() / time.Second)
+}
+
+// This one parses a disappearing messages timer like 30s or 10m, off is zero
+func parseTTL(arg string) (time.Duration, error) {
+	if arg == "off" || arg == "0" {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(arg)
+	if err != nil || ttl < time.Second {
+		return 0, fmt.Errorf("timer must be a duration like 30s, 10m or 1h, or off")
+	}
+
+	return ttl.Truncate(time.Second), nil
+}
+
+// Method that prints messages received from self
+func (ui *UI) printSelfMessage(msg chat.ChatMessage) {
+	ui.deliverOwn(ui.ChatRoom, msg)
+	ui.showSelfMessage(msg)
+}
+
+// Method that adds one of our messages to the message list
+func (ui *UI) showSelfMessage(msg chat.ChatMessage) {
+	ui.appendEntry(&bufferEntry{
+		ID:         msg.ID,
+		SenderID:   ui.SelfID().Pretty(),
+		SenderName: ui.Username,
+		Text:       msg.Message,
+		ReplyTo:    msg.ReplyTo,
+		Quote:      msg.Quote,
+		Attachment: msg.Attachment,
+		Poll:       msg.Poll,
+		Command:    msg.Command,
+		Self:       true,
+		Expires:    expiry(msg),
+	})
+}
+
+// Method that prints messages received from a peer
+func (ui *UI) printChatMessage(msg chat.ChatMessage) {
+	// muted peers are still there, we just don't look
+	if from, err := peer.Decode(msg.SenderID); err == nil && ui.Host.PeerLists.IsMuted(from) {
+		return
+	}
+
+	ui.checkKeyPin(msg)
+
+	switch msg.Type {
+	case chat.MessageEdit:
+		ui.applyEdit(msg)
+		return
+
+	case chat.MessageDelete:
+		ui.applyDelete(msg)
+		return
+
+	case chat.MessageReact:
+		ui.applyReaction(msg)
+		return
+
+	case chat.MessageVote:
+		ui.applyVote(msg)
+		return
+
+	case chat.MessagePreview:
+		ui.applyPreview(msg)
+		return
+
+	case chat.MessageReceipt:
+		ui.applyReceipt(msg)
+		return
+
+	case chat.MessagePoll:
+		if msg.Poll == nil || len(msg.Poll.Options) < 2 || len(msg.Poll.Options) > chat.MaxPollOptions {
+			return
+		}
+
+	case chat.MessageModeration:
+		ui.printLogMessage(chat.ChatLog{Prefix: "mod", Msg: chat.DescribeModeration(msg.Moderation)})
+		if msg.Moderation.Action == chat.ModTopic && len(msg.Moderation.Description) > 0 {
+			ui.printLogMessage(chat.ChatLog{Prefix: "topic", Msg: msg.Moderation.Description})
+		}
+		if msg.Moderation.Action == chat.ModTTL || msg.Moderation.Action == chat.ModTopic {
+			ui.queueTitleUpdate()
+		}
+		return
+
+	case chat.MessageCommand:
+		if msg.Command == nil || !chat.BotCommandPattern.MatchString(msg.Command.Name) {
+			return
+		}
+
+	case chat.MessageResponse:
+		if msg.Response == nil {
+			return
+		}
+
+		// responses show the command they answer, like replies do
+		msg.ReplyTo = msg.Ref
+
+	case chat.MessageImage, chat.MessagePaste:
+		if msg.Attachment == nil || (msg.Type == chat.MessagePaste) != (msg.Attachment.Mime == p2p.PasteMime) {
+			return
+		}
+
+		from, err := peer.Decode(msg.SenderID)
+		if err != nil {
+			return
+		}
+
+		ui.imagesLock.Lock()
+		ui.images = append(ui.images, sharedImage{from: from, att: msg.Attachment})
+		ui.imagesLock.Unlock()
+	}
+
+	mentioned := ui.isMentioned(msg)
+
+	ui.appendEntry(&bufferEntry{
+		ID:         msg.ID,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		Text:       msg.Message,
+		ReplyTo:    msg.ReplyTo,
+		Quote:      msg.Quote,
+		Attachment: msg.Attachment,
+		Poll:       msg.Poll,
+		Command:    msg.Command,
+		Response:   msg.Response,
+		Mentioned:  mentioned,
+		Expires:    expiry(msg),
+		Time:       sentTime(msg),
+	})
+
+	if mentioned {
+		ui.ringBell()
+	}
+
+	// the author learns we have seen it with the next receipt
+	if ui.Receipts && len(msg.ID) > 0 {
+		ui.receiptsLock.Lock()
+		// busy rooms see more than the receipts can tell about,
+		// the oldest ones go untold then
+		if len(ui.pendingReceipts) == maxPendingReceipts {
+			ui.pendingReceipts = ui.pendingReceipts[1:]
+		}
+		ui.pendingReceipts = append(ui.pendingReceipts, msg.ID)
+		ui.receiptsLock.Unlock()
+	}
+}
+
+// Method that sends a single receipt for all the messages seen since the last one
+func (ui *UI) sendReceipts() {
+	ui.receiptsLock.Lock()
+	refs := ui.pendingReceipts
+	if len(refs) > maxReceiptRefs {
+		refs, ui.pendingReceipts = refs[:maxReceiptRefs], refs[maxReceiptRefs:]
+	} else {
+		ui.pendingReceipts = nil
+	}
+	ui.receiptsLock.Unlock()
+
+	if len(refs) > 0 {
+		ui.ChatRoom.Send(chat.ChatMessage{Type: chat.MessageReceipt, Refs: refs})
+	}
+}
+
+// Method that counts a receipt towards our messages it refers to
+func (ui *UI) applyReceipt(msg chat.ChatMessage) {
+	changed := false
+	for _, ref := range msg.Refs {
+		entry, ok := ui.buffer.Get(ref)
+		if !ok || !entry.Self {
+			continue
+		}
+
+		ui.buffer.Update(entry, func(e *bufferEntry) {
+			changed = e.AddSeen(msg.SenderID) || changed
+		})
+	}
+
+	if changed {
+		ui.rerender()
+	}
+}
+
+// Method that checks the key of the sender against the one pinned for
+// their username, and warns loudly the first time it doesn't match
+func (ui *UI) checkKeyPin(msg chat.ChatMessage) {
+	if ui.Pins == nil {
+		return
+	}
+
+	from, err := peer.Decode(msg.SenderID)
+	if err != nil {
+		return
+	}
+
+	previous, changed, err := ui.Pins.Check(msg.SenderName, from)
+	if err != nil {
+		ui.printLogMessage(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the key pins: %s", err)})
+	}
+
+	warning := msg.SenderName + "/" + msg.SenderID
+	if !changed || ui.keyWarnings[warning] {
+		return
+	}
+	ui.keyWarnings[warning] = true
+
+	name := chat.Disambiguate(msg.SenderName, msg.SenderID)
+	ui.printLogMessage(chat.ChatLog{
+		Prefix: "WARNING",
+		Msg: fmt.Sprintf("%s IS USING A DIFFERENT IDENTITY KEY\n"+
+			"    %s used to be %s, now it is %s. It could be someone else using the same name,\n"+
+			"    or someone pretending to be them. If you trust the new key, /repin %s",
+			msg.SenderName, msg.SenderName, p2p.ShortID(previous), p2p.ShortID(from), name),
+		Alert: true,
+	})
+
+	// this is worth a bell, even when nobody mentioned us
+	ui.ringBell()
+}
+
+// Method that rings the terminal bell, if the user wants it
+func (ui *UI) ringBell() {
+	if !ui.Bell {
+		return
+	}
+
+	atomic.StoreInt32(&ui.bellPending, 1)
+	ui.draws.Request()
+}
+
+// Method that checks if a message is for us, because it mentions us, or
+// because it has our name or one of our keywords in it
+func (ui *UI) isMentioned(msg chat.ChatMessage) bool {
+	if chat.Mentions(msg, ui.SelfID()) {
+		return true
+	}
+
+	words := ui.Keywords()
+	// everyone starts out with the default name, it calls no one in particular
+	if ui.Username != p2p.DefaultUsername {
+		words = append(words, ui.Username)
+	}
+
+	return chat.ContainsWord(msg.Message, words)
+}
+
+// Method that shows or hides when each message was sent
+func (ui *UI) SetTimestamps(on bool) {
+	ui.renderLock.Lock()
+	ui.timestamps = on
+	ui.renderLock.Unlock()
+
+	ui.rerender()
+}
+
+// Method that returns the words highlighting messages
+func (ui *UI) Keywords() []string {
+	ui.keywordsLock.Lock()
+	defer ui.keywordsLock.Unlock()
+
+	return append([]string(nil), ui.keywords...)
+}
+
+// Method that sets the words highlighting messages
+func (ui *UI) SetKeywords(words []string) {
+	ui.keywordsLock.Lock()
+	defer ui.keywordsLock.Unlock()
+
+	ui.keywords = words
+}
+
+// Method that applies an edit to the message it refers to, as long
+// as the edit comes from the author of the original message
+func (ui *UI) applyEdit(msg chat.ChatMessage) {
+	entry, ok := ui.buffer.Get(msg.Ref)
+	if !ok || entry.SenderID != msg.SenderID || !entry.IsEditable() {
+		return
+	}
+
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		e.Text = msg.Message
+		e.Edited = true
+	})
+	ui.historyError(ui.History.Edit(ui.RoomName, entry.ID, msg.SenderID, msg.Message))
+
+	ui.rerender()
+}
+
+// Method that retracts the message a delete refers to, as long
+// as the delete comes from the author of the original message
+func (ui *UI) applyDelete(msg chat.ChatMessage) {
+	entry, ok := ui.buffer.Get(msg.Ref)
+	if !ok {
+		// messages from before we joined might still be kept
+		ui.historyError(ui.History.Forget(ui.RoomName, msg.Ref, msg.SenderID))
+		return
+	}
+	if entry.SenderID != msg.SenderID || !entry.IsActive() {
+		return
+	}
+
+	ui.retract(entry)
+}
+
+// Method that adds a reaction to the message it refers to
+func (ui *UI) applyReaction(msg chat.ChatMessage) {
+	emoji, err := chat.ParseReaction(msg.Message)
+	if err != nil {
+		return
+	}
+
+	entry, ok := ui.buffer.Get(msg.Ref)
+	if !ok || entry.Deleted {
+		return
+	}
+
+	added := false
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		added = e.AddReaction(emoji, msg.SenderID)
+	})
+
+	if added {
+		ui.rerender()
+	}
+}
+
+// Method that attaches a link preview to the message it refers to, as long
+// as it comes from the author of the message and the link is in the message
+func (ui *UI) applyPreview(msg chat.ChatMessage) {
+	if msg.Preview == nil {
+		return
+	}
+
+	entry, ok := ui.buffer.Get(msg.Ref)
+	if !ok || entry.SenderID != msg.SenderID || !entry.IsActive() {
+		return
+	}
+
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		if strings.Contains(e.Text, msg.Preview.URL) {
+			e.Preview = msg.Preview
+		}
+	})
+
+	ui.rerender()
+}
+
+// Method that counts a vote in the poll it refers to
+func (ui *UI) applyVote(msg chat.ChatMessage) {
+	entry, ok := ui.buffer.Get(msg.Ref)
+	if !ok || !entry.IsPoll() {
+		return
+	}
+
+	voted := false
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		voted = e.Vote(msg.SenderID, msg.Choice)
+	})
+
+	if voted {
+		ui.rerender()
+	}
+}
+
+// Method that purges the content of a message from the buffer,
+// leaving just a marker in its place
+func (ui *UI) retract(entry *bufferEntry) {
+	var att *p2p.Attachment
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		att = e.Attachment
+		e.Text = ""
+		e.Attachment = nil
+		e.Poll = nil
+		e.Votes = nil
+		e.Command = nil
+		e.Response = nil
+		e.Preview = nil
+		e.Edited = false
+		e.Deleted = true
+		e.Reactions = nil
+	})
+
+	// a deleted image can no longer be downloaded either
+	if att != nil {
+		ui.forgetImage(att, entry.SenderID, entry.Self)
+	}
+
+	// nor can the message be found again
+	ui.historyError(ui.History.Forget(ui.RoomName, entry.ID, entry.SenderID))
+
+	ui.rerender()
+}
+
+// Method that drops a shared image, unsharing it if it was ours
+func (ui *UI) forgetImage(att *p2p.Attachment, senderID string, self bool) {
+	if self {
+		ui.Host.Attachments.Unshare(att.Hash)
+	}
+
+	ui.imagesLock.Lock()
+	defer ui.imagesLock.Unlock()
+
+	for i, img := range ui.images {
+		if img.att.Hash == att.Hash && img.from.Pretty() == senderID {
+			ui.images = append(ui.images[:i], ui.images[i+1:]...)
+			break
+		}
+	}
+}
+
+// Method that removes disappearing messages whose time is up
+func (ui *UI) expireMessages() {
+	now := time.Now()
+	ui.historyError(ui.History.Expire(now))
+
+	expired := ui.buffer.Expire(now)
+	if len(expired) == 0 {
+		return
+	}
+
+	for _, entry := range expired {
+		if entry.Attachment != nil {
+			ui.forgetImage(entry.Attachment, entry.SenderID, entry.Self)
+		}
+	}
+
+	ui.rerender()
+}
+
+// Method that formats a message list entry for display
+func (ui *UI) formatEntry(entry bufferEntry) string {
+	// expects the render lock to be held, as it is while printing
+	stamp := ""
+	if ui.timestamps && !entry.Time.IsZero() {
+		stamp = fmt.Sprintf("[%s]%s[-] ", ui.theme.Dim, tview.Escape(entry.Time.Format("[15:04]")))
+	}
+
+	if entry.IsLog() {
+		text := tview.Escape(entry.Text)
+		if entry.Alert {
+			first, rest := text, ""
+			if idx := strings.IndexByte(text, '\n'); idx >= 0 {
+				first, rest = text[:idx], text[idx:]
+			}
+			text = fmt.Sprintf("[%s:%s] %s [-:-]%s", ui.theme.Text, ui.theme.Alert, first, rest)
+		}
+		return fmt.Sprintf("%s[%s]<%s>:[-] %s", stamp, ui.theme.Log, entry.LogPrefix, text)
+	}
+
+	color := ui.theme.peerColor(entry.SenderID)
+	if entry.Self {
+		color = ui.theme.Self
+	}
+	name := entry.SenderName
+	if ui.collisions[name] {
+		name = chat.Disambiguate(name, entry.SenderID)
+	}
+	if id, err := peer.Decode(entry.SenderID); err == nil && !entry.Self {
+		name = ui.withPetname(id, name)
+		if ui.Host.PeerLists.IsVerified(id) {
+			name += " ✓"
+		}
+	}
+	name = tview.Escape(name)
+
+	prompt := fmt.Sprintf("[%s]<%s>:[-]", color, name)
+	if entry.Mentioned {
+		prompt = fmt.Sprintf("[%s:%s]<%s>:[-:-]", ui.theme.MentionText, ui.theme.Mention, name)
+	}
+	if len(ui.findQuery) > 0 && findMatch(entry, ui.findQuery) {
+		prompt = fmt.Sprintf("[%s:%s]<%s>:[-:-]", ui.theme.MentionText, ui.theme.Match, name)
+	}
+
+	if entry.Deleted {
+		return fmt.Sprintf("%s [%s]message deleted by author[-]", prompt, ui.theme.Dim)
+	}
+
+	// very long messages are cut short, so they can't flood the list
+	shown, cut := truncateText(entry.Text)
+	text := renderMarkdown(shown, ui.theme)
+	if cut {
+		text += fmt.Sprintf(" [%s]… (%s more, /expand to read all)[-]", ui.theme.Dim, p2p.FormatSize(int64(len(entry.Text)-len(shown))))
+	}
+	if entry.Attachment != nil {
+		text = ui.imageLine(entry.Attachment)
+	}
+	if entry.Attachment != nil && entry.Attachment.Mime == p2p.PasteMime {
+		text = tview.Escape(fmt.Sprintf("[paste: %s, %s — /view to read, /save to download]", entry.Attachment.Name, p2p.FormatSize(entry.Attachment.Size)))
+	}
+	if entry.Poll != nil {
+		text = formatPoll(entry.Poll, entry.Votes, entry.Votes[ui.SelfID().Pretty()], ui.theme)
+	}
+	if entry.Command != nil {
+		text = formatBotCommand(entry.Command)
+	}
+	if entry.Response != nil {
+		text = formatBotResponse(entry.Response, ui.theme)
+	}
+
+	if entry.Edited {
+		text += fmt.Sprintf(" [%s](edited)[-]", ui.theme.Dim)
+	}
+	if len(entry.SeenBy) > 0 {
+		text += fmt.Sprintf(" [%s]✓ %d[-]", ui.theme.Dim, len(entry.SeenBy))
+	}
+
+	// link previews go beneath the message
+	if entry.Preview != nil {
+		text += formatPreview(entry.Preview, ui.theme)
+	}
+
+	// reactions go on their own line, beneath the message
+	if len(entry.Reactions) > 0 {
+		text += fmt.Sprintf("\n    [%s]%s[-]", ui.theme.Dim, formatReactions(entry.Reactions))
+	}
+
+	// replies quote their parent, above the message
+	quote := ""
+	if len(entry.ReplyTo) > 0 {
+		quote = fmt.Sprintf("[%s]  ┌ %s[-]\n", ui.theme.Dim, ui.quoteLine(entry))
+	}
+
+	line := fmt.Sprintf("%s%s%s %s", quote, stamp, prompt, text)
+
+	// messages are regions, so search results can jump to them
+	if messageRegionPattern.MatchString(entry.ID) {
+		line = fmt.Sprintf(`["%s"]%s[""]`, entry.ID, line)
+	}
+
+	return line
+}
+
+// Method that renders the short quote of the message a reply is to. Without
+// the original around, the quote that came with the reply does, unless the
+// original was deleted since
+func (ui *UI) quoteLine(entry bufferEntry) string {
+	quote := ui.quoteOf(entry.ReplyTo)
+	if _, known := ui.buffer.Get(entry.ReplyTo); !known && entry.Quote != nil {
+		// the sender might not have cut it short
+		quote = chat.NewReplyQuote(entry.Quote.Sender, entry.Quote.Text)
+	}
+	if quote == nil {
+		return "reply to a message not in view"
+	}
+
+	return tview.Escape(fmt.Sprintf("<%s>: %s", quote.Sender, quote.Text))
+}
+
+// Method that checks if an entry belongs in the current view
+func (ui *UI) inView(entry bufferEntry) bool {
+	if len(ui.threadRoot) == 0 {
+		return true
+	}
+
+	if entry.IsLog() || len(entry.ID) == 0 {
+		return false
+	}
+
+	return ui.buffer.ThreadRoot(entry.ID) == ui.threadRoot
+}
+
+// Method that sets the message list title for the current room and view
+func (ui *UI) updateTitle() {
+	// the fingerprint is in the room name for joining, not for reading
+	name, _ := p2p.SplitRoomName(ui.RoomName)
+	if tab := ui.activeTab(); tab != nil && tab.room == ui.ChatRoom {
+		name = tab.Name()
+	}
+	title := fmt.Sprintf("ChatRoom: %s", tview.Escape(name))
+	if ui.HasPassword() {
+		title += " 🔒"
+	}
+	if meta := ui.Moderation.Meta(); len(meta.Topic) > 0 {
+		title += fmt.Sprintf(" — %s", tview.Escape(meta.Topic))
+	}
+	if ttl := ui.MessageTTL(); ttl > 0 {
+		title += fmt.Sprintf(" — ⏱ %s", ttl)
+	}
+	if len(ui.threadRoot) > 0 {
+		title += " — thread (/thread off to leave)"
+	}
+
+	if query, current, count := ui.findState(); len(query) > 0 {
+		if count == 0 {
+			title += fmt.Sprintf(" — find \"%s\": no matches (Esc)", tview.Escape(query))
+		} else {
+			title += fmt.Sprintf(" — find \"%s\": %d of %d (n/N, Esc)", tview.Escape(query), current, count)
+		}
+	}
+
+	ui.logsLock.Lock()
+	if ui.unseenLogs > 0 {
+		title += fmt.Sprintf(" — %d new logs (/logs)", ui.unseenLogs)
+	}
+	ui.logsLock.Unlock()
+
+	if unread := ui.unreadElsewhere(); unread > 0 {
+		title += fmt.Sprintf(" — %d unread in other rooms", unread)
+	}
+
+	ui.scrollLock.Lock()
+	if ui.unseenBelow {
+		title += " — [::b]↓ new messages below (PgDn)[::-]"
+	} else if ui.scrolledBack {
+		title += " — scrolled back (PgDn)"
+	}
+	ui.scrollLock.Unlock()
+
+	ui.messageList.SetTitle(title)
+}
+
+// Method that adds an entry to the buffer and prints it
+func (ui *UI) appendEntry(entry *bufferEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	ui.remember(entry)
+
+	// deferred first, so it runs once the render lock is let go
+	defer ui.trimScrollback()
+
+	ui.renderLock.Lock()
+	defer ui.renderLock.Unlock()
+
+	ui.buffer.Append(entry)
+	if !ui.inView(*entry) {
+		return
+	}
+
+	// batches go to the message list at once, rather than a line at a time
+	var list io.Writer = ui.messageList
+	if ui.batch != nil {
+		list = ui.batch
+	}
+	fmt.Fprint(list, ui.daySeparator(*entry))
+	fmt.Fprintln(list, ui.formatEntry(*entry))
+
+	// new matches can be gone through as well
+	if len(ui.findQuery) > 0 && findMatch(*entry, ui.findQuery) {
+		ui.findMatches = append(ui.findMatches, entry.ID)
+		go ui.queueUpdateDraw(ui.updateTitle)
+	}
+
+	// someone has to tell about messages out of sight
+	ui.scrollLock.Lock()
+	notify := ui.scrolledBack && !ui.unseenBelow && !entry.IsLog()
+	if notify {
+		ui.unseenBelow = true
+	}
+	ui.scrollLock.Unlock()
+
+	if notify {
+		go ui.queueUpdateDraw(ui.updateTitle)
+	}
+}
+
+// Method that scrolls the message list by the given number of lines,
+// up for negative ones. Runs on the UI goroutine, as input handlers do
+func (ui *UI) scrollMessages(lines int) {
+	row, _ := ui.messageList.GetScrollOffset()
+
+	ui.scrollLock.Lock()
+	if lines < 0 {
+		// nothing above, or everything fits anyway
+		if row == 0 {
+			ui.scrollLock.Unlock()
+			return
+		}
+		ui.scrolledBack = true
+		ui.scrollTarget = 0
+	} else if ui.scrolledBack {
+		// the next draw tells if this went past the end
+		ui.scrollTarget = row + lines
+	}
+	ui.scrollLock.Unlock()
+
+	target := row + lines
+	if target < 0 {
+		target = 0
+	}
+	ui.messageList.ScrollTo(target, 0)
+	ui.updateTitle()
+}
+
+// Method that checks, after a draw, if the last scroll down reached the
+// end of the message list. Drawing pulls the offset back when it went past,
+// and the list follows new messages again from there
+func (ui *UI) checkScrollEnd() {
+	ui.scrollLock.Lock()
+	target := ui.scrollTarget
+	ui.scrollTarget = 0
+	ui.scrollLock.Unlock()
+
+	if target == 0 {
+		return
+	}
+
+	if row, _ := ui.messageList.GetScrollOffset(); row < target {
+		ui.scrollLock.Lock()
+		ui.scrolledBack = false
+		ui.unseenBelow = false
+		ui.scrollLock.Unlock()
+
+		ui.queueTitleUpdate()
+	}
+}
+
+// Method that brings the message list back to the latest message,
+// if it was scrolled back. Runs on the UI goroutine, as input handlers do
+func (ui *UI) scrollToLatest() {
+	ui.scrollLock.Lock()
+	scrolled := ui.scrolledBack
+	ui.scrolledBack = false
+	ui.unseenBelow = false
+	ui.scrollTarget = 0
+	ui.scrollLock.Unlock()
+
+	if !scrolled && len(ui.messageList.GetHighlights()) == 0 {
+		return
+	}
+
+	ui.messageList.Highlight()
+	ui.messageList.ScrollToEnd()
+	ui.updateTitle()
+}
+
+// Method that prints the whole buffer again,
+// after one of the entries already displayed has changed
+func (ui *UI) rerender() {
+	ui.renderLock.Lock()
+	defer ui.renderLock.Unlock()
+
+	text := &strings.Builder{}
+	text.WriteString(ui.sessionStart())
+	text.WriteString(ui.trimmedLine())
+	for _, entry := range ui.buffer.Entries() {
+		if ui.inView(entry) {
+			text.WriteString(ui.daySeparator(entry))
+			fmt.Fprintln(text, ui.formatEntry(entry))
+		}
+	}
+
+	ui.messageList.SetText(text.String())
+	// which has what the batch held so far
+	if ui.batch != nil {
+		ui.batch.Reset()
+	}
+}
+
+// Method that drops the oldest messages and logs past the scrollback from
+// the message list, printing what is left again. The history keeps them,
+// if there is one
+func (ui *UI) trimScrollback() {
+	if len(ui.buffer.Trim(ui.Scrollback)) > 0 {
+		ui.rerender()
+	}
+}
+
+// Method that returns the line telling how many of the oldest messages were
+// dropped from the message list, if any were
+func (ui *UI) trimmedLine() string {
+	trimmed := ui.buffer.Trimmed()
+	if trimmed == 0 {
+		return ""
+	}
+
+	where := "start with -history to keep them"
+	if ui.History != nil {
+		where = "/history has them"
+	}
+
+	return fmt.Sprintf("[%s]— %d older lines dropped, %s —[-]\n", ui.theme.Dim, trimmed, where)
+}
+
+// Method that returns the line that separates a message from those of
+// the day before, if it is the first of its day. Expects the render lock
+// to be held, as it is while printing
+func (ui *UI) daySeparator(entry bufferEntry) string {
+	if entry.Time.IsZero() {
+		return ""
+	}
+
+	day := entry.Time.Format(dayLayout)
+	if day == ui.lastDay {
+		return ""
+	}
+	ui.lastDay = day
+
+	return fmt.Sprintf("[%s]— %s —[-]\n", ui.theme.Dim, formatDay(entry.Time))
+}
+
+// Method that returns the line the message list starts with, telling when
+// we started. Expects the render lock to be held, as it is while printing
+func (ui *UI) sessionStart() string {
+	ui.lastDay = ui.started.Format(dayLayout)
+
+	return fmt.Sprintf("[%s]— session started %s at %s —[-]\n", ui.theme.Dim, formatDay(ui.started), ui.started.Format("15:04"))
+}
+
+// This one formats a day for the separators, with the year only when it isn't this one
+func formatDay(t time.Time) string {
+	if t.Year() != time.Now().Year() {
+		return t.Format("Monday, Jan 2 2006")
+	}
+
+	return t.Format("Monday, Jan 2")
+}
+
+// Method that empties the message list
+func (ui *UI) clearMessages() {
+	ui.renderLock.Lock()
+	defer ui.renderLock.Unlock()
+
+	ui.buffer.Clear()
+	ui.messageList.Clear()
+	fmt.Fprint(ui.messageList, ui.sessionStart())
+	if ui.batch != nil {
+		ui.batch.Reset()
+	}
+
+	// nothing left to scroll back to
+	ui.scrollLock.Lock()
+	ui.scrolledBack = false
+	ui.unseenBelow = false
+	ui.scrollLock.Unlock()
+	ui.messageList.ScrollToEnd()
+}
+
+// Method that renders the placeholder line of a shared image
+func (ui *UI) imageLine(att *p2p.Attachment) string {
+	hint := "/save to download"
+	if ui.Graphics != graphicsNone {
+		hint = "/view to preview, /save to download"
+	}
+
+	return tview.Escape(fmt.Sprintf("[image: %s, %s — %s]", att.Name, p2p.FormatSize(att.Size), hint))
+}
+
+// Method that finds the newest shared image with the given name,
+// or just the newest one when the name is empty
+func (ui *UI) findImage(name string) (sharedImage, bool) {
+	ui.imagesLock.Lock()
+	defer ui.imagesLock.Unlock()
+
+	for i := len(ui.images) - 1; i >= 0; i-- {
+		if name == "" || ui.images[i].att.Name == name {
+			return ui.images[i], true
+		}
+	}
+
+	return sharedImage{}, false
+}
+
+// Method that prints log messages to the log pane, and the log file
+func (ui *UI) printLogMessage(log chat.ChatLog) {
+	ui.recordLog(ui.ChatRoom, log)
+	ui.showLogMessage(log)
+}
+
+// Method that writes a log of the room to the log file, if there is one
+func (ui *UI) recordLog(cr *chat.ChatRoom, log chat.ChatLog) {
+	if ui.FileLog == nil {
+		return
+	}
+
+	entry := ui.FileLog.WithFields(logrus.Fields{
+		"room":   cr.RoomName,
+		"prefix": log.Prefix,
+	})
+	if isErrorLog(log) {
+		entry.Warnln(log.Msg)
+	} else {
+		entry.Infoln(log.Msg)
+	}
+}
+
+// Method that adds a log to the log pane
+func (ui *UI) showLogMessage(log chat.ChatLog) {
+	// logs tell of topics, names and errors that came from other peers
+	entry := bufferEntry{LogPrefix: log.Prefix, Text: p2p.SanitizeText(log.Msg), Alert: log.Alert, Time: time.Now()}
+
+	ui.renderLock.Lock()
+	line := ui.formatEntry(entry)
+	ui.renderLock.Unlock()
+
+	fmt.Fprintln(ui.logList, line)
+
+	// quiet mode only lets errors through, and only for a moment
+	if ui.isQuiet() {
+		if isErrorLog(log) {
+			ui.flashError(log)
+		}
+		return
+	}
+
+	// the title counts what a hidden pane holds back
+	if !ui.panes.logsShown() {
+		ui.logsLock.Lock()
+		ui.unseenLogs++
+		ui.logsLock.Unlock()
+
+		ui.queueTitleUpdate()
+	}
+}
+
+// Method that refreshes the status bar, with who is around and how
+// well we are connected, so it's no guess whether discovery worked
+func (ui *UI) syncStatus() {
+	th := ui.theme
+	item := func(label, value string) string {
+		return fmt.Sprintf("[%s]%s:[-] %s", th.Dim, label, value)
+	}
+
+	reachability := strings.ToLower(ui.Host.Reachability().String())
+	routing := "0 peers"
+	if ui.Host.KadDHT != nil {
+		routing = fmt.Sprintf("%d peers", ui.Host.KadDHT.RoutingTable().Size())
+	}
+	// peers nearby still chat, the rest of the world comes later
+	if ui.Host.BootstrapRetrying() {
+		routing = fmt.Sprintf("[%s]no bootstrap peer yet, retrying[-]", th.Alert)
+	}
+
+	name := ui.RoomName
+	if tab := ui.activeTab(); tab != nil {
+		name = tab.Name()
+	}
+
+	items := []string{
+		item("room", tview.Escape(name)),
+		item("user", tview.Escape(ui.Username)),
+		item("peers", fmt.Sprintf("%d here, %d connected", len(ui.GetPeers()), len(ui.Host.Host.Network().Peers()))),
+		item("nat", reachability),
+		item("dht", routing),
+	}
+	// started without waiting any longer, the rest is still under way
+	if state := ui.Host.Startup(); state != p2p.StartupJoined {
+		items = append(items, item("startup", string(state)))
+	}
+	status := strings.Join(items, " │ ")
+
+	if flash, ok := ui.flashing(); ok {
+		status = flash
+	}
+
+	// the status bar has no say in drawing, so it asks when it changed
+	if text := " " + status; ui.statusBar.GetText(false) != text {
+		ui.statusBar.SetText(text)
+		ui.draws.Request()
+	}
+}
+
+// Method that refreshes the listo of peers
+func (ui *UI) syncPeerList() {
+	// get all chatroom peers, by name so they don't jump around
+	peers := ui.GetPeers()
+	collisions := ui.UsernameCollisions()
+
+	labels := make(map[peer.ID]string, len(peers))
+	for _, p := range peers {
+		labels[p] = ui.peerLabel(p, collisions)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if labels[peers[i]] != labels[peers[j]] {
+			return labels[peers[i]] < labels[peers[j]]
+		}
+		return peers[i] < peers[j]
+	})
+
+	listed := make([]string, len(peers))
+	for i, p := range peers {
+		listed[i] = labels[p]
+	}
+
+	// refresh the UI, the selection stays with the same peer,
+	// and the same list isn't drawn again
+	ui.TerminalApp.QueueUpdate(func() {
+		if samePeerList(peers, listed, ui.listedPeers, ui.listedLabels) {
+			return
+		}
+
+		var selected peer.ID
+		if current := ui.peerList.GetCurrentItem(); current < len(ui.listedPeers) {
+			selected = ui.listedPeers[current]
+		}
+
+		ui.peerList.Clear()
+		for i, p := range peers {
+			ui.peerList.AddItem(labels[p], "", 0, nil)
+			if p == selected {
+				ui.peerList.SetCurrentItem(i)
+			}
+		}
+		ui.listedPeers = peers
+		ui.listedLabels = listed
+
+		if len(peers) == 0 && ui.peerList.HasFocus() {
+			ui.TerminalApp.SetFocus(ui.inputField)
+		}
+		ui.draws.Request()
+	})
+}
+
+// This one checks if the peers would be listed the same way they are
+func samePeerList(peers []peer.ID, labels []string, listedPeers []peer.ID, listedLabels []string) bool {
+	if len(peers) != len(listedPeers) || len(labels) != len(listedLabels) {
+		return false
+	}
+
+	for i := range peers {
+		if peers[i] != listedPeers[i] || labels[i] != listedLabels[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Method that looks for usernames held by more than one peer,
+// and tells them apart in the message list when that changes
+func (ui *UI) refreshCollisions() {
+	collisions := ui.UsernameCollisions()
+
+	ui.renderLock.Lock()
+	previous := ui.collisions
+	changed := len(previous) != len(collisions)
+	for name := range collisions {
+		changed = changed || !previous[name]
+	}
+	ui.collisions = collisions
+	ui.renderLock.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if collisions[ui.Username] && !previous[ui.Username] {
+		ui.printLogMessage(chat.ChatLog{
+			Prefix: "username",
+			Msg:    fmt.Sprintf("someone else here is also called %s, you are shown as %s", ui.Username, chat.Disambiguate(ui.Username, ui.SelfID().Pretty())),
+		})
+	}
+
+	ui.rerender()
+}
+
+// Method that shows a modal asking the user to accept or decline a file
+func (ui *UI) promptFileOffer(offer *p2p.FileOffer) {
+	// each offer gets its own page, so they can stack up
+	page := fmt.Sprintf("offer-%s-%s", offer.From.Pretty(), offer.Name)
+
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("%s (%s) wants to send you\n%s (%d bytes)",
+			tview.Escape(p2p.SanitizeText(offer.SenderName)), p2p.ShortID(offer.From), tview.Escape(p2p.SanitizeText(offer.Name)), offer.Size)).
+		AddButtons([]string{"Accept", "Decline"}).
+		SetDoneFunc(func(_ int, label string) {
+			offer.Answer(label == "Accept")
+			ui.pages.RemovePage(page)
+			ui.TerminalApp.SetFocus(ui.inputField)
+		})
+
+	ui.queueUpdateDraw(func() {
+		ui.pages.AddPage(page, modal, true, true)
+		ui.TerminalApp.SetFocus(modal)
+	})
+}
+
+// Method that offers to send text too long for a message as a paste
+func (ui *UI) promptPaste(text string, replyTo string) {
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("This message is %s, too long to send as is.\nSend it as a paste attachment instead?", p2p.FormatSize(int64(len(text))))).
+		AddButtons([]string{"Send as paste", "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			ui.pages.RemovePage("paste")
+			ui.TerminalApp.SetFocus(ui.inputField)
+
+			if label == "Send as paste" {
+				go ui.sendPaste(text, replyTo)
+			} else {
+				// give the text back, so it isn't lost
+				ui.inputField.SetText(text)
+			}
+		})
+
+	ui.queueUpdateDraw(func() {
+		ui.pages.AddPage("paste", modal, true, true)
+		ui.TerminalApp.SetFocus(modal)
+	})
+}
+
+// Method that shares long text as a paste attachment and announces it
+func (ui *UI) sendPaste(text string, replyTo string) {
+	att, err := ui.Host.Attachments.SharePaste(text)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "pasteerr", Msg: fmt.Sprintf("could not share paste: %s", err)})
+		return
+	}
+
+	pasteMsg := chat.ChatMessage{Type: chat.MessagePaste, ID: chat.NewMessageID(), Attachment: att, ReplyTo: replyTo, TTL: ui.messageTTL()}
+	if len(replyTo) > 0 {
+		pasteMsg.Quote = ui.quoteOf(replyTo)
+	}
+	ui.ChatRoom.Send(pasteMsg)
+	ui.printSelfMessage(pasteMsg)
+}
+
+// Method that shows who has seen one of our messages
+func (ui *UI) showSeenBy(entry *bufferEntry) {
+	var seenBy []string
+	ui.buffer.Update(entry, func(e *bufferEntry) {
+		seenBy = append(seenBy, e.SeenBy...)
+	})
+
+	names := make([]string, 0, len(seenBy))
+	for _, seen := range seenBy {
+		id, err := peer.Decode(seen)
+		if err != nil {
+			continue
+		}
+
+		name := p2p.ShortID(id)
+		if prof := ui.Host.Profiles.Lookup(id); prof != nil {
+			name = chat.Disambiguate(prof.Username, seen)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text := "Nobody has seen this message yet"
+	if len(names) > 0 {
+		text = fmt.Sprintf("Seen by %d:\n%s", len(names), strings.Join(names, "\n"))
+	}
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"OK"}).
+		SetDoneFunc(func(int, string) {
+			ui.pages.RemovePage("seen")
+			ui.TerminalApp.SetFocus(ui.inputField)
+		})
+
+	ui.queueUpdateDraw(func() {
+		ui.pages.AddPage("seen", modal, true, true)
+		ui.TerminalApp.SetFocus(modal)
+	})
+}
+
+// Method that keeps a message in the history, if we keep one
+func (ui *UI) remember(entry *bufferEntry) {
+	if ui.History == nil || entry.IsLog() || len(entry.ID) == 0 {
+		return
+	}
+
+	text := entry.Text
+	switch {
+	case entry.Attachment != nil:
+		text = entry.Attachment.Name
+	case entry.Poll != nil:
+		text = entry.Poll.Question
+	case entry.Response != nil:
+		text = entry.Response.Text
+	}
+
+	rec := chat.HistoryRecord{
+		Room:       ui.RoomName,
+		ID:         entry.ID,
+		SenderID:   entry.SenderID,
+		SenderName: entry.SenderName,
+		Text:       text,
+		Sent:       time.Now().Unix(),
+	}
+	if !entry.Expires.IsZero() {
+		rec.Expires = entry.Expires.Unix()
+	}
+
+	ui.historyError(ui.History.Add(rec))
+}
+
+// Method that logs a failure to update the history on disk
+func (ui *UI) historyError(err error) {
+	if err != nil {
+		ui.printLogMessage(chat.ChatLog{Prefix: "histerr", Msg: fmt.Sprintf("could not update the history: %s", err)})
+	}
+}
+
+// Method that searches the history of every room we were in
+func (ui *UI) handleSearch(query string) {
+	if ui.History == nil {
+		ui.Log(chat.ChatLog{Prefix: "search", Msg: "no history is kept, start with -history to keep one"})
+		return
+	}
+
+	if len(chat.SearchWords(query)) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /search <words>"})
+		return
+	}
+
+	results := ui.History.Search(query)
+	if len(results) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "search", Msg: fmt.Sprintf("nothing found for %q", query)})
+		return
+	}
+
+	ui.showSearch(query, results)
+}
+
+// Method that shows search results in a panel above the chat,
+// Enter jumps to the selected message and Esc closes the panel
+func (ui *UI) showSearch(query string, results []chat.HistoryRecord) {
+	list := tview.NewList().
+		SetHighlightFullLine(true)
+	list.SetBorder(true).
+		SetTitle(tview.Escape(fmt.Sprintf("Search: %s — %d found, Enter to jump, Esc to close", query, len(results))))
+
+	for _, rec := range results {
+		rec := rec
+		room, _ := p2p.SplitRoomName(rec.Room)
+		where := fmt.Sprintf("%s · %s · <%s>", room, time.Unix(rec.Sent, 0).Format("2006-01-02 15:04"), rec.SenderName)
+
+		// the first line is enough to tell results apart
+		text := rec.Text
+		if idx := strings.IndexByte(text, '\n'); idx >= 0 {
+			text = text[:idx] + " …"
+		}
+
+		list.AddItem(tview.Escape(where), "  "+tview.Escape(text), 0, func() {
+			ui.jumpTo(rec)
+		})
+	}
+
+	list.SetDoneFunc(func() {
+		ui.pages.RemovePage("search")
+		ui.TerminalApp.SetFocus(ui.inputField)
+	})
+
+	ui.queueUpdateDraw(func() {
+		ui.pages.AddPage("search", list, true, true)
+		ui.TerminalApp.SetFocus(list)
+	})
+}
+
+// Method that jumps to a search result, in the message list while
+// it is still there, or in the history around it otherwise.
+// Runs on the UI goroutine, as the search panel calls it
+func (ui *UI) jumpTo(rec chat.HistoryRecord) {
+	ui.pages.RemovePage("search")
+	ui.TerminalApp.SetFocus(ui.inputField)
+
+	if rec.Room == ui.RoomName && messageRegionPattern.MatchString(rec.ID) {
+		if entry, ok := ui.buffer.Get(rec.ID); ok && ui.inView(*entry) {
+			ui.messageList.Highlight(rec.ID).ScrollToHighlight()
+
+			ui.scrollLock.Lock()
+			ui.scrolledBack = true
+			ui.scrollLock.Unlock()
+			ui.updateTitle()
+			return
+		}
+	}
+
+	ui.showContext(rec)
+}
+
+// Method that shows a kept message among the ones around it, until Esc is pressed
+func (ui *UI) showContext(rec chat.HistoryRecord) {
+	room, _ := p2p.SplitRoomName(rec.Room)
+	title := fmt.Sprintf("%s — Esc to close", room)
+	if rec.Room != ui.RoomName {
+		title = fmt.Sprintf("%s — /room %s to go back there, Esc to close", room, rec.Room)
+	}
+
+	text := &strings.Builder{}
+	for _, r := range ui.History.Context(rec.Room, rec.ID, searchContext) {
+		line := fmt.Sprintf("%s <%s>: %s", time.Unix(r.Sent, 0).Format("2006-01-02 15:04"), tview.Escape(r.SenderName), tview.Escape(r.Text))
+		if r.ID == rec.ID {
+			line = fmt.Sprintf("[%s:%s]%s[-:-]", ui.theme.MentionText, ui.theme.Mention, line)
+		}
+		fmt.Fprintln(text, line)
+	}
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(text.String()).
+		SetScrollable(true)
+	view.SetBorder(true).
+		SetTitle(tview.Escape(title))
+	view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			ui.pages.RemovePage("context")
+			ui.TerminalApp.SetFocus(ui.inputField)
+		}
+	})
+
+	ui.pages.AddPage("context", view, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
+
+// Method that shows a paste above the chat, until Esc is pressed
+func (ui *UI) showPaste(name string, data []byte) {
+	view := tview.NewTextView().
+		SetText(p2p.SanitizeText(string(data))).
+		SetScrollable(true)
+	view.SetBorder(true).
+		SetTitle(fmt.Sprintf("%s — Esc to close", tview.Escape(p2p.SanitizeText(name))))
+	view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			ui.pages.RemovePage("view-paste")
+			ui.TerminalApp.SetFocus(ui.inputField)
+		}
+	})
+
+	ui.queueUpdateDraw(func() {
+		ui.pages.AddPage("view-paste", view, true, true)
+		ui.TerminalApp.SetFocus(view)
+	})
+}
+
+func (ui *UI) handleCommand(cmd uiCommand) {
+	switch cmd.cmdtype {
+	case "/quit":
+		// stop chatting, go home
+		ui.TerminalApp.Stop()
+		return
+
+	case "/clear":
+		// clear UI message box
+		ui.clearMessages()
+
+	case "/room":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "missing room name for command"})
+			return
+		}
+
+		roomName := cmd.cmdarg
+		if args := strings.SplitN(cmd.cmdarg, " ", 2); args[0] == "create" && len(args) == 2 {
+			created, err := ui.Host.RoomKeys.Create(strings.TrimSpace(args[1]))
+			if err != nil {
+				ui.Log(chat.ChatLog{Prefix: "jumperr", Msg: fmt.Sprintf("could not create room: %s", err)})
+				return
+			}
+
+			ui.Log(chat.ChatLog{Prefix: "roomchange", Msg: fmt.Sprintf("created room %s, share this name to invite others", created)})
+			roomName = created
+		}
+
+		ui.openRoom(roomName)
+		ui.saveSettings(func(s *Settings) { s.Room = roomName })
+
+	case "/leave":
+		ui.leaveRoom()
+
+	case "/user":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "missing user name for command"})
+		} else {
+			// we are the same person in every room
+			ui.tabsLock.Lock()
+			for _, tab := range ui.tabs {
+				tab.room.UpdateUser(cmd.cmdarg)
+			}
+			ui.tabsLock.Unlock()
+			ui.inputField.SetLabel(fmt.Sprintf("%s > ", ui.Username))
+			ui.saveSettings(func(s *Settings) { s.Username = cmd.cmdarg })
+
+			// the name is ours anyway, but others should be able to tell us apart
+			if holders := ui.UsernameHolders(ui.Username); len(holders) > 0 {
+				ui.Log(chat.ChatLog{
+					Prefix: "username",
+					Msg: fmt.Sprintf("%s is already used by %s, you will be shown as %s", ui.Username,
+						chat.Disambiguate(ui.Username, holders[0].Pretty()), chat.Disambiguate(ui.Username, ui.SelfID().Pretty())),
+				})
+			}
+		}
+
+	case "/edit":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "missing new text for command"})
+			return
+		}
+
+		if err := chat.CheckMessageLength(cmd.cmdarg); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "toolong", Msg: err.Error()})
+			return
+		}
+
+		last, ok := ui.buffer.LastSelf((*bufferEntry).IsEditable)
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no message of yours to edit"})
+			return
+		}
+
+		ui.ChatRoom.Send(chat.ChatMessage{Type: chat.MessageEdit, Ref: last.ID, Message: cmd.cmdarg})
+
+		ui.buffer.Update(last, func(e *bufferEntry) {
+			e.Text = cmd.cmdarg
+			e.Edited = true
+		})
+		ui.historyError(ui.History.Edit(ui.RoomName, last.ID, last.SenderID, cmd.cmdarg))
+		ui.rerender()
+
+	case "/delete":
+		var entry *bufferEntry
+		var ok bool
+		if len(cmd.cmdarg) == 0 {
+			entry, ok = ui.buffer.LastSelf((*bufferEntry).IsActive)
+		} else {
+			entry, ok = ui.buffer.FindSelf(cmd.cmdarg)
+		}
+
+		if !ok || !entry.IsActive() {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no message of yours to delete"})
+			return
+		}
+
+		ui.ChatRoom.Send(chat.ChatMessage{Type: chat.MessageDelete, Ref: entry.ID})
+		ui.retract(entry)
+
+	case "/react":
+		emoji, err := chat.ParseReaction(cmd.cmdarg)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+			return
+		}
+
+		entry, ok := ui.buffer.Last((*bufferEntry).IsActive)
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no message to react to"})
+			return
+		}
+
+		ui.ChatRoom.Send(chat.ChatMessage{Type: chat.MessageReact, Ref: entry.ID, Message: emoji})
+
+		added := false
+		ui.buffer.Update(entry, func(e *bufferEntry) {
+			added = e.AddReaction(emoji, ui.SelfID().Pretty())
+		})
+		if added {
+			ui.rerender()
+		}
+
+	case "/poll":
+		p, err := chat.ParsePoll(cmd.cmdarg)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+			return
+		}
+
+		pollMsg := chat.ChatMessage{Type: chat.MessagePoll, ID: chat.NewMessageID(), Poll: p, TTL: ui.messageTTL()}
+		ui.ChatRoom.Send(pollMsg)
+		ui.printSelfMessage(pollMsg)
+
+	case "/vote":
+		choice, err := strconv.Atoi(cmd.cmdarg)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /vote <option number>"})
+			return
+		}
+
+		// vote in the latest poll
+		entry, ok := ui.buffer.Last((*bufferEntry).IsPoll)
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no poll to vote in"})
+			return
+		}
+
+		voted, valid := false, false
+		ui.buffer.Update(entry, func(e *bufferEntry) {
+			valid = e.Poll != nil && choice >= 1 && choice <= len(e.Poll.Options)
+			voted = e.Vote(ui.SelfID().Pretty(), choice)
+		})
+		if !valid {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("the poll has no option %d", choice)})
+			return
+		}
+		if !voted {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("you already voted for %d", choice)})
+			return
+		}
+
+		ui.ChatRoom.Send(chat.ChatMessage{Type: chat.MessageVote, Ref: entry.ID, Choice: choice})
+		ui.rerender()
+
+	case "/reply":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "missing reply text for command"})
+			return
+		}
+
+		// reply to the message picked, or the latest message from someone else
+		parent, ok := ui.buffer.Last(func(e *bufferEntry) bool { return !e.Self && e.IsActive() })
+		if len(cmd.cmdref) > 0 {
+			parent, ok = ui.buffer.Get(cmd.cmdref)
+			ok = ok && parent.IsActive()
+		}
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no message to reply to"})
+			return
+		}
+
+		ui.sendReply(cmd.cmdarg, parent.ID)
+
+	case "/thread":
+		if cmd.cmdarg == "off" {
+			ui.threadRoot = ""
+		} else {
+			// show the thread with the latest reply in it
+			reply, ok := ui.buffer.Last(func(e *bufferEntry) bool { return len(e.ReplyTo) > 0 })
+			if !ok {
+				ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no threads in this room yet"})
+				return
+			}
+			ui.threadRoot = ui.buffer.ThreadRoot(reply.ID)
+		}
+
+		ui.updateTitle()
+		ui.rerender()
+
+	case "/mod":
+		ui.handleModeration(cmd.cmdarg)
+
+	case "/password":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /password <password|off>"})
+			return
+		}
+
+		password := cmd.cmdarg
+		if password == "off" {
+			password = ""
+		}
+
+		if err := ui.SetPassword(password); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "autherr", Msg: fmt.Sprintf("could not set the password: %s", err)})
+			return
+		}
+
+		if len(password) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "auth", Msg: "the room is open to everyone again"})
+		} else {
+			ui.Log(chat.ChatLog{Prefix: "auth", Msg: "only peers who know the password are heard now, and only they can read you"})
+		}
+
+		ui.queueTitleUpdate()
+
+	case "/dm":
+		target, err := ui.FindPeer(cmd.cmdarg)
+		if len(cmd.cmdarg) == 0 || err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /dm <peer>, with the peer from the peer list"})
+			return
+		}
+
+		ui.startDM(target)
+
+	case "/expand":
+		entry, ok := ui.expandable()
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no message was cut short"})
+			return
+		}
+
+		ui.showPaste(fmt.Sprintf("message from %s", entry.SenderName), []byte(entry.Text))
+
+	case "/find":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "what to find? /find <text>, or Ctrl+F to find as you type"})
+			return
+		}
+
+		ui.queueUpdateDraw(func() {
+			if ui.findText(cmd.cmdarg) > 0 {
+				ui.TerminalApp.SetFocus(ui.messageList)
+				return
+			}
+
+			ui.stopFind()
+			go func() {
+				ui.Log(chat.ChatLog{Prefix: "find", Msg: "no message in view has that text"})
+			}()
+		})
+
+	case "/search":
+		ui.handleSearch(cmd.cmdarg)
+
+	case "/topic":
+		ui.handleTopic(cmd.cmdarg)
+
+	case "/ttl":
+		ttl, err := parseTTL(cmd.cmdarg)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+			return
+		}
+
+		ui.TTL = ttl
+		if ttl == 0 {
+			ui.Log(chat.ChatLog{Prefix: "ttl", Msg: "your messages no longer disappear"})
+		} else {
+			ui.Log(chat.ChatLog{Prefix: "ttl", Msg: fmt.Sprintf("your messages disappear after %s", ttl)})
+		}
+		if ui.Moderation.TTL() > 0 {
+			ui.Log(chat.ChatLog{Prefix: "ttl", Msg: fmt.Sprintf("but the room creator set %s for everyone", ui.Moderation.TTL())})
+		}
+
+		ui.queueTitleUpdate()
+
+	case "/block", "/mute":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("usage: %s <peer>", cmd.cmdtype)})
+			return
+		}
+
+		target, err := ui.FindPeer(cmd.cmdarg)
+		if err != nil {
+			// peers no longer around can still be listed by their full ID
+			if target, err = peer.Decode(cmd.cmdarg); err != nil {
+				ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no peer matching %s in the room", cmd.cmdarg)})
+				return
+			}
+		}
+
+		lists := ui.Host.PeerLists
+		if cmd.cmdtype == "/block" {
+			err = lists.Block(target)
+		} else {
+			err = lists.Mute(target)
+		}
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the lists: %s", err)})
+		}
+
+		if cmd.cmdtype == "/block" && lists.Gate {
+			ui.Host.Host.Network().ClosePeer(target)
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "lists", Msg: fmt.Sprintf("%s %s", listVerbs[cmd.cmdtype], p2p.ShortID(target))})
+
+	case "/unblock", "/unmute":
+		target, ok := ui.Host.PeerLists.Find(cmd.cmdarg)
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no listed peer matching %s", cmd.cmdarg)})
+			return
+		}
+
+		var err error
+		if cmd.cmdtype == "/unblock" {
+			err = ui.Host.PeerLists.Unblock(target)
+		} else {
+			err = ui.Host.PeerLists.Unmute(target)
+		}
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the lists: %s", err)})
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "lists", Msg: fmt.Sprintf("%s %s", listVerbs[cmd.cmdtype], p2p.ShortID(target))})
+
+	case "/watch", "/unwatch":
+		ui.handleWatch(cmd)
+
+	case "/history":
+		ui.handleHistory(cmd.cmdarg)
+
+	case "/sync":
+		ui.handleSync(cmd.cmdarg)
+
+	case "/help":
+		ui.showHelp(cmd.cmdarg)
+
+	case "/peers":
+		ui.listPeers()
+
+	case "/logs":
+		if ui.isQuiet() {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "quiet mode hides the log pane, /quiet off shows it"})
+			return
+		}
+
+		ui.queueUpdateDraw(func() {
+			ui.changePanes(ui.panes.toggleLogs)
+		})
+
+	case "/quiet":
+		if cmd.cmdarg != "on" && cmd.cmdarg != "off" {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /quiet on|off"})
+			return
+		}
+
+		on := cmd.cmdarg == "on"
+		ui.queueUpdateDraw(func() {
+			ui.SetQuiet(on)
+		})
+		ui.saveSettings(func(s *Settings) { s.Quiet = on })
+
+	case "/keymap":
+		ui.queueUpdateDraw(func() {
+			if len(cmd.cmdarg) > 0 {
+				if err := ui.SetKeymap(cmd.cmdarg); err != nil {
+					go func() { ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()}) }()
+					return
+				}
+				ui.saveSettings(func(s *Settings) { s.Keymap = cmd.cmdarg })
+			}
+
+			keymap := ui.keymap()
+			go func() {
+				ui.Log(chat.ChatLog{Prefix: "keymap", Msg: fmt.Sprintf("using the %s keymap", keymap)})
+			}()
+		})
+
+	case "/layout":
+		switch cmd.cmdarg {
+		case "":
+			ui.Log(chat.ChatLog{Prefix: "layout", Msg: ui.panes.String()})
+		case "peers":
+			ui.queueUpdateDraw(func() {
+				ui.changePanes(ui.panes.togglePeers)
+			})
+		case "logs":
+			ui.queueUpdateDraw(func() {
+				ui.changePanes(ui.panes.toggleLogs)
+			})
+		case "reset":
+			ui.queueUpdateDraw(func() {
+				ui.changePanes(ui.panes.reset)
+			})
+		default:
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /layout [peers | logs | reset], Ctrl and the arrows resize the panes"})
+		}
+
+	case "/timestamps":
+		if cmd.cmdarg != "on" && cmd.cmdarg != "off" {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /timestamps on|off"})
+			return
+		}
+
+		on := cmd.cmdarg == "on"
+		ui.SetTimestamps(on)
+		ui.saveSettings(func(s *Settings) { s.Timestamps = on })
+
+	case "/keywords":
+		switch cmd.cmdarg {
+		case "":
+		case "off":
+			ui.SetKeywords(nil)
+			ui.saveSettings(func(s *Settings) { s.Keywords = nil })
+		default:
+			words := strings.Fields(cmd.cmdarg)
+			ui.SetKeywords(words)
+			ui.saveSettings(func(s *Settings) { s.Keywords = words })
+		}
+
+		if words := ui.Keywords(); len(words) > 0 {
+			ui.Log(chat.ChatLog{Prefix: "keywords", Msg: fmt.Sprintf("messages with your name or %s are highlighted", strings.Join(words, ", "))})
+		} else {
+			ui.Log(chat.ChatLog{Prefix: "keywords", Msg: "messages with your name are highlighted"})
+		}
+
+	case "/lists":
+		blocked, muted := ui.Host.PeerLists.Summary()
+		ui.Log(chat.ChatLog{Prefix: "lists", Msg: fmt.Sprintf("blocked: %s | muted: %s", strings.Join(blocked, ", "), strings.Join(muted, ", "))})
+
+	case "/verify":
+		ui.handleVerify(cmd.cmdarg)
+
+	case "/alias":
+		ui.handleAlias(cmd.cmdarg)
+
+	case "/seen":
+		var entry *bufferEntry
+		var ok bool
+		if len(cmd.cmdarg) == 0 {
+			entry, ok = ui.buffer.LastSelf((*bufferEntry).IsActive)
+		} else {
+			entry, ok = ui.buffer.FindSelf(cmd.cmdarg)
+		}
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no message of yours to look at"})
+			return
+		}
+
+		ui.showSeenBy(entry)
+
+	case "/repin":
+		username, suffix := chat.SplitUsername(cmd.cmdarg)
+		if len(username) == 0 || len(suffix) == 0 || ui.Pins == nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /repin <name>#<peer>"})
+			return
+		}
+
+		target, err := ui.FindPeer(suffix)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+			return
+		}
+
+		if err := ui.Pins.Pin(username, target); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the key pins: %s", err)})
+			return
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "verify", Msg: fmt.Sprintf("%s is now pinned to %s", username, p2p.ShortID(target))})
+
+	case "/send":
+		args := strings.SplitN(cmd.cmdarg, " ", 2)
+		if len(args[0]) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /send <peer> [path], without a path to pick the file"})
+			return
+		}
+
+		// no path, no typing it out either
+		if len(args) < 2 || len(args[1]) == 0 {
+			if _, err := ui.FindPeer(args[0]); err != nil {
+				ui.Log(chat.ChatLog{Prefix: "fileerr", Msg: err.Error()})
+				return
+			}
+
+			ui.queueUpdateDraw(func() {
+				ui.pickFile(fmt.Sprintf("send to %s", tview.Escape(args[0])), func(path string) {
+					ui.handleCommand(uiCommand{cmdtype: "/send", cmdarg: args[0] + " " + path})
+				})
+			})
+			return
+		}
+
+		to, err := ui.FindPeer(args[0])
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "fileerr", Msg: err.Error()})
+			return
+		}
+
+		if err := ui.Host.Files.SendFile(ui.Context(), to, args[1], ui.Username); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "fileerr", Msg: fmt.Sprintf("could not send file: %s", err)})
+		}
+
+	case "/cancel":
+		id, ok := ui.latestTransfer()
+		if len(cmd.cmdarg) > 0 {
+			n, err := strconv.Atoi(cmd.cmdarg)
+			id, ok = n, err == nil
+		}
+		if !ok || !ui.Host.Files.Cancel(id) {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "no such transfer under way"})
+		}
+
+	case "/image":
+		if len(cmd.cmdarg) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "missing image path for command"})
+			return
+		}
+
+		att, err := ui.Host.Attachments.ShareImage(cmd.cmdarg)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: fmt.Sprintf("could not share image: %s", err)})
+			return
+		}
+
+		imageMsg := chat.ChatMessage{Type: chat.MessageImage, ID: chat.NewMessageID(), Attachment: att, TTL: ui.messageTTL()}
+		ui.ChatRoom.Send(imageMsg)
+		ui.printSelfMessage(imageMsg)
+
+	case "/save":
+		img, ok := ui.findImage(cmd.cmdarg)
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: "no such image in the room"})
+			return
+		}
+
+		data, err := ui.Host.Attachments.Fetch(ui.Context(), img.from, img.att)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: fmt.Sprintf("could not download image: %s", err)})
+			return
+		}
+
+		// hidden files are not for a peer to make, nor files already there to replace
+		name := filepath.Base(img.att.Name)
+		if strings.HasPrefix(name, ".") {
+			name = "image" + filepath.Ext(name)
+		}
+		f, err := p2p.CreateUniqueFile(ui.Host.Files.DownloadDir, name)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: fmt.Sprintf("could not save image: %s", err)})
+			return
+		}
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: fmt.Sprintf("could not save image: %s", err)})
+			return
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "image", Msg: fmt.Sprintf("saved %s", f.Name())})
+
+	case "/view":
+		img, ok := ui.findImage(cmd.cmdarg)
+		if !ok {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: "no such image in the room"})
+			return
+		}
+
+		// pastes are just text, any terminal can show them
+		if img.att.Mime == p2p.PasteMime {
+			data, err := ui.Host.Attachments.Fetch(ui.Context(), img.from, img.att)
+			if err != nil {
+				ui.Log(chat.ChatLog{Prefix: "pasteerr", Msg: fmt.Sprintf("could not download paste: %s", err)})
+				return
+			}
+
+			ui.showPaste(img.att.Name, data)
+			return
+		}
+
+		if ui.Graphics == graphicsNone {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: "terminal does not support image previews, use /save"})
+			return
+		}
+
+		data, err := ui.Host.Attachments.Fetch(ui.Context(), img.from, img.att)
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: fmt.Sprintf("could not download image: %s", err)})
+			return
+		}
+
+		// the preview is drawn straight to the terminal, so step out of the UI for a bit
+		var drawErr error
+		ui.TerminalApp.Suspend(func() {
+			fmt.Print("\x1b[2J\x1b[H")
+			if drawErr = drawImage(os.Stdout, data, ui.Graphics); drawErr != nil {
+				return
+			}
+			fmt.Printf("%s — press Enter to return to the chat", img.att.Name)
+			fmt.Scanln()
+		})
+
+		if drawErr != nil {
+			ui.Log(chat.ChatLog{Prefix: "imgerr", Msg: fmt.Sprintf("could not preview image: %s", drawErr)})
+		}
+
+	default:
+		// commands of our own hooks are theirs to answer
+		if cmd.hooked {
+			return
+		}
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("unsupported command - %s, /help lists them all", cmd.cmdtype)})
+	}
+}
+
+// Method that shows every command above the chat, until Esc is pressed,
+// or explains a single command in the log
+func (ui *UI) showHelp(name string) {
+	if len(name) > 0 {
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+
+		for _, cmd := range helpCommands {
+			for _, alias := range strings.Fields(cmd.usage) {
+				if alias == name {
+					ui.Log(chat.ChatLog{Prefix: "help", Msg: fmt.Sprintf("%s - %s", cmd.usage, cmd.desc)})
+					return
+				}
+			}
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no command %s, /help lists them all", name)})
+		return
+	}
+
+	text := &strings.Builder{}
+	for _, cmd := range helpCommands {
+		fmt.Fprintf(text, "[%s]%s[-]\n    %s\n", ui.theme.Command, tview.Escape(cmd.usage), cmd.desc)
+	}
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetText(text.String()).
+		SetScrollable(true)
+	view.SetBorder(true).
+		SetTitle("Commands — Esc to close")
+	view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			ui.pages.RemovePage("help")
+			ui.TerminalApp.SetFocus(ui.inputField)
+		}
+	})
+
+	ui.queueUpdateDraw(func() {
+		ui.pages.AddPage("help", view, true, true)
+		ui.TerminalApp.SetFocus(view)
+	})
+}
+
+// Method that lists the peers in the room in the log,
+// with their names and how we treat them
+func (ui *UI) listPeers() {
+	peers := ui.GetPeers()
+	if len(peers) == 0 {
+		ui.Log(chat.ChatLog{Prefix: "peers", Msg: "nobody else is here yet"})
+		return
+	}
+
+	ui.Log(chat.ChatLog{Prefix: "peers", Msg: fmt.Sprintf("%d in the room besides you", len(peers))})
+
+	for _, p := range peers {
+		name := "?"
+		if prof := ui.Host.Profiles.Lookup(p); prof != nil {
+			name = tview.Escape(prof.Username)
+		}
+
+		var marks []string
+		if ui.Host.PeerLists.IsVerified(p) {
+			marks = append(marks, "verified")
+		}
+		if ui.Host.PeerLists.IsMuted(p) {
+			marks = append(marks, "muted")
+		}
+		if ui.Moderation.IsSilenced(p) {
+			marks = append(marks, "silenced by a moderator")
+		}
+
+		line := fmt.Sprintf("%s %s", p2p.ShortID(p), name)
+		if len(marks) > 0 {
+			line += fmt.Sprintf(" (%s)", strings.Join(marks, ", "))
+		}
+		ui.Log(chat.ChatLog{Prefix: "peers", Msg: line})
+	}
+}
+
+// Method that shows the room topic, or sets it as "topic | description"
+func (ui *UI) handleTopic(arg string) {
+	if len(arg) == 0 {
+		meta := ui.Moderation.Meta()
+		if len(meta.Topic) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "topic", Msg: "this room has no topic"})
+			return
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "topic", Msg: meta.Topic})
+		if len(meta.Description) > 0 {
+			ui.Log(chat.ChatLog{Prefix: "topic", Msg: meta.Description})
+		}
+		if !meta.Created.IsZero() {
+			ui.Log(chat.ChatLog{Prefix: "topic", Msg: fmt.Sprintf("room created on %s", meta.Created.Format("2006-01-02 15:04"))})
+		}
+		return
+	}
+
+	topic, description := arg, ""
+	if i := strings.Index(arg, "|"); i >= 0 {
+		topic, description = strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+1:])
+	}
+
+	if err := ui.SetTopic(topic, description); err != nil {
+		ui.Log(chat.ChatLog{Prefix: "moderr", Msg: err.Error()})
+		return
+	}
+
+	ui.queueTitleUpdate()
+}
+
+// Method that handles the /mod subcommands
+func (ui *UI) handleModeration(arg string) {
+	args := strings.Fields(arg)
+	if len(args) == 0 {
+		owner := ui.Moderation.Owner()
+		if len(owner) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "mod", Msg: "the creator of this room hasn't been seen yet"})
+			return
+		}
+		ui.Log(chat.ChatLog{Prefix: "mod", Msg: fmt.Sprintf("this room was created by %s", p2p.ShortID(owner))})
+		return
+	}
+
+	action := args[0]
+	if action == chat.ModClaim {
+		if err := ui.Moderate(chat.ModClaim, ui.SelfID(), 0); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "moderr", Msg: err.Error()})
+			return
+		}
+		ui.Log(chat.ChatLog{Prefix: "mod", Msg: "you are the creator of this room"})
+		return
+	}
+
+	if action == chat.ModTTL && len(args) == 2 {
+		ttl, err := parseTTL(args[1])
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: err.Error()})
+			return
+		}
+
+		if err := ui.Moderate(chat.ModTTL, ui.SelfID(), ttl); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "moderr", Msg: err.Error()})
+			return
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "mod", Msg: "disappearing messages set for the room"})
+		ui.queueTitleUpdate()
+		return
+	}
+
+	if len(args) < 2 || (action != chat.ModGrant && action != chat.ModRevoke && action != chat.ModMute && action != chat.ModKick) {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /mod [claim | grant <peer> | revoke <peer> | mute <peer> [minutes] | kick <peer> | ttl <duration|off>]"})
+		return
+	}
+
+	target, err := ui.FindPeer(args[1])
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "moderr", Msg: err.Error()})
+		return
+	}
+
+	duration := chat.DefaultMuteDuration
+	if action == chat.ModMute && len(args) > 2 {
+		minutes, err := strconv.Atoi(args[2])
+		if err != nil || minutes <= 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "mute duration must be a number of minutes"})
+			return
+		}
+		duration = time.Duration(minutes) * time.Minute
+	}
+
+	if err := ui.Moderate(action, target, duration); err != nil {
+		ui.Log(chat.ChatLog{Prefix: "moderr", Msg: err.Error()})
+		return
+	}
+
+	ui.Log(chat.ChatLog{Prefix: "mod", Msg: fmt.Sprintf("%s %s done", action, p2p.ShortID(target))})
+}
+
+// Method that shows the safety number shared with a peer,
+// or marks the peer as verified once it was compared
+func (ui *UI) handleVerify(arg string) {
+	args := strings.Fields(arg)
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && args[1] != "confirm" && args[1] != "revoke") {
+		ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: "usage: /verify <peer> [confirm | revoke]"})
+		return
+	}
+
+	target, err := ui.FindPeer(args[0])
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "verifyerr", Msg: err.Error()})
+		return
+	}
+
+	if len(args) == 2 {
+		verb := "verified"
+		if args[1] == "confirm" {
+			err = ui.Host.PeerLists.Verify(target)
+		} else {
+			verb = "no longer verified"
+			err = ui.Host.PeerLists.Unverify(target)
+		}
+		if err != nil {
+			ui.Log(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the lists: %s", err)})
+			return
+		}
+
+		ui.Log(chat.ChatLog{Prefix: "verify", Msg: fmt.Sprintf("%s is %s", p2p.ShortID(target), verb)})
+		ui.rerender()
+		return
+	}
+
+	theirs, err := p2p.PeerPublicKey(ui.Host, target)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "verifyerr", Msg: err.Error()})
+		return
+	}
+
+	number, emojis, err := p2p.SafetyString(ui.Host.Host.Peerstore().PubKey(ui.SelfID()), theirs)
+	if err != nil {
+		ui.Log(chat.ChatLog{Prefix: "verifyerr", Msg: err.Error()})
+		return
+	}
+
+	status := "not verified yet"
+	if ui.Host.PeerLists.IsVerified(target) {
+		status = "verified"
+	}
+
+	ui.Log(chat.ChatLog{Prefix: "verify", Msg: fmt.Sprintf("safety number with %s (%s): %s", p2p.ShortID(target), status, number)})
+	ui.Log(chat.ChatLog{Prefix: "verify", Msg: fmt.Sprintf("or as emojis: %s", emojis)})
+	ui.Log(chat.ChatLog{Prefix: "verify", Msg: fmt.Sprintf("compare it with them in person or over a call, then /verify %s confirm", args[0])})
+}
+
+// this will handle UI events
+func (ui *UI) eventHandler() {
+	refresh := time.NewTicker(time.Second)
+	defer refresh.Stop()
+
+	for {
+		select {
+		case msg := <-ui.MsgInputs:
+			// send the message and show it as our own
+			ui.sendMessage(msg)
+
+		case cmd := <-ui.CmdInputs:
+			go ui.invokeCommand(cmd)
+
+		case ev := <-ui.roomEvents:
+			// print received messages and logs of the room in view,
+			// and keep those of the other rooms for later, along with
+			// whatever else came in meanwhile
+			ui.handleRoomEvents(ui.takeRoomEvents(ev))
+
+		case ev := <-ui.tabEvents:
+			// show another room, or leave one
+			ui.handleTabEvent(ev)
+
+		case invite := <-ui.Host.DMs.Invites:
+			// join the conversation, joining takes a moment
+			go ui.acceptDM(invite)
+
+		case offer := <-ui.Host.Files.Offers:
+			// ask the user what to do with the file
+			ui.promptFileOffer(offer)
+
+		case log := <-ui.Host.Files.Logs:
+			// display file transfer progress
+			ui.printLogMessage(log)
+
+		case ev := <-ui.Host.Files.Progress:
+			// and keep the transfers under way in sight
+			ui.updateTransfer(ev)
+
+		case <-refresh.C:
+			// periodically refresh the peer list
+			ui.syncPeerList()
+			// and the connection state
+			ui.syncStatus()
+			// and whether the rooms are still connected at all
+			ui.syncConnection()
+			// and who of those we wait for showed up
+			ui.syncWatched()
+			// and let disappearing messages go
+			ui.expireMessages()
+			// and tell apart peers using the same name
+			ui.refreshCollisions()
+			// and tell others what we have seen
+			ui.sendReceipts()
+
+		case <-ui.Context().Done():
+			// end event loop
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/xtopala/p2pchat/chat"
+	"github.com/xtopala/p2pchat/p2p"
+)
+
+// where a watched peer was last seen: connected to us, and in which rooms
+type watchedState struct {
+	online bool
+	rooms  map[string]bool
+}
+
+// Method that announces watched peers coming online or joining one of our
+// rooms, with an alert and the bell. Runs on the event loop
+func (ui *UI) syncWatched() {
+	watched := ui.Host.PeerLists.WatchedPeers()
+	if len(watched) == 0 {
+		ui.watching = nil
+		return
+	}
+
+	ui.tabsLock.Lock()
+	tabs := append([]*roomTab(nil), ui.tabs...)
+	ui.tabsLock.Unlock()
+
+	// who is in which room, looked up once for every watched peer
+	present := make(map[peer.ID][]string)
+	for _, tab := range tabs {
+		for _, p := range tab.room.GetPeers() {
+			present[p] = append(present[p], tab.Name())
+		}
+	}
+
+	states := make(map[peer.ID]watchedState, len(watched))
+	var news []string
+	for _, id := range watched {
+		state := watchedState{
+			online: ui.Host.Host.Network().Connectedness(id) == network.Connected,
+			rooms:  make(map[string]bool),
+		}
+		for _, room := range present[id] {
+			state.rooms[room] = true
+		}
+		// being in a room means being around, even without a direct connection
+		state.online = state.online || len(state.rooms) > 0
+		states[id] = state
+
+		previous := ui.watching[id]
+		if state.online && !previous.online {
+			news = append(news, fmt.Sprintf("%s is online", ui.peerName(id)))
+		}
+		for room := range state.rooms {
+			if !previous.rooms[room] {
+				news = append(news, fmt.Sprintf("%s joined %s", ui.peerName(id), room))
+			}
+		}
+	}
+	ui.watching = states
+
+	if len(news) == 0 {
+		return
+	}
+
+	sort.Strings(news)
+	ui.ringBell()
+	go func() {
+		ui.Log(chat.ChatLog{Prefix: "watch", Msg: strings.Join(news, "\n"), Alert: true})
+	}()
+}
+
+// Method that handles the watch commands: /watch with a peer watches it and
+// without one lists the watched peers, /unwatch stops watching a peer
+func (ui *UI) handleWatch(cmd uiCommand) {
+	lists := ui.Host.PeerLists
+
+	if cmd.cmdtype == "/unwatch" {
+		var target peer.ID
+		for _, id := range lists.WatchedPeers() {
+			if len(cmd.cmdarg) > 0 && strings.HasSuffix(id.Pretty(), cmd.cmdarg) {
+				target = id
+			}
+		}
+		if len(target) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no watched peer matching %s", cmd.cmdarg)})
+			return
+		}
+
+		if err := lists.Unwatch(target); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the lists: %s", err)})
+		}
+		ui.Log(chat.ChatLog{Prefix: "watch", Msg: fmt.Sprintf("stopped watching %s", ui.peerName(target))})
+		return
+	}
+
+	if len(cmd.cmdarg) == 0 {
+		watched := lists.WatchedPeers()
+		if len(watched) == 0 {
+			ui.Log(chat.ChatLog{Prefix: "watch", Msg: "you are not watching anyone, /watch <peer> to start"})
+			return
+		}
+
+		lines := make([]string, len(watched))
+		for i, id := range watched {
+			status := "offline"
+			if ui.Host.Host.Network().Connectedness(id) == network.Connected {
+				status = "online"
+			}
+			lines[i] = fmt.Sprintf("%s (%s): %s", ui.peerName(id), p2p.ShortID(id), status)
+		}
+		sort.Strings(lines)
+		ui.Log(chat.ChatLog{Prefix: "watch", Msg: "watched peers:\n" + strings.Join(lines, "\n")})
+		return
+	}
+
+	target, err := ui.FindPeer(cmd.cmdarg)
+	if err != nil {
+		// peers not around yet are watched by their full ID
+		if target, err = peer.Decode(cmd.cmdarg); err != nil {
+			ui.Log(chat.ChatLog{Prefix: "badcmd", Msg: fmt.Sprintf("no peer matching %s in the room, use the full peer ID", cmd.cmdarg)})
+			return
+		}
+	}
+
+	if err := lists.Watch(target); err != nil {
+		ui.Log(chat.ChatLog{Prefix: "listerr", Msg: fmt.Sprintf("could not save the lists: %s", err)})
+	}
+	ui.Log(chat.ChatLog{Prefix: "watch", Msg: fmt.Sprintf("watching %s, you will hear when they come online or join a room", ui.peerName(target))})
+}
@@ -0,0 +1,17 @@
+package tui
+
+import (
+	"os"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// This one makes characters of ambiguous width, like the box drawing ones of
+// the borders, a single column wide. In CJK locales they would count as two,
+// which few terminals agree with, and every box ends up misaligned. Setting
+// RUNEWIDTH_EASTASIAN=1 keeps them two columns wide, for terminals that are
+func FixAmbiguousWidth() {
+	if len(os.Getenv("RUNEWIDTH_EASTASIAN")) == 0 {
+		runewidth.DefaultCondition.EastAsianWidth = false
+	}
+}
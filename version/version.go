@@ -0,0 +1,173 @@
+package version
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// what this build is, set when building a release with
+// -ldflags "-X github.com/xtopala/p2pchat/version.Version=v1.2.0 -X github.com/xtopala/p2pchat/version.Commit=abc123"
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// where the latest release is looked up, the releases of the repository
+const DefaultFeed = "https://api.github.com/repos/xtopala/p2pchat/releases/latest"
+
+// how long looking up the latest release may take
+const feedTimeout = 10 * time.Second
+
+// most of the release feed read, it's a few kilobytes
+const maxFeedSize = 1 << 20
+
+// Info is what a build knows about itself
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go"`
+	Platform  string `json:"platform"`
+}
+
+// This one returns what this build is, as the linker was told, or as the go
+// command recorded it when it wasn't, like for go install of a tagged version.
+// Builds that don't know their version are dev
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if len(info.Version) == 0 && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" && len(info.Commit) == 0 {
+				info.Commit = setting.Value
+			}
+		}
+	}
+
+	if len(info.Version) == 0 {
+		info.Version = "dev"
+	}
+
+	return info
+}
+
+// Method that returns the version, with the commit it was built from
+func (i Info) String() string {
+	if len(i.Commit) == 0 {
+		return i.Version
+	}
+
+	commit := i.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+
+	return fmt.Sprintf("%s (%s)", i.Version, commit)
+}
+
+// This one returns the user agent hosts identify with to their peers,
+// so they can tell which versions are around, like p2pchat/v1.2.0
+func UserAgent() string {
+	return "p2pchat/" + Get().Version
+}
+
+// Release is the latest release, as the feed tells
+type Release struct {
+	Tag string `json:"tag_name"`
+	URL string `json:"html_url"`
+}
+
+// This one asks the release feed for the latest release. The feed answers
+// like the GitHub API does for the latest release of a repository
+func Latest(ctx context.Context, feed string) (*Release, error) {
+	ctx, cancel := context.WithTimeout(ctx, feedTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", UserAgent())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("looking up the latest release failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("looking up the latest release failed: %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, maxFeedSize)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("reading the latest release failed: %w", err)
+	}
+	if len(release.Tag) == 0 {
+		return nil, fmt.Errorf("the release feed names no release")
+	}
+
+	return &release, nil
+}
+
+// This one tells if version a comes before version b, comparing them like
+// v1.2.3, number by number. Versions not like that, like dev, come before
+// nothing, as there is no telling
+func Older(a, b string) bool {
+	va, ok := parse(a)
+	if !ok {
+		return false
+	}
+	vb, ok := parse(b)
+	if !ok {
+		return false
+	}
+
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] < vb[i]
+		}
+	}
+
+	return false
+}
+
+// This one reads the major, minor and patch numbers of a version like
+// v1.2.3, leaving out whatever comes after them, like -rc1
+func parse(version string) ([3]int, bool) {
+	var numbers [3]int
+
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+
+	fields := strings.Split(version, ".")
+	if len(fields) != 3 {
+		return numbers, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return numbers, false
+		}
+		numbers[i] = n
+	}
+
+	return numbers, true
+}
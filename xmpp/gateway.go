@@ -0,0 +1,380 @@
+package xmpp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"github.com/xtopala/p2pchat/chat"
+)
+
+// how long connecting to the server and the handshake may take
+const dialTimeout = 15 * time.Second
+
+// how long to wait before connecting again after losing the server,
+// doubling after each failed try up to the longest wait
+const (
+	reconnectMinWait = 2 * time.Second
+	reconnectMaxWait = time.Minute
+)
+
+// Config is how the gateway reaches the XMPP server, as an external component
+type Config struct {
+	// host and port the server accepts components on, like localhost:5347
+	Server string
+	// domain of the component, like p2pchat.example.org
+	Domain string
+	// secret shared with the server for the component
+	Secret string
+	// address of the multi-user chat room, like lobby@conference.example.org
+	Room string
+	// nickname of the gateway in the room
+	Nick string
+}
+
+// Gateway relays the messages of a p2pchat room to a multi-user chat room on
+// an XMPP server and back, so Jabber clients can take part. It connects as
+// an external component, and reconnects when the server goes away
+type Gateway struct {
+	config Config
+	room   *chat.ChatRoom
+
+	// lock for the connection, nil while it's down
+	lock sync.Mutex
+	conn net.Conn
+}
+
+// Constructor function for a gateway between the chat room and the XMPP room
+func NewGateway(config Config, cr *chat.ChatRoom) *Gateway {
+	return &Gateway{config: config, room: cr}
+}
+
+// Method that returns the address the gateway sends from
+func (g *Gateway) jid() string {
+	return "bridge@" + g.config.Domain + "/p2pchat"
+}
+
+// Method that returns the address of the gateway in the room
+func (g *Gateway) occupant() string {
+	return g.config.Room + "/" + g.config.Nick
+}
+
+// Method that runs the gateway until the context is done, relaying messages
+// both ways and keeping the server connected. Close it first, to leave the
+// XMPP room rather than just drop out of it
+func (g *Gateway) Run(ctx context.Context) {
+	g.room.OnMessage(func(msg chat.ChatMessage) {
+		if ctx.Err() == nil {
+			g.toXMPP(msg)
+		}
+	})
+	g.room.OnLog(func(log chat.ChatLog) {
+		g.room.Host.Logger.WithFields(logrus.Fields{
+			"room":   g.room.RoomName,
+			"prefix": log.Prefix,
+		}).Debugln(log.Msg)
+	})
+
+	g.connectLoop(ctx)
+}
+
+// Method that connects to the server and serves the connection,
+// connecting again when it's lost, until the context is done
+func (g *Gateway) connectLoop(ctx context.Context) {
+	wait := reconnectMinWait
+	for {
+		started := time.Now()
+		err := g.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// a connection that held for a while starts the waits over
+		if time.Since(started) > reconnectMaxWait {
+			wait = reconnectMinWait
+		}
+		g.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"wait":  wait.String(),
+		}).Warnln("XMPP connection lost, connecting again")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if wait *= 2; wait > reconnectMaxWait {
+			wait = reconnectMaxWait
+		}
+	}
+}
+
+// Method that connects to the server, joins the room and reads
+// from the server until the connection fails
+func (g *Gateway) serve(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", g.config.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the context going away closes the connection, ending the reads
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	decoder := xml.NewDecoder(conn)
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := g.handshake(conn, decoder); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	g.lock.Lock()
+	g.conn = conn
+	g.lock.Unlock()
+	defer func() {
+		g.lock.Lock()
+		g.conn = nil
+		g.lock.Unlock()
+	}()
+
+	join := presence{From: g.jid(), To: g.occupant(), Join: &mucJoin{}}
+	if err := g.send(join); err != nil {
+		return err
+	}
+	g.room.Host.Logger.WithFields(logrus.Fields{
+		"room": g.config.Room,
+		"nick": g.config.Nick,
+	}).Infoln("Joined the XMPP room")
+
+	return g.read(decoder)
+}
+
+// Method that opens the stream to the server and authenticates
+// the component, with the secret hashed along with the stream ID
+func (g *Gateway) handshake(conn net.Conn, decoder *xml.Decoder) error {
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream xmlns='%s' xmlns:stream='%s' to='%s'>", nsComponent, nsStreams, escape(g.config.Domain))
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return err
+	}
+
+	start, err := nextElement(decoder)
+	if err != nil {
+		return err
+	}
+	if start.Name.Space != nsStreams || start.Name.Local != "stream" {
+		return fmt.Errorf("expected a stream, got %s", start.Name.Local)
+	}
+
+	var streamID string
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "id" {
+			streamID = attr.Value
+		}
+	}
+
+	hash := sha1.Sum([]byte(streamID + g.config.Secret))
+	if _, err := fmt.Fprintf(conn, "<handshake>%s</handshake>", hex.EncodeToString(hash[:])); err != nil {
+		return err
+	}
+
+	reply, err := nextElement(decoder)
+	if err != nil {
+		return err
+	}
+	if reply.Name.Local == "error" {
+		var streamErr streamError
+		decoder.DecodeElement(&streamErr, &reply)
+		return fmt.Errorf("server refused the component: %s", conditionName(streamErr.Conditions))
+	}
+	if reply.Name.Local != "handshake" {
+		return fmt.Errorf("expected a handshake, got %s", reply.Name.Local)
+	}
+
+	return decoder.Skip()
+}
+
+// Method that reads stanzas from the server, until the stream ends or fails
+func (g *Gateway) read(decoder *xml.Decoder) error {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token := token.(type) {
+		case xml.EndElement:
+			return fmt.Errorf("server closed the stream")
+
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "message":
+				var msg message
+				if err := decoder.DecodeElement(&msg, &token); err != nil {
+					return err
+				}
+				g.toRoom(msg)
+
+			case "presence":
+				var pres presence
+				if err := decoder.DecodeElement(&pres, &token); err != nil {
+					return err
+				}
+				if pres.Type == "error" && pres.From == g.occupant() && pres.Error != nil {
+					return fmt.Errorf("could not join the room: %s", conditionName(pres.Error.Conditions))
+				}
+
+			case "iq":
+				var req iq
+				if err := decoder.DecodeElement(&req, &token); err != nil {
+					return err
+				}
+				g.answer(req)
+
+			case "error":
+				var streamErr streamError
+				decoder.DecodeElement(&streamErr, &token)
+				return fmt.Errorf("stream error: %s", conditionName(streamErr.Conditions))
+
+			default:
+				if err := decoder.Skip(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Method that answers an iq from the server, pings with a pong
+// and anything else as something the gateway doesn't serve
+func (g *Gateway) answer(req iq) {
+	if req.Type != "get" && req.Type != "set" {
+		return
+	}
+
+	if req.Type == "get" && req.Ping != nil {
+		g.send(iq{From: req.To, To: req.From, Type: "result", ID: req.ID})
+		return
+	}
+
+	g.send(unavailable(req))
+}
+
+// Method that passes a message of the XMPP room on to the chat room,
+// with the nickname of whoever sent it
+func (g *Gateway) toRoom(msg message) {
+	if msg.Type != "groupchat" || msg.Delay != nil || len(strings.TrimSpace(msg.Body)) == 0 {
+		return
+	}
+
+	// the room echoes what we send, and says who sent what by the resource
+	if !strings.HasPrefix(msg.From, g.config.Room+"/") || msg.From == g.occupant() {
+		return
+	}
+	nick := strings.TrimPrefix(msg.From, g.config.Room+"/")
+
+	text := fmt.Sprintf("<%s> %s", nick, msg.Body)
+	if err := chat.CheckMessageLength(text); err != nil {
+		g.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"nick":  nick,
+		}).Warnln("Message from the XMPP room not relayed")
+		return
+	}
+
+	g.room.Send(g.room.NewTextMessage(text))
+}
+
+// Method that passes a message of the chat room on to the XMPP room, with
+// the name of whoever sent it. Dropped while the server isn't connected
+func (g *Gateway) toXMPP(msg chat.ChatMessage) {
+	if msg.Type != chat.MessageText && msg.Type != chat.MessageCommand {
+		return
+	}
+	// muted peers are still there, we just don't pass them on
+	if from, err := peer.Decode(msg.SenderID); err == nil && g.room.Host.PeerLists.IsMuted(from) {
+		return
+	}
+
+	out := message{
+		From: g.jid(),
+		To:   g.config.Room,
+		Type: "groupchat",
+		ID:   msg.ID,
+		Body: fmt.Sprintf("<%s> %s", msg.SenderName, msg.Message),
+	}
+	if err := g.send(out); err != nil {
+		g.room.Host.Logger.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Debugln("Message not relayed to the XMPP room")
+	}
+}
+
+// Method that writes a stanza to the server
+func (g *Gateway) send(stanza interface{}) error {
+	data, err := xml.Marshal(stanza)
+	if err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.conn == nil {
+		return fmt.Errorf("not connected to the XMPP server")
+	}
+
+	_, err = g.conn.Write(data)
+	return err
+}
+
+// Method that leaves the XMPP room and ends the stream, if connected
+func (g *Gateway) Close() {
+	g.send(presence{From: g.jid(), To: g.occupant(), Type: "unavailable"})
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.conn != nil {
+		g.conn.Write([]byte("</stream:stream>"))
+	}
+}
+
+// This one returns the next element started in the stream
+func nextElement(decoder *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// This one escapes text for an attribute of the stream header
+func escape(text string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(text))
+
+	return sb.String()
+}
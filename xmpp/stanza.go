@@ -0,0 +1,100 @@
+package xmpp
+
+import (
+	"encoding/xml"
+)
+
+// namespaces of the streams and stanzas the gateway speaks
+const (
+	nsStreams   = "http://etherx.jabber.org/streams"
+	nsComponent = "jabber:component:accept"
+	nsMUC       = "http://jabber.org/protocol/muc"
+	nsStanzas   = "urn:ietf:params:xml:ns:xmpp-stanzas"
+)
+
+// a message stanza, a groupchat message of the room for the gateway
+type message struct {
+	XMLName xml.Name `xml:"message"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+	ID      string   `xml:"id,attr,omitempty"`
+	Body    string   `xml:"body,omitempty"`
+
+	// set on messages from the history of the room, sent when joining it
+	Delay *struct{} `xml:"urn:xmpp:delay delay"`
+}
+
+// a presence stanza, how the gateway joins and leaves the room
+type presence struct {
+	XMLName xml.Name `xml:"presence"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
+
+	// asks to join a room, without its history
+	Join *mucJoin `xml:"http://jabber.org/protocol/muc x,omitempty"`
+	// why a presence failed
+	Error *stanzaError `xml:"error,omitempty"`
+}
+
+// the join element of a presence to a room
+type mucJoin struct {
+	History mucHistory `xml:"history"`
+}
+
+// how much history of a room to get when joining it
+type mucHistory struct {
+	MaxStanzas int `xml:"maxstanzas,attr"`
+}
+
+// an iq stanza, which the gateway only answers pings with
+type iq struct {
+	XMLName xml.Name `xml:"iq"`
+	From    string   `xml:"from,attr,omitempty"`
+	To      string   `xml:"to,attr,omitempty"`
+	Type    string   `xml:"type,attr"`
+	ID      string   `xml:"id,attr"`
+
+	Ping  *struct{}    `xml:"urn:xmpp:ping ping"`
+	Error *stanzaError `xml:"error,omitempty"`
+}
+
+// the error of a stanza, with the condition it names
+type stanzaError struct {
+	Type       string      `xml:"type,attr"`
+	Conditions []condition `xml:",any"`
+}
+
+// an element named after what went wrong, like conflict
+type condition struct {
+	XMLName xml.Name
+}
+
+// the error ending a stream, with the condition it names
+type streamError struct {
+	Conditions []condition `xml:",any"`
+}
+
+// This one returns the name of the first condition, which says what went wrong
+func conditionName(conditions []condition) string {
+	if len(conditions) == 0 {
+		return "unknown"
+	}
+
+	return conditions[0].XMLName.Local
+}
+
+// This one makes the error answering an iq the gateway doesn't serve
+func unavailable(req iq) iq {
+	return iq{
+		From: req.To,
+		To:   req.From,
+		Type: "error",
+		ID:   req.ID,
+		Error: &stanzaError{
+			Type:       "cancel",
+			Conditions: []condition{{XMLName: xml.Name{Space: nsStanzas, Local: "service-unavailable"}}},
+		},
+	}
+}